@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wpnpeiris/nats-s3/internal/kms"
+)
+
+var kmsUsageStr = `
+Usage: nats-s3 kms --file <path> <command> <key-id>
+
+Manages the keys of the file backed KMS used for SSE-KMS. A running gateway
+picks up changes within a second.
+
+Commands:
+    create <key-id>     Create a new key
+    rotate <key-id>     Add a new key version; older versions still decrypt
+    disable <key-id>    Disable a key; its objects become unreadable
+    enable <key-id>     Enable a disabled key
+    default <key-id>    Use the key when requests name no key
+`
+
+// runKMS executes the kms admin command and exits.
+func runKMS(args []string) {
+	fs := flag.NewFlagSet("nats-s3 kms", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("%s\n", kmsUsageStr)
+		os.Exit(0)
+	}
+	file := fs.String("file", "", "Path to the KMS key file")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *file == "" || fs.NArg() != 2 {
+		fs.Usage()
+	}
+
+	keyService, err := kms.NewFileKMS(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	keyID := fs.Arg(1)
+	switch fs.Arg(0) {
+	case "create":
+		err = keyService.CreateKey(keyID)
+	case "rotate":
+		err = keyService.RotateKey(keyID)
+	case "disable":
+		err = keyService.SetKeyEnabled(keyID, false)
+	case "enable":
+		err = keyService.SetKeyEnabled(keyID, true)
+	case "default":
+		err = keyService.SetDefaultKey(keyID)
+	default:
+		fs.Usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kms %s %s: %v\n", fs.Arg(0), keyID, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
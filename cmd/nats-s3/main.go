@@ -9,8 +9,10 @@ import (
 
 var usageStr = `
 Usage: nats-s3 [options]
+       nats-s3 kms --file <path> <command> <key-id>
 
 Server Options:
+    --config <path>                  Path to a YAML config file (flags override NATS_S3_* variables, which override the file)
     --listen <host:port>             HTTP bind address for NATS S3 (default: 0.0.0.0:5222)
 
 NATS Connection Options:
@@ -26,8 +28,59 @@ NATS Authentication Options (choose one):
     --natsNKeyFile <path>        NATS server NKey seed file path (NKey auth)
     --natsCredsFile <path>       NATS server credentials file path (JWT auth)
 
+NATS TLS Options:
+    --natsTLSCert <path>         Client certificate file for mutual TLS to NATS
+    --natsTLSKey <path>          Client private key file for mutual TLS to NATS
+    --natsTLSCA <path>           CA certificate file verifying the NATS servers
+
 S3 Options:
     --s3.credentials <path>          Path to S3 credentials file (JSON format, required)
+    --s3.encryption-key <path>       Path to the 256-bit master key file enabling SSE-S3
+    --s3.encrypt-by-default          Encrypt objects with SSE-S3 unless the request asks for SSE-C
+    --s3.kms-key-file <path>         Path to the KMS key file enabling SSE-KMS (created if missing)
+    --s3.compression <alg>           Compress objects at rest: zstd or s2 (default: disabled)
+    --s3.compress-content-types <l>  Comma separated content types to compress (default: all)
+    --s3.domain <domain>             Serve virtual-hosted-style requests for <bucket>.<domain> (repeatable)
+    --s3.bucket-routes <path>        Path to a JSON file routing buckets to JetStream domains or NATS connections
+
+Website Options:
+    --website.listen <host:port>     Serve bucket websites, named by the first label of the host name (default: disabled)
+    --website.domain <domain>        Serve bucket websites for <bucket>.<domain> on the S3 listener (repeatable)
+
+TLS Options:
+    --tls.cert <path>                Certificate file serving HTTPS (reloaded when it changes)
+    --tls.key <path>                 Private key file serving HTTPS (reloaded when it changes)
+    --tls.client-ca <path>           CA certificate file requiring and verifying client certificates
+
+Metrics Options:
+    --metrics.max-buckets <N>        Maximum distinct buckets labeling request metrics (default: 100, 0 disables)
+
+Tracing Options:
+    --tracing.endpoint <host:port>   OTLP/HTTP endpoint exporting OpenTelemetry traces (default: disabled)
+    --tracing.insecure               Export traces over plain HTTP
+    --tracing.sample-ratio <ratio>   Fraction of new traces sampled, from 0 to 1 (default: 1)
+    --tracing.service-name <name>    service.name of the exported spans (default: nats-s3)
+
+Access Log Options:
+    --access-log.flush-interval <d>  How often access logs are delivered to target buckets (default: 1m)
+    --access-log.stdout              Also write every access log record to stdout as a JSON line
+
+Rate Limit Options:
+    --ratelimit.rules <path>         Path to a JSON file limiting requests and bandwidth per access key, bucket and class
+    --ratelimit.shared               Keep rate limit state in JetStream KV, shared by all gateways
+
+Admission Control Options:
+    --admission.max-transfers <N>    Maximum concurrent uploads, downloads and multipart completions (default: unlimited)
+    --admission.max-bytes <N>        Byte budget shared by concurrent transfers (default: unlimited)
+    --admission.queue-size <N>       Maximum transfers waiting to start (default: 100)
+    --admission.queue-timeout <d>    How long a transfer waits to start (default: 30s)
+
+Cache Options:
+    --cache.memory-bytes <N>         Size of the in-memory cache of small objects (default: disabled)
+    --cache.dir <path>               Directory of the on-disk cache of small objects (default: disabled)
+    --cache.disk-bytes <N>           Size of the on-disk cache of small objects
+    --cache.max-object-size <N>      Size of the largest object cached (default: 1048576)
+    --cache.ttl <d>                  How long cached objects are served (default: 5m)
 
 Logging Options:
     --log.format <format>            Log output format: logfmt or json (default: logfmt)
@@ -38,6 +91,8 @@ HTTP Server Timeout Options:
     --http.write-timeout <duration>  HTTP server write timeout (default: 15m)
     --http.idle-timeout <duration>   HTTP server idle timeout (default: 120s)
     --http.read-header-timeout <dur> HTTP server read header timeout (default: 30s)
+    --http.shutdown-delay <dur>      Time /healthz reports 503 before the listener closes (default: 0s)
+    --http.shutdown-timeout <dur>    Grace period for in-flight requests on shutdown (default: 30s)
 
 Common Options:
     -h, --help                       Show this message
@@ -52,6 +107,9 @@ Examples:
             --natsUser admin --natsPassword secret \
             --s3.credentials credentials.json
 
+    # Start from a config file, overriding the password through the environment
+    NATS_S3_NATS_PASSWORD_FILE=/run/secrets/nats-password nats-s3 --config nats-s3.yaml
+
     # Start with custom listen address and debug logging
     nats-s3 --listen 0.0.0.0:8080 \
             --natsServers nats://127.0.0.1:4222 \
@@ -75,6 +133,10 @@ func usage() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "kms" {
+		runKMS(os.Args[2:])
+	}
+
 	fs := flag.NewFlagSet("nats-s3", flag.ExitOnError)
 	fs.Usage = usage
 	opts, err := server.ConfigureOptions(fs, os.Args[1:], printVersionAndExit, fs.Usage)
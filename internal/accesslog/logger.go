@@ -0,0 +1,160 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/nats-io/nuid"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+)
+
+const (
+	defaultFlushInterval = time.Minute
+	defaultMaxBatchBytes = 1 << 20
+
+	// keyTimeLayout dates the keys of the delivered log objects.
+	keyTimeLayout = "2006-01-02-15-04-05"
+)
+
+// Target is the bucket and key prefix the access logs of a bucket are
+// delivered to.
+type Target struct {
+	Bucket string
+	Prefix string
+}
+
+// DeliverFunc writes a batch of access log lines as an object.
+type DeliverFunc func(ctx context.Context, bucket, key string, data []byte) error
+
+// Options configures a Logger.
+type Options struct {
+	// FlushInterval is how often batches are delivered; zero uses one minute
+	FlushInterval time.Duration
+	// MaxBatchBytes delivers a batch early once it grows past this size;
+	// zero uses 1 MiB
+	MaxBatchBytes int
+	// Stdout, when set, receives every record as a JSON line, whether or not
+	// its bucket has logging enabled
+	Stdout io.Writer
+}
+
+// Logger batches access log records per target and delivers each batch as
+// an object named <prefix>YYYY-MM-DD-HH-MM-SS-<unique>. Delivery is best
+// effort: a batch that cannot be written is logged and dropped.
+type Logger struct {
+	logger  log.Logger
+	deliver DeliverFunc
+	opts    Options
+
+	mu      sync.Mutex
+	batches map[Target]*bytes.Buffer
+
+	flush chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewLogger starts a Logger delivering batches with deliver.
+func NewLogger(logger log.Logger, deliver DeliverFunc, opts Options) *Logger {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = defaultMaxBatchBytes
+	}
+	l := &Logger{
+		logger:  logger,
+		deliver: deliver,
+		opts:    opts,
+		batches: make(map[Target]*bytes.Buffer),
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	l.wg.Add(1)
+	go l.run()
+	return l
+}
+
+// Log records a served request. The record is batched for target unless
+// target is nil, and written to Stdout when configured.
+func (l *Logger) Log(target *Target, rec *Record) {
+	var line []byte
+	if l.opts.Stdout != nil {
+		var err error
+		line, err = json.Marshal(rec)
+		if err != nil {
+			logging.Error(l.logger, "msg", "Error encoding access log record", "err", err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if line != nil {
+		l.opts.Stdout.Write(append(line, '\n'))
+	}
+	if target == nil {
+		return
+	}
+	batch, ok := l.batches[*target]
+	if !ok {
+		batch = &bytes.Buffer{}
+		l.batches[*target] = batch
+	}
+	batch.WriteString(rec.Format())
+	batch.WriteByte('\n')
+	if batch.Len() >= l.opts.MaxBatchBytes {
+		select {
+		case l.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush delivers the pending batches.
+func (l *Logger) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	batches := l.batches
+	l.batches = make(map[Target]*bytes.Buffer)
+	l.mu.Unlock()
+
+	var errs []error
+	now := time.Now().UTC()
+	for target, batch := range batches {
+		key := fmt.Sprintf("%s%s-%s", target.Prefix, now.Format(keyTimeLayout), nuid.Next())
+		if err := l.deliver(ctx, target.Bucket, key, batch.Bytes()); err != nil {
+			logging.Error(l.logger, "msg", "Failed to deliver access logs", "bucket", target.Bucket,
+				"key", key, "bytes", batch.Len(), "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the periodic delivery and delivers the pending batches.
+func (l *Logger) Close(ctx context.Context) error {
+	close(l.done)
+	l.wg.Wait()
+	return l.Flush(ctx)
+}
+
+func (l *Logger) run() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		case <-l.flush:
+		}
+		l.Flush(context.Background())
+	}
+}
@@ -0,0 +1,83 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+)
+
+func TestRecordFormat(t *testing.T) {
+	rec := &Record{
+		Bucket:           "photos",
+		Time:             time.Date(2019, 2, 6, 0, 0, 38, 0, time.UTC),
+		RemoteIP:         "192.0.2.3",
+		Requester:        "AKIDEXAMPLE",
+		Operation:        "REST.GET.OBJECT",
+		Key:              "2019/my photo.jpg",
+		RequestURI:       "GET /photos/2019/my%20photo.jpg HTTP/1.1",
+		Status:           200,
+		BytesSent:        2662992,
+		ObjectSize:       3462992,
+		TotalTime:        70,
+		TurnAroundTime:   10,
+		UserAgent:        `aws-cli/1.16 "test"`,
+		SignatureVersion: "SigV4",
+		AuthType:         "AuthHeader",
+		HostHeader:       "localhost:5222",
+	}
+	want := `- photos [06/Feb/2019:00:00:38 +0000] 192.0.2.3 AKIDEXAMPLE - REST.GET.OBJECT 2019/my%20photo.jpg ` +
+		`"GET /photos/2019/my%20photo.jpg HTTP/1.1" 200 - 2662992 3462992 70 10 "-" "aws-cli/1.16 \"test\"" ` +
+		`- - SigV4 - AuthHeader localhost:5222 - - -`
+	if got := rec.Format(); got != want {
+		t.Errorf("unexpected log line\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var mu sync.Mutex
+	delivered := make(map[string]string)
+	deliver := func(ctx context.Context, bucket, key string, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered[bucket+"/"+key] = string(data)
+		return nil
+	}
+	var stdout bytes.Buffer
+	l := NewLogger(logging.NewLogger(logging.Config{Level: "debug"}), deliver, Options{FlushInterval: time.Hour, Stdout: &stdout})
+
+	target := &Target{Bucket: "logs", Prefix: "photos/"}
+	l.Log(target, &Record{Bucket: "photos", Operation: "REST.PUT.OBJECT", Status: 200})
+	l.Log(target, &Record{Bucket: "photos", Operation: "REST.GET.OBJECT", Status: 404, ErrorCode: "NoSuchKey"})
+	l.Log(nil, &Record{Operation: "REST.GET.SERVICE", Status: 200})
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if len(delivered) != 1 {
+		t.Fatalf("expected one delivered batch, got %v", delivered)
+	}
+	for name, data := range delivered {
+		if !strings.HasPrefix(name, "logs/photos/") {
+			t.Errorf("unexpected log object %s", name)
+		}
+		lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[1], "REST.GET.OBJECT") || !strings.Contains(lines[1], " 404 NoSuchKey ") {
+			t.Errorf("unexpected log object content %q", data)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 JSON lines on stdout, got %q", stdout.String())
+	}
+	var rec Record
+	if err := json.Unmarshal([]byte(lines[2]), &rec); err != nil || rec.Operation != "REST.GET.SERVICE" {
+		t.Errorf("unexpected JSON line %q: %v", lines[2], err)
+	}
+}
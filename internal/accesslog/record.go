@@ -0,0 +1,102 @@
+package accesslog
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayout is the time format of S3 server access logs.
+const timeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Record is a request served by the gateway, in the fields of the S3 server
+// access log format.
+type Record struct {
+	BucketOwner      string    `json:"bucket_owner"`
+	Bucket           string    `json:"bucket"`
+	Time             time.Time `json:"time"`
+	RemoteIP         string    `json:"remote_ip"`
+	Requester        string    `json:"requester"`
+	RequestID        string    `json:"request_id"`
+	Operation        string    `json:"operation"`
+	Key              string    `json:"key"`
+	RequestURI       string    `json:"request_uri"`
+	Status           int       `json:"http_status"`
+	ErrorCode        string    `json:"error_code"`
+	BytesSent        int64     `json:"bytes_sent"`
+	ObjectSize       int64     `json:"object_size"`
+	TotalTime        int64     `json:"total_time_ms"`
+	TurnAroundTime   int64     `json:"turn_around_time_ms"`
+	Referer          string    `json:"referer"`
+	UserAgent        string    `json:"user_agent"`
+	VersionID        string    `json:"version_id"`
+	HostID           string    `json:"host_id"`
+	SignatureVersion string    `json:"signature_version"`
+	CipherSuite      string    `json:"cipher_suite"`
+	AuthType         string    `json:"authentication_type"`
+	HostHeader       string    `json:"host_header"`
+	TLSVersion       string    `json:"tls_version"`
+}
+
+// Format returns the record as a line of the S3 server access log format,
+// without the trailing newline. Empty fields are written as "-", and
+// ObjectSize as "-" when negative.
+func (r *Record) Format() string {
+	var b strings.Builder
+	field := func(v string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if v == "" {
+			v = "-"
+		}
+		b.WriteString(v)
+	}
+	quoted := func(v string) {
+		if v == "" {
+			v = "-"
+		}
+		field(`"` + strings.ReplaceAll(v, `"`, `\"`) + `"`)
+	}
+	number := func(v int64) {
+		if v < 0 {
+			field("-")
+			return
+		}
+		field(strconv.FormatInt(v, 10))
+	}
+
+	field(r.BucketOwner)
+	field(r.Bucket)
+	field("[" + r.Time.UTC().Format(timeLayout) + "]")
+	field(r.RemoteIP)
+	field(r.Requester)
+	field(r.RequestID)
+	field(r.Operation)
+	field((&url.URL{Path: r.Key}).EscapedPath())
+	quoted(r.RequestURI)
+	field(strconv.Itoa(r.Status))
+	field(r.ErrorCode)
+	if r.BytesSent == 0 {
+		field("-")
+	} else {
+		number(r.BytesSent)
+	}
+	number(r.ObjectSize)
+	number(r.TotalTime)
+	number(r.TurnAroundTime)
+	quoted(r.Referer)
+	quoted(r.UserAgent)
+	field(r.VersionID)
+	field(r.HostID)
+	field(r.SignatureVersion)
+	field(r.CipherSuite)
+	field(r.AuthType)
+	field(r.HostHeader)
+	field(r.TLSVersion)
+	// Access point ARN and ACL required
+	field("")
+	field("")
+	return b.String()
+}
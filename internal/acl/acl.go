@@ -0,0 +1,252 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Permissions granted by an ACL.
+const (
+	PermRead        = "READ"
+	PermWrite       = "WRITE"
+	PermReadACP     = "READ_ACP"
+	PermWriteACP    = "WRITE_ACP"
+	PermFullControl = "FULL_CONTROL"
+)
+
+// Groups an ACL may grant permissions to.
+const (
+	AllUsers           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	AuthenticatedUsers = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// Canned ACLs.
+const (
+	Private                = "private"
+	PublicRead             = "public-read"
+	PublicReadWrite        = "public-read-write"
+	AuthenticatedRead      = "authenticated-read"
+	BucketOwnerRead        = "bucket-owner-read"
+	BucketOwnerFullControl = "bucket-owner-full-control"
+)
+
+// Object ownership controls of a bucket.
+const (
+	ObjectWriter         = "ObjectWriter"
+	BucketOwnerPreferred = "BucketOwnerPreferred"
+	BucketOwnerEnforced  = "BucketOwnerEnforced"
+)
+
+// HeaderACL sets the canned ACL of a bucket or object.
+const HeaderACL = "x-amz-acl"
+
+// grantHeaders are the headers granting a permission, by permission.
+var grantHeaders = []struct {
+	header, perm string
+}{
+	{"x-amz-grant-full-control", PermFullControl},
+	{"x-amz-grant-read", PermRead},
+	{"x-amz-grant-read-acp", PermReadACP},
+	{"x-amz-grant-write", PermWrite},
+	{"x-amz-grant-write-acp", PermWriteACP},
+}
+
+var (
+	// ErrInvalidCanned is returned for an unknown canned ACL.
+	ErrInvalidCanned = errors.New("invalid canned ACL")
+	// ErrInvalidGrant is returned for a malformed grant.
+	ErrInvalidGrant = errors.New("invalid grant")
+	// ErrUnsupportedGrantee is returned for grants to email addresses and
+	// unknown groups, which have no access key to resolve to.
+	ErrUnsupportedGrantee = errors.New("unsupported grantee")
+	// ErrConflictingHeaders is returned when a request sets both a canned
+	// ACL and explicit grants.
+	ErrConflictingHeaders = errors.New("canned ACL and grant headers are exclusive")
+)
+
+// Grantee is the access key or group a permission is granted to.
+type Grantee struct {
+	// ID is the access key of the grantee
+	ID string `json:"id,omitempty"`
+	// URI is the group of the grantee, AllUsers or AuthenticatedUsers
+	URI string `json:"uri,omitempty"`
+}
+
+// Grant gives a permission to a grantee.
+type Grant struct {
+	Grantee    Grantee `json:"grantee"`
+	Permission string  `json:"permission"`
+}
+
+// ACL is the access control list of a bucket or object. Its owner has full
+// control, whatever the grants.
+type ACL struct {
+	Owner  string  `json:"owner"`
+	Grants []Grant `json:"grants"`
+}
+
+// New returns an ACL of the owner with the grants, checking that they name
+// a grantee and a known permission.
+func New(owner string, grants []Grant) (*ACL, error) {
+	for _, g := range grants {
+		switch {
+		case !validPermission(g.Permission):
+			return nil, fmt.Errorf("%w: permission %q", ErrInvalidGrant, g.Permission)
+		case g.Grantee.ID == "" && g.Grantee.URI == "":
+			return nil, fmt.Errorf("%w: missing grantee", ErrInvalidGrant)
+		case g.Grantee.URI != "" && g.Grantee.URI != AllUsers && g.Grantee.URI != AuthenticatedUsers:
+			return nil, fmt.Errorf("%w: group %s", ErrUnsupportedGrantee, g.Grantee.URI)
+		}
+	}
+	return &ACL{Owner: owner, Grants: grants}, nil
+}
+
+// Canned returns the canned ACL of an owner. bucketOwner is the owner of
+// the bucket of an object, granted access by the bucket-owner-* ACLs; it is
+// empty for buckets.
+func Canned(name, owner, bucketOwner string) (*ACL, error) {
+	a := &ACL{Owner: owner}
+	if owner != "" {
+		a.Grants = append(a.Grants, Grant{Grantee: Grantee{ID: owner}, Permission: PermFullControl})
+	}
+	switch name {
+	case Private:
+	case PublicRead:
+		a.Grants = append(a.Grants, Grant{Grantee: Grantee{URI: AllUsers}, Permission: PermRead})
+	case PublicReadWrite:
+		a.Grants = append(a.Grants,
+			Grant{Grantee: Grantee{URI: AllUsers}, Permission: PermRead},
+			Grant{Grantee: Grantee{URI: AllUsers}, Permission: PermWrite})
+	case AuthenticatedRead:
+		a.Grants = append(a.Grants, Grant{Grantee: Grantee{URI: AuthenticatedUsers}, Permission: PermRead})
+	case BucketOwnerRead:
+		if bucketOwner != "" && bucketOwner != owner {
+			a.Grants = append(a.Grants, Grant{Grantee: Grantee{ID: bucketOwner}, Permission: PermRead})
+		}
+	case BucketOwnerFullControl:
+		if bucketOwner != "" && bucketOwner != owner {
+			a.Grants = append(a.Grants, Grant{Grantee: Grantee{ID: bucketOwner}, Permission: PermFullControl})
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidCanned, name)
+	}
+	return a, nil
+}
+
+// FromHeaders returns the ACL set by the x-amz-acl or x-amz-grant-* headers
+// of a request, or nil when it sets none.
+func FromHeaders(h http.Header, owner, bucketOwner string) (*ACL, error) {
+	var grants []Grant
+	for _, gh := range grantHeaders {
+		value := h.Get(gh.header)
+		if value == "" {
+			continue
+		}
+		for _, grantee := range strings.Split(value, ",") {
+			g, err := parseGrantee(grantee)
+			if err != nil {
+				return nil, err
+			}
+			grants = append(grants, Grant{Grantee: g, Permission: gh.perm})
+		}
+	}
+
+	canned := h.Get(HeaderACL)
+	switch {
+	case canned != "" && grants != nil:
+		return nil, ErrConflictingHeaders
+	case canned != "":
+		return Canned(canned, owner, bucketOwner)
+	case grants != nil:
+		return New(owner, grants)
+	}
+	return nil, nil
+}
+
+// HasHeaders reports whether a request sets an ACL with the x-amz-acl or
+// x-amz-grant-* headers.
+func HasHeaders(h http.Header) bool {
+	if h.Get(HeaderACL) != "" {
+		return true
+	}
+	for _, gh := range grantHeaders {
+		if h.Get(gh.header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGrantee parses a grantee of a grant header, such as id="key" or
+// uri="http://acs.amazonaws.com/groups/global/AllUsers".
+func parseGrantee(s string) (Grantee, error) {
+	kind, value, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return Grantee{}, fmt.Errorf("%w: %q", ErrInvalidGrant, s)
+	}
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if value == "" {
+		return Grantee{}, fmt.Errorf("%w: %q", ErrInvalidGrant, s)
+	}
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "id":
+		return Grantee{ID: value}, nil
+	case "uri":
+		if value != AllUsers && value != AuthenticatedUsers {
+			return Grantee{}, fmt.Errorf("%w: group %s", ErrUnsupportedGrantee, value)
+		}
+		return Grantee{URI: value}, nil
+	case "emailaddress":
+		return Grantee{}, fmt.Errorf("%w: email address %s", ErrUnsupportedGrantee, value)
+	}
+	return Grantee{}, fmt.Errorf("%w: %q", ErrInvalidGrant, s)
+}
+
+// Allows reports whether the ACL gives a permission to an access key. An
+// empty access key is an anonymous caller.
+func (a *ACL) Allows(accessKey, perm string) bool {
+	if accessKey != "" && accessKey == a.Owner {
+		return true
+	}
+	for _, g := range a.Grants {
+		if g.Permission != perm && g.Permission != PermFullControl {
+			continue
+		}
+		switch {
+		case g.Grantee.URI == AllUsers:
+			return true
+		case accessKey == "":
+		case g.Grantee.URI == AuthenticatedUsers, g.Grantee.ID == accessKey:
+			return true
+		}
+	}
+	return false
+}
+
+// OwnerOnly reports whether the ACL grants nothing beyond full control to
+// its owner and the bucket owner, as buckets with BucketOwnerEnforced
+// ownership accept.
+func (a *ACL) OwnerOnly(bucketOwner string) bool {
+	for _, g := range a.Grants {
+		owner := g.Grantee.ID != "" && (g.Grantee.ID == a.Owner || g.Grantee.ID == bucketOwner)
+		if !owner || g.Permission != PermFullControl {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidOwnership reports whether s is an object ownership control.
+func ValidOwnership(s string) bool {
+	return s == ObjectWriter || s == BucketOwnerPreferred || s == BucketOwnerEnforced
+}
+
+func validPermission(p string) bool {
+	switch p {
+	case PermRead, PermWrite, PermReadACP, PermWriteACP, PermFullControl:
+		return true
+	}
+	return false
+}
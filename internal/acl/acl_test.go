@@ -0,0 +1,79 @@
+package acl
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestCanned(t *testing.T) {
+	a, err := Canned(PublicRead, "alice", "")
+	if err != nil {
+		t.Fatalf("canned ACL failed: %v", err)
+	}
+	if !a.Allows("alice", PermWriteACP) || !a.Allows("", PermRead) || a.Allows("", PermWrite) {
+		t.Fatalf("unexpected public-read ACL: %+v", a)
+	}
+
+	a, err = Canned(BucketOwnerRead, "alice", "bob")
+	if err != nil {
+		t.Fatalf("canned ACL failed: %v", err)
+	}
+	if !a.Allows("bob", PermRead) || a.Allows("bob", PermWrite) || a.Allows("carol", PermRead) {
+		t.Fatalf("unexpected bucket-owner-read ACL: %+v", a)
+	}
+
+	if _, err := Canned("world-writable", "alice", ""); !errors.Is(err, ErrInvalidCanned) {
+		t.Fatalf("expected ErrInvalidCanned, got %v", err)
+	}
+}
+
+func TestFromHeaders(t *testing.T) {
+	h := http.Header{}
+	if a, err := FromHeaders(h, "alice", ""); a != nil || err != nil || HasHeaders(h) {
+		t.Fatalf("expected no ACL without headers, got %+v %v", a, err)
+	}
+
+	h.Set("x-amz-grant-read", `id="bob", uri="`+AuthenticatedUsers+`"`)
+	h.Set("x-amz-grant-write-acp", `id=carol`)
+	a, err := FromHeaders(h, "alice", "")
+	if err != nil {
+		t.Fatalf("grant headers failed: %v", err)
+	}
+	if len(a.Grants) != 3 || !a.Allows("dave", PermRead) || a.Allows("", PermRead) ||
+		!a.Allows("carol", PermWriteACP) || a.Allows("bob", PermWrite) {
+		t.Fatalf("unexpected ACL: %+v", a)
+	}
+
+	h.Set(HeaderACL, Private)
+	if _, err := FromHeaders(h, "alice", ""); !errors.Is(err, ErrConflictingHeaders) {
+		t.Fatalf("expected ErrConflictingHeaders, got %v", err)
+	}
+
+	for value, want := range map[string]error{
+		`emailAddress="bob@example.com"`:                ErrUnsupportedGrantee,
+		`uri="http://acs.amazonaws.com/groups/s3/Logs"`: ErrUnsupportedGrantee,
+		`bob`:   ErrInvalidGrant,
+		`id=""`: ErrInvalidGrant,
+	} {
+		h := http.Header{}
+		h.Set("x-amz-grant-read", value)
+		if _, err := FromHeaders(h, "alice", ""); !errors.Is(err, want) {
+			t.Fatalf("grant %s: expected %v, got %v", value, want, err)
+		}
+	}
+}
+
+func TestOwnerOnly(t *testing.T) {
+	a, _ := Canned(BucketOwnerFullControl, "alice", "bob")
+	if !a.OwnerOnly("bob") {
+		t.Fatalf("expected bucket-owner-full-control to be owner only: %+v", a)
+	}
+	a, _ = Canned(AuthenticatedRead, "alice", "")
+	if a.OwnerOnly("") {
+		t.Fatalf("expected authenticated-read not to be owner only: %+v", a)
+	}
+	if _, err := New("alice", []Grant{{Grantee: Grantee{ID: "bob"}, Permission: "DELETE"}}); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("expected ErrInvalidGrant, got %v", err)
+	}
+}
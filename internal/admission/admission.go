@@ -0,0 +1,203 @@
+package admission
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Outcomes of a request for admission, as reported to Metrics.
+const (
+	OutcomeAdmitted  = "admitted"
+	OutcomeQueueFull = "queue_full"
+	OutcomeTimeout   = "timeout"
+	OutcomeCanceled  = "canceled"
+)
+
+var (
+	// ErrQueueFull is returned when a transfer cannot start and the queue
+	// holds as many waiting transfers as allowed.
+	ErrQueueFull = errors.New("admission queue is full")
+	// ErrTimeout is returned when a transfer waited in the queue for the
+	// queue timeout without starting.
+	ErrTimeout = errors.New("admission queue timeout")
+)
+
+// Options limits the transfers running at once.
+type Options struct {
+	// MaxTransfers is the number of concurrent transfers; zero is unlimited
+	MaxTransfers int
+	// MaxBytes is the byte budget shared by concurrent transfers; zero is
+	// unlimited
+	MaxBytes int64
+	// QueueSize is the number of transfers waiting to start; zero rejects
+	// transfers over the limits at once
+	QueueSize int
+	// QueueTimeout is how long a transfer waits to start; zero waits as
+	// long as its request
+	QueueTimeout time.Duration
+}
+
+// Enabled reports whether the options limit transfers.
+func (o Options) Enabled() bool {
+	return o.MaxTransfers > 0 || o.MaxBytes > 0
+}
+
+// Metrics is notified of the state of a Controller.
+type Metrics interface {
+	// Waiting adds delta to the number of queued transfers
+	Waiting(delta int)
+	// Waited records how long a transfer waited and the outcome
+	Waited(d time.Duration, outcome string)
+	// InUse records the transfers running and the bytes they reserved
+	InUse(transfers int, bytes int64)
+}
+
+// Controller admits transfers while they fit the concurrency limit and
+// byte budget, queueing the others in arrival order.
+type Controller struct {
+	opts    Options
+	metrics Metrics
+
+	mu        sync.Mutex
+	transfers int
+	bytes     int64
+	waiters   list.List
+}
+
+type waiter struct {
+	bytes int64
+	ready chan struct{}
+}
+
+// NewController returns a Controller enforcing opts; metrics may be nil.
+func NewController(opts Options, metrics Metrics) *Controller {
+	return &Controller{opts: opts, metrics: metrics}
+}
+
+// Acquire waits until a transfer reserving bytes may start and returns the
+// function releasing it. A transfer larger than the byte budget reserves
+// the whole budget, so that it runs alone rather than never. Returns
+// ErrQueueFull or ErrTimeout when the transfer cannot start, or the error of
+// ctx when its request ends first.
+func (c *Controller) Acquire(ctx context.Context, bytes int64) (func(), error) {
+	if c == nil || !c.opts.Enabled() {
+		return func() {}, nil
+	}
+	if bytes < 0 {
+		bytes = 0
+	}
+	if c.opts.MaxBytes > 0 && bytes > c.opts.MaxBytes {
+		bytes = c.opts.MaxBytes
+	}
+
+	c.mu.Lock()
+	if c.waiters.Len() == 0 && c.fits(bytes) {
+		c.admit(bytes)
+		c.mu.Unlock()
+		c.waited(0, OutcomeAdmitted)
+		return c.releaser(bytes), nil
+	}
+	if c.waiters.Len() >= c.opts.QueueSize {
+		c.mu.Unlock()
+		c.waited(0, OutcomeQueueFull)
+		return nil, ErrQueueFull
+	}
+	w := &waiter{bytes: bytes, ready: make(chan struct{})}
+	elem := c.waiters.PushBack(w)
+	c.mu.Unlock()
+	if c.metrics != nil {
+		c.metrics.Waiting(1)
+		defer c.metrics.Waiting(-1)
+	}
+
+	started := time.Now()
+	var timeout <-chan time.Time
+	if c.opts.QueueTimeout > 0 {
+		timer := time.NewTimer(c.opts.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	outcome := OutcomeTimeout
+	select {
+	case <-w.ready:
+		c.waited(time.Since(started), OutcomeAdmitted)
+		return c.releaser(bytes), nil
+	case <-timeout:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+		outcome = OutcomeCanceled
+	}
+
+	c.mu.Lock()
+	select {
+	case <-w.ready:
+		// Admitted while giving up
+		c.mu.Unlock()
+		c.releaser(bytes)()
+	default:
+		c.waiters.Remove(elem)
+		// The transfers behind may fit now that this one left the head
+		c.wake()
+		c.mu.Unlock()
+	}
+	c.waited(time.Since(started), outcome)
+	return nil, err
+}
+
+// fits reports whether a transfer reserving bytes may start now.
+func (c *Controller) fits(bytes int64) bool {
+	if c.opts.MaxTransfers > 0 && c.transfers >= c.opts.MaxTransfers {
+		return false
+	}
+	return c.opts.MaxBytes <= 0 || c.bytes+bytes <= c.opts.MaxBytes
+}
+
+func (c *Controller) admit(bytes int64) {
+	c.transfers++
+	c.bytes += bytes
+	if c.metrics != nil {
+		c.metrics.InUse(c.transfers, c.bytes)
+	}
+}
+
+// wake admits the queued transfers that fit, in arrival order.
+func (c *Controller) wake() {
+	for elem := c.waiters.Front(); elem != nil; elem = c.waiters.Front() {
+		w := elem.Value.(*waiter)
+		if !c.fits(w.bytes) {
+			return
+		}
+		c.waiters.Remove(elem)
+		c.admit(w.bytes)
+		close(w.ready)
+	}
+}
+
+// releaser returns the function ending a transfer, which may be called more
+// than once.
+func (c *Controller) releaser(bytes int64) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.transfers--
+			c.bytes -= bytes
+			if c.metrics != nil {
+				c.metrics.InUse(c.transfers, c.bytes)
+			}
+			c.wake()
+		})
+	}
+}
+
+func (c *Controller) waited(d time.Duration, outcome string) {
+	if c.metrics != nil {
+		c.metrics.Waited(d, outcome)
+	}
+}
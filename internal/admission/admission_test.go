@@ -0,0 +1,117 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	mu        sync.Mutex
+	queued    int
+	outcomes  map[string]int
+	transfers int
+	bytes     int64
+}
+
+func (m *recordingMetrics) Waiting(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queued += delta
+}
+
+func (m *recordingMetrics) Waited(_ time.Duration, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes[outcome]++
+}
+
+func (m *recordingMetrics) InUse(transfers int, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transfers, m.bytes = transfers, bytes
+}
+
+func TestController(t *testing.T) {
+	ctx := context.Background()
+	metrics := &recordingMetrics{outcomes: make(map[string]int)}
+	c := NewController(Options{MaxTransfers: 2, MaxBytes: 100, QueueSize: 1, QueueTimeout: 50 * time.Millisecond}, metrics)
+
+	first, err := c.Acquire(ctx, 60)
+	if err != nil {
+		t.Fatalf("first transfer: %v", err)
+	}
+	// Over the byte budget, so it queues and times out
+	if _, err := c.Acquire(ctx, 60); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// A queued transfer starts once the one ahead is released
+	admitted := make(chan error, 1)
+	go func() {
+		release, err := c.Acquire(ctx, 60)
+		if err == nil {
+			defer release()
+		}
+		admitted <- err
+	}()
+	waitFor(t, func() bool {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.queued == 1
+	})
+	if _, err := c.Acquire(ctx, 1); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+	first()
+	first() // releasing twice is harmless
+	if err := <-admitted; err != nil {
+		t.Fatalf("queued transfer: %v", err)
+	}
+
+	// A transfer larger than the budget runs alone
+	big, err := c.Acquire(ctx, 1000)
+	if err != nil {
+		t.Fatalf("big transfer: %v", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Acquire(canceled, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the request to be canceled, got %v", err)
+	}
+	big()
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if metrics.transfers != 0 || metrics.bytes != 0 || metrics.queued != 0 {
+		t.Errorf("expected nothing in use, got %+v", metrics)
+	}
+	want := map[string]int{OutcomeAdmitted: 3, OutcomeTimeout: 1, OutcomeQueueFull: 1, OutcomeCanceled: 1}
+	for outcome, n := range want {
+		if metrics.outcomes[outcome] != n {
+			t.Errorf("expected %d %s outcomes, got %d", n, outcome, metrics.outcomes[outcome])
+		}
+	}
+}
+
+func TestController_Disabled(t *testing.T) {
+	c := NewController(Options{QueueSize: 0}, nil)
+	for i := 0; i < 10; i++ {
+		if _, err := c.Acquire(context.Background(), 1<<30); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
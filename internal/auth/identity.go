@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Signature versions and authentication types of a request, as reported in
+// access logs.
+const (
+	SignatureV4         = "SigV4"
+	AuthTypeHeader      = "AuthHeader"
+	AuthTypeQueryString = "QueryString"
+)
+
+// Identity describes who sent a request. Auth fills the Identity of the
+// request context once the signature is verified; it stays empty for
+// anonymous requests and when authentication is disabled.
+type Identity struct {
+	AccessKey        string
+	SignatureVersion string
+	AuthType         string
+}
+
+type identityKey struct{}
+
+// WithIdentity returns ctx carrying the Identity Auth fills.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFrom returns the Identity of a context, if any.
+func IdentityFrom(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// recordIdentity records the verified access key of a request in its
+// Identity, if any.
+func recordIdentity(r *http.Request, accessKey string) {
+	id, ok := IdentityFrom(r.Context())
+	if !ok {
+		return
+	}
+	id.AccessKey = accessKey
+	id.SignatureVersion = SignatureV4
+	id.AuthType = AuthTypeQueryString
+	if r.Header.Get("Authorization") != "" {
+		id.AuthType = AuthTypeHeader
+	}
+}
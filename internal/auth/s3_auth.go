@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"github.com/wpnpeiris/nats-s3/internal/credential"
 	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
 	"net/http"
 	"net/url"
 	"sort"
@@ -52,80 +54,112 @@ func (iam *IdentityAccessManagement) isDisabled() bool {
 	return iam.credentialStore == nil
 }
 
+// Enabled reports whether requests are authenticated.
+func (iam *IdentityAccessManagement) Enabled() bool {
+	return !iam.isDisabled()
+}
+
 // Auth verifies AWS SigV4 (header-based and presigned URL) against the
 // configured credential. On success, calls the wrapped handler; otherwise
-// returns an S3-style XML error response.
+// returns an S3-style XML error response. Unsigned requests are passed on
+// as anonymous, with an empty access key in their Identity, for the
+// wrapped handler to authorize.
 func (iam *IdentityAccessManagement) Auth(f http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if iam.isDisabled() {
 			f(w, r)
 			return
 		}
-
-		// Support both header-based SigV4 and presigned URL SigV4.
-		hp, authErr := extractAuthHeaderParameters(r)
-		if authErr != nil {
-			model.WriteErrorResponse(w, r, authErr.code)
-			return
+		if _, ok := IdentityFrom(r.Context()); !ok {
+			r = r.WithContext(WithIdentity(r.Context(), &Identity{}))
 		}
-
-		// Look up the secret key for the access key from the credential store
-		secretKey, found := iam.credentialStore.Get(hp.accessKey)
-		if !found {
-			model.WriteErrorResponse(w, r, model.ErrInvalidAccessKeyID)
+		if !isSigned(r) {
+			f(w, r)
 			return
 		}
 
-		authErr = validateAuthHeaderParameters(hp)
-		if authErr != nil {
-			model.WriteErrorResponse(w, r, authErr.code)
+		_, span := tracing.Start(r.Context(), "IdentityAccessManagement.Auth")
+		accessKey, errCode := iam.verify(r)
+		if errCode != model.ErrNone {
+			apiError := model.GetAPIError(errCode)
+			span.SetStatus(codes.Error, apiError.Code)
+			span.End()
+			model.WriteErrorResponse(w, r, errCode)
 			return
 		}
+		span.End()
+		recordIdentity(r, accessKey)
 
-		canURI := buildCanonicalURI(r)
-		canQuery := buildCanonicalQueryString(r)
-		canHeaders := buildCanonicalHeaders(hp.signedHeaders, r)
+		f(w, r)
+	}
+}
 
-		if hp.hashedPayload == "" {
-			hp.hashedPayload = "UNSIGNED-PAYLOAD"
-		}
+// isSigned reports whether a request carries a SigV4 signature, in its
+// Authorization header or presigned URL.
+func isSigned(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.URL.Query().Has("X-Amz-Algorithm")
+}
 
-		canReq := strings.Join([]string{
-			r.Method,
-			canURI,
-			canQuery,
-			canHeaders,
-			strings.ToLower(hp.signedHeaders),
-			hp.hashedPayload,
-		}, "\n")
-		hash := sha256.Sum256([]byte(canReq))
-		canReqHashHex := hex.EncodeToString(hash[:])
-
-		// Build StringToSign
-		scope := strings.Join([]string{hp.scopeDate, hp.scopeRegion, hp.scopeService, "aws4_request"}, "/")
-		sts := strings.Join([]string{
-			"AWS4-HMAC-SHA256",
-			hp.requestTime,
-			scope,
-			canReqHashHex,
-		}, "\n")
-
-		// Derive signing key and compute signature
-		kDate := hmacSHA256([]byte("AWS4"+secretKey), hp.scopeDate)
-		kRegion := hmacSHA256(kDate, hp.scopeRegion)
-		kService := hmacSHA256(kRegion, hp.scopeService)
-		kSigning := hmacSHA256(kService, "aws4_request")
-		sigBytes := hmacSHA256(kSigning, sts)
-		calcSig := hex.EncodeToString(sigBytes)
-
-		if !hmac.Equal([]byte(hp.signature), []byte(calcSig)) {
-			model.WriteErrorResponse(w, r, model.ErrSignatureDoesNotMatch)
-			return
-		}
+// verify checks the SigV4 signature of a request, returning its access key
+// and ErrNone when it is valid.
+func (iam *IdentityAccessManagement) verify(r *http.Request) (string, model.ErrorCode) {
+	// Support both header-based SigV4 and presigned URL SigV4.
+	hp, authErr := extractAuthHeaderParameters(r)
+	if authErr != nil {
+		return "", authErr.code
+	}
 
-		f(w, r)
-		return
+	// Look up the secret key for the access key from the credential store
+	secretKey, found := iam.credentialStore.Get(hp.accessKey)
+	if !found {
+		return "", model.ErrInvalidAccessKeyID
+	}
+
+	authErr = validateAuthHeaderParameters(hp)
+	if authErr != nil {
+		return "", authErr.code
+	}
+
+	canURI := buildCanonicalURI(r)
+	canQuery := buildCanonicalQueryString(r)
+	canHeaders := buildCanonicalHeaders(hp.signedHeaders, r)
+
+	if hp.hashedPayload == "" {
+		hp.hashedPayload = "UNSIGNED-PAYLOAD"
+	}
+
+	canReq := strings.Join([]string{
+		r.Method,
+		canURI,
+		canQuery,
+		canHeaders,
+		strings.ToLower(hp.signedHeaders),
+		hp.hashedPayload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canReq))
+	canReqHashHex := hex.EncodeToString(hash[:])
+
+	// Build StringToSign
+	scope := strings.Join([]string{hp.scopeDate, hp.scopeRegion, hp.scopeService, "aws4_request"}, "/")
+	sts := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		hp.requestTime,
+		scope,
+		canReqHashHex,
+	}, "\n")
+
+	// Derive signing key and compute signature
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), hp.scopeDate)
+	kRegion := hmacSHA256(kDate, hp.scopeRegion)
+	kService := hmacSHA256(kRegion, hp.scopeService)
+	kSigning := hmacSHA256(kService, "aws4_request")
+	sigBytes := hmacSHA256(kSigning, sts)
+	calcSig := hex.EncodeToString(sigBytes)
+
+	if !hmac.Equal([]byte(hp.signature), []byte(calcSig)) {
+		return "", model.ErrSignatureDoesNotMatch
 	}
+	return hp.accessKey, model.ErrNone
 }
 
 // extractAuthHeaderParameters extracts parameters from either the Authorization header or X-Amz-* query params.
@@ -229,6 +263,9 @@ func validateAuthHeaderParameters(hp *AuthHeaderParameters) *AuthError {
 	return nil
 }
 
+// buildCanonicalURI returns the request path as the client sent and signed
+// it. Virtual-hosted-style requests carry the bucket in the Host header, so
+// their canonical URI is the object key alone, and "/" for the bucket.
 func buildCanonicalURI(r *http.Request) string {
 	canURI := r.URL.EscapedPath()
 	if canURI == "" {
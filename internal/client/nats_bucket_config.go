@@ -0,0 +1,201 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/acl"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"github.com/wpnpeiris/nats-s3/internal/website"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BucketConfigStoreName is the Key-Value bucket holding per-bucket settings
+// that have no JetStream Object Store equivalent.
+const BucketConfigStoreName = "bucket_config"
+
+// BucketConfig holds the gateway-level configuration of a bucket.
+type BucketConfig struct {
+	Encryption  *BucketEncryption   `json:"encryption,omitempty"`
+	Compression *CompressionOptions `json:"compression,omitempty"`
+	Quota       *BucketQuota        `json:"quota,omitempty"`
+	Replication *BucketReplication  `json:"replication,omitempty"`
+	Logging     *BucketLogging      `json:"logging,omitempty"`
+	Website     *website.Config     `json:"website,omitempty"`
+	// ReplicaOf names the bucket this bucket is a replica of
+	ReplicaOf string `json:"replica_of,omitempty"`
+	// Owner is the access key that created the bucket
+	Owner string `json:"owner,omitempty"`
+	// ACL is the access control list of the bucket; nil keeps every
+	// authenticated caller in full control
+	ACL *acl.ACL `json:"acl,omitempty"`
+	// ObjectOwnership is the ownership control of the objects of the bucket
+	ObjectOwnership string `json:"object_ownership,omitempty"`
+}
+
+// BucketEncryption is the default server side encryption of a bucket,
+// applied to objects written without explicit encryption headers.
+type BucketEncryption struct {
+	Algorithm string `json:"algorithm"`
+	KMSKeyID  string `json:"kms_key_id,omitempty"`
+}
+
+// BucketLogging is the bucket and key prefix the access logs of a bucket
+// are delivered to.
+type BucketLogging struct {
+	TargetBucket string `json:"target_bucket"`
+	TargetPrefix string `json:"target_prefix,omitempty"`
+}
+
+// bucketConfigStore persists BucketConfig entries keyed by bucket name. It
+// stays on the gateway connection rather than following bucket routes, as
+// the replication of a bucket must remain readable to fail its reads over
+// while the bucket's route is unreachable.
+type bucketConfigStore struct {
+	logger log.Logger
+	kv     jetstream.KeyValue
+}
+
+func newBucketConfigStore(ctx context.Context, logger log.Logger, js jetstream.JetStream) (*bucketConfigStore, error) {
+	kv, err := getOrCreateKeyValue(ctx, js, BucketConfigStoreName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access bucket config store: %w", err)
+	}
+	return &bucketConfigStore{logger: logger, kv: kv}, nil
+}
+
+// get returns the configuration of a bucket and the revision it was read
+// at. Buckets without configuration return an empty config and revision 0.
+func (b *bucketConfigStore) get(ctx context.Context, bucket string) (*BucketConfig, uint64, error) {
+	entry, err := b.kv.Get(ctx, bucket)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return &BucketConfig{}, 0, nil
+		}
+		logging.Error(logging.WithContext(ctx, b.logger), "msg", "Error at bucketConfigStore.get when kv.Get()", "err", err)
+		return nil, 0, err
+	}
+	var cfg BucketConfig
+	if err := json.Unmarshal(entry.Value(), &cfg); err != nil {
+		logging.Error(logging.WithContext(ctx, b.logger), "msg", "Error at bucketConfigStore.get when json.Unmarshal()", "err", err)
+		return nil, 0, err
+	}
+	return &cfg, entry.Revision(), nil
+}
+
+// update applies fn to the configuration of a bucket and stores the result,
+// retrying when another writer updated the configuration concurrently.
+func (b *bucketConfigStore) update(ctx context.Context, bucket string, fn func(cfg *BucketConfig) error) error {
+	for {
+		cfg, rev, err := b.get(ctx, bucket)
+		if err != nil {
+			return err
+		}
+		if err := fn(cfg); err != nil {
+			return err
+		}
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		if rev == 0 {
+			_, err = b.kv.Create(ctx, bucket, data)
+		} else {
+			_, err = b.kv.Update(ctx, bucket, data, rev)
+		}
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			logging.Debug(logging.WithContext(ctx, b.logger), "msg", "Bucket config changed concurrently, retrying", "bucket", bucket)
+			continue
+		}
+		logging.Error(logging.WithContext(ctx, b.logger), "msg", "Error at bucketConfigStore.update", "err", err)
+		return err
+	}
+}
+
+// quotas returns the quota of every bucket that has one.
+func (b *bucketConfigStore) quotas(ctx context.Context) (map[string]*BucketQuota, error) {
+	keys, err := b.kv.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*BucketQuota)
+	for bucket := range keys.Keys() {
+		cfg, _, err := b.get(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Quota != nil {
+			res[bucket] = cfg.Quota
+		}
+	}
+	return res, nil
+}
+
+// remove deletes the configuration of a deleted bucket.
+func (b *bucketConfigStore) remove(ctx context.Context, bucket string) {
+	err := b.kv.Purge(ctx, bucket)
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		logging.Warn(logging.WithContext(ctx, b.logger), "msg", "Failed to delete bucket config", "bucket", bucket, "err", err)
+	}
+}
+
+// GetBucketConfig returns the gateway-level configuration of a bucket.
+func (c *NatsObjectClient) GetBucketConfig(ctx context.Context, bucket string) (_ *BucketConfig, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetBucketConfig", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	logging.Debug(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Get bucket config: %s", bucket))
+	if err := c.checkBucket(ctx, bucket); err != nil {
+		return nil, err
+	}
+	cfg, _, err := c.bucketConfigs.get(ctx, bucket)
+	return cfg, err
+}
+
+// UpdateBucketConfig applies fn to the gateway-level configuration of a
+// bucket and persists the result.
+func (c *NatsObjectClient) UpdateBucketConfig(ctx context.Context, bucket string, fn func(cfg *BucketConfig) error) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.UpdateBucketConfig", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Update bucket config: %s", bucket))
+	if err := c.checkBucket(ctx, bucket); err != nil {
+		return err
+	}
+	var quotaRemoved bool
+	err = c.bucketConfigs.update(ctx, bucket, func(cfg *BucketConfig) error {
+		hadQuota := cfg.Quota != nil
+		if err := fn(cfg); err != nil {
+			return err
+		}
+		quotaRemoved = hadQuota && cfg.Quota == nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Writes are not tracked without a quota, so a later quota recounts
+	if quotaRemoved {
+		c.usage.remove(ctx, bucket)
+	}
+	return nil
+}
+
+// checkBucket returns ErrBucketNotFound when the bucket does not exist.
+func (c *NatsObjectClient) checkBucket(ctx context.Context, bucket string) error {
+	_, err := c.bucketJS(bucket).ObjectStore(ctx, bucket)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			return ErrBucketNotFound
+		}
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at checkBucket", "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var ErrInvalidBucketOptions = errors.New("invalid bucket options")
+
+// JetStream error codes reported when a stream cannot be placed as requested.
+const (
+	jsClusterNoPeersErrCode        = 10005
+	jsInsufficientResourcesErrCode = 10023
+	jsReplicasNotSupportedErrCode  = 10074
+)
+
+// BucketOptions are the JetStream settings of a new bucket. Zero values use
+// the gateway defaults: file storage and the configured replica count.
+type BucketOptions struct {
+	Storage     jetstream.StorageType
+	Replicas    int
+	MaxBytes    int64
+	TTL         time.Duration
+	Compression bool
+	Description string
+	Placement   *jetstream.Placement
+}
+
+// Validate reports whether the options can be applied to a new bucket.
+func (o BucketOptions) Validate() error {
+	if o.Storage != jetstream.FileStorage && o.Storage != jetstream.MemoryStorage {
+		return fmt.Errorf("%w: unknown storage type", ErrInvalidBucketOptions)
+	}
+	if o.Replicas < 0 || o.Replicas > 5 {
+		return fmt.Errorf("%w: replicas must be between 1 and 5", ErrInvalidBucketOptions)
+	}
+	if o.MaxBytes < 0 {
+		return fmt.Errorf("%w: max bytes must not be negative", ErrInvalidBucketOptions)
+	}
+	if o.TTL < 0 {
+		return fmt.Errorf("%w: ttl must not be negative", ErrInvalidBucketOptions)
+	}
+	return nil
+}
+
+// BucketInfo describes the object store backing a bucket.
+type BucketInfo struct {
+	BucketOptions
+	Name   string
+	Size   uint64
+	Sealed bool
+}
+
+// GetBucketInfo returns the settings and usage of a bucket.
+func (c *NatsObjectClient) GetBucketInfo(ctx context.Context, bucket string) (_ *BucketInfo, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetBucketInfo", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	logging.Debug(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Get bucket info: %s", bucket))
+	os, err := c.bucketJS(bucket).ObjectStore(ctx, bucket)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			return nil, ErrBucketNotFound
+		}
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetBucketInfo", "err", err)
+		return nil, err
+	}
+	status, err := os.Status(ctx)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetBucketInfo", "err", err)
+		return nil, err
+	}
+	info := &BucketInfo{
+		BucketOptions: BucketOptions{
+			Storage:     status.Storage(),
+			Replicas:    status.Replicas(),
+			TTL:         status.TTL(),
+			Compression: status.IsCompressed(),
+			Description: status.Description(),
+		},
+		Name:   bucket,
+		Size:   status.Size(),
+		Sealed: status.Sealed(),
+	}
+	if bs, ok := status.(*jetstream.ObjectBucketStatus); ok && bs.StreamInfo() != nil {
+		// Unlimited buckets report -1
+		info.MaxBytes = max(bs.StreamInfo().Config.MaxBytes, 0)
+		info.Placement = bs.StreamInfo().Config.Placement
+	}
+	return info, nil
+}
+
+// placementError reports whether err is JetStream refusing to place a stream
+// with the requested replicas or placement.
+func placementError(err error) bool {
+	var apiErr *jetstream.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode {
+	case jsClusterNoPeersErrCode, jsInsufficientResourcesErrCode, jsReplicasNotSupportedErrCode:
+		return true
+	}
+	return false
+}
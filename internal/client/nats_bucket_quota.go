@@ -0,0 +1,427 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var ErrQuotaExceeded = errors.New("bucket quota exceeded")
+
+// JetStream error codes reported when a stream or server has no room left.
+const (
+	jsStorageResourcesExceededErrCode = 10047
+	jsStreamStoreFailedErrCode        = 10077
+)
+
+// BucketQuota limits the logical size and object count of a bucket. Writes
+// crossing a hard limit are rejected; crossing a soft limit is only reported.
+// Zero disables a limit.
+type BucketQuota struct {
+	HardBytes   int64 `json:"hard_bytes,omitempty"`
+	SoftBytes   int64 `json:"soft_bytes,omitempty"`
+	HardObjects int64 `json:"hard_objects,omitempty"`
+	SoftObjects int64 `json:"soft_objects,omitempty"`
+}
+
+// BucketUsageStoreName is the Key-Value bucket holding the usage counters
+// of buckets with a quota.
+const BucketUsageStoreName = "bucket_usage"
+
+// BucketUsage is the logical size and object count of a bucket. Bytes
+// includes the parts of multipart uploads still in progress.
+type BucketUsage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// SoftExceeded reports whether usage is past a soft limit of the quota.
+func (q *BucketQuota) SoftExceeded(u BucketUsage) bool {
+	return (q.SoftBytes > 0 && u.Bytes > q.SoftBytes) ||
+		(q.SoftObjects > 0 && u.Objects > q.SoftObjects)
+}
+
+// hardExceeded reports whether usage is past a hard limit of the quota.
+func (q *BucketQuota) hardExceeded(u BucketUsage) bool {
+	return (q.HardBytes > 0 && u.Bytes > q.HardBytes) ||
+		(q.HardObjects > 0 && u.Objects > q.HardObjects)
+}
+
+// remaining returns the bytes that may be added to usage before the hard
+// byte limit is crossed, or -1 without a hard byte limit.
+func (q *BucketQuota) remaining(u BucketUsage) int64 {
+	if q.HardBytes <= 0 {
+		return -1
+	}
+	return max(q.HardBytes-u.Bytes, 0)
+}
+
+// checkQuota returns ErrQuotaExceeded when usage after a write is past a
+// hard limit of quota, and logs crossing a soft limit.
+func checkQuota(ctx context.Context, logger log.Logger, bucket string, quota *BucketQuota, after BucketUsage) error {
+	if quota.hardExceeded(after) {
+		logging.Warn(logging.WithContext(ctx, logger), "msg", "Bucket hard quota exceeded", "bucket", bucket,
+			"bytes", after.Bytes, "objects", after.Objects)
+		return ErrQuotaExceeded
+	}
+	if quota.SoftExceeded(after) {
+		logging.Warn(logging.WithContext(ctx, logger), "msg", "Bucket soft quota exceeded", "bucket", bucket,
+			"bytes", after.Bytes, "softBytes", quota.SoftBytes,
+			"objects", after.Objects, "softObjects", quota.SoftObjects)
+	}
+	return nil
+}
+
+// GetBucketUsage returns the logical size and object count of a bucket,
+// counting multipart objects by their assembled size.
+func (c *NatsObjectClient) GetBucketUsage(ctx context.Context, bucket string) (_ *BucketUsage, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetBucketUsage", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	usage, err := c.usage.current(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// CheckBucketQuota returns ErrQuotaExceeded when writing size bytes to key
+// would take the bucket past a hard limit of its quota. An object replacing
+// key is accounted for by its size difference, and an empty key checks an
+// additional object without replacing any. A negative size checks the object
+// count only. Crossing a soft limit is logged.
+//
+// The returned allowance is the number of bytes the write may take before
+// the hard byte limit is crossed, or -1 when the bucket has none; writes
+// read their data through QuotaReader with it so that the bytes actually
+// written are held to the limit.
+func (c *NatsObjectClient) CheckBucketQuota(ctx context.Context, bucket string, key string, size int64) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.CheckBucketQuota", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	cfg, err := c.GetBucketConfig(ctx, bucket)
+	if err != nil {
+		return 0, err
+	}
+	quota := cfg.Quota
+	if quota == nil {
+		return -1, nil
+	}
+	// Usage of the object being replaced
+	var replaced BucketUsage
+	if key != "" {
+		os, err := c.bucketJS(bucket).ObjectStore(ctx, bucket)
+		if err != nil {
+			logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at CheckBucketQuota", "err", err)
+			return 0, err
+		}
+		prev, err := os.GetInfo(ctx, key)
+		if err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at CheckBucketQuota", "err", err)
+			return 0, err
+		}
+		replaced = objectUsage(prev)
+	}
+
+	usage, err := c.usage.check(ctx, bucket, func(usage BucketUsage) error {
+		after := BucketUsage{Bytes: usage.Bytes - replaced.Bytes + max(size, 0), Objects: usage.Objects - replaced.Objects + 1}
+		return checkQuota(ctx, c.logger, bucket, quota, after)
+	})
+	if err != nil {
+		return 0, err
+	}
+	usage.Bytes -= replaced.Bytes
+	return quota.remaining(usage), nil
+}
+
+// QuotaReader limits r to the allowance returned by CheckBucketQuota,
+// failing with ErrQuotaExceeded once more data is read. A negative
+// allowance leaves r unlimited.
+func QuotaReader(r io.Reader, allowance int64) io.Reader {
+	if allowance < 0 {
+		return r
+	}
+	return &quotaReader{r: r, n: allowance}
+}
+
+type quotaReader struct {
+	r io.Reader
+	n int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	// Read one byte past the allowance to notice data beyond it
+	if int64(len(p)) > q.n+1 {
+		p = p[:q.n+1]
+	}
+	n, err := q.r.Read(p)
+	q.n -= int64(n)
+	if q.n < 0 {
+		return 0, ErrQuotaExceeded
+	}
+	return n, err
+}
+
+// Usage counters are counted again once they are older than
+// usageReconcileInterval, correcting the drift of objects expired by a
+// bucket TTL or written to the object store around the gateway. A check
+// refused by a counter older than usageRecountInterval counts the bucket
+// before the refusal stands.
+const (
+	usageReconcileInterval = 5 * time.Minute
+	usageRecountInterval   = 10 * time.Second
+)
+
+// bucketUsageStore keeps a usage counter per bucket with a quota, so that
+// quota checks do not list the bucket. A counter is created by counting the
+// bucket the first time its usage is needed and is then adjusted by every
+// write of the gateway; buckets without a counter are not tracked. Counters
+// live in the JetStream context each bucket is routed to.
+type bucketUsageStore struct {
+	logger    log.Logger
+	routes    *BucketRouter
+	reconcile time.Duration
+	recount   time.Duration
+}
+
+// usageCounter is the stored counter of a bucket, with the time the bucket
+// was last counted. Writes finished before that time are part of the count
+// and do not adjust it.
+type usageCounter struct {
+	BucketUsage
+	Counted time.Time `json:"counted"`
+}
+
+func newBucketUsageStore(ctx context.Context, logger log.Logger, routes *BucketRouter) (*bucketUsageStore, error) {
+	if _, err := routes.contextKeyValue(ctx, routes.js, BucketUsageStoreName); err != nil {
+		return nil, err
+	}
+	return &bucketUsageStore{
+		logger:    logger,
+		routes:    routes,
+		reconcile: usageReconcileInterval,
+		recount:   usageRecountInterval,
+	}, nil
+}
+
+// current returns the usage of a bucket, counting the bucket when it has no
+// counter yet or its counter is due to be reconciled.
+func (u *bucketUsageStore) current(ctx context.Context, bucket string) (BucketUsage, error) {
+	counter, err := u.load(ctx, bucket, u.reconcile)
+	return counter.BucketUsage, err
+}
+
+// check returns the usage of a bucket once fn accepts it. When fn refuses a
+// counter older than the recount interval, the bucket is counted again and
+// fn decides on the fresh usage, so that a drifted counter does not refuse
+// writes the bucket has room for.
+func (u *bucketUsageStore) check(ctx context.Context, bucket string, fn func(BucketUsage) error) (BucketUsage, error) {
+	counter, err := u.load(ctx, bucket, u.reconcile)
+	if err != nil {
+		return BucketUsage{}, err
+	}
+	err = fn(counter.BucketUsage)
+	if !errors.Is(err, ErrQuotaExceeded) || time.Since(counter.Counted) < u.recount {
+		return counter.BucketUsage, err
+	}
+	counter, err = u.load(ctx, bucket, u.recount)
+	if err != nil {
+		return BucketUsage{}, err
+	}
+	return counter.BucketUsage, fn(counter.BucketUsage)
+}
+
+// load returns the counter of a bucket, counting the bucket when it has no
+// counter or the counter is older than maxAge. A count replaces the counter
+// only at the revision it was read at, so that a concurrent recount or
+// write is not overwritten but read again.
+func (u *bucketUsageStore) load(ctx context.Context, bucket string, maxAge time.Duration) (usageCounter, error) {
+	kv, err := u.routes.KeyValue(ctx, bucket, BucketUsageStoreName)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, u.logger), "msg", "Error at bucketUsageStore.load", "err", err)
+		return usageCounter{}, err
+	}
+	for {
+		var revision uint64
+		entry, err := kv.Get(ctx, bucket)
+		if err == nil {
+			var counter usageCounter
+			if err := json.Unmarshal(entry.Value(), &counter); err != nil {
+				logging.Error(logging.WithContext(ctx, u.logger), "msg", "Error at bucketUsageStore.load when json.Unmarshal()", "err", err)
+				return usageCounter{}, err
+			}
+			if time.Since(counter.Counted) < maxAge {
+				return counter, nil
+			}
+			revision = entry.Revision()
+		} else if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			logging.Error(logging.WithContext(ctx, u.logger), "msg", "Error at bucketUsageStore.load when kv.Get()", "err", err)
+			return usageCounter{}, err
+		}
+
+		counter, err := u.count(ctx, bucket)
+		if err != nil {
+			return usageCounter{}, err
+		}
+		data, err := json.Marshal(counter)
+		if err != nil {
+			return usageCounter{}, err
+		}
+		if revision == 0 {
+			_, err = kv.Create(ctx, bucket, data)
+		} else {
+			_, err = kv.Update(ctx, bucket, data, revision)
+		}
+		if err == nil {
+			return counter, nil
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			logging.Error(logging.WithContext(ctx, u.logger), "msg", "Error at bucketUsageStore.load when storing the count", "err", err)
+			return usageCounter{}, err
+		}
+		// Another writer changed the counter while the bucket was counted
+	}
+}
+
+// count sums the logical size of the objects of a bucket and of the parts
+// of its uploads in progress. Parts of completed uploads are counted by the
+// size of their object. The counter is stamped with the time the listing
+// started; writes finishing while the bucket is listed may be missed or
+// counted twice until the next reconciliation.
+func (u *bucketUsageStore) count(ctx context.Context, bucket string) (usageCounter, error) {
+	logging.Info(logging.WithContext(ctx, u.logger), "msg", fmt.Sprintf("Count bucket usage: %s", bucket))
+	os, err := u.routes.JetStream(bucket).ObjectStore(ctx, bucket)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			return usageCounter{}, ErrBucketNotFound
+		}
+		logging.Error(logging.WithContext(ctx, u.logger), "msg", "Error at bucketUsageStore.count", "err", err)
+		return usageCounter{}, err
+	}
+	counted := time.Now()
+	objects, err := os.List(ctx)
+	if err != nil && !errors.Is(err, jetstream.ErrNoObjectsFound) {
+		logging.Error(logging.WithContext(ctx, u.logger), "msg", "Error at bucketUsageStore.count", "err", err)
+		return usageCounter{}, err
+	}
+
+	var usage BucketUsage
+	completed := make(map[string]bool)
+	for _, info := range objects {
+		if isPartKey(info.Name) {
+			continue
+		}
+		if IsManifest(info) {
+			completed[layoutUploadID(info)] = true
+		}
+		resolveObjectInfo(info)
+		usage.Bytes += int64(info.Size)
+		usage.Objects++
+	}
+	for _, info := range objects {
+		if isPartKey(info.Name) && !completed[partUploadID(info.Name)] {
+			usage.Bytes += int64(info.Size)
+		}
+	}
+	return usageCounter{BucketUsage: usage, Counted: counted}, nil
+}
+
+// add adjusts the counter of a tracked bucket by delta, retrying when
+// another writer updated it concurrently. The write delta accounts for has
+// already happened, finishing at written, so failures are only logged. A
+// counter counted after written already holds the write and is left alone.
+func (u *bucketUsageStore) add(ctx context.Context, bucket string, delta BucketUsage, written time.Time) {
+	if delta == (BucketUsage{}) {
+		return
+	}
+	kv, err := u.routes.KeyValue(ctx, bucket, BucketUsageStoreName)
+	if err != nil {
+		logging.Warn(logging.WithContext(ctx, u.logger), "msg", "Failed to update bucket usage", "bucket", bucket, "err", err)
+		return
+	}
+	for {
+		entry, err := kv.Get(ctx, bucket)
+		if err != nil {
+			if !errors.Is(err, jetstream.ErrKeyNotFound) {
+				logging.Warn(logging.WithContext(ctx, u.logger), "msg", "Failed to update bucket usage", "bucket", bucket, "err", err)
+			}
+			return
+		}
+		var counter usageCounter
+		if err := json.Unmarshal(entry.Value(), &counter); err != nil {
+			logging.Warn(logging.WithContext(ctx, u.logger), "msg", "Failed to update bucket usage", "bucket", bucket, "err", err)
+			return
+		}
+		if counter.Counted.After(written) {
+			return
+		}
+		counter.Bytes = max(counter.Bytes+delta.Bytes, 0)
+		counter.Objects = max(counter.Objects+delta.Objects, 0)
+		data, err := json.Marshal(counter)
+		if err != nil {
+			return
+		}
+		_, err = kv.Update(ctx, bucket, data, entry.Revision())
+		if err == nil {
+			return
+		}
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			logging.Debug(logging.WithContext(ctx, u.logger), "msg", "Bucket usage changed concurrently, retrying", "bucket", bucket)
+			continue
+		}
+		logging.Warn(logging.WithContext(ctx, u.logger), "msg", "Failed to update bucket usage", "bucket", bucket, "err", err)
+		return
+	}
+}
+
+// remove drops the counter of a bucket, which stops tracking it until its
+// usage is needed again.
+func (u *bucketUsageStore) remove(ctx context.Context, bucket string) {
+	kv, err := u.routes.KeyValue(ctx, bucket, BucketUsageStoreName)
+	if err != nil {
+		logging.Warn(logging.WithContext(ctx, u.logger), "msg", "Failed to delete bucket usage", "bucket", bucket, "err", err)
+		return
+	}
+	err = kv.Purge(ctx, bucket)
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		logging.Warn(logging.WithContext(ctx, u.logger), "msg", "Failed to delete bucket usage", "bucket", bucket, "err", err)
+	}
+}
+
+// objectUsage is the usage of a single object, or zero for a missing one.
+func objectUsage(info *jetstream.ObjectInfo) BucketUsage {
+	if info == nil {
+		return BucketUsage{}
+	}
+	size := info.Size
+	if v, ok := info.Metadata[MetaObjectSize]; ok {
+		if s, err := strconv.ParseUint(v, 10, 64); err == nil {
+			size = s
+		}
+	}
+	return BucketUsage{Bytes: int64(size), Objects: 1}
+}
+
+// storeError reports a write refused because the stream or server is full as
+// ErrQuotaExceeded, and returns other errors unchanged.
+func storeError(err error) error {
+	var apiErr *jetstream.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch {
+	case apiErr.ErrorCode == jsStorageResourcesExceededErrCode,
+		apiErr.ErrorCode == jsStreamStoreFailedErrCode && strings.Contains(apiErr.Description, "exceeded"):
+		return fmt.Errorf("%w: %v", ErrQuotaExceeded, err)
+	}
+	return err
+}
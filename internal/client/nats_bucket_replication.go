@@ -0,0 +1,477 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var ErrInvalidReplication = errors.New("invalid replication configuration")
+
+// Replication status of an object, as reported in x-amz-replication-status.
+const (
+	ReplicationStatusCompleted = "COMPLETED"
+	ReplicationStatusPending   = "PENDING"
+	ReplicationStatusFailed    = "FAILED"
+	ReplicationStatusReplica   = "REPLICA"
+)
+
+// BucketReplication replicates the object store of a bucket into a bucket in
+// another JetStream domain or cluster. A destination with the name of the
+// bucket itself is a mirror, which needs a different domain; any other
+// destination sources the bucket's stream with its subjects renamed.
+type BucketReplication struct {
+	ID                string `json:"id,omitempty"`
+	DestinationBucket string `json:"destination_bucket"`
+	// Domain is the JetStream domain of the destination; empty is the
+	// domain the gateway is connected to.
+	Domain string `json:"domain,omitempty"`
+	// Cluster places the destination stream in a cluster of its domain.
+	Cluster string `json:"cluster,omitempty"`
+	// ReadFailover serves reads from the destination while the bucket is
+	// unavailable.
+	ReadFailover bool `json:"read_failover,omitempty"`
+}
+
+// mirror reports whether the destination mirrors the bucket's stream.
+func (r *BucketReplication) mirror(bucket string) bool {
+	return r.DestinationBucket == bucket
+}
+
+// GetBucketReplication returns the replication of a bucket, or nil when the
+// bucket is not replicated.
+func (c *NatsObjectClient) GetBucketReplication(ctx context.Context, bucket string) (_ *BucketReplication, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetBucketReplication", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	cfg, err := c.GetBucketConfig(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Replication, nil
+}
+
+// PutBucketReplication creates or updates the stream replicating a bucket
+// into its destination and records the replication in the bucket config.
+func (c *NatsObjectClient) PutBucketReplication(ctx context.Context, bucket string, rep BucketReplication) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.PutBucketReplication", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Put bucket replication: %s -> %s/%s", bucket, rep.Domain, rep.DestinationBucket))
+	if rep.DestinationBucket == "" {
+		return fmt.Errorf("%w: missing destination bucket", ErrInvalidReplication)
+	}
+	src, err := c.bucketJS(bucket).Stream(ctx, objectStreamName(bucket))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrStreamNotFound) {
+			return ErrBucketNotFound
+		}
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutBucketReplication", "err", err)
+		return err
+	}
+	srcDomain, err := c.jetStreamDomain(ctx, c.bucketJS(bucket))
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutBucketReplication", "err", err)
+		return err
+	}
+	js, err := c.replicaJetStream(&rep)
+	if err != nil {
+		return err
+	}
+	dstDomain, err := c.jetStreamDomain(ctx, js)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutBucketReplication", "err", err)
+		return err
+	}
+	local := dstDomain == srcDomain
+	if local && rep.mirror(bucket) {
+		return fmt.Errorf("%w: a bucket cannot replicate into itself", ErrInvalidReplication)
+	}
+	if !local && srcDomain == "" {
+		return fmt.Errorf("%w: replicating to another domain needs the bucket's domain to be named", ErrInvalidReplication)
+	}
+
+	srcCfg := src.CachedInfo().Config
+	cfg := jetstream.StreamConfig{
+		Name:        objectStreamName(rep.DestinationBucket),
+		Description: fmt.Sprintf("Replica of bucket %s", bucket),
+		Storage:     srcCfg.Storage,
+		Replicas:    srcCfg.Replicas,
+		MaxBytes:    srcCfg.MaxBytes,
+		MaxAge:      srcCfg.MaxAge,
+		Compression: srcCfg.Compression,
+		Discard:     jetstream.DiscardNew,
+		AllowDirect: true,
+	}
+	if rep.Cluster != "" {
+		cfg.Placement = &jetstream.Placement{Cluster: rep.Cluster}
+	}
+	source := &jetstream.StreamSource{Name: src.CachedInfo().Config.Name}
+	if !local {
+		source.Domain = srcDomain
+	}
+	if rep.mirror(bucket) {
+		cfg.Mirror = source
+		cfg.MirrorDirect = true
+	} else {
+		cfg.Subjects = []string{
+			fmt.Sprintf("$O.%s.C.>", rep.DestinationBucket),
+			fmt.Sprintf("$O.%s.M.>", rep.DestinationBucket),
+		}
+		cfg.AllowRollup = true
+		source.SubjectTransforms = []jetstream.SubjectTransformConfig{{
+			Source:      fmt.Sprintf("$O.%s.>", bucket),
+			Destination: fmt.Sprintf("$O.%s.>", rep.DestinationBucket),
+		}}
+		cfg.Sources = []*jetstream.StreamSource{source}
+	}
+
+	if _, err := js.CreateOrUpdateStream(ctx, cfg); err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutBucketReplication", "err", err)
+		if placementError(err) {
+			return fmt.Errorf("%w: %v", ErrInvalidReplication, err)
+		}
+		return err
+	}
+
+	// Replicas served by this gateway report their objects as replicas
+	if rep.Domain == "" {
+		err = c.bucketConfigs.update(ctx, rep.DestinationBucket, func(cfg *BucketConfig) error {
+			cfg.ReplicaOf = bucket
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.bucketConfigs.update(ctx, bucket, func(cfg *BucketConfig) error {
+		cfg.Replication = &rep
+		return nil
+	})
+}
+
+// DeleteBucketReplication stops replicating a bucket. The destination keeps
+// the objects replicated so far and becomes a bucket of its own.
+func (c *NatsObjectClient) DeleteBucketReplication(ctx context.Context, bucket string) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.DeleteBucketReplication", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Delete bucket replication: %s", bucket))
+	rep, err := c.GetBucketReplication(ctx, bucket)
+	if err != nil || rep == nil {
+		return err
+	}
+	js, err := c.replicaJetStream(rep)
+	if err != nil {
+		return err
+	}
+	stream, err := js.Stream(ctx, objectStreamName(rep.DestinationBucket))
+	switch {
+	case errors.Is(err, jetstream.ErrStreamNotFound):
+	case err != nil:
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at DeleteBucketReplication", "err", err)
+		return err
+	default:
+		cfg := stream.CachedInfo().Config
+		if cfg.Mirror != nil || len(cfg.Sources) > 0 {
+			cfg.Mirror = nil
+			cfg.Sources = nil
+			if _, err := js.UpdateStream(ctx, cfg); err != nil {
+				logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at DeleteBucketReplication", "err", err)
+				return err
+			}
+		}
+	}
+
+	replica, _, err := c.bucketConfigs.get(ctx, rep.DestinationBucket)
+	if err != nil {
+		return err
+	}
+	if replica.ReplicaOf == bucket {
+		err = c.bucketConfigs.update(ctx, rep.DestinationBucket, func(cfg *BucketConfig) error {
+			cfg.ReplicaOf = ""
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.bucketConfigs.update(ctx, bucket, func(cfg *BucketConfig) error {
+		cfg.Replication = nil
+		return nil
+	})
+}
+
+// ObjectReplicationStatus reports the replication status of an object:
+// COMPLETED once the replica has caught up with the bucket's stream past the
+// object, PENDING until then, FAILED when the progress of the replica cannot
+// be read and REPLICA for objects of a replica bucket. The parts of a
+// multipart object precede its manifest in the stream, so they are
+// replicated by then too. Objects of buckets that are not replicated have no
+// status.
+//
+// The status is answered from the progress last read for the bucket; reading
+// it again happens in the background, so that reads never wait for the
+// replica.
+func (c *NatsObjectClient) ObjectReplicationStatus(ctx context.Context, bucket string, info *jetstream.ObjectInfo) string {
+	cfg, _, err := c.bucketConfigs.get(ctx, bucket)
+	if err != nil {
+		return ""
+	}
+	if cfg.ReplicaOf != "" {
+		return ReplicationStatusReplica
+	}
+	rep := cfg.Replication
+	if rep == nil {
+		return ""
+	}
+	progress, stale := c.replication.get(bucket, rep)
+	if stale {
+		go c.refreshReplication(bucket, rep)
+	}
+	switch {
+	case !progress.synced.IsZero() && !info.ModTime.After(progress.synced):
+		return ReplicationStatusCompleted
+	case progress.failed:
+		return ReplicationStatusFailed
+	}
+	return ReplicationStatusPending
+}
+
+// Replication progress is read again once it is older than
+// replicationRefreshInterval, waiting at most replicationRefreshTimeout for
+// the bucket and its replica.
+const (
+	replicationRefreshInterval = 500 * time.Millisecond
+	replicationRefreshTimeout  = 5 * time.Second
+)
+
+// replicationTracker holds how far the replica of each replicated bucket has
+// caught up.
+type replicationTracker struct {
+	mu       sync.Mutex
+	progress map[string]*replicaProgress
+}
+
+type replicaProgress struct {
+	// synced is the time of the newest message of the bucket's stream the
+	// replica holds; objects written up to it are replicated.
+	synced     time.Time
+	failed     bool
+	checked    time.Time
+	refreshing bool
+}
+
+// replicationKey identifies the replication of a bucket into a destination,
+// so that a new destination starts without progress.
+func replicationKey(bucket string, rep *BucketReplication) string {
+	return bucket + "/" + rep.Domain + "/" + rep.DestinationBucket
+}
+
+// get returns the progress of a replication, and whether the caller is to
+// refresh it.
+func (t *replicationTracker) get(bucket string, rep *BucketReplication) (replicaProgress, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.progress == nil {
+		t.progress = make(map[string]*replicaProgress)
+	}
+	key := replicationKey(bucket, rep)
+	p, ok := t.progress[key]
+	if !ok {
+		p = &replicaProgress{}
+		t.progress[key] = p
+	}
+	stale := !p.refreshing && time.Since(p.checked) >= replicationRefreshInterval
+	if stale {
+		p.refreshing = true
+	}
+	return *p, stale
+}
+
+// update records the progress read for a replication. Progress only moves
+// forward, as a replica keeps what it has received.
+func (t *replicationTracker) update(bucket string, rep *BucketReplication, synced time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.progress[replicationKey(bucket, rep)]
+	if !ok {
+		return
+	}
+	p.refreshing = false
+	p.checked = time.Now()
+	p.failed = err != nil
+	if synced.After(p.synced) {
+		p.synced = synced
+	}
+}
+
+// refreshReplication reads the progress of the replica of a bucket.
+func (c *NatsObjectClient) refreshReplication(bucket string, rep *BucketReplication) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicationRefreshTimeout)
+	defer cancel()
+	synced, err := c.replicaSynced(ctx, bucket, rep)
+	if err != nil {
+		logging.Debug(c.logger, "msg", "Replica unavailable", "bucket", bucket, "err", err)
+	}
+	c.replication.update(bucket, rep, synced, err)
+}
+
+// replicaSynced returns the time of the newest message of the bucket's
+// stream its replica holds, or zero when that message is no longer in the
+// bucket's stream. A mirror keeps the sequences of the bucket's stream, while
+// a sourced replica records them in a header of each message.
+func (c *NatsObjectClient) replicaSynced(ctx context.Context, bucket string, rep *BucketReplication) (time.Time, error) {
+	src, err := c.bucketJS(bucket).Stream(ctx, objectStreamName(bucket))
+	if err != nil {
+		return time.Time{}, err
+	}
+	js, err := c.replicaJetStream(rep)
+	if err != nil {
+		return time.Time{}, err
+	}
+	dst, err := js.Stream(ctx, objectStreamName(rep.DestinationBucket))
+	if err != nil {
+		return time.Time{}, err
+	}
+	srcState, dstState := src.CachedInfo().State, dst.CachedInfo().State
+
+	seq := dstState.LastSeq
+	if !rep.mirror(bucket) && seq > 0 {
+		msg, err := dst.GetMsg(ctx, seq)
+		if err != nil {
+			return time.Time{}, err
+		}
+		seq = sourcedSequence(msg.Header)
+	}
+	if seq >= srcState.LastSeq {
+		return srcState.LastTime, nil
+	}
+	if seq == 0 {
+		return time.Time{}, nil
+	}
+	msg, err := src.GetMsg(ctx, seq)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return msg.Time, nil
+}
+
+// streamSourceHeader records the origin stream and sequence of a sourced
+// message.
+const streamSourceHeader = "Nats-Stream-Source"
+
+// sourcedSequence returns the sequence in its origin stream of a message
+// sourced into another stream, or zero for messages written to the stream
+// itself.
+func sourcedSequence(h nats.Header) uint64 {
+	fields := strings.Fields(h.Get(streamSourceHeader))
+	if len(fields) < 2 {
+		return 0
+	}
+	seq, _ := strconv.ParseUint(fields[1], 10, 64)
+	return seq
+}
+
+// readStore opens the object store of a bucket for reading. When the bucket
+// cannot be reached and replicates with read failover, its replica is
+// opened. A bucket that does not exist is reported as such, not failed over.
+func (c *NatsObjectClient) readStore(ctx context.Context, bucket string) (jetstream.ObjectStore, error) {
+	os, err := c.bucketJS(bucket).ObjectStore(ctx, bucket)
+	if err == nil {
+		return os, nil
+	}
+	if failoverError(err) {
+		cfg, _, cerr := c.bucketConfigs.get(ctx, bucket)
+		if cerr == nil && cfg.Replication != nil && cfg.Replication.ReadFailover {
+			replica, rerr := c.replicaStore(ctx, cfg.Replication)
+			if rerr == nil {
+				logging.Warn(logging.WithContext(ctx, c.logger), "msg", "Bucket unavailable, reading from replica", "bucket", bucket,
+					"replica", cfg.Replication.DestinationBucket, "domain", cfg.Replication.Domain, "err", err)
+				return replica, nil
+			}
+			logging.Warn(logging.WithContext(ctx, c.logger), "msg", "Replica unavailable", "bucket", bucket, "err", rerr)
+		}
+	}
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		return nil, ErrBucketNotFound
+	}
+	return nil, err
+}
+
+// replicaStore opens the destination object store of a replication.
+func (c *NatsObjectClient) replicaStore(ctx context.Context, rep *BucketReplication) (jetstream.ObjectStore, error) {
+	js, err := c.replicaJetStream(rep)
+	if err != nil {
+		return nil, err
+	}
+	return js.ObjectStore(ctx, rep.DestinationBucket)
+}
+
+// replicaJetStream returns the JetStream context of a replication's
+// destination. Without a domain the destination is routed like any bucket,
+// otherwise it is reached in the named domain over the gateway connection.
+func (c *NatsObjectClient) replicaJetStream(rep *BucketReplication) (jetstream.JetStream, error) {
+	if rep.Domain == "" {
+		return c.bucketJS(rep.DestinationBucket), nil
+	}
+	return c.domainJetStream(rep.Domain)
+}
+
+// domainJetStream returns a JetStream context for a domain on the gateway
+// connection.
+func (c *NatsObjectClient) domainJetStream(domain string) (jetstream.JetStream, error) {
+	c.domainsMu.Lock()
+	defer c.domainsMu.Unlock()
+	if js, ok := c.domains[domain]; ok {
+		return js, nil
+	}
+	js, err := jetstream.NewWithDomain(c.client.NATS(), domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context for domain %s: %w", domain, err)
+	}
+	if c.domains == nil {
+		c.domains = make(map[string]jetstream.JetStream)
+	}
+	c.domains[domain] = js
+	return js, nil
+}
+
+// failoverError reports whether err means the JetStream holding the bucket
+// cannot be reached, rather than the bucket being missing or the request
+// invalid.
+func failoverError(err error) bool {
+	if errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, jetstream.ErrNoStreamResponse) ||
+		errors.Is(err, nats.ErrConnectionClosed) ||
+		errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == 503
+}
+
+// jetStreamDomain returns the JetStream domain a context is bound to.
+func (c *NatsObjectClient) jetStreamDomain(ctx context.Context, js jetstream.JetStream) (string, error) {
+	account, err := js.AccountInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	return account.Domain, nil
+}
+
+// objectStreamName is the name of the stream backing an object store.
+func objectStreamName(bucket string) string {
+	return "OBJ_" + bucket
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+)
+
+// BucketRoute sends the buckets matching it to a JetStream domain and/or
+// another NATS connection, such as a leafnode domain or another account.
+type BucketRoute struct {
+	// Bucket matches a bucket name exactly
+	Bucket string
+	// Prefix matches every bucket name starting with it
+	Prefix string
+	// Domain is the JetStream domain of the matching buckets; empty is the
+	// domain of the connection
+	Domain string
+	// Servers connects the matching buckets through their own NATS
+	// connection; empty shares the gateway connection
+	Servers string
+	// Options are the connection options of Servers, such as credentials
+	Options []nats.Option
+}
+
+// matches reports whether the route applies to bucket.
+func (r *BucketRoute) matches(bucket string) bool {
+	if r.Bucket != "" {
+		return r.Bucket == bucket
+	}
+	return strings.HasPrefix(bucket, r.Prefix)
+}
+
+// boundRoute is a route with its JetStream context.
+type boundRoute struct {
+	BucketRoute
+	js jetstream.JetStream
+}
+
+// BucketRouter resolves the JetStream context holding the object store of a
+// bucket. Buckets no route matches use the gateway connection.
+type BucketRouter struct {
+	js     jetstream.JetStream
+	routes []*boundRoute
+	// clients are the connections opened for routes with their own servers
+	clients []*Client
+
+	kvMu sync.Mutex
+	kvs  map[routedKV]jetstream.KeyValue
+}
+
+// routedKV identifies a gateway Key-Value bucket in a JetStream context.
+type routedKV struct {
+	js   jetstream.JetStream
+	name string
+}
+
+// NewBucketRouter binds routes to JetStream contexts, connecting routes with
+// their own servers. An exact bucket route wins over prefix routes, and the
+// longest matching prefix wins over shorter ones.
+func NewBucketRouter(logger log.Logger, natsClient *Client, routes []BucketRoute) (*BucketRouter, error) {
+	js, err := natsClient.Jetstream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+	router := &BucketRouter{js: js}
+	for i, route := range routes {
+		if route.Bucket == "" && route.Prefix == "" {
+			return nil, fmt.Errorf("bucket route %d matches no bucket", i)
+		}
+		if route.Bucket != "" && route.Prefix != "" {
+			return nil, fmt.Errorf("bucket route %d sets both bucket and prefix", i)
+		}
+		nc := natsClient.NATS()
+		if route.Servers != "" {
+			routeClient := NewClient("s3-gateway-route")
+			if err := routeClient.SetupConnectionToNATS(route.Servers, route.Options...); err != nil {
+				return nil, fmt.Errorf("failed to connect bucket route %d to NATS: %w", i, err)
+			}
+			nc = routeClient.NATS()
+			router.clients = append(router.clients, routeClient)
+		}
+		var routeJS jetstream.JetStream
+		if route.Domain != "" {
+			routeJS, err = jetstream.NewWithDomain(nc, route.Domain)
+		} else {
+			routeJS, err = jetstream.New(nc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JetStream context of bucket route %d: %w", i, err)
+		}
+		logging.Info(logger, "msg", "Routing buckets", "bucket", route.Bucket, "prefix", route.Prefix,
+			"domain", route.Domain, "servers", route.Servers)
+		router.routes = append(router.routes, &boundRoute{BucketRoute: route, js: routeJS})
+	}
+	return router, nil
+}
+
+// JetStream returns the JetStream context of a bucket.
+func (b *BucketRouter) JetStream(bucket string) jetstream.JetStream {
+	if route := b.route(bucket); route != nil {
+		return route.js
+	}
+	return b.js
+}
+
+// KeyValue returns the gateway Key-Value bucket name in the JetStream
+// context of bucket, creating it on first use, so that the state the
+// gateway keeps about a bucket lives next to its object store.
+func (b *BucketRouter) KeyValue(ctx context.Context, bucket string, name string) (jetstream.KeyValue, error) {
+	return b.contextKeyValue(ctx, b.JetStream(bucket), name)
+}
+
+// contextKeyValue returns the Key-Value bucket name in a JetStream context,
+// creating it on first use.
+func (b *BucketRouter) contextKeyValue(ctx context.Context, js jetstream.JetStream, name string) (jetstream.KeyValue, error) {
+	id := routedKV{js: js, name: name}
+	b.kvMu.Lock()
+	kv, ok := b.kvs[id]
+	b.kvMu.Unlock()
+	if ok {
+		return kv, nil
+	}
+
+	// Reach JetStream without the lock, so an unreachable route does not
+	// hold up buckets of other routes
+	kv, err := getOrCreateKeyValue(ctx, js, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access %s store: %w", name, err)
+	}
+	b.kvMu.Lock()
+	defer b.kvMu.Unlock()
+	if b.kvs == nil {
+		b.kvs = make(map[routedKV]jetstream.KeyValue)
+	}
+	b.kvs[id] = kv
+	return kv, nil
+}
+
+// route returns the route of a bucket, or nil when no route matches.
+func (b *BucketRouter) route(bucket string) *boundRoute {
+	var best *boundRoute
+	for _, route := range b.routes {
+		if !route.matches(bucket) {
+			continue
+		}
+		if route.Bucket != "" {
+			return route
+		}
+		if best == nil || len(route.Prefix) > len(best.Prefix) {
+			best = route
+		}
+	}
+	return best
+}
+
+// Drain drains the connections opened for routes with their own servers.
+func (b *BucketRouter) Drain(ctx context.Context) error {
+	var errs []error
+	for _, c := range b.clients {
+		if err := c.Drain(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// contexts returns every distinct JetStream context buckets are routed to.
+func (b *BucketRouter) contexts() []jetstream.JetStream {
+	res := []jetstream.JetStream{b.js}
+	for _, route := range b.routes {
+		res = append(res, route.js)
+	}
+	return res
+}
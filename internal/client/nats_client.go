@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/nats-io/nats.go/jetstream"
 	"log"
+	"sync/atomic"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nuid"
@@ -12,7 +14,6 @@ import (
 const (
 	MetaStoreName     = "mp_meta"
 	PartMetaStoreName = "mp_part_meta"
-	TempStoreName     = "mp_temp"
 )
 
 // Client wraps a NATS connection and metadata used by gateway components.
@@ -20,6 +21,10 @@ type Client struct {
 	id   string
 	kind string
 	nc   *nats.Conn
+	// draining is set once Drain closes the connection on purpose
+	draining atomic.Bool
+	// closed is closed together with the connection
+	closed chan struct{}
 }
 
 // NewClient creates a new Client with a generated ID and the provided kind
@@ -27,8 +32,9 @@ type Client struct {
 func NewClient(kind string) *Client {
 	id := nuid.Next()
 	return &Client{
-		id:   id,
-		kind: kind,
+		id:     id,
+		kind:   kind,
+		closed: make(chan struct{}),
 	}
 }
 
@@ -51,12 +57,35 @@ func (c *Client) SetupConnectionToNATS(servers string, options ...nats.Option) e
 		log.Println("Reconnected to NATS!")
 	})
 	nc.SetClosedHandler(func(_ *nats.Conn) {
+		close(c.closed)
+		if c.draining.Load() {
+			log.Println("Connection to NATS is drained.")
+			return
+		}
 		log.Fatal("Connection to NATS is closed! Service cannot continue.")
 	})
 
 	return err
 }
 
+// Drain lets pending publishes and subscriptions complete and closes the
+// connection, waiting until it is closed or ctx is done.
+func (c *Client) Drain(ctx context.Context) error {
+	if c.nc == nil {
+		return nil
+	}
+	c.draining.Store(true)
+	if err := c.nc.Drain(); err != nil {
+		return err
+	}
+	select {
+	case <-c.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // NATS returns the underlying NATS connection.
 func (c *Client) NATS() *nats.Conn {
 	return c.nc
@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"github.com/nats-io/nats-server/v2/server"
 	"testing"
+	"time"
 
 	nservertest "github.com/nats-io/nats-server/v2/test"
 	"github.com/nats-io/nats.go"
@@ -42,3 +44,25 @@ func TestClientSetupConnectionToNATS(t *testing.T) {
 	nc.SetClosedHandler(func(_ *nats.Conn) {})
 	nc.Close()
 }
+
+// TestClientDrain verifies that draining closes the connection without
+// treating the close as a fatal loss of NATS.
+func TestClientDrain(t *testing.T) {
+	opts := nservertest.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	s := nservertest.RunServer(&opts)
+	defer s.Shutdown()
+
+	c := NewClient("drain-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("SetupConnectionToNATS failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Drain(ctx); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if !c.NATS().IsClosed() {
+		t.Fatalf("expected connection to be closed after drain")
+	}
+}
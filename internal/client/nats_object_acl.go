@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/acl"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// MetaObjectACL records the access control list of an object, as JSON.
+const MetaObjectACL = InternalMetaPrefix + "acl"
+
+// ObjectACL returns the access control list of an object, or nil when it
+// has none.
+func ObjectACL(info *jetstream.ObjectInfo) (*acl.ACL, error) {
+	if info == nil || info.Metadata[MetaObjectACL] == "" {
+		return nil, nil
+	}
+	var a acl.ACL
+	if err := json.Unmarshal([]byte(info.Metadata[MetaObjectACL]), &a); err != nil {
+		return nil, fmt.Errorf("invalid object ACL: %w", err)
+	}
+	return &a, nil
+}
+
+// SetObjectACL records an access control list in the metadata of an object
+// about to be written. A nil ACL records nothing.
+func SetObjectACL(metadata map[string]string, a *acl.ACL) {
+	if a == nil {
+		return
+	}
+	data, _ := json.Marshal(a)
+	metadata[MetaObjectACL] = string(data)
+}
+
+// PutObjectACL replaces the access control list of an existing object.
+func (c *NatsObjectClient) PutObjectACL(ctx context.Context, bucket string, key string, a *acl.ACL) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.PutObjectACL", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(bucket, key)
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Put object ACL: %s/%s", bucket, key))
+
+	os, err := c.bucketJS(bucket).ObjectStore(ctx, bucket)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object store", "err", err)
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			return ErrBucketNotFound
+		}
+		return err
+	}
+
+	info, err := os.GetInfo(ctx, key)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrObjectNotFound) {
+			return ErrObjectNotFound
+		}
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object info", "err", err)
+		return err
+	}
+
+	if info.Metadata == nil {
+		info.Metadata = make(map[string]string)
+	}
+	SetObjectACL(info.Metadata, a)
+
+	meta := jetstream.ObjectMeta{
+		Name:        info.Name,
+		Description: info.Description,
+		Metadata:    info.Metadata,
+		Headers:     info.Headers,
+	}
+	err = os.UpdateMeta(ctx, key, meta)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error updating object metadata", "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+)
+
+// Tiers of the object cache.
+const (
+	CacheTierMemory = "memory"
+	CacheTierDisk   = "disk"
+)
+
+const (
+	// defaultCacheMaxObjectSize is the size of the largest object cached
+	// when CacheOptions leaves it unset
+	defaultCacheMaxObjectSize = 1 << 20
+	// cacheFilePrefix names the files of the disk tier, which are removed
+	// when the cache starts
+	cacheFilePrefix = "nats-s3-cache-"
+)
+
+// CacheOptions configures the read cache of small objects.
+type CacheOptions struct {
+	// MemoryBytes is the size of the memory tier; zero disables it
+	MemoryBytes int64
+	// Dir holds the disk tier; empty disables it
+	Dir string
+	// DiskBytes is the size of the disk tier
+	DiskBytes int64
+	// MaxObjectSize is the size of the largest object cached; zero caches
+	// objects of up to 1 MiB
+	MaxObjectSize int64
+	// TTL expires cached objects; zero keeps them until invalidated or
+	// evicted
+	TTL time.Duration
+}
+
+// Enabled reports whether the options enable a cache tier.
+func (o CacheOptions) Enabled() bool {
+	return o.MemoryBytes > 0 || (o.Dir != "" && o.DiskBytes > 0)
+}
+
+// ObjectCache caches the data and metadata of small unencrypted objects in
+// memory and/or on local disk, evicting the least recently used ones. Objects
+// evicted from memory move to disk, and disk hits move back to memory. The
+// object stores of cached buckets are watched, so that entries are dropped as
+// soon as another gateway or a NATS client changes their objects. Files of
+// the disk tier are read, written and removed without holding the lock, so
+// that a slow disk does not hold up memory hits.
+type ObjectCache struct {
+	logger log.Logger
+	opts   CacheOptions
+
+	mu     sync.Mutex
+	memory cacheTier
+	disk   cacheTier
+	// generations count the invalidations of each bucket, so that reads
+	// racing a change are not cached
+	generations map[string]uint64
+	// watchers are the watches of cached buckets, including those still
+	// being created
+	watchers map[string]*bucketWatch
+	hits     map[string]uint64
+	misses   uint64
+	// spills and unlinks are the disk tier writes and removals decided
+	// under the lock, carried out by unlock
+	spills  []spill
+	unlinks []string
+}
+
+// bucketWatch stops the watch of a bucket. Objects of the bucket are only
+// cached once the watch is active.
+type bucketWatch struct {
+	cancel context.CancelFunc
+	active bool
+}
+
+// spill is an entry evicted from memory at generation gen of its bucket,
+// to be written to the disk tier.
+type spill struct {
+	entry *cacheEntry
+	gen   uint64
+}
+
+// cacheTier is a least recently used list of entries bounded in size.
+type cacheTier struct {
+	max     int64
+	size    int64
+	lru     list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	bucket  string
+	key     string
+	info    jetstream.ObjectInfo
+	data    []byte
+	path    string
+	size    int64
+	expires time.Time
+}
+
+// CacheStats is a snapshot of the usage of an ObjectCache.
+type CacheStats struct {
+	// Hits counts the reads served per tier
+	Hits    map[string]uint64
+	Misses  uint64
+	Bytes   map[string]int64
+	Entries map[string]int
+}
+
+// NewObjectCache returns an empty cache, clearing the files a previous run
+// left in the disk tier.
+func NewObjectCache(logger log.Logger, opts CacheOptions) (*ObjectCache, error) {
+	if opts.MaxObjectSize <= 0 {
+		opts.MaxObjectSize = defaultCacheMaxObjectSize
+	}
+	c := &ObjectCache{
+		logger:      logger,
+		opts:        opts,
+		generations: make(map[string]uint64),
+		watchers:    make(map[string]*bucketWatch),
+		hits:        make(map[string]uint64),
+	}
+	c.memory.init(opts.MemoryBytes)
+	if opts.Dir != "" && opts.DiskBytes > 0 {
+		if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		stale, err := filepath.Glob(filepath.Join(opts.Dir, cacheFilePrefix+"*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range stale {
+			_ = os.Remove(path)
+		}
+		c.disk.init(opts.DiskBytes)
+	}
+	return c, nil
+}
+
+func (t *cacheTier) init(max int64) {
+	t.max = max
+	t.entries = make(map[string]*list.Element)
+}
+
+func cacheKey(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// lookup returns a cached object, counting the hit or miss.
+func (c *ObjectCache) lookup(bucket, key string) (*jetstream.ObjectInfo, []byte, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	k := cacheKey(bucket, key)
+	now := time.Now()
+
+	c.mu.Lock()
+	if elem, ok := c.memory.entries[k]; ok {
+		e := elem.Value.(*cacheEntry)
+		if c.expired(e, now) {
+			c.remove(&c.memory, elem)
+		} else {
+			c.memory.lru.MoveToFront(elem)
+			c.hits[CacheTierMemory]++
+			c.unlock()
+			return e.infoCopy(), e.data, true
+		}
+	}
+	elem, ok := c.disk.entries[k]
+	if ok && c.expired(elem.Value.(*cacheEntry), now) {
+		c.remove(&c.disk, elem)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		c.unlock()
+		return nil, nil, false
+	}
+	e := elem.Value.(*cacheEntry)
+	c.unlock()
+
+	data, err := os.ReadFile(e.path)
+
+	c.mu.Lock()
+	defer c.unlock()
+	// The entry may have been invalidated or evicted while it was read
+	if c.disk.entries[k] != elem {
+		c.misses++
+		return nil, nil, false
+	}
+	if err != nil {
+		logging.Warn(c.logger, "msg", "Failed to read cached object", "path", e.path, "err", err)
+		c.remove(&c.disk, elem)
+		c.misses++
+		return nil, nil, false
+	}
+	c.hits[CacheTierDisk]++
+	if c.memory.max > 0 {
+		// Promote the object back to memory
+		c.remove(&c.disk, elem)
+		c.add(&cacheEntry{bucket: e.bucket, key: k, info: e.info, data: data, size: e.size, expires: e.expires})
+	} else {
+		c.disk.lru.MoveToFront(elem)
+	}
+	return e.infoCopy(), data, true
+}
+
+// unlock releases the lock, then carries out the disk tier writes and
+// removals decided under it.
+func (c *ObjectCache) unlock() {
+	spills, unlinks := c.spills, c.unlinks
+	c.spills, c.unlinks = nil, nil
+	c.mu.Unlock()
+	for _, path := range unlinks {
+		_ = os.Remove(path)
+	}
+	for _, sp := range spills {
+		c.writeSpill(sp)
+	}
+}
+
+func (c *ObjectCache) expired(e *cacheEntry, now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+// infoCopy returns a copy of the cached metadata that callers may modify.
+func (e *cacheEntry) infoCopy() *jetstream.ObjectInfo {
+	info := e.info
+	info.Metadata = maps.Clone(e.info.Metadata)
+	info.Headers = maps.Clone(e.info.Headers)
+	return &info
+}
+
+// begin starts watching the object store of a bucket and returns the
+// generation a read of the bucket passes to store. Returns false when the
+// bucket cannot be watched, and so its objects not be cached, and while
+// another read is still creating its watch. The watch is created without
+// holding the lock, as it is a round trip to JetStream.
+func (c *ObjectCache) begin(ctx context.Context, bucket string, store jetstream.ObjectStore) (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	if bw, ok := c.watchers[bucket]; ok {
+		gen := c.generations[bucket]
+		c.mu.Unlock()
+		return gen, bw.active
+	}
+	watchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	bw := &bucketWatch{cancel: cancel}
+	c.watchers[bucket] = bw
+	c.mu.Unlock()
+
+	w, err := store.Watch(watchCtx, jetstream.UpdatesOnly())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		cancel()
+		if c.watchers[bucket] == bw {
+			delete(c.watchers, bucket)
+		}
+		logging.Warn(logging.WithContext(ctx, c.logger), "msg", "Failed to watch bucket for the object cache", "bucket", bucket, "err", err)
+		return 0, false
+	}
+	// The bucket was invalidated or the cache closed meanwhile
+	if c.watchers[bucket] != bw {
+		cancel()
+		_ = w.Stop()
+		return 0, false
+	}
+	bw.active = true
+	go c.watch(watchCtx, bucket, w)
+	return c.generations[bucket], true
+}
+
+// watching reports whether the watch of a bucket is active.
+func (c *ObjectCache) watching(bucket string) bool {
+	bw, ok := c.watchers[bucket]
+	return ok && bw.active
+}
+
+// watch drops the entries of objects changed in a bucket until the watch
+// is stopped.
+func (c *ObjectCache) watch(ctx context.Context, bucket string, w jetstream.ObjectWatcher) {
+	defer func() { _ = w.Stop() }()
+	for {
+		select {
+		case info, ok := <-w.Updates():
+			if !ok {
+				// The bucket went away or the watch failed; forget the
+				// bucket, so that the next read watches it again
+				if ctx.Err() == nil {
+					c.invalidateBucket(bucket)
+				}
+				return
+			}
+			if info != nil {
+				c.invalidate(bucket, info.Name)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// store caches an object read at generation gen, unless it is too large,
+// encrypted or changed since.
+func (c *ObjectCache) store(bucket, key string, gen uint64, info *jetstream.ObjectInfo, data []byte) {
+	if c == nil || int64(len(data)) > c.opts.MaxObjectSize || int64(info.Size) != int64(len(data)) {
+		return
+	}
+	if algorithm, _ := ObjectEncryption(info.Metadata); algorithm != "" {
+		return
+	}
+	e := &cacheEntry{
+		bucket: bucket,
+		key:    cacheKey(bucket, key),
+		info:   *info,
+		data:   data,
+		size:   int64(len(data)),
+	}
+	e.info = *e.infoCopy()
+	if c.opts.TTL > 0 {
+		e.expires = time.Now().Add(c.opts.TTL)
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+	if c.generations[bucket] != gen {
+		return
+	}
+	if !c.watching(bucket) {
+		return
+	}
+	c.drop(e.key)
+	c.add(e)
+}
+
+// add puts an entry in the memory tier, or the disk tier when memory is
+// disabled, evicting the least recently used entries to make room.
+func (c *ObjectCache) add(e *cacheEntry) {
+	if c.memory.max > 0 && e.size <= c.memory.max {
+		c.memory.entries[e.key] = c.memory.lru.PushFront(e)
+		c.memory.size += e.size
+		for c.memory.size > c.memory.max {
+			oldest := c.memory.lru.Back()
+			evicted := oldest.Value.(*cacheEntry)
+			c.remove(&c.memory, oldest)
+			c.spill(evicted)
+		}
+		return
+	}
+	c.spill(e)
+}
+
+// spill queues an entry to be moved to the disk tier, when there is one.
+func (c *ObjectCache) spill(e *cacheEntry) {
+	if c.disk.max <= 0 || e.size > c.disk.max || e.data == nil {
+		return
+	}
+	c.spills = append(c.spills, spill{entry: e, gen: c.generations[e.bucket]})
+}
+
+// writeSpill writes a spilled entry to a file and adds it to the disk tier,
+// unless its bucket changed or stopped being cached, or the object was
+// cached again meanwhile.
+func (c *ObjectCache) writeSpill(sp spill) {
+	e := sp.entry
+	path := filepath.Join(c.opts.Dir, cacheFilePrefix+nuid.Next())
+	if err := os.WriteFile(path, e.data, 0o600); err != nil {
+		logging.Warn(c.logger, "msg", "Failed to write cached object", "path", path, "err", err)
+		_ = os.Remove(path)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+	_, inMemory := c.memory.entries[e.key]
+	_, onDisk := c.disk.entries[e.key]
+	if c.generations[e.bucket] != sp.gen || !c.watching(e.bucket) || inMemory || onDisk {
+		c.unlinks = append(c.unlinks, path)
+		return
+	}
+	e.data = nil
+	e.path = path
+	c.disk.entries[e.key] = c.disk.lru.PushFront(e)
+	c.disk.size += e.size
+	for c.disk.size > c.disk.max {
+		c.remove(&c.disk, c.disk.lru.Back())
+	}
+}
+
+// remove takes an entry out of a tier, queueing its file for deletion.
+func (c *ObjectCache) remove(t *cacheTier, elem *list.Element) {
+	e := elem.Value.(*cacheEntry)
+	t.lru.Remove(elem)
+	delete(t.entries, e.key)
+	t.size -= e.size
+	if e.path != "" {
+		c.unlinks = append(c.unlinks, e.path)
+	}
+}
+
+// drop removes a key from both tiers.
+func (c *ObjectCache) drop(key string) {
+	if elem, ok := c.memory.entries[key]; ok {
+		c.remove(&c.memory, elem)
+	}
+	if elem, ok := c.disk.entries[key]; ok {
+		c.remove(&c.disk, elem)
+	}
+}
+
+// invalidate drops an object from the cache.
+func (c *ObjectCache) invalidate(bucket, key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	c.generations[bucket]++
+	c.drop(cacheKey(bucket, key))
+}
+
+// invalidateBucket drops the objects of a bucket and stops watching it.
+func (c *ObjectCache) invalidateBucket(bucket string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	c.generations[bucket]++
+	if bw, ok := c.watchers[bucket]; ok {
+		bw.cancel()
+		delete(c.watchers, bucket)
+	}
+	prefix := cacheKey(bucket, "")
+	for _, t := range []*cacheTier{&c.memory, &c.disk} {
+		for key, elem := range t.entries {
+			if strings.HasPrefix(key, prefix) {
+				c.remove(t, elem)
+			}
+		}
+	}
+}
+
+// Stats returns the hits, misses and usage of the cache.
+func (c *ObjectCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    maps.Clone(c.hits),
+		Misses:  c.misses,
+		Bytes:   map[string]int64{CacheTierMemory: c.memory.size, CacheTierDisk: c.disk.size},
+		Entries: map[string]int{CacheTierMemory: c.memory.lru.Len(), CacheTierDisk: c.disk.lru.Len()},
+	}
+}
+
+// Close stops the watches and removes the files of the disk tier.
+func (c *ObjectCache) Close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.unlock()
+	for bucket, bw := range c.watchers {
+		bw.cancel()
+		delete(c.watchers, bucket)
+	}
+	for c.disk.lru.Len() > 0 {
+		c.remove(&c.disk, c.disk.lru.Back())
+	}
+}
@@ -0,0 +1,265 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestObjectCache(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	c := NewClient("cache-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	dir := t.TempDir()
+	cache, err := NewObjectCache(logger, CacheOptions{MemoryBytes: 10, Dir: dir, DiskBytes: 100, MaxObjectSize: 50})
+	if err != nil {
+		t.Fatalf("NewObjectCache failed: %v", err)
+	}
+	defer cache.Close()
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+
+	ctx := context.Background()
+	bucket := "cached"
+	if _, err := oc.CreateBucket(ctx, bucket, BucketOptions{}); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	put := func(key, data string) {
+		t.Helper()
+		if _, err := oc.PutObjectStream(ctx, bucket, key, "text/plain", nil, bytes.NewReader([]byte(data)), nil); err != nil {
+			t.Fatalf("PutObjectStream failed: %v", err)
+		}
+	}
+	get := func(key string) string {
+		t.Helper()
+		_, data, err := oc.GetObject(ctx, bucket, key, nil)
+		if err != nil {
+			t.Fatalf("GetObject failed: %v", err)
+		}
+		return string(data)
+	}
+
+	put("a", "aaaaaaaa")
+	put("b", "bbbbbbbb")
+	get("a")
+	get("b") // evicts a to disk
+	if got := get("a"); got != "aaaaaaaa" {
+		t.Fatalf("unexpected data %q", got)
+	}
+	stats := cache.Stats()
+	if stats.Misses != 2 || stats.Hits[CacheTierDisk] != 1 {
+		t.Errorf("expected 2 misses and a disk hit, got %+v", stats)
+	}
+	if got := get("a"); got != "aaaaaaaa" || cache.Stats().Hits[CacheTierMemory] != 1 {
+		t.Errorf("expected a memory hit, got %q and %+v", got, cache.Stats())
+	}
+	data, err := oc.GetObjectRange(ctx, bucket, "a", nil, 2, 3)
+	if err != nil || string(data) != "aaa" {
+		t.Errorf("unexpected range %q: %v", data, err)
+	}
+
+	// Writes of this gateway replace the cached object at once
+	put("a", "updated")
+	if got := get("a"); got != "updated" {
+		t.Errorf("expected the updated object, got %q", got)
+	}
+
+	// Writes of other NATS clients are picked up by the watch
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	store, err := js.ObjectStore(bucket)
+	if err != nil {
+		t.Fatalf("ObjectStore failed: %v", err)
+	}
+	if _, err := store.PutString("a", "native"); err != nil {
+		t.Fatalf("PutString failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for get("a") != "native" {
+		if time.Now().After(deadline) {
+			t.Fatal("cached object not invalidated by the watch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Large objects are not cached
+	put("large", string(bytes.Repeat([]byte("x"), 60)))
+	get("large")
+	get("large")
+	if stats := cache.Stats(); stats.Entries[CacheTierMemory]+stats.Entries[CacheTierDisk] > 2 {
+		t.Errorf("expected the large object not to be cached, got %+v", stats)
+	}
+
+	if err := oc.DeleteBucket(ctx, bucket); err != nil {
+		t.Fatalf("DeleteBucket failed: %v", err)
+	}
+	if stats := cache.Stats(); stats.Entries[CacheTierMemory]+stats.Entries[CacheTierDisk] != 0 {
+		t.Errorf("expected the bucket to be dropped, got %+v", stats)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no cache files, got %d", len(files))
+	}
+}
+
+func TestObjectCache_ConcurrentDiskTier(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	c := NewClient("cache-concurrent-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	logger := logging.NewLogger(logging.Config{Level: "info"})
+	dir := t.TempDir()
+	// Memory holds a single object, so reads keep moving objects between
+	// the tiers
+	cache, err := NewObjectCache(logger, CacheOptions{MemoryBytes: 16, Dir: dir, DiskBytes: 64, MaxObjectSize: 16})
+	if err != nil {
+		t.Fatalf("NewObjectCache failed: %v", err)
+	}
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+
+	ctx := context.Background()
+	bucket := "cached-concurrent"
+	if _, err := oc.CreateBucket(ctx, bucket, BucketOptions{}); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	keys := []string{"k0", "k1", "k2", "k3", "k4", "k5"}
+	for _, key := range keys {
+		if _, err := oc.PutObjectStream(ctx, bucket, key, "text/plain", nil, bytes.NewReader([]byte("data-"+key)), nil); err != nil {
+			t.Fatalf("PutObjectStream failed: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(keys))
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				key := keys[(i+n)%len(keys)]
+				_, data, err := oc.GetObject(ctx, bucket, key, nil)
+				if err != nil || string(data) != "data-"+key {
+					errs <- fmt.Errorf("GetObject %s: %q, %v", key, data, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if stats := cache.Stats(); stats.Hits[CacheTierDisk] == 0 {
+		t.Errorf("expected disk hits, got %+v", stats)
+	}
+
+	cache.Close()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected no cache files after Close, got %d", len(files))
+	}
+}
+
+// blockedWatchStore is an object store whose watches are held until
+// released.
+type blockedWatchStore struct {
+	jetstream.ObjectStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockedWatchStore) Watch(ctx context.Context, opts ...jetstream.WatchOpt) (jetstream.ObjectWatcher, error) {
+	close(s.started)
+	<-s.release
+	return s.ObjectStore.Watch(ctx, opts...)
+}
+
+func TestObjectCache_WatchOutsideLock(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	ctx := context.Background()
+	objects, err := js.CreateObjectStore(ctx, jetstream.ObjectStoreConfig{Bucket: "watched"})
+	if err != nil {
+		t.Fatalf("create object store failed: %v", err)
+	}
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	cache, err := NewObjectCache(logger, CacheOptions{MemoryBytes: 100})
+	if err != nil {
+		t.Fatalf("NewObjectCache failed: %v", err)
+	}
+	defer cache.Close()
+
+	store := &blockedWatchStore{ObjectStore: objects, started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan bool)
+	go func() {
+		_, ok := cache.begin(ctx, "watched", store)
+		done <- ok
+	}()
+	<-store.started
+
+	// The cache serves other buckets and reads while the watch is created,
+	// without caching the bucket until it is watched
+	stalled := make(chan struct{})
+	go func() {
+		defer close(stalled)
+		cache.lookup("other", "key")
+		if _, ok := cache.begin(ctx, "watched", store); ok {
+			t.Errorf("expected the bucket not to be cached before its watch is active")
+		}
+	}()
+	select {
+	case <-stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cache blocked while a watch was created")
+	}
+
+	close(store.release)
+	if ok := <-done; !ok {
+		t.Fatalf("expected the watch to start")
+	}
+	if _, ok := cache.begin(ctx, "watched", store); !ok {
+		t.Fatalf("expected the bucket to be cached once watched")
+	}
+}
@@ -2,15 +2,24 @@ package client
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/kms"
 	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var ErrBucketNotFound = errors.New("bucket not found")
@@ -18,19 +27,53 @@ var ErrObjectNotFound = errors.New("object not found")
 var ErrUploadNotFound = errors.New("multipart upload not found")
 var ErrUploadCompleted = errors.New("completed multipart upload")
 var ErrMissingPart = errors.New("missing part")
+var ErrInvalidPart = errors.New("invalid part")
+var ErrPartTooSmall = errors.New("part too small")
 var ErrBucketAlreadyExists = errors.New("bucket already exists")
 
+// RequestIDHeader is the object header holding the ID of the request that
+// wrote the object.
+const RequestIDHeader = "X-Request-Id"
+
+// requestHeaders adds the ID of the request in ctx to the headers of an
+// object, linking the object to the request that wrote it.
+func requestHeaders(ctx context.Context, h nats.Header) {
+	if id := logging.RequestID(ctx); id != "" {
+		h.Set(RequestIDHeader, id)
+	}
+}
+
 type NatsObjectClientOptions struct {
 	Replicas int
+	// MasterKey wraps the data keys of SSE-S3 objects; nil disables SSE-S3
+	MasterKey []byte
+	// KMS issues the data keys of SSE-KMS objects; nil disables SSE-KMS
+	KMS kms.KMS
+	// Compression is the default compression of buckets without their own
+	Compression CompressionOptions
+	// Routes resolves the JetStream context of each bucket; nil keeps every
+	// bucket on the gateway connection
+	Routes *BucketRouter
+	// Cache serves reads of small objects; nil disables caching
+	Cache *ObjectCache
 }
 
 // NatsObjectClient provides convenience helpers for common NATS JetStream
 // Object Store operations, built on top of the base Client connection.
 type NatsObjectClient struct {
-	logger log.Logger
-	client *Client
-	js     jetstream.JetStream
-	opts   NatsObjectClientOptions
+	logger  log.Logger
+	client  *Client
+	opts    NatsObjectClientOptions
+	keyring *sseKeyring
+	cache   *ObjectCache
+
+	bucketConfigs *bucketConfigStore
+	usage         *bucketUsageStore
+	routes        *BucketRouter
+	replication   replicationTracker
+
+	domainsMu sync.Mutex
+	domains   map[string]jetstream.JetStream
 }
 
 func NewNatsObjectClient(logger log.Logger,
@@ -42,19 +85,46 @@ func NewNatsObjectClient(logger log.Logger,
 		opts.Replicas = 1
 	}
 
-	js, err := natsClient.Jetstream()
+	keyring, err := newSSEKeyring(opts.MasterKey, opts.KMS)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+
+	routes := opts.Routes
+	if routes == nil {
+		routes, err = NewBucketRouter(logger, natsClient, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bucketConfigs, err := newBucketConfigStore(context.Background(), logger, routes.js)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+		return nil, err
+	}
+
+	usage, err := newBucketUsageStore(context.Background(), logger, routes)
+	if err != nil {
+		return nil, err
 	}
 
 	return &NatsObjectClient{
-		logger: logger,
-		client: natsClient,
-		js:     js,
-		opts:   opts,
+		logger:        logger,
+		client:        natsClient,
+		opts:          opts,
+		keyring:       keyring,
+		cache:         opts.Cache,
+		bucketConfigs: bucketConfigs,
+		usage:         usage,
+		routes:        routes,
 	}, nil
 }
 
+// bucketJS returns the JetStream context holding the object store of a bucket.
+func (c *NatsObjectClient) bucketJS(bucket string) jetstream.JetStream {
+	return c.routes.JetStream(bucket)
+}
+
 // IsConnected checks if NATS is connected
 func (c *NatsObjectClient) IsConnected() bool {
 	nc := c.client.NATS()
@@ -67,162 +137,400 @@ func (c *NatsObjectClient) Stats() nats.Statistics {
 	return nc.Stats()
 }
 
-// CreateBucket creates a JetStream Object Store bucket.
-func (c *NatsObjectClient) CreateBucket(ctx context.Context, bucketName string) (jetstream.ObjectStoreStatus, error) {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Create bucket: %s", bucketName))
+// CreateBucket creates a JetStream Object Store bucket with the given
+// options. Returns ErrInvalidBucketOptions when JetStream cannot place the
+// bucket as requested.
+func (c *NatsObjectClient) CreateBucket(ctx context.Context, bucketName string, opts BucketOptions) (_ jetstream.ObjectStoreStatus, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.CreateBucket", attribute.String("aws.s3.bucket", bucketName))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Create bucket: %s", bucketName))
+	if opts.Replicas == 0 {
+		opts.Replicas = c.opts.Replicas
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 
 	// Check if bucket already exists to fail duplicate creation explicitly
-	_, err := c.js.ObjectStore(ctx, bucketName)
+	js := c.bucketJS(bucketName)
+	_, err = js.ObjectStore(ctx, bucketName)
 	if err == nil {
-		logging.Info(c.logger, "msg", fmt.Sprintf("Bucket already exists: %s", bucketName))
+		logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Bucket already exists: %s", bucketName))
 		return nil, ErrBucketAlreadyExists
 	} else if !errors.Is(err, jetstream.ErrBucketNotFound) {
-		logging.Error(c.logger, "msg", "Unexpected Error at ObjectStore (existence check)", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Unexpected Error at ObjectStore (existence check)", "err", err)
 		return nil, err
 	}
 
-	os, err := c.js.CreateObjectStore(ctx, jetstream.ObjectStoreConfig{
-		Bucket:   bucketName,
-		Storage:  jetstream.FileStorage,
-		Replicas: c.opts.Replicas,
+	os, err := js.CreateObjectStore(ctx, jetstream.ObjectStoreConfig{
+		Bucket:      bucketName,
+		Description: opts.Description,
+		TTL:         opts.TTL,
+		MaxBytes:    opts.MaxBytes,
+		Storage:     opts.Storage,
+		Replicas:    opts.Replicas,
+		Placement:   opts.Placement,
+		Compression: opts.Compression,
 	})
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at CreateObjectStore", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at CreateObjectStore", "err", err)
+		if placementError(err) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBucketOptions, err)
+		}
 		return nil, err
 	}
 
 	return os.Status(ctx)
 }
 
-// DeleteBucket deletes a bucket identified by its name.
-func (c *NatsObjectClient) DeleteBucket(ctx context.Context, bucket string) error {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Delete bucket: %s", bucket))
-	js, err := c.client.Jetstream()
-	if err != nil {
-		logging.Error(c.logger, "msg", "Error at DeleteBucket", "err", err)
-		return err
-	}
+// DeleteBucket deletes a bucket identified by its name, together with the
+// parts of its multipart objects and uploads, which live in its object store.
+func (c *NatsObjectClient) DeleteBucket(ctx context.Context, bucket string) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.DeleteBucket", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidateBucket(bucket)
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Delete bucket: %s", bucket))
+	js := c.bucketJS(bucket)
 	err = js.DeleteObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at DeleteBucket", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at DeleteBucket", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return ErrBucketNotFound
 		}
 		return err
 	}
+	c.bucketConfigs.remove(ctx, bucket)
+	c.usage.remove(ctx, bucket)
 	return nil
 }
 
 // DeleteObject removes an object identified by bucket and key.
-func (c *NatsObjectClient) DeleteObject(ctx context.Context, bucket string, key string) error {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Delete object on bucket: [%s/%s]", bucket, key))
-	js, err := c.client.Jetstream()
-	if err != nil {
-		logging.Error(c.logger, "msg", "Error at DeleteObject", "err", err)
-		return err
-	}
+func (c *NatsObjectClient) DeleteObject(ctx context.Context, bucket string, key string) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.DeleteObject", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	// Drop the cached object whether or not the change completed
+	defer c.cache.invalidate(bucket, key)
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Delete object on bucket: [%s/%s]", bucket, key))
+	js := c.bucketJS(bucket)
 	os, err := js.ObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at DeleteObject", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at DeleteObject", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return ErrBucketNotFound
 		}
 		return err
 	}
+	if isPartKey(key) {
+		return ErrObjectNotFound
+	}
+	info, err := os.GetInfo(ctx, key)
+	if err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at DeleteObject", "err", err)
+		return err
+	}
+	layout := c.releasedLayout(ctx, os, info)
 	err = os.Delete(ctx, key)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at DeleteObject", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at DeleteObject", "err", err)
 		if errors.Is(err, jetstream.ErrObjectNotFound) {
 			return ErrObjectNotFound
 		}
 		return err
 	}
+	written := time.Now()
+	releaseParts(ctx, c.logger, os, layout)
+	removed := objectUsage(info)
+	c.usage.add(ctx, bucket, BucketUsage{Bytes: -removed.Bytes, Objects: -removed.Objects}, written)
 
 	return nil
 }
 
-// GetObjectInfo fetches metadata for an object.
-func (c *NatsObjectClient) GetObjectInfo(ctx context.Context, bucket string, key string) (*jetstream.ObjectInfo, error) {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Get object info: [%s/%s]", bucket, key))
-	js, err := c.client.Jetstream()
+// releasedLayout returns the layout of an object about to be deleted or
+// overwritten, whose parts are released once it is gone. Failing to load it
+// leaves the parts behind rather than failing the write.
+func (c *NatsObjectClient) releasedLayout(ctx context.Context, os jetstream.ObjectStore, info *jetstream.ObjectInfo) *ObjectLayout {
+	layout, err := readLayout(ctx, os, info)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObjectInfo", "err", err)
-		return nil, err
+		logging.Warn(logging.WithContext(ctx, c.logger), "msg", "Failed to load layout of released manifest", "bucket", info.Bucket, "key", info.Name, "err", err)
+		return nil
 	}
-	os, err := js.ObjectStore(ctx, bucket)
+	return layout
+}
+
+// GetObjectInfo fetches metadata for an object.
+func (c *NatsObjectClient) GetObjectInfo(ctx context.Context, bucket string, key string) (_ *jetstream.ObjectInfo, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetObjectInfo", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Get object info: [%s/%s]", bucket, key))
+	if isPartKey(key) {
+		return nil, ErrObjectNotFound
+	}
+	if info, _, ok := c.cache.lookup(bucket, key); ok {
+		return info, nil
+	}
+	if o := resolvedFrom(ctx, bucket, key); o != nil {
+		obj := o.infoCopy()
+		resolveObjectInfo(obj)
+		return obj, nil
+	}
+	os, err := c.readStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObjectInfo", "err", err)
-		if errors.Is(err, jetstream.ErrBucketNotFound) {
-			return nil, ErrBucketNotFound
-		}
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetObjectInfo", "err", err)
 		return nil, err
 	}
 	obj, err := os.GetInfo(ctx, key)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObjectInfo", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetObjectInfo", "err", err)
 		if errors.Is(err, jetstream.ErrObjectNotFound) {
 			return nil, ErrObjectNotFound
 		}
 		return nil, err
 	}
+	resolveObjectInfo(obj)
 
 	return obj, err
 }
 
-// GetObject retrieves an object's metadata and bytes.
-func (c *NatsObjectClient) GetObject(ctx context.Context, bucket string, key string) (*jetstream.ObjectInfo, []byte, error) {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Get object : [%s/%s]", bucket, key))
-	js, err := c.client.Jetstream()
+// resolvedObjectKey is the context key of the lookup made by ResolveObject.
+type resolvedObjectKey struct{}
+
+// resolvedObject is the store and info of an object looked up for a request,
+// with the cache generation a read of it stores at.
+type resolvedObject struct {
+	bucket    string
+	key       string
+	store     jetstream.ObjectStore
+	info      *jetstream.ObjectInfo
+	gen       uint64
+	cacheable bool
+}
+
+// infoCopy returns the info as stored, for the caller to resolve.
+func (o *resolvedObject) infoCopy() *jetstream.ObjectInfo {
+	info := *o.info
+	return &info
+}
+
+// ResolveObject looks up the info of an object like GetObjectInfo and
+// returns a context carrying the lookup, so that GetObjectInfo, GetObject,
+// GetObjectRange and OpenObject in that context do not look the object up
+// again. Objects served from the cache are not carried, as their reads are
+// served from the cache too.
+func (c *NatsObjectClient) ResolveObject(ctx context.Context, bucket string, key string) (_ context.Context, _ *jetstream.ObjectInfo, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.ResolveObject", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	if isPartKey(key) {
+		return ctx, nil, ErrObjectNotFound
+	}
+	if info, _, ok := c.cache.lookup(bucket, key); ok {
+		return ctx, info, nil
+	}
+	o, err := c.resolve(ctx, bucket, key)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObject", "err", err)
-		return nil, nil, err
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at ResolveObject", "err", err)
+		return ctx, nil, err
 	}
-	os, err := js.ObjectStore(ctx, bucket)
+	info := o.infoCopy()
+	resolveObjectInfo(info)
+	return context.WithValue(ctx, resolvedObjectKey{}, o), info, nil
+}
+
+// resolvedFrom returns the lookup of an object carried by ctx, or nil.
+func resolvedFrom(ctx context.Context, bucket, key string) *resolvedObject {
+	o, _ := ctx.Value(resolvedObjectKey{}).(*resolvedObject)
+	if o == nil || o.bucket != bucket || o.key != key {
+		return nil
+	}
+	return o
+}
+
+// lookup returns the lookup of an object carried by ctx, or looks the
+// object up.
+func (c *NatsObjectClient) lookup(ctx context.Context, bucket, key string) (*resolvedObject, error) {
+	if o := resolvedFrom(ctx, bucket, key); o != nil {
+		return o, nil
+	}
+	return c.resolve(ctx, bucket, key)
+}
+
+// resolve opens the store of an object and looks the object up. The bucket
+// is watched by the cache first, so that a read racing a change of the
+// object is not cached.
+func (c *NatsObjectClient) resolve(ctx context.Context, bucket, key string) (*resolvedObject, error) {
+	os, err := c.readStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObject", "err", err)
-		if errors.Is(err, jetstream.ErrBucketNotFound) {
-			return nil, nil, ErrBucketNotFound
-		}
-		return nil, nil, err
+		return nil, err
 	}
+	gen, cacheable := c.cache.begin(ctx, bucket, os)
 	info, err := os.GetInfo(ctx, key)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObject", "err", err)
 		if errors.Is(err, jetstream.ErrObjectNotFound) {
-			return nil, nil, ErrObjectNotFound
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return &resolvedObject{bucket: bucket, key: key, store: os, info: info, gen: gen, cacheable: cacheable}, nil
+}
+
+// GetObject retrieves an object's metadata and bytes, decrypting encrypted
+// objects. SSE-C objects require the customer key in sse.
+func (c *NatsObjectClient) GetObject(ctx context.Context, bucket string, key string, sse *ServerSideEncryption) (_ *jetstream.ObjectInfo, _ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetObject", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Get object : [%s/%s]", bucket, key))
+	if isPartKey(key) {
+		return nil, nil, ErrObjectNotFound
+	}
+	if info, data, ok := c.cache.lookup(bucket, key); ok {
+		if _, err := c.keyring.open(ctx, info.Metadata, sse); err != nil {
+			return nil, nil, err
 		}
+		return info, data, nil
+	}
+	o, err := c.lookup(ctx, bucket, key)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetObject", "err", err)
 		return nil, nil, err
 	}
-	res, err := os.GetBytes(ctx, key)
+	os, info := o.store, o.infoCopy()
+	dataKey, err := c.keyring.open(ctx, info.Metadata, sse)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObject", "err", err)
+		logging.Warn(logging.WithContext(ctx, c.logger), "msg", "Error at GetObject", "err", err)
 		return nil, nil, err
 	}
+	var res []byte
+	if IsManifest(info) || dataKey != nil || info.Metadata[MetaCompression] != "" {
+		res, err = c.readObject(ctx, os, info, dataKey, 0, -1)
+	} else {
+		res, err = os.GetBytes(ctx, key)
+	}
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetObject", "err", err)
+		return nil, nil, err
+	}
+	resolveObjectInfo(info)
+	if o.cacheable {
+		c.cache.store(bucket, key, o.gen, info, res)
+	}
 	return info, res, nil
 }
 
-// ListBuckets returns a channel of object store statuses for all buckets.
-func (c *NatsObjectClient) ListBuckets(ctx context.Context) (<-chan jetstream.ObjectStoreStatus, error) {
-	logging.Info(c.logger, "msg", "List buckets")
-	js, err := c.client.Jetstream()
+// GetObjectRange reads length bytes of an object starting at offset. The
+// data in front of the offset is skipped without being decrypted, and the
+// object is not read past the end of the range.
+func (c *NatsObjectClient) GetObjectRange(ctx context.Context, bucket string, key string, sse *ServerSideEncryption, offset int64, length int64) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetObjectRange", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key), attribute.Int64("aws.s3.offset", offset), attribute.Int64("aws.s3.length", length))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Get object range: [%s/%s] offset=%d length=%d", bucket, key, offset, length))
+	if isPartKey(key) {
+		return nil, ErrObjectNotFound
+	}
+	if info, data, ok := c.cache.lookup(bucket, key); ok {
+		if _, err := c.keyring.open(ctx, info.Metadata, sse); err != nil {
+			return nil, err
+		}
+		return dataRange(data, offset, length), nil
+	}
+	o, err := c.lookup(ctx, bucket, key)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetObjectRange", "err", err)
+		return nil, err
+	}
+	os, info := o.store, o.infoCopy()
+	dataKey, err := c.keyring.open(ctx, info.Metadata, sse)
+	if err != nil {
+		logging.Warn(logging.WithContext(ctx, c.logger), "msg", "Error at GetObjectRange", "err", err)
+		return nil, err
+	}
+	res, err := c.readObject(ctx, os, info, dataKey, offset, length)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at ListBuckets", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetObjectRange", "err", err)
 		return nil, err
 	}
-	return js.ObjectStores(ctx).Status(), nil
+	return res, nil
 }
 
-// ListObjects lists all objects in the given bucket.
-func (c *NatsObjectClient) ListObjects(ctx context.Context, bucket string) ([]*jetstream.ObjectInfo, error) {
-	logging.Info(c.logger, "msg", fmt.Sprintf("List objects: [%s]", bucket))
-	js, err := c.client.Jetstream()
+// dataRange returns length bytes of data starting at offset, or up to its
+// end when length is negative.
+func dataRange(data []byte, offset int64, length int64) []byte {
+	offset = min(max(offset, 0), int64(len(data)))
+	end := int64(len(data))
+	if length >= 0 {
+		end = min(offset+length, end)
+	}
+	return data[offset:end]
+}
+
+// CheckObjectKey verifies that sse carries what is needed to read the object,
+// such as the matching customer key of an SSE-C object.
+func (c *NatsObjectClient) CheckObjectKey(ctx context.Context, info *jetstream.ObjectInfo, sse *ServerSideEncryption) error {
+	_, err := c.keyring.open(ctx, info.Metadata, sse)
+	return err
+}
+
+// readObject reads the data of an object from offset, stitching manifest
+// parts and decrypting as needed. A negative length reads to the end.
+func (c *NatsObjectClient) readObject(ctx context.Context, os jetstream.ObjectStore, info *jetstream.ObjectInfo, dataKey []byte, offset int64, length int64) ([]byte, error) {
+	codec := objectCodec{dataKey: dataKey, compression: info.Metadata[MetaCompression]}
+	var rc io.ReadCloser
+	var err error
+	if IsManifest(info) {
+		var layout *ObjectLayout
+		layout, err = readLayout(ctx, os, info)
+		if err == nil {
+			rc = openManifest(ctx, os, layout, codec, offset)
+		}
+	} else {
+		rc, err = os.Get(ctx, info.Name)
+		if err == nil {
+			rc, err = codec.decode(rc, offset)
+		}
+	}
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at ListObjects", "err", err)
 		return nil, err
 	}
+	defer rc.Close()
+	if length < 0 {
+		return io.ReadAll(rc)
+	}
+	return io.ReadAll(io.LimitReader(rc, length))
+}
+
+// ListBuckets returns a channel of object store statuses for all buckets.
+func (c *NatsObjectClient) ListBuckets(ctx context.Context) (_ <-chan jetstream.ObjectStoreStatus, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.ListBuckets")
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", "List buckets")
+	contexts := c.routes.contexts()
+	res := make(chan jetstream.ObjectStoreStatus)
+	go func() {
+		defer close(res)
+		for _, js := range contexts {
+			for status := range js.ObjectStores(ctx).Status() {
+				// A bucket is listed by the context it is routed to only
+				if c.bucketJS(status.Bucket()) != js {
+					continue
+				}
+				select {
+				case res <- status:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return res, nil
+}
+
+// ListObjects lists all objects in the given bucket.
+func (c *NatsObjectClient) ListObjects(ctx context.Context, bucket string) (_ []*jetstream.ObjectInfo, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.ListObjects", attribute.String("aws.s3.bucket", bucket))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("List objects: [%s]", bucket))
+	js := c.bucketJS(bucket)
 	os, err := js.ObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at ListObjects", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at ListObjects", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return nil, ErrBucketNotFound
 		}
@@ -230,37 +538,64 @@ func (c *NatsObjectClient) ListObjects(ctx context.Context, bucket string) ([]*j
 	}
 	ls, err := os.List(ctx)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at ListObjects", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at ListObjects", "err", err)
 		if errors.Is(err, jetstream.ErrNoObjectsFound) {
 			return nil, ErrObjectNotFound
 		}
 		return nil, err
 	}
-	return ls, err
+	objects := ls[:0]
+	for _, info := range ls {
+		if isPartKey(info.Name) {
+			continue
+		}
+		resolveObjectInfo(info)
+		objects = append(objects, info)
+	}
+	return objects, nil
 }
 
-// PutObjectStream writes an object using a streaming reader.
+// PutObjectStream writes an object using a streaming reader. When sse is
+// set, the data is encrypted under a new data key before it reaches
+// JetStream, and the plaintext size and MD5 are recorded in the metadata.
 func (c *NatsObjectClient) PutObjectStream(ctx context.Context,
 	bucket string,
 	key string,
 	contentType string,
 	metadata map[string]string,
-	reader io.Reader) (*jetstream.ObjectInfo, error) {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Pub object (stream): [%s/%s]", bucket, key))
-	js, err := c.client.Jetstream()
-	if err != nil {
-		logging.Error(c.logger, "msg", "Error at PutObjectStream", "err", err)
-		return nil, err
-	}
+	reader io.Reader,
+	sse *ServerSideEncryption) (_ *jetstream.ObjectInfo, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.PutObjectStream", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(bucket, key)
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Pub object (stream): [%s/%s]", bucket, key))
+	if isPartKey(key) {
+		return nil, fmt.Errorf("object key %q is reserved", key)
+	}
+	js := c.bucketJS(bucket)
 	os, err := js.ObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at PutObjectStream", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutObjectStream", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return nil, ErrBucketNotFound
 		}
 		return nil, err
 	}
 
+	dataKey, sseMeta, err := c.keyring.seal(ctx, sse)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutObjectStream", "err", err)
+		return nil, err
+	}
+
+	// Remember the parts of the object being replaced so they can be released
+	prev, err := os.GetInfo(ctx, key)
+	if err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutObjectStream", "err", err)
+		return nil, err
+	}
+	prevLayout := c.releasedLayout(ctx, os, prev)
+
 	meta := jetstream.ObjectMeta{
 		Name:     key,
 		Metadata: metadata,
@@ -268,21 +603,103 @@ func (c *NatsObjectClient) PutObjectStream(ctx context.Context,
 			"Content-Type": []string{contentType},
 		},
 	}
+	requestHeaders(ctx, meta.Headers)
 
-	return os.Put(ctx, meta, reader)
+	cfg, _, err := c.bucketConfigs.get(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	codec := objectCodec{dataKey: dataKey, compression: compressionFor(cfg, c.opts.Compression, contentType)}
+
+	var plain *plaintextCounter
+	if !codec.identity() {
+		meta.Metadata = make(map[string]string, len(metadata)+len(sseMeta)+3)
+		for k, v := range metadata {
+			meta.Metadata[k] = v
+		}
+		for k, v := range sseMeta {
+			meta.Metadata[k] = v
+		}
+		if codec.compression != "" {
+			meta.Metadata[MetaCompression] = codec.compression
+		}
+		plain = newPlaintextCounter(reader)
+		reader, err = codec.encode(plain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Put(ctx, meta, reader)
+	if err != nil {
+		return nil, storeError(err)
+	}
+	written := time.Now()
+	if plain != nil {
+		if codec.compression != "" {
+			writeStats.record(codec.compression, plain.size, int64(info.Size))
+		}
+		info.Metadata[MetaObjectSize] = strconv.FormatInt(plain.size, 10)
+		info.Metadata[MetaObjectETag] = plain.etag()
+		if err := os.UpdateMeta(ctx, key, info.ObjectMeta); err != nil {
+			logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutObjectStream when UpdateMeta()", "err", err)
+			return nil, err
+		}
+		resolveObjectInfo(info)
+	}
+	releaseParts(ctx, c.logger, os, prevLayout)
+	replaced := objectUsage(prev)
+	c.usage.add(ctx, bucket, BucketUsage{Bytes: int64(info.Size) - replaced.Bytes, Objects: 1 - replaced.Objects}, written)
+	return info, nil
 }
 
-// GetObjectRetention retrieves retention metadata for an object
-func (c *NatsObjectClient) GetObjectRetention(ctx context.Context, bucket string, key string) (mode string, retainUntilDate string, err error) {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Get object retention: %s/%s", bucket, key))
-	js, err := c.client.Jetstream()
+// plaintextCounter tracks the size and MD5 of the data read through it.
+type plaintextCounter struct {
+	r    io.Reader
+	h    hash.Hash
+	size int64
+}
+
+func newPlaintextCounter(r io.Reader) *plaintextCounter {
+	return &plaintextCounter{r: r, h: md5.New()}
+}
+
+func (p *plaintextCounter) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.h.Write(b[:n])
+	p.size += int64(n)
+	return n, err
+}
+
+// etag returns the hex MD5 of the data read so far.
+func (p *plaintextCounter) etag() string {
+	return hex.EncodeToString(p.h.Sum(nil))
+}
+
+// GetObjectLayout returns the part layout of an object of bucket assembled
+// from a multipart upload, or nil when the object was uploaded in a single
+// part.
+func (c *NatsObjectClient) GetObjectLayout(ctx context.Context, bucket string, info *jetstream.ObjectInfo) (*ObjectLayout, error) {
+	logging.Debug(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Get object layout: [%s/%s]", bucket, info.Name))
+	if !IsManifest(info) {
+		return nil, nil
+	}
+	os, err := c.readStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObjectRetention when jetstream.New()", "err", err)
-		return "", "", err
+		return nil, err
 	}
+	return readLayout(ctx, os, info)
+}
+
+// GetObjectRetention retrieves retention metadata for an object
+func (c *NatsObjectClient) GetObjectRetention(ctx context.Context, bucket string, key string) (mode string, retainUntilDate string, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.GetObjectRetention", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Get object retention: %s/%s", bucket, key))
+	js := c.bucketJS(bucket)
 	os, err := js.ObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at GetObjectRetention", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at GetObjectRetention", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return "", "", ErrBucketNotFound
 		}
@@ -294,7 +711,7 @@ func (c *NatsObjectClient) GetObjectRetention(ctx context.Context, bucket string
 		if errors.Is(err, jetstream.ErrObjectNotFound) {
 			return "", "", ErrObjectNotFound
 		}
-		logging.Error(c.logger, "msg", "Error getting object info", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object info", "err", err)
 		return "", "", err
 	}
 
@@ -311,16 +728,15 @@ func (c *NatsObjectClient) GetObjectRetention(ctx context.Context, bucket string
 }
 
 // PutObjectRetention sets retention metadata for an existing object
-func (c *NatsObjectClient) PutObjectRetention(ctx context.Context, bucket string, key string, mode string, retainUntilDate string) error {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Put object retention: %s/%s mode=%s until=%s", bucket, key, mode, retainUntilDate))
-	js, err := c.client.Jetstream()
-	if err != nil {
-		logging.Error(c.logger, "msg", "Error at PutObjectRetention when jetstream.New()", "err", err)
-		return err
-	}
+func (c *NatsObjectClient) PutObjectRetention(ctx context.Context, bucket string, key string, mode string, retainUntilDate string) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.PutObjectRetention", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(bucket, key)
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Put object retention: %s/%s mode=%s until=%s", bucket, key, mode, retainUntilDate))
+	js := c.bucketJS(bucket)
 	os, err := js.ObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error at PutObjectRetention", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at PutObjectRetention", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return ErrBucketNotFound
 		}
@@ -333,7 +749,7 @@ func (c *NatsObjectClient) PutObjectRetention(ctx context.Context, bucket string
 		if errors.Is(err, jetstream.ErrObjectNotFound) {
 			return ErrObjectNotFound
 		}
-		logging.Error(c.logger, "msg", "Error getting object info", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object info", "err", err)
 		return err
 	}
 
@@ -354,7 +770,7 @@ func (c *NatsObjectClient) PutObjectRetention(ctx context.Context, bucket string
 
 	err = os.UpdateMeta(ctx, key, meta)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error updating object metadata", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error updating object metadata", "err", err)
 		return err
 	}
 
@@ -363,18 +779,17 @@ func (c *NatsObjectClient) PutObjectRetention(ctx context.Context, bucket string
 
 // PutObjectTags sets or replaces tags on an existing object.
 // Tags are stored in metadata with "x-amz-tag-" prefix.
-func (c *NatsObjectClient) PutObjectTags(ctx context.Context, bucket string, key string, tagMetadata map[string]string) error {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Put object tags: %s/%s", bucket, key))
+func (c *NatsObjectClient) PutObjectTags(ctx context.Context, bucket string, key string, tagMetadata map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.PutObjectTags", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(bucket, key)
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Put object tags: %s/%s", bucket, key))
 
-	js, err := c.client.Jetstream()
-	if err != nil {
-		logging.Error(c.logger, "msg", "Error at PutObjectTags when jetstream.New()", "err", err)
-		return err
-	}
+	js := c.bucketJS(bucket)
 
 	os, err := js.ObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error getting object store", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object store", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return ErrBucketNotFound
 		}
@@ -387,7 +802,7 @@ func (c *NatsObjectClient) PutObjectTags(ctx context.Context, bucket string, key
 		if errors.Is(err, jetstream.ErrObjectNotFound) {
 			return ErrObjectNotFound
 		}
-		logging.Error(c.logger, "msg", "Error getting object info", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object info", "err", err)
 		return err
 	}
 
@@ -412,7 +827,7 @@ func (c *NatsObjectClient) PutObjectTags(ctx context.Context, bucket string, key
 
 	err = os.UpdateMeta(ctx, key, meta)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error updating object metadata", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error updating object metadata", "err", err)
 		return err
 	}
 
@@ -420,18 +835,17 @@ func (c *NatsObjectClient) PutObjectTags(ctx context.Context, bucket string, key
 }
 
 // DeleteObjectTags removes all tags from an object.
-func (c *NatsObjectClient) DeleteObjectTags(ctx context.Context, bucket string, key string) error {
-	logging.Info(c.logger, "msg", fmt.Sprintf("Delete object tags: %s/%s", bucket, key))
+func (c *NatsObjectClient) DeleteObjectTags(ctx context.Context, bucket string, key string) (err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.DeleteObjectTags", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	defer c.cache.invalidate(bucket, key)
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Delete object tags: %s/%s", bucket, key))
 
-	js, err := c.client.Jetstream()
-	if err != nil {
-		logging.Error(c.logger, "msg", "Error at DeleteObjectTags when jetstream.New()", "err", err)
-		return err
-	}
+	js := c.bucketJS(bucket)
 
 	os, err := js.ObjectStore(ctx, bucket)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error getting object store", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object store", "err", err)
 		if errors.Is(err, jetstream.ErrBucketNotFound) {
 			return ErrBucketNotFound
 		}
@@ -444,7 +858,7 @@ func (c *NatsObjectClient) DeleteObjectTags(ctx context.Context, bucket string,
 		if errors.Is(err, jetstream.ErrObjectNotFound) {
 			return ErrObjectNotFound
 		}
-		logging.Error(c.logger, "msg", "Error getting object info", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error getting object info", "err", err)
 		return err
 	}
 
@@ -461,7 +875,7 @@ func (c *NatsObjectClient) DeleteObjectTags(ctx context.Context, bucket string,
 
 	err = os.UpdateMeta(ctx, key, meta)
 	if err != nil {
-		logging.Error(c.logger, "msg", "Error updating object metadata", "err", err)
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error updating object metadata", "err", err)
 		return err
 	}
 
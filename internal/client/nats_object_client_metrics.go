@@ -27,6 +27,22 @@ type MetricCollector struct {
 
 	// Total reconnect metrics to NATS
 	totalReconnectDesc *prometheus.Desc
+
+	// Compression at rest metrics, per algorithm
+	compressionLogicalDesc *prometheus.Desc
+	compressionStoredDesc  *prometheus.Desc
+	compressionRatioDesc   *prometheus.Desc
+
+	// Usage metrics of buckets with a quota
+	bucketUsageBytesDesc   *prometheus.Desc
+	bucketUsageObjectsDesc *prometheus.Desc
+	quotaSoftExceededDesc  *prometheus.Desc
+
+	// Read cache metrics, per tier
+	cacheHitsDesc    *prometheus.Desc
+	cacheMissesDesc  *prometheus.Desc
+	cacheBytesDesc   *prometheus.Desc
+	cacheEntriesDesc *prometheus.Desc
 }
 
 func NewMetricCollector(logger log.Logger, client *NatsObjectClient) *MetricCollector {
@@ -53,6 +69,69 @@ func NewMetricCollector(logger log.Logger, client *NatsObjectClient) *MetricColl
 			nil,
 			nil,
 		),
+
+		compressionLogicalDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "compression_logical_bytes_total"),
+			"The total number of uncompressed bytes written to compressed objects.",
+			[]string{"algorithm"},
+			nil,
+		),
+		compressionStoredDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "compression_stored_bytes_total"),
+			"The total number of bytes stored for compressed objects.",
+			[]string{"algorithm"},
+			nil,
+		),
+		compressionRatioDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "compression_ratio"),
+			"The ratio of uncompressed to stored bytes of compressed objects.",
+			[]string{"algorithm"},
+			nil,
+		),
+
+		bucketUsageBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "bucket_usage_bytes"),
+			"The logical size of a bucket with a quota.",
+			[]string{"bucket"},
+			nil,
+		),
+		bucketUsageObjectsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "bucket_usage_objects"),
+			"The number of objects in a bucket with a quota.",
+			[]string{"bucket"},
+			nil,
+		),
+		quotaSoftExceededDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "bucket_quota_soft_exceeded"),
+			"Whether a bucket is past a soft limit of its quota.",
+			[]string{"bucket"},
+			nil,
+		),
+
+		cacheHitsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "cache_hits_total"),
+			"The total number of object reads served by the read cache.",
+			[]string{"tier"},
+			nil,
+		),
+		cacheMissesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "cache_misses_total"),
+			"The total number of object reads not found in the read cache.",
+			nil,
+			nil,
+		),
+		cacheBytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "cache_bytes"),
+			"The size of the objects held by the read cache.",
+			[]string{"tier"},
+			nil,
+		),
+		cacheEntriesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, objectStoreSubsystem, "cache_entries"),
+			"The number of objects held by the read cache.",
+			[]string{"tier"},
+			nil,
+		),
 	}
 }
 
@@ -80,6 +159,56 @@ func (c MetricCollector) Collect(ch chan<- prometheus.Metric) {
 		prometheus.CounterValue,
 		float64(stats.Reconnects),
 	)
+
+	logical, stored := writeStats.snapshot()
+	for algorithm, n := range logical {
+		ch <- prometheus.MustNewConstMetric(c.compressionLogicalDesc, prometheus.CounterValue, float64(n), algorithm)
+		ch <- prometheus.MustNewConstMetric(c.compressionStoredDesc, prometheus.CounterValue, float64(stored[algorithm]), algorithm)
+		if stored[algorithm] > 0 {
+			ch <- prometheus.MustNewConstMetric(c.compressionRatioDesc, prometheus.GaugeValue, float64(n)/float64(stored[algorithm]), algorithm)
+		}
+	}
+
+	c.collectQuotas(ch)
+	c.collectCache(ch)
+}
+
+// collectCache reports the hits, misses and usage of the read cache.
+func (c MetricCollector) collectCache(ch chan<- prometheus.Metric) {
+	if c.client.cache == nil {
+		return
+	}
+	stats := c.client.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(c.cacheMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+	for _, tier := range []string{CacheTierMemory, CacheTierDisk} {
+		ch <- prometheus.MustNewConstMetric(c.cacheHitsDesc, prometheus.CounterValue, float64(stats.Hits[tier]), tier)
+		ch <- prometheus.MustNewConstMetric(c.cacheBytesDesc, prometheus.GaugeValue, float64(stats.Bytes[tier]), tier)
+		ch <- prometheus.MustNewConstMetric(c.cacheEntriesDesc, prometheus.GaugeValue, float64(stats.Entries[tier]), tier)
+	}
+}
+
+// collectQuotas reports the usage of buckets with a quota.
+func (c MetricCollector) collectQuotas(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	quotas, err := c.client.bucketConfigs.quotas(ctx)
+	if err != nil {
+		logging.Warn(c.logger, "msg", "Error at listing bucket quotas for metrics", "err", err)
+		return
+	}
+	for bucket, quota := range quotas {
+		usage, err := c.client.GetBucketUsage(ctx, bucket)
+		if err != nil {
+			logging.Warn(c.logger, "msg", "Error at bucket usage for metrics", "bucket", bucket, "err", err)
+			continue
+		}
+		exceeded := 0.0
+		if quota.SoftExceeded(*usage) {
+			exceeded = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.bucketUsageBytesDesc, prometheus.GaugeValue, float64(usage.Bytes), bucket)
+		ch <- prometheus.MustNewConstMetric(c.bucketUsageObjectsDesc, prometheus.GaugeValue, float64(usage.Objects), bucket)
+		ch <- prometheus.MustNewConstMetric(c.quotaSoftExceededDesc, prometheus.GaugeValue, exceeded, bucket)
+	}
 }
 
 // countBuckets return number of buckets
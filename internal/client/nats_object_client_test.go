@@ -3,13 +3,19 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
 	"testing"
 	"time"
 
+	"github.com/wpnpeiris/nats-s3/internal/encryption"
 	"github.com/wpnpeiris/nats-s3/internal/logging"
 	"github.com/wpnpeiris/nats-s3/internal/testutil"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 func TestNatsObjectClient_BasicCRUD(t *testing.T) {
@@ -48,7 +54,7 @@ func TestNatsObjectClient_BasicCRUD(t *testing.T) {
 	}
 
 	// Put
-	info, err := oc.PutObjectStream(context.Background(), bucket, key, "text/plain", map[string]string{"k": "v"}, bytes.NewReader(data))
+	info, err := oc.PutObjectStream(context.Background(), bucket, key, "text/plain", map[string]string{"k": "v"}, bytes.NewReader(data), nil)
 	if err != nil {
 		t.Fatalf("PutObject failed: %v", err)
 	}
@@ -69,7 +75,7 @@ func TestNatsObjectClient_BasicCRUD(t *testing.T) {
 	}
 
 	// Get
-	gotInfo, gotData, err := oc.GetObject(context.Background(), bucket, key)
+	gotInfo, gotData, err := oc.GetObject(context.Background(), bucket, key, nil)
 	if err != nil {
 		t.Fatalf("GetObject failed: %v", err)
 	}
@@ -77,6 +83,21 @@ func TestNatsObjectClient_BasicCRUD(t *testing.T) {
 		t.Fatalf("unexpected GetObject: info=%+v data=%q", gotInfo, string(gotData))
 	}
 
+	// Reads in the context of ResolveObject reuse its lookup
+	rctx, ri, err := oc.ResolveObject(context.Background(), bucket, key)
+	if err != nil || ri.Size != uint64(len(data)) {
+		t.Fatalf("unexpected ResolveObject: info=%+v err=%v", ri, err)
+	}
+	if _, err := oc.PutObjectStream(context.Background(), bucket, key, "text/plain", map[string]string{"k": "v"}, bytes.NewReader(data[:1]), nil); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if gi, err := oc.GetObjectInfo(rctx, bucket, key); err != nil || gi.Size != uint64(len(data)) {
+		t.Fatalf("expected the resolved info, got %+v err=%v", gi, err)
+	}
+	if gi, err := oc.GetObjectInfo(context.Background(), bucket, key); err != nil || gi.Size != 1 {
+		t.Fatalf("expected the replaced info, got %+v err=%v", gi, err)
+	}
+
 	// ListObjects should include our key
 	list, err := oc.ListObjects(context.Background(), bucket)
 	if err != nil {
@@ -128,3 +149,577 @@ delete:
 		t.Fatalf("expected error getting deleted object info")
 	}
 }
+
+func TestNatsObjectClient_MultipartManifest(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	c := NewClient("manifest-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+
+	bucket := "manifestbucket"
+	key := "big/object.bin"
+	uploadID := "upload-1"
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket}); err != nil {
+		t.Fatalf("create object store failed: %v", err)
+	}
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+	mp, err := NewMultiPartStore(logger, c, MultiPartStoreOptions{})
+	if err != nil {
+		t.Fatalf("NewMultiPartStore failed: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := mp.InitMultipartUpload(ctx, bucket, key, uploadID, "", nil, nil); err != nil {
+		t.Fatalf("InitMultipartUpload failed: %v", err)
+	}
+	parts := [][]byte{
+		bytes.Repeat([]byte("a"), 5*1024*1024),
+		bytes.Repeat([]byte("b"), 5*1024*1024),
+		[]byte("unused"),
+		[]byte("tail"),
+	}
+	var completed []CompletedPart
+	for i, data := range parts {
+		etag, err := mp.UploadPart(ctx, bucket, key, uploadID, i+1, io.NopCloser(bytes.NewReader(data)), nil)
+		if err != nil {
+			t.Fatalf("UploadPart %d failed: %v", i+1, err)
+		}
+		if i != 2 {
+			completed = append(completed, CompletedPart{Number: i + 1, ETag: etag})
+		}
+	}
+	etag, err := mp.CompleteMultipartUpload(ctx, bucket, key, uploadID, completed)
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	// The stored object is a manifest; the data stays in parts of the bucket.
+	store, err := js.ObjectStore(bucket)
+	if err != nil {
+		t.Fatalf("bucket store failed: %v", err)
+	}
+	if _, err := store.GetInfo(partKey(uploadID, 1)); err != nil {
+		t.Fatalf("expected part data to be kept: %v", err)
+	}
+	if _, err := store.GetInfo(partKey(uploadID, 3)); err == nil {
+		t.Fatalf("expected unused part data to be removed")
+	}
+
+	want := append(append(append([]byte{}, parts[0]...), parts[1]...), parts[3]...)
+	info, data, err := oc.GetObject(ctx, bucket, key, nil)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("unexpected manifest data: got %d bytes", len(data))
+	}
+	if info.Size != uint64(len(want)) || `"`+info.Digest+`"` != etag {
+		t.Fatalf("unexpected manifest info: size=%d digest=%q etag=%s", info.Size, info.Digest, etag)
+	}
+
+	// Parts keep the numbers they were uploaded with
+	layout, err := oc.GetObjectLayout(ctx, bucket, info)
+	if err != nil || layout == nil {
+		t.Fatalf("GetObjectLayout failed: %v", err)
+	}
+	if offset, size, err := layout.PartRange(4); err != nil || offset != 10*1024*1024 || size != 4 {
+		t.Fatalf("unexpected range of part 4: offset=%d size=%d err=%v", offset, size, err)
+	}
+	if _, _, err := layout.PartRange(3); !errors.Is(err, ErrInvalidPartNumber) {
+		t.Fatalf("expected ErrInvalidPartNumber for part 3, got %v", err)
+	}
+	list, err := oc.ListObjects(ctx, bucket)
+	if err != nil || len(list) != 1 || list[0].Size != uint64(len(want)) {
+		t.Fatalf("unexpected ListObjects: %+v err=%v", list, err)
+	}
+
+	// Deleting the object releases the part data.
+	if err := oc.DeleteObject(ctx, bucket, key); err != nil {
+		t.Fatalf("DeleteObject failed: %v", err)
+	}
+	if _, err := store.GetInfo(partKey(uploadID, 1)); err == nil {
+		t.Fatalf("expected part data to be released on delete")
+	}
+}
+
+func TestNatsObjectClient_EncryptedMultipart(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	c := NewClient("sse-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	bucket := "ssebucket"
+	key := "secret.bin"
+	uploadID := "upload-1"
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket}); err != nil {
+		t.Fatalf("create object store failed: %v", err)
+	}
+
+	masterKey, _ := encryption.GenerateKey()
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{MasterKey: masterKey})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+	mp, err := NewMultiPartStore(logger, c, MultiPartStoreOptions{MasterKey: masterKey})
+	if err != nil {
+		t.Fatalf("NewMultiPartStore failed: %v", err)
+	}
+
+	ctx := context.Background()
+	sse := &ServerSideEncryption{Algorithm: SSEAlgorithmAES256}
+	if err := mp.InitMultipartUpload(ctx, bucket, key, uploadID, "", sse, nil); err != nil {
+		t.Fatalf("InitMultipartUpload failed: %v", err)
+	}
+	parts := [][]byte{
+		bytes.Repeat([]byte("secret-a"), 5*1024*1024/8),
+		[]byte("secret-tail"),
+	}
+	var completed []CompletedPart
+	for i, data := range parts {
+		etag, err := mp.UploadPart(ctx, bucket, key, uploadID, i+1, io.NopCloser(bytes.NewReader(data)), nil)
+		if err != nil {
+			t.Fatalf("UploadPart %d failed: %v", i+1, err)
+		}
+		completed = append(completed, CompletedPart{Number: i + 1, ETag: etag})
+	}
+	if _, err := mp.CompleteMultipartUpload(ctx, bucket, key, uploadID, completed); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	// Part data is encrypted at rest
+	store, err := js.ObjectStore(bucket)
+	if err != nil {
+		t.Fatalf("bucket store failed: %v", err)
+	}
+	raw, err := store.GetBytes(partKey(uploadID, 2))
+	if err != nil || bytes.Contains(raw, parts[1]) {
+		t.Fatalf("expected encrypted part data (err=%v)", err)
+	}
+
+	want := append(append([]byte{}, parts[0]...), parts[1]...)
+	info, data, err := oc.GetObject(ctx, bucket, key, nil)
+	if err != nil || !bytes.Equal(data, want) {
+		t.Fatalf("GetObject mismatch (err=%v)", err)
+	}
+	if algorithm, _ := ObjectEncryption(info.Metadata); algorithm != SSEAlgorithmAES256 {
+		t.Fatalf("unexpected object encryption %q", algorithm)
+	}
+
+	// Ranges spanning the part boundary decrypt both parts
+	offset := int64(len(parts[0]) - 4)
+	got, err := oc.GetObjectRange(ctx, bucket, key, nil, offset, 8)
+	if err != nil || !bytes.Equal(got, want[offset:offset+8]) {
+		t.Fatalf("GetObjectRange mismatch: %q (err=%v)", got, err)
+	}
+
+	// Opened objects are read sequentially and at random offsets
+	single := bytes.Repeat([]byte("single-0"), 300*1024/8)
+	if _, err := oc.PutObjectStream(ctx, bucket, "single.bin", "", nil, bytes.NewReader(single), sse); err != nil {
+		t.Fatalf("PutObjectStream failed: %v", err)
+	}
+	for name, data := range map[string][]byte{key: want, "single.bin": single} {
+		o, err := oc.OpenObject(ctx, bucket, name, nil)
+		if err != nil {
+			t.Fatalf("OpenObject %s failed: %v", name, err)
+		}
+		if o.Size() != int64(len(data)) {
+			t.Fatalf("%s: expected size %d, got %d", name, len(data), o.Size())
+		}
+		read, err := io.ReadAll(io.NewSectionReader(o, 0, o.Size()))
+		if err != nil || !bytes.Equal(read, data) {
+			t.Fatalf("%s: sequential read mismatch (err=%v)", name, err)
+		}
+		for _, off := range []int64{int64(len(data)) - 10, 3, int64(len(data)) / 2} {
+			buf := make([]byte, 16)
+			n, err := o.ReadAt(buf, off)
+			if end := min(off+16, int64(len(data))); !bytes.Equal(buf[:n], data[off:end]) || (n < 16) != (err == io.EOF) {
+				t.Fatalf("%s: ReadAt %d mismatch: %q (err=%v)", name, off, buf[:n], err)
+			}
+		}
+		o.Close()
+	}
+}
+
+func TestNatsObjectClient_Compression(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	c := NewClient("compression-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	bucket := "zbucket"
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket}); err != nil {
+		t.Fatalf("create object store failed: %v", err)
+	}
+
+	masterKey, _ := encryption.GenerateKey()
+	opts := CompressionOptions{Algorithm: "zstd", ContentTypes: []string{"text/*"}}
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{MasterKey: masterKey, Compression: opts})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+	mp, err := NewMultiPartStore(logger, c, MultiPartStoreOptions{MasterKey: masterKey, Compression: opts})
+	if err != nil {
+		t.Fatalf("NewMultiPartStore failed: %v", err)
+	}
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("2026-10-18 GET /index.html 200\n"), 200000)
+	sum := md5.Sum(data)
+
+	for _, sse := range []*ServerSideEncryption{nil, {Algorithm: SSEAlgorithmAES256}} {
+		key := "log.txt"
+		if _, err := oc.PutObjectStream(ctx, bucket, key, "text/plain; charset=utf-8", nil, bytes.NewReader(data), sse); err != nil {
+			t.Fatalf("PutObjectStream failed: %v", err)
+		}
+		info, err := oc.GetObjectInfo(ctx, bucket, key)
+		if err != nil {
+			t.Fatalf("GetObjectInfo failed: %v", err)
+		}
+		// Size and ETag are those of the uncompressed object
+		if info.Size != uint64(len(data)) || info.Metadata[MetaObjectETag] != hex.EncodeToString(sum[:]) {
+			t.Fatalf("unexpected size %d or etag %q", info.Size, info.Metadata[MetaObjectETag])
+		}
+		if info.Metadata[MetaCompression] != "zstd" {
+			t.Fatalf("expected zstd compression, got %q", info.Metadata[MetaCompression])
+		}
+		_, got, err := oc.GetObject(ctx, bucket, key, nil)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("GetObject mismatch (err=%v)", err)
+		}
+		offset := int64(len(data) - 100)
+		got, err = oc.GetObjectRange(ctx, bucket, key, nil, offset, 50)
+		if err != nil || !bytes.Equal(got, data[offset:offset+50]) {
+			t.Fatalf("GetObjectRange mismatch (err=%v)", err)
+		}
+	}
+
+	// Content types outside the configured list are stored as-is
+	if _, err := oc.PutObjectStream(ctx, bucket, "image.png", "image/png", nil, bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("PutObjectStream failed: %v", err)
+	}
+	if info, _ := oc.GetObjectInfo(ctx, bucket, "image.png"); info.Metadata[MetaCompression] != "" {
+		t.Fatalf("expected image/png to be stored uncompressed")
+	}
+
+	// Multipart parts are compressed and ranges read across part boundaries
+	key, uploadID := "multi.txt", "upload-z"
+	if err := mp.InitMultipartUpload(ctx, bucket, key, uploadID, "text/plain", nil, nil); err != nil {
+		t.Fatalf("InitMultipartUpload failed: %v", err)
+	}
+	parts := [][]byte{data[:5*1024*1024], data[5*1024*1024:]}
+	var completed []CompletedPart
+	for i, p := range parts {
+		etag, err := mp.UploadPart(ctx, bucket, key, uploadID, i+1, io.NopCloser(bytes.NewReader(p)), nil)
+		if err != nil {
+			t.Fatalf("UploadPart %d failed: %v", i+1, err)
+		}
+		completed = append(completed, CompletedPart{Number: i + 1, ETag: etag})
+	}
+	if _, err := mp.CompleteMultipartUpload(ctx, bucket, key, uploadID, completed); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	store, err := js.ObjectStore(bucket)
+	if err != nil {
+		t.Fatalf("bucket store failed: %v", err)
+	}
+	if partInfo, err := store.GetInfo(partKey(uploadID, 1)); err != nil || partInfo.Size >= uint64(len(parts[0])) {
+		t.Fatalf("expected compressed part data (err=%v)", err)
+	}
+	offset := int64(len(parts[0]) - 10)
+	got, err := oc.GetObjectRange(ctx, bucket, key, nil, offset, 20)
+	if err != nil || !bytes.Equal(got, data[offset:offset+20]) {
+		t.Fatalf("GetObjectRange across parts mismatch (err=%v)", err)
+	}
+
+	logical, stored := writeStats.snapshot()
+	if logical["zstd"] == 0 || stored["zstd"] >= logical["zstd"] {
+		t.Fatalf("unexpected compression stats: logical=%d stored=%d", logical["zstd"], stored["zstd"])
+	}
+}
+
+func TestNatsObjectClient_BucketRoutes(t *testing.T) {
+	hub := testutil.StartJSServer(t)
+	defer hub.Shutdown()
+	edge := testutil.StartJSServer(t)
+	defer edge.Shutdown()
+
+	c := NewClient("routes-test")
+	if err := c.SetupConnectionToNATS(hub.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	routes, err := NewBucketRouter(logger, c, []BucketRoute{
+		{Prefix: "edge-", Servers: edge.ClientURL()},
+		{Prefix: "edge-hub-"},
+		{Bucket: "edge-hub-pinned", Servers: edge.ClientURL()},
+	})
+	if err != nil {
+		t.Fatalf("NewBucketRouter failed: %v", err)
+	}
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{Routes: routes})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, bucket := range []string{"local", "edge-1", "edge-hub-1", "edge-hub-pinned"} {
+		if _, err := oc.CreateBucket(ctx, bucket, BucketOptions{}); err != nil {
+			t.Fatalf("CreateBucket %s failed: %v", bucket, err)
+		}
+	}
+
+	edgeNC, err := nats.Connect(edge.ClientURL())
+	if err != nil {
+		t.Fatalf("connect edge failed: %v", err)
+	}
+	defer edgeNC.Close()
+	edgeJS, err := edgeNC.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	hubJS, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	// The longest prefix wins, and an exact bucket wins over any prefix
+	for bucket, onEdge := range map[string]bool{"local": false, "edge-1": true, "edge-hub-1": false, "edge-hub-pinned": true} {
+		want, other := hubJS, edgeJS
+		if onEdge {
+			want, other = edgeJS, hubJS
+		}
+		if _, err := want.ObjectStore(bucket); err != nil {
+			t.Fatalf("expected bucket %s on its routed server: %v", bucket, err)
+		}
+		if _, err := other.ObjectStore(bucket); err == nil {
+			t.Fatalf("bucket %s created on the wrong server", bucket)
+		}
+	}
+
+	data := []byte("routed")
+	if _, err := oc.PutObjectStream(ctx, "edge-1", "k", "text/plain", nil, bytes.NewReader(data), nil); err != nil {
+		t.Fatalf("PutObjectStream failed: %v", err)
+	}
+	_, got, err := oc.GetObject(ctx, "edge-1", "k", nil)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetObject mismatch: %q %v", got, err)
+	}
+
+	// Multipart sessions and part metadata follow the route of their bucket
+	mp, err := NewMultiPartStore(logger, c, MultiPartStoreOptions{Routes: routes})
+	if err != nil {
+		t.Fatalf("NewMultiPartStore failed: %v", err)
+	}
+	if err := mp.InitMultipartUpload(ctx, "edge-1", "mp", "routed-upload", "", nil, nil); err != nil {
+		t.Fatalf("InitMultipartUpload failed: %v", err)
+	}
+	if _, err := mp.UploadPart(ctx, "edge-1", "mp", "routed-upload", 1, io.NopCloser(bytes.NewReader(data)), nil); err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+	for _, name := range []string{MetaStoreName, PartMetaStoreName} {
+		kv, err := edgeJS.KeyValue(name)
+		if err != nil {
+			t.Fatalf("expected %s on the edge server: %v", name, err)
+		}
+		if keys, err := kv.Keys(); err != nil || len(keys) != 1 {
+			t.Fatalf("expected the upload in %s on the edge server, got %v %v", name, keys, err)
+		}
+		if kv, err := hubJS.KeyValue(name); err == nil {
+			if keys, _ := kv.Keys(); len(keys) != 0 {
+				t.Fatalf("upload of a routed bucket stored in %s on the hub: %v", name, keys)
+			}
+		}
+	}
+	sum := md5.Sum(data)
+	if _, err := mp.CompleteMultipartUpload(ctx, "edge-1", "mp", "routed-upload", []CompletedPart{{Number: 1, ETag: hex.EncodeToString(sum[:])}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	_, got, err = oc.GetObject(ctx, "edge-1", "mp", nil)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetObject of multipart object mismatch: %q %v", got, err)
+	}
+
+	ch, err := oc.ListBuckets(ctx)
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %v", err)
+	}
+	listed := map[string]int{}
+	for status := range ch {
+		listed[status.Bucket()]++
+	}
+	for _, bucket := range []string{"local", "edge-1", "edge-hub-1", "edge-hub-pinned"} {
+		if listed[bucket] != 1 {
+			t.Fatalf("expected bucket %s listed once, got %v", bucket, listed)
+		}
+	}
+}
+
+func TestNatsObjectClient_MultipartTTL(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	c := NewClient("ttl-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+	mp, err := NewMultiPartStore(logger, c, MultiPartStoreOptions{})
+	if err != nil {
+		t.Fatalf("NewMultiPartStore failed: %v", err)
+	}
+
+	ctx := context.Background()
+	bucket, key, uploadID := "ttlbucket", "object.bin", "upload-1"
+	const ttl = 2 * time.Second
+	if _, err := oc.CreateBucket(ctx, bucket, BucketOptions{TTL: ttl}); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	if err := mp.InitMultipartUpload(ctx, bucket, key, uploadID, "", nil, nil); err != nil {
+		t.Fatalf("InitMultipartUpload failed: %v", err)
+	}
+	data := []byte("part data")
+	etag, err := mp.UploadPart(ctx, bucket, key, uploadID, 1, io.NopCloser(bytes.NewReader(data)), nil)
+	if err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	// The part is older than the manifest, but completing writes it again so
+	// that it does not expire before the object pointing at it.
+	time.Sleep(ttl * 3 / 4)
+	if _, err := mp.CompleteMultipartUpload(ctx, bucket, key, uploadID, []CompletedPart{{Number: 1, ETag: etag}}); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+	time.Sleep(ttl / 2)
+	if _, got, err := oc.GetObject(ctx, bucket, key, nil); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected the object past the age of its upload: %q err=%v", got, err)
+	}
+}
+
+func TestNatsObjectClient_UsageReconcile(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	c := NewClient("usage-test")
+	if err := c.SetupConnectionToNATS(s.ClientURL()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc := c.NATS()
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	oc, err := NewNatsObjectClient(logger, c, NatsObjectClientOptions{})
+	if err != nil {
+		t.Fatalf("NewNatsObjectClient failed: %v", err)
+	}
+
+	ctx := context.Background()
+	bucket := "usagebucket"
+	if _, err := oc.CreateBucket(ctx, bucket, BucketOptions{}); err != nil {
+		t.Fatalf("CreateBucket failed: %v", err)
+	}
+	err = oc.UpdateBucketConfig(ctx, bucket, func(cfg *BucketConfig) error {
+		cfg.Quota = &BucketQuota{HardObjects: 1}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateBucketConfig failed: %v", err)
+	}
+	if _, err := oc.CheckBucketQuota(ctx, bucket, "a", 1); err != nil {
+		t.Fatalf("CheckBucketQuota failed: %v", err)
+	}
+	if _, err := oc.PutObjectStream(ctx, bucket, "a", "", nil, bytes.NewReader([]byte("a")), nil); err != nil {
+		t.Fatalf("PutObjectStream failed: %v", err)
+	}
+
+	// The object is removed around the gateway, as a TTL would
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	os, err := js.ObjectStore(ctx, bucket)
+	if err != nil {
+		t.Fatalf("ObjectStore failed: %v", err)
+	}
+	if err := os.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := oc.CheckBucketQuota(ctx, bucket, "b", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the recently counted counter to refuse, got %v", err)
+	}
+	// A refusal by an older counter counts the bucket again
+	oc.usage.recount = 0
+	if _, err := oc.CheckBucketQuota(ctx, bucket, "b", 1); err != nil {
+		t.Fatalf("expected the recount to accept, got %v", err)
+	}
+	usage, err := oc.GetBucketUsage(ctx, bucket)
+	if err != nil || usage.Objects != 0 {
+		t.Fatalf("unexpected usage after recount: %+v err=%v", usage, err)
+	}
+
+	// Objects written around the gateway are found once the counter is due
+	if _, err := os.PutBytes(ctx, "c", []byte("cc")); err != nil {
+		t.Fatalf("PutBytes failed: %v", err)
+	}
+	oc.usage.reconcile = 0
+	usage, err = oc.GetBucketUsage(ctx, bucket)
+	if err != nil || *usage != (BucketUsage{Bytes: 2, Objects: 1}) {
+		t.Fatalf("unexpected usage after reconciliation: %+v err=%v", usage, err)
+	}
+
+	// Writes finished before the count are part of it and not added again
+	oc.usage.reconcile = time.Hour
+	oc.usage.add(ctx, bucket, BucketUsage{Bytes: 2, Objects: 1}, time.Now().Add(-time.Minute))
+	usage, err = oc.GetBucketUsage(ctx, bucket)
+	if err != nil || *usage != (BucketUsage{Bytes: 2, Objects: 1}) {
+		t.Fatalf("expected a write older than the count to be ignored: %+v err=%v", usage, err)
+	}
+}
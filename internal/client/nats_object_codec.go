@@ -0,0 +1,146 @@
+package client
+
+import (
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/wpnpeiris/nats-s3/internal/compression"
+	"github.com/wpnpeiris/nats-s3/internal/encryption"
+)
+
+// MetaCompression records the algorithm the data of an object is compressed
+// with at rest.
+const MetaCompression = InternalMetaPrefix + "compression"
+
+// CompressionOptions selects the objects compressed at rest.
+type CompressionOptions struct {
+	// Algorithm is zstd or s2; empty disables compression
+	Algorithm string `json:"algorithm"`
+	// ContentTypes limits compression to matching content types such as
+	// "text/*" or "application/json"; empty compresses every object
+	ContentTypes []string `json:"content_types,omitempty"`
+}
+
+// algorithmFor returns the compression of an object with the given content
+// type, or "" when the object is stored as-is.
+func (o CompressionOptions) algorithmFor(contentType string) string {
+	if o.Algorithm == "" || len(o.ContentTypes) == 0 {
+		return o.Algorithm
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, pattern := range o.ContentTypes {
+		if ok, _ := path.Match(strings.ToLower(pattern), mediaType); ok {
+			return o.Algorithm
+		}
+	}
+	return ""
+}
+
+// compressionFor resolves the compression of a new object. A bucket setting
+// wins over the gateway default.
+func compressionFor(cfg *BucketConfig, defaults CompressionOptions, contentType string) string {
+	if cfg != nil && cfg.Compression != nil {
+		return cfg.Compression.algorithmFor(contentType)
+	}
+	return defaults.algorithmFor(contentType)
+}
+
+// objectCodec describes how the data of an object is transformed at rest:
+// compressed first, then encrypted.
+type objectCodec struct {
+	dataKey     []byte
+	compression string
+}
+
+// identity reports whether data is stored as-is.
+func (c objectCodec) identity() bool {
+	return c.dataKey == nil && c.compression == ""
+}
+
+// encode returns a reader yielding the stored form of plaintext r.
+func (c objectCodec) encode(r io.Reader) (io.Reader, error) {
+	var err error
+	if c.compression != "" {
+		r, err = compression.NewReader(r, c.compression)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.dataKey != nil {
+		r, err = encryption.NewReader(r, c.dataKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// decode returns the plaintext of stored data from the given offset,
+// closing rc on Close.
+func (c objectCodec) decode(rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	if c.compression == "" {
+		if c.dataKey != nil {
+			return decryptingReadCloser(rc, c.dataKey, offset)
+		}
+		return skippingReadCloser(rc, offset)
+	}
+	var r io.Reader = rc
+	var err error
+	if c.dataKey != nil {
+		// Compressed frames are addressed in the decrypted stream
+		r, err = encryption.NewDecryptReader(rc, c.dataKey)
+		if err != nil {
+			_ = rc.Close()
+			return nil, err
+		}
+	}
+	r, err = compression.NewDecompressReaderAt(r, offset)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
+}
+
+// writeStats counts the compressed writes of this process for metrics.
+var writeStats = newCompressionStats()
+
+// compressionStats accumulates the logical and stored bytes of compressed
+// writes per algorithm.
+type compressionStats struct {
+	mu      sync.Mutex
+	logical map[string]int64
+	stored  map[string]int64
+}
+
+func newCompressionStats() *compressionStats {
+	return &compressionStats{logical: map[string]int64{}, stored: map[string]int64{}}
+}
+
+func (s *compressionStats) record(algorithm string, logical int64, stored int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logical[algorithm] += logical
+	s.stored[algorithm] += stored
+}
+
+// snapshot returns copies of the logical and stored byte counters.
+func (s *compressionStats) snapshot() (map[string]int64, map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logical := make(map[string]int64, len(s.logical))
+	stored := make(map[string]int64, len(s.stored))
+	for k, v := range s.logical {
+		logical[k] = v
+	}
+	for k, v := range s.stored {
+		stored[k] = v
+	}
+	return logical, stored
+}
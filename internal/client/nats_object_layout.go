@@ -0,0 +1,275 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+)
+
+const (
+	// InternalMetaPrefix marks object metadata entries owned by the gateway.
+	// These entries are never exposed to S3 clients as headers.
+	InternalMetaPrefix = "x-nats-s3-"
+	// MetaMultipartUploadID records the upload that produced an object.
+	MetaMultipartUploadID = InternalMetaPrefix + "mp-upload-id"
+	// MetaMultipartPartsCount records the number of parts of an object.
+	MetaMultipartPartsCount = InternalMetaPrefix + "mp-parts-count"
+	// MetaMultipartManifest marks an object whose data is its part layout,
+	// the object itself being stitched together from its parts on read.
+	MetaMultipartManifest = InternalMetaPrefix + "mp-manifest"
+	// MetaObjectSize records the size of an object as seen by S3 clients when
+	// it differs from the size of the stored bytes.
+	MetaObjectSize = InternalMetaPrefix + "size"
+	// MetaObjectETag records the ETag (unquoted) of an object as seen by S3
+	// clients when it differs from the digest of the stored bytes.
+	MetaObjectETag = InternalMetaPrefix + "etag"
+
+	// partObjectPrefix names the objects holding the uploaded parts of a
+	// bucket, next to its objects. Object keys containing ".." are rejected,
+	// so parts never collide with objects.
+	partObjectPrefix = "..parts/"
+)
+
+var ErrInvalidPartNumber = errors.New("invalid part number")
+
+// errObjectChanged is returned when an object is replaced while it is read.
+var errObjectChanged = errors.New("object changed while reading")
+
+// ObjectLayout is the part manifest of an object assembled from a multipart
+// upload. Parts are kept in the order they were assembled and keep the part
+// numbers they were uploaded with, which need not be contiguous.
+type ObjectLayout struct {
+	UploadID string     `json:"upload_id"`
+	Bucket   string     `json:"bucket"`
+	Key      string     `json:"key"`
+	Size     uint64     `json:"size"`
+	Parts    []PartMeta `json:"parts"`
+}
+
+// PartRange returns the byte offset and length of the part uploaded with
+// the given part number within the assembled object.
+func (l *ObjectLayout) PartRange(partNumber int) (offset uint64, size uint64, err error) {
+	for _, p := range l.Parts {
+		if p.Number == partNumber {
+			return offset, p.Size, nil
+		}
+		offset += p.Size
+	}
+	return 0, 0, ErrInvalidPartNumber
+}
+
+// readLayout returns the layout of a manifest object, which is stored as the
+// data of the manifest, or nil when the object is not a manifest. Parts and
+// layout live in the object store of the bucket, so they share its limits,
+// replication and lifetime.
+func readLayout(ctx context.Context, os jetstream.ObjectStore, info *jetstream.ObjectInfo) (*ObjectLayout, error) {
+	if !IsManifest(info) {
+		return nil, nil
+	}
+	res, err := os.Get(ctx, info.Name)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrObjectNotFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	defer res.Close()
+	current, err := res.Info()
+	if err != nil {
+		return nil, err
+	}
+	if current.NUID != info.NUID {
+		return nil, errObjectChanged
+	}
+	var layout ObjectLayout
+	if err := json.NewDecoder(res).Decode(&layout); err != nil {
+		return nil, fmt.Errorf("failed to decode layout of %s: %w", info.Name, err)
+	}
+	return &layout, nil
+}
+
+// openManifest returns a reader over the data of a manifest object from the
+// given offset, reading its parts from the object store one after the other.
+// Parts in front of the offset are not read at all. Each part is decoded
+// with codec.
+func openManifest(ctx context.Context, os jetstream.ObjectStore, layout *ObjectLayout, codec objectCodec, offset int64) io.ReadCloser {
+	m := &manifestReader{ctx: ctx, store: os, layout: layout, codec: codec}
+	for m.next < len(layout.Parts) && offset >= int64(layout.Parts[m.next].Size) {
+		offset -= int64(layout.Parts[m.next].Size)
+		m.next++
+	}
+	m.skip = offset
+	return m
+}
+
+// releaseParts deletes the part data of a manifest object that has been
+// deleted or overwritten. A nil layout is ignored.
+func releaseParts(ctx context.Context, logger log.Logger, os jetstream.ObjectStore, layout *ObjectLayout) {
+	if layout == nil {
+		return
+	}
+	for _, p := range layout.Parts {
+		pk := partKey(layout.UploadID, p.Number)
+		if err := os.Delete(ctx, pk); err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			logging.Warn(logging.WithContext(ctx, logger), "msg", "Failed to delete part of released manifest", "part", pk, "err", err)
+		}
+	}
+}
+
+// refreshParts writes the parts of a layout again, so that in a bucket
+// whose objects expire they are not older than the manifest written after
+// them. The stored bytes are copied as they are, encrypted or compressed.
+func refreshParts(ctx context.Context, os jetstream.ObjectStore, layout *ObjectLayout) error {
+	for _, p := range layout.Parts {
+		pk := partKey(layout.UploadID, p.Number)
+		res, err := os.Get(ctx, pk)
+		if err != nil {
+			return fmt.Errorf("failed to read part %d: %w", p.Number, err)
+		}
+		info, err := res.Info()
+		if err == nil {
+			_, err = os.Put(ctx, info.ObjectMeta, res)
+		}
+		_ = res.Close()
+		if err != nil {
+			return fmt.Errorf("failed to refresh part %d: %w", p.Number, err)
+		}
+	}
+	return nil
+}
+
+// manifestReader stitches the parts of a manifest object together in layout
+// order, opening each part only when the previous one is exhausted.
+type manifestReader struct {
+	ctx     context.Context
+	store   jetstream.ObjectStore
+	layout  *ObjectLayout
+	codec   objectCodec
+	next    int
+	skip    int64
+	current io.ReadCloser
+}
+
+func (m *manifestReader) Read(p []byte) (int, error) {
+	for {
+		if m.current == nil {
+			if m.next >= len(m.layout.Parts) {
+				return 0, io.EOF
+			}
+			part := m.layout.Parts[m.next]
+			pk := partKey(m.layout.UploadID, part.Number)
+			var res io.ReadCloser
+			var err error
+			res, err = m.store.Get(m.ctx, pk)
+			if err == nil {
+				res, err = m.codec.decode(res, m.skip)
+			}
+			if err != nil {
+				return 0, fmt.Errorf("failed to open part %d of manifest: %w", part.Number, err)
+			}
+			m.current = res
+			m.skip = 0
+			m.next++
+		}
+		n, err := m.current.Read(p)
+		if err == io.EOF {
+			_ = m.current.Close()
+			m.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (m *manifestReader) Close() error {
+	if m.current != nil {
+		return m.current.Close()
+	}
+	return nil
+}
+
+// IsManifest reports whether the object data is stitched from its parts.
+func IsManifest(info *jetstream.ObjectInfo) bool {
+	return info != nil && info.Metadata != nil && info.Metadata[MetaMultipartManifest] == "true"
+}
+
+// resolveObjectInfo rewrites the size and digest of an object to the values
+// S3 clients expect when the stored bytes differ from the object contents.
+func resolveObjectInfo(info *jetstream.ObjectInfo) {
+	if info == nil || info.Metadata == nil {
+		return
+	}
+	if v, ok := info.Metadata[MetaObjectSize]; ok {
+		if size, err := strconv.ParseUint(v, 10, 64); err == nil {
+			info.Size = size
+		}
+	}
+	if v, ok := info.Metadata[MetaObjectETag]; ok && v != "" {
+		info.Digest = v
+	}
+}
+
+// partKey names the object holding an uploaded part in the object store of
+// its bucket.
+func partKey(uploadID string, part int) string {
+	return fmt.Sprintf("%s%s/%06d", partObjectPrefix, uploadID, part)
+}
+
+// isPartKey reports whether an object of a bucket holds an uploaded part.
+func isPartKey(name string) bool {
+	return strings.HasPrefix(name, partObjectPrefix)
+}
+
+// partUploadID returns the upload ID of the part stored under a part key.
+func partUploadID(name string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(name, partObjectPrefix), "/")
+	return id
+}
+
+// layoutUploadID returns the upload ID recorded on a multipart object.
+func layoutUploadID(info *jetstream.ObjectInfo) string {
+	if info == nil || info.Metadata == nil {
+		return ""
+	}
+	return info.Metadata[MetaMultipartUploadID]
+}
+
+// PartsCount returns the number of parts recorded on the object, or 0 when
+// the object was not produced by a multipart upload.
+func PartsCount(info *jetstream.ObjectInfo) int {
+	if info == nil || info.Metadata == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(info.Metadata[MetaMultipartPartsCount])
+	return n
+}
+
+// IsInternalMetadata reports whether a metadata key is owned by the gateway.
+func IsInternalMetadata(key string) bool {
+	return strings.HasPrefix(key, InternalMetaPrefix)
+}
+
+// getOrCreateKeyValue binds to the named Key-Value bucket, creating it when
+// it does not exist yet. Its writes carry the trace context of the request.
+func getOrCreateKeyValue(ctx context.Context, js jetstream.JetStream, name string) (jetstream.KeyValue, error) {
+	kv, err := js.KeyValue(ctx, name)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: name})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tracing.KeyValue(js, kv), nil
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"encoding/base64"
@@ -8,23 +9,38 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/acl"
+	"github.com/wpnpeiris/nats-s3/internal/kms"
 	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // PartMeta describes a single part in a multipart upload.
-// It records the part number, ETag (checksum), size in bytes, and the
-// time the part was stored (Unix seconds).
+// It records the part number, ETag (checksum), CRC32 checksum (base64),
+// size in bytes, and the time the part was stored (Unix seconds).
 type PartMeta struct {
-	Number   int    `json:"number"`
-	ETag     string `json:"etag"`
-	Size     uint64 `json:"size"`
-	StoredAt int64  `json:"stored_at_unix"`
+	Number        int    `json:"number"`
+	ETag          string `json:"etag"`
+	ChecksumCRC32 string `json:"checksum_crc32,omitempty"`
+	Size          uint64 `json:"size"`
+	StoredAt      int64  `json:"stored_at_unix"`
+}
+
+// CompletedPart identifies an uploaded part by number and the ETag the client
+// received for it, as listed in a CompleteMultipartUpload request.
+type CompletedPart struct {
+	Number int
+	ETag   string
 }
 
 // UploadMeta captures the server-side state of a multipart upload.
@@ -34,111 +50,177 @@ type PartMeta struct {
 // Individual part metadata is stored in separate KV entries to avoid write conflicts.
 // The Parts field is populated on-demand when calling ListParts or CompleteMultipartUpload.
 type UploadMeta struct {
-	UploadID  string           `json:"upload_id"`
-	Bucket    string           `json:"bucket"`
-	Key       string           `json:"key"`
-	Initiated time.Time        `json:"initiated"`
-	Owner     string           `json:"owner,omitempty"` // optional, auth principal
-	MinPartSz int64            `json:"min_part_size"`   // default 5MiB
-	MaxParts  int              `json:"max_parts"`       // default 10000
-	Parts     map[int]PartMeta `json:"-"`               // Not persisted, populated on-demand
+	UploadID    string            `json:"upload_id"`
+	Bucket      string            `json:"bucket"`
+	Key         string            `json:"key"`
+	Initiated   time.Time         `json:"initiated"`
+	Owner       string            `json:"owner,omitempty"`        // optional, auth principal
+	MinPartSz   int64             `json:"min_part_size"`          // default 5MiB
+	MaxParts    int               `json:"max_parts"`              // default 10000
+	SSE         map[string]string `json:"sse,omitempty"`          // wrapped data key of encrypted uploads
+	ContentType string            `json:"content_type,omitempty"` // content type of the assembled object
+	Compression string            `json:"compression,omitempty"`  // compression of the parts at rest
+	ACL         *acl.ACL          `json:"acl,omitempty"`          // access control list of the assembled object
+	Parts       map[int]PartMeta  `json:"-"`                      // Not persisted, populated on-demand
+}
+
+type MultiPartStoreOptions struct {
+	// MasterKey wraps the data keys of SSE-S3 uploads; nil disables SSE-S3
+	MasterKey []byte
+	// KMS issues the data keys of SSE-KMS uploads; nil disables SSE-KMS
+	KMS kms.KMS
+	// Compression is the default compression of buckets without their own
+	Compression CompressionOptions
+	// Routes resolves the JetStream context of each bucket; nil keeps every
+	// bucket on the gateway connection
+	Routes *BucketRouter
+	// Cache is the read cache of the object client, whose entries completed
+	// uploads replace
+	Cache *ObjectCache
 }
 
 // MultiPartStore groups storage backends used for multipart uploads.
-// metaStore tracks session metadata in a Key-Value bucket, while
-// tempPartStore holds uploaded parts in a temporary Object Store.
+// Session and part metadata are tracked in Key-Value buckets, while uploaded
+// parts are stored in the object store of their bucket until they are
+// assembled or aborted. Both follow the route of their bucket.
 type MultiPartStore struct {
-	logger          log.Logger
-	js              jetstream.JetStream
-	metaStore       jetstream.KeyValue
-	partMetaStore   jetstream.KeyValue
-	partObjectStore jetstream.ObjectStore
+	logger        log.Logger
+	routes        *BucketRouter
+	bucketConfigs *bucketConfigStore
+	usage         *bucketUsageStore
+	keyring       *sseKeyring
+	compression   CompressionOptions
+	cache         *ObjectCache
 }
 
-func NewMultiPartStore(logger log.Logger, c *Client) (*MultiPartStore, error) {
+func NewMultiPartStore(logger log.Logger, c *Client, opts MultiPartStoreOptions) (*MultiPartStore, error) {
 	ctx := context.Background()
-	js, err := c.Jetstream()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create multipart session store when calling Jetstream(): %w", err)
+	routes := opts.Routes
+	if routes == nil {
+		var err error
+		routes, err = NewBucketRouter(logger, c, nil)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	metaKV, err := js.KeyValue(ctx, MetaStoreName)
+	// Sessions of buckets on the gateway connection are served from startup
+	if _, err := routes.contextKeyValue(ctx, routes.js, MetaStoreName); err != nil {
+		return nil, fmt.Errorf("failed to access multipart meta store: %w", err)
+	}
+	if _, err := routes.contextKeyValue(ctx, routes.js, PartMetaStoreName); err != nil {
+		return nil, fmt.Errorf("failed to access multipart part-meta store: %w", err)
+	}
+
+	bucketConfigs, err := newBucketConfigStore(ctx, logger, routes.js)
 	if err != nil {
-		if errors.Is(err, jetstream.ErrBucketNotFound) {
-			metaKV, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
-				Bucket: MetaStoreName,
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to create multipart meta store when calling js.CreateKeyValue(): %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to access multipart meta store when calling js.KeyValue(): %w", err)
-		}
+		return nil, err
 	}
 
-	partMetaKV, err := js.KeyValue(ctx, PartMetaStoreName)
+	usage, err := newBucketUsageStore(ctx, logger, routes)
 	if err != nil {
-		if errors.Is(err, jetstream.ErrBucketNotFound) {
-			partMetaKV, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
-				Bucket: PartMetaStoreName,
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to create multipart part-meta store when calling js.CreateKeyValue(): %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to access multipart part-meta store when calling js.KeyValue(): %w", err)
-		}
+		return nil, err
 	}
 
-	partOS, err := js.ObjectStore(ctx, TempStoreName)
+	keyring, err := newSSEKeyring(opts.MasterKey, opts.KMS)
 	if err != nil {
-		if errors.Is(err, jetstream.ErrBucketNotFound) {
-			partOS, err = js.CreateObjectStore(ctx, jetstream.ObjectStoreConfig{
-				Bucket: TempStoreName,
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to create multipart temp store when calling js.CreateObjectStore(): %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to access multipart temp store when calling js.ObjectStore(): %w", err)
-		}
+		return nil, fmt.Errorf("invalid master key: %w", err)
 	}
 
 	return &MultiPartStore{
-		logger:          logger,
-		js:              js,
-		metaStore:       metaKV,
-		partMetaStore:   partMetaKV,
-		partObjectStore: partOS,
+		logger:        logger,
+		routes:        routes,
+		bucketConfigs: bucketConfigs,
+		usage:         usage,
+		keyring:       keyring,
+		compression:   opts.Compression,
+		cache:         opts.Cache,
 	}, nil
 }
 
 // InitMultipartUpload creates and persists a new multipart upload session
-// for the given bucket/key and uploadID.
-func (m *MultiPartStore) InitMultipartUpload(ctx context.Context, bucket string, key string, uploadID string) error {
-	logging.Info(m.logger, "msg", fmt.Sprintf("Init multipart upload: [%s/%s]", bucket, key))
+// for the given bucket/key and uploadID. When sse is set, a data key for the
+// upload is generated and all parts are encrypted with it. Whether parts are
+// compressed is decided once for the upload from its content type. A non-nil
+// objectACL is recorded on the assembled object.
+func (m *MultiPartStore) InitMultipartUpload(ctx context.Context, bucket string, key string, uploadID string, contentType string, sse *ServerSideEncryption, objectACL *acl.ACL) (err error) {
+	ctx, span := tracing.Start(ctx, "MultiPartStore.InitMultipartUpload", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key), attribute.String("aws.s3.upload_id", uploadID))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("Init multipart upload: [%s/%s]", bucket, key))
+	_, sseMeta, err := m.keyring.seal(ctx, sse)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at InitMultipartUpload", "err", err)
+		return err
+	}
+	cfg, _, err := m.bucketConfigs.get(ctx, bucket)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at InitMultipartUpload", "err", err)
+		return err
+	}
 	meta := UploadMeta{
-		UploadID:  uploadID,
-		Bucket:    bucket,
-		Key:       key,
-		Initiated: time.Now().UTC(),
-		MinPartSz: 5 * 1024 * 1024,
-		MaxParts:  10000,
+		UploadID:    uploadID,
+		Bucket:      bucket,
+		Key:         key,
+		Initiated:   time.Now().UTC(),
+		MinPartSz:   5 * 1024 * 1024,
+		MaxParts:    10000,
+		SSE:         sseMeta,
+		ContentType: contentType,
+		Compression: compressionFor(cfg, m.compression, contentType),
+		ACL:         objectACL,
 	}
 
 	return m.saveUploadMeta(ctx, meta)
 }
 
-// UploadPart streams a part into temporary storage and records its ETag/size
-// under the multipart session. Returns the hex ETag (without quotes).
-func (m *MultiPartStore) UploadPart(ctx context.Context, bucket string, key string, uploadID string, part int, dataReader io.ReadCloser) (string, error) {
-	logging.Info(m.logger, "msg", fmt.Sprintf("Upload part:%06d [%s/%s], UploadID: %s", part, bucket, key, uploadID))
+// UploadPart streams a part into its bucket and records its ETag/size
+// under the multipart session. Parts of encrypted uploads are encrypted with
+// the upload data key; SSE-C uploads require the customer key in sse. Parts
+// count towards the quota of the bucket as they are uploaded, and a part
+// taking the bucket past its hard byte limit fails with ErrQuotaExceeded.
+// Returns the hex ETag (without quotes).
+func (m *MultiPartStore) UploadPart(ctx context.Context, bucket string, key string, uploadID string, part int, dataReader io.ReadCloser, sse *ServerSideEncryption) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "MultiPartStore.UploadPart", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key), attribute.String("aws.s3.upload_id", uploadID), attribute.Int("aws.s3.part_number", part))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("Upload part:%06d [%s/%s], UploadID: %s", part, bucket, key, uploadID))
+
+	md, err := m.getUploadMeta(ctx, bucket, metaKey(bucket, key, uploadID))
+	if err != nil {
+		return "", ErrUploadNotFound
+	}
+	var meta UploadMeta
+	if err := json.Unmarshal(md.Value(), &meta); err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at UploadPart", "err", err)
+		return "", err
+	}
+	dataKey, err := m.keyring.open(ctx, meta.SSE, sse)
+	if err != nil {
+		logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Error at UploadPart", "err", err)
+		return "", err
+	}
 
-	h := md5.New()
+	// A part uploaded again replaces the earlier one
+	var replaced int64
+	prevPart, err := m.getPartMeta(ctx, bucket, key, uploadID, part)
+	if err == nil {
+		replaced = int64(prevPart.Size)
+	}
+	allowance, err := m.partAllowance(ctx, bucket, replaced)
+	if err != nil {
+		return "", err
+	}
+
+	crc := crc32.NewIEEE()
+	plain := newPlaintextCounter(io.TeeReader(QuotaReader(dataReader, allowance), crc))
+	codec := objectCodec{dataKey: dataKey, compression: meta.Compression}
+	src, err := codec.encode(plain)
+	if err != nil {
+		return "", err
+	}
 	pr, pw := io.Pipe()
 	go func() {
 		defer pw.Close()
-		_, err := io.Copy(io.MultiWriter(h, pw), dataReader)
+		_, err := io.Copy(pw, src)
 		if err != nil {
 			_ = pw.CloseWithError(err)
 		}
@@ -148,17 +230,24 @@ func (m *MultiPartStore) UploadPart(ctx context.Context, bucket string, key stri
 	done := watchContextCancellation(ctx, pr)
 	defer close(done) // Ensure goroutine cleanup on all exit paths
 
-	partKey := partKey(bucket, key, uploadID, part)
-	obj, err := m.savePartData(ctx, partKey, pr)
+	obj, err := m.savePartData(ctx, bucket, partKey(uploadID, part), pr)
 	if err != nil {
 		// Close the reader to signal the goroutine to stop
 		_ = pr.Close()
 		return "", err
 	}
+	written := time.Now()
+	if codec.compression != "" {
+		writeStats.record(codec.compression, plain.size, int64(obj.Size))
+	}
 
-	etag := strings.ToLower(hex.EncodeToString(h.Sum(nil)))
+	etag := plain.etag()
 	partMeta := PartMeta{
-		Number: part, ETag: `"` + etag + `"`, Size: obj.Size, StoredAt: time.Now().Unix(),
+		Number:        part,
+		ETag:          `"` + etag + `"`,
+		ChecksumCRC32: base64.StdEncoding.EncodeToString(crc.Sum(nil)),
+		Size:          uint64(plain.size),
+		StoredAt:      time.Now().Unix(),
 	}
 
 	// Save part metadata in its own KV entry
@@ -166,52 +255,80 @@ func (m *MultiPartStore) UploadPart(ctx context.Context, bucket string, key stri
 	if err != nil {
 		return "", err
 	}
+	m.usage.add(ctx, bucket, BucketUsage{Bytes: plain.size - replaced}, written)
 	return etag, nil
 }
 
+// partAllowance returns the bytes a part replacing a part of the given size
+// may take before the bucket crosses its hard byte limit, or -1 when the
+// bucket has no quota.
+func (m *MultiPartStore) partAllowance(ctx context.Context, bucket string, replaced int64) (int64, error) {
+	cfg, _, err := m.bucketConfigs.get(ctx, bucket)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.Quota == nil {
+		return -1, nil
+	}
+	usage, err := m.usage.check(ctx, bucket, func(usage BucketUsage) error {
+		usage.Bytes -= replaced
+		return checkQuota(ctx, m.logger, bucket, cfg.Quota, usage)
+	})
+	if err != nil {
+		return 0, err
+	}
+	usage.Bytes -= replaced
+	return cfg.Quota.remaining(usage), nil
+}
+
 // AbortMultipartUpload aborts an in‑progress multipart upload, deleting any
 // uploaded parts and removing the session metadata.
-func (m *MultiPartStore) AbortMultipartUpload(ctx context.Context, bucket string, key string, uploadID string) error {
-	logging.Info(m.logger, "msg", fmt.Sprintf("Abort multipart upload: [%s/%s], UploadID: %s", bucket, key, uploadID))
+func (m *MultiPartStore) AbortMultipartUpload(ctx context.Context, bucket string, key string, uploadID string) (err error) {
+	ctx, span := tracing.Start(ctx, "MultiPartStore.AbortMultipartUpload", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key), attribute.String("aws.s3.upload_id", uploadID))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("Abort multipart upload: [%s/%s], UploadID: %s", bucket, key, uploadID))
 	mk := metaKey(bucket, key, uploadID)
-	md, err := m.getUploadMeta(ctx, mk)
+	md, err := m.getUploadMeta(ctx, bucket, mk)
 	if err != nil {
 		return ErrUploadNotFound
 	}
 
 	var meta UploadMeta
 	if err := json.Unmarshal(md.Value(), &meta); err != nil {
-		logging.Error(m.logger, "msg", "Error at AbortMultipartUpload", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at AbortMultipartUpload", "err", err)
 		return err
 	}
 
 	// Get all part metadata to find all parts to delete
 	parts, err := m.getAllPartMeta(ctx, bucket, key, uploadID)
 	if err != nil {
-		logging.Warn(m.logger, "msg", "Error getting part metadata at AbortMultipartUpload", "err", err)
+		logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Error getting part metadata at AbortMultipartUpload", "err", err)
 		// Continue with cleanup even if we can't get all parts
 		parts = make(map[int]PartMeta)
 	}
 
-	// Delete temporary part data from Object Store
-	for pn := range parts {
-		partKey := partKey(bucket, key, uploadID, pn)
-		err := m.removePartData(ctx, partKey)
-		if err != nil {
-			logging.Warn(m.logger, "msg", "Error deleting part upload at AbortMultipartUpload", "err", err)
+	// Delete part data from the bucket
+	var released int64
+	for pn, pmeta := range parts {
+		err := m.removePartData(ctx, bucket, partKey(uploadID, pn))
+		if err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Error deleting part upload at AbortMultipartUpload", "err", err)
+			continue
 		}
+		released += int64(pmeta.Size)
 	}
+	m.usage.add(ctx, bucket, BucketUsage{Bytes: -released}, time.Now())
 
 	// Delete part metadata from KV store
 	err = m.deleteAllPartMeta(ctx, bucket, key, uploadID)
 	if err != nil {
-		logging.Warn(m.logger, "msg", "Failed to delete part metadata at AbortMultipartUpload", "err", err)
+		logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Failed to delete part metadata at AbortMultipartUpload", "err", err)
 	}
 
 	// Delete session metadata
 	err = m.removeUploadMeta(ctx, meta)
 	if err != nil {
-		logging.Warn(m.logger, "msg", "Failed to delete multipart session data at AbortMultipartUpload", "err", err)
+		logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Failed to delete multipart session data at AbortMultipartUpload", "err", err)
 		return err
 	}
 
@@ -220,24 +337,26 @@ func (m *MultiPartStore) AbortMultipartUpload(ctx context.Context, bucket string
 
 // ListParts returns the multipart upload metadata for the given
 // bucket/key/uploadID, including uploaded parts with sizes and ETags.
-func (m *MultiPartStore) ListParts(ctx context.Context, bucket string, key string, uploadID string) (*UploadMeta, error) {
-	logging.Info(m.logger, "msg", fmt.Sprintf("List parts: [%s/%s], UploadID: %s", bucket, key, uploadID))
+func (m *MultiPartStore) ListParts(ctx context.Context, bucket string, key string, uploadID string) (_ *UploadMeta, err error) {
+	ctx, span := tracing.Start(ctx, "MultiPartStore.ListParts", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key), attribute.String("aws.s3.upload_id", uploadID))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("List parts: [%s/%s], UploadID: %s", bucket, key, uploadID))
 	mk := metaKey(bucket, key, uploadID)
-	md, err := m.getUploadMeta(ctx, mk)
+	md, err := m.getUploadMeta(ctx, bucket, mk)
 	if err != nil {
 		return nil, ErrUploadNotFound
 	}
 
 	var meta UploadMeta
 	if err := json.Unmarshal(md.Value(), &meta); err != nil {
-		logging.Error(m.logger, "msg", "Error at ListParts", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at ListParts", "err", err)
 		return nil, err
 	}
 
 	// Populate parts from individual KV entries
 	parts, err := m.getAllPartMeta(ctx, bucket, key, uploadID)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at ListParts when getAllPartMeta()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at ListParts when getAllPartMeta()", "err", err)
 		return nil, err
 	}
 	meta.Parts = parts
@@ -245,126 +364,217 @@ func (m *MultiPartStore) ListParts(ctx context.Context, bucket string, key strin
 	return &meta, nil
 }
 
-// CompleteMultipartUpload concatenates the uploaded parts into the final
-// object, computes and returns the multipart ETag, and cleans up temporary
-// parts and metadata.
-func (m *MultiPartStore) CompleteMultipartUpload(ctx context.Context, bucket string, key string, uploadID string, sortedPartNumbers []int) (string, error) {
-	logging.Info(m.logger, "msg", fmt.Sprintf("Complete multipart upload: [%s/%s], UploadID: %s", bucket, key, uploadID))
+// CompleteMultipartUpload assembles the uploaded parts into the final object
+// without copying their data. The object itself is a manifest holding the
+// part layout, whose metadata carries the logical size and ETag, while the
+// parts stay in the bucket and are stitched together on read following the
+// layout. Every listed part must have been uploaded with a matching ETag, and
+// all parts but the last must be at least the minimum part size of the
+// upload. The parts already count towards the quota of the bucket, which is
+// checked again for the object replacing key. Parts not referenced by the
+// completion, and the upload session metadata, are cleaned up. Returns the
+// multipart ETag.
+func (m *MultiPartStore) CompleteMultipartUpload(ctx context.Context, bucket string, key string, uploadID string, completed []CompletedPart) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "MultiPartStore.CompleteMultipartUpload", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key), attribute.String("aws.s3.upload_id", uploadID))
+	defer func() { tracing.End(span, err) }()
+	defer m.cache.invalidate(bucket, key)
+	logging.Info(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("Complete multipart upload: [%s/%s], UploadID: %s", bucket, key, uploadID))
 	mk := metaKey(bucket, key, uploadID)
-	md, err := m.getUploadMeta(ctx, mk)
+	md, err := m.getUploadMeta(ctx, bucket, mk)
 	if err != nil {
 		return "", ErrUploadNotFound
 	}
 
 	var meta UploadMeta
 	if err := json.Unmarshal(md.Value(), &meta); err != nil {
-		logging.Error(m.logger, "msg", "Error at CompleteMultipartUpload", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at CompleteMultipartUpload", "err", err)
 		return "", err
 	}
 
 	// Populate parts from individual KV entries
 	parts, err := m.getAllPartMeta(ctx, bucket, key, uploadID)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at CompleteMultipartUpload when getAllPartMeta()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at CompleteMultipartUpload when getAllPartMeta()", "err", err)
 		return "", err
 	}
 	meta.Parts = parts
 
-	md5Concat := md5.New()
-	pr, pw := io.Pipe()
-	go func() {
-		defer pw.Close()
-		for _, pn := range sortedPartNumbers {
-			pmeta, ok := meta.Parts[pn]
-			if !ok {
-				_ = pw.CloseWithError(ErrMissingPart)
-				return
-			}
-			partKey := partKey(bucket, key, uploadID, pn)
-			rpart, err := m.getPartData(ctx, partKey)
-			if err != nil {
-				_ = pw.CloseWithError(err)
-				return
-			}
-
-			rawHex := strings.Trim(pmeta.ETag, `"`)
-			b, _ := hex.DecodeString(rawHex)
-			md5Concat.Write(b)
-
-			_, err = io.Copy(pw, rpart)
-			rpart.Close()
-			if err != nil {
-				_ = pw.CloseWithError(err)
-				return
-			}
+	os, err := m.routes.JetStream(bucket).ObjectStore(ctx, bucket)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at CompleteMultipartUpload", "err", err)
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			return "", ErrBucketNotFound
 		}
-	}()
+		return "", err
+	}
 
-	// Ensure the goroutine is cleaned up on early return
-	defer func() {
-		if err != nil {
-			// Close reader to unblock the goroutine if we're returning with an error
-			_ = pr.Close()
+	md5Concat := md5.New()
+	layout := ObjectLayout{UploadID: uploadID, Bucket: bucket, Key: key}
+	used := make(map[int]bool, len(completed))
+	for i, cp := range completed {
+		pmeta, ok := meta.Parts[cp.Number]
+		if !ok {
+			logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Completing with a part that was not uploaded", "uploadID", uploadID, "part", cp.Number)
+			return "", ErrInvalidPart
 		}
-	}()
+		rawHex := strings.Trim(pmeta.ETag, `"`)
+		if !strings.EqualFold(strings.Trim(cp.ETag, `"`), rawHex) {
+			logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Completing with a mismatched part ETag", "uploadID", uploadID, "part", cp.Number)
+			return "", ErrInvalidPart
+		}
+		if i < len(completed)-1 && int64(pmeta.Size) < meta.MinPartSz {
+			logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Completing with a part below the minimum size", "uploadID", uploadID, "part", cp.Number, "size", pmeta.Size)
+			return "", ErrPartTooSmall
+		}
+		b, _ := hex.DecodeString(rawHex)
+		md5Concat.Write(b)
 
-	// Cancel the composition if request context is done
-	done := watchContextCancellation(ctx, pr)
-	defer close(done) // Ensure goroutine cleanup on all exit paths
+		layout.Parts = append(layout.Parts, pmeta)
+		layout.Size += pmeta.Size
+		used[cp.Number] = true
+	}
+
+	etagHex := strings.ToLower(hex.EncodeToString(md5Concat.Sum(nil)))
+	etag := fmt.Sprintf("%s-%d", etagHex, len(completed))
 
-	os, err := m.js.ObjectStore(ctx, bucket)
+	// Remember the parts of the object being replaced so they can be released
+	prev, err := os.GetInfo(ctx, key)
+	if err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at CompleteMultipartUpload", "err", err)
+		return "", err
+	}
+	prevLayout, err := readLayout(ctx, os, prev)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at CompleteMultipartUpload", "err", err)
-		if errors.Is(err, jetstream.ErrBucketNotFound) {
-			return "", ErrBucketNotFound
+		logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Failed to load layout of replaced manifest", "bucket", bucket, "key", key, "err", err)
+	}
+
+	// Parts left out of the object are released, and the object replaced
+	var unused int64
+	for pn, pmeta := range meta.Parts {
+		if !used[pn] {
+			unused += int64(pmeta.Size)
 		}
+	}
+	replaced := objectUsage(prev)
+	delta := BucketUsage{Bytes: -unused - replaced.Bytes, Objects: 1 - replaced.Objects}
+	if err := m.checkCompletionQuota(ctx, bucket, delta); err != nil {
 		return "", err
 	}
-	_, err = os.Put(ctx, jetstream.ObjectMeta{Name: key}, pr)
+
+	// Parts age from their upload, so in a bucket whose objects expire they
+	// are written again to live as long as the manifest pointing at them
+	status, err := os.Status(ctx)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at CompleteMultipartUpload", "err", err)
+		return "", err
+	}
+	if status.TTL() > 0 {
+		if err := refreshParts(ctx, os, &layout); err != nil {
+			logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at CompleteMultipartUpload when refreshing parts", "err", err)
+			return "", storeError(err)
+		}
+	}
+
+	// The layout is the data of the manifest, so that both are written at once
+	data, err := json.Marshal(layout)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at CompleteMultipartUpload", "err", err)
 		return "", err
 	}
 
-	etagHex := hex.EncodeToString(md5Concat.Sum(nil))
-	finalETag := fmt.Sprintf(`"%s-%d"`, strings.ToLower(etagHex), len(sortedPartNumbers))
+	metadata := map[string]string{
+		MetaMultipartUploadID:   uploadID,
+		MetaMultipartPartsCount: strconv.Itoa(len(completed)),
+		MetaMultipartManifest:   "true",
+		MetaObjectSize:          strconv.FormatUint(layout.Size, 10),
+		MetaObjectETag:          etag,
+	}
+	// Parts of encrypted uploads share the upload data key
+	for k, v := range meta.SSE {
+		metadata[k] = v
+	}
+	if meta.Compression != "" {
+		metadata[MetaCompression] = meta.Compression
+	}
+	SetObjectACL(metadata, meta.ACL)
+	objMeta := jetstream.ObjectMeta{
+		Name:     key,
+		Metadata: metadata,
+		Headers:  nats.Header{},
+	}
+	if meta.ContentType != "" {
+		objMeta.Headers.Set("Content-Type", meta.ContentType)
+	}
+	requestHeaders(ctx, objMeta.Headers)
+	_, err = os.Put(ctx, objMeta, bytes.NewReader(data))
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at CompleteMultipartUpload", "err", err)
+		return "", storeError(err)
+	}
+	written := time.Now()
+	if prevLayout != nil && prevLayout.UploadID != uploadID {
+		releaseParts(ctx, m.logger, os, prevLayout)
+	}
 
-	// Delete temporary part data from Object Store
-	err = m.removeAllPartData(ctx, bucket, key, uploadID, meta.Parts)
+	// Delete part data not referenced by the completed object
+	err = m.removeUnusedPartData(ctx, os, uploadID, meta.Parts, used)
 	if err != nil {
-		logging.Warn(m.logger, "msg", "Failed to clean multipart temp part data at CompleteMultipartUpload", "err", err)
+		logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Failed to clean multipart temp part data at CompleteMultipartUpload", "err", err)
 	}
+	m.usage.add(ctx, bucket, delta, written)
 
 	// Delete part metadata from KV store
 	err = m.deleteAllPartMeta(ctx, bucket, key, uploadID)
 	if err != nil {
-		logging.Warn(m.logger, "msg", "Failed to clean multipart part metadata at CompleteMultipartUpload", "err", err)
+		logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Failed to clean multipart part metadata at CompleteMultipartUpload", "err", err)
 	}
 
 	// Delete metadata
 	err = m.removeUploadMeta(ctx, meta)
 	if err != nil {
-		logging.Warn(m.logger, "Failed to delete multipart meta data", "err", err)
+		logging.Warn(logging.WithContext(ctx, m.logger), "Failed to delete multipart meta data", "err", err)
 		return "", err
 	}
 
-	return finalETag, nil
+	return `"` + etag + `"`, nil
+}
+
+// checkCompletionQuota returns ErrQuotaExceeded when completing an upload,
+// changing the usage of the bucket by delta, takes the bucket past a hard
+// limit of its quota. The usage already holds the uploaded parts, so the
+// assembled object is checked at its layout size.
+func (m *MultiPartStore) checkCompletionQuota(ctx context.Context, bucket string, delta BucketUsage) error {
+	cfg, _, err := m.bucketConfigs.get(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if cfg.Quota == nil {
+		return nil
+	}
+	_, err = m.usage.check(ctx, bucket, func(usage BucketUsage) error {
+		after := BucketUsage{Bytes: usage.Bytes + delta.Bytes, Objects: usage.Objects + delta.Objects}
+		return checkQuota(ctx, m.logger, bucket, cfg.Quota, after)
+	})
+	return err
 }
 
 // saveUploadMeta persists the given meta value at the provided key in the
 // UploadMeta Key-Value store. The value is expected to be a JSON-encoded
 // UploadMeta blob. Returns any error encountered during the put operation.
 func (m *MultiPartStore) saveUploadMeta(ctx context.Context, meta UploadMeta) error {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("creating upload meta: %v", meta))
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("creating upload meta: %v", meta))
 	data, err := json.Marshal(meta)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at saveUploadMeta when json.Marshal()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at saveUploadMeta when json.Marshal()", "err", err)
+		return err
+	}
+	kv, err := m.sessions(ctx, meta.Bucket)
+	if err != nil {
 		return err
 	}
 	key := metaKey(meta.Bucket, meta.Key, meta.UploadID)
-	_, err = m.metaStore.Put(ctx, key, data)
+	_, err = kv.Put(ctx, key, data)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at saveUploadMeta when sessionStore.Put()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at saveUploadMeta when sessionStore.Put()", "err", err)
 		return err
 	}
 	return nil
@@ -372,11 +582,15 @@ func (m *MultiPartStore) saveUploadMeta(ctx context.Context, meta UploadMeta) er
 
 // removeUploadMeta delete the persisted multipart upload metadata.
 func (m *MultiPartStore) removeUploadMeta(ctx context.Context, meta UploadMeta) error {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("remove upload meta: %v", meta))
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("remove upload meta: %v", meta))
+	kv, err := m.sessions(ctx, meta.Bucket)
+	if err != nil {
+		return err
+	}
 	key := metaKey(meta.Bucket, meta.Key, meta.UploadID)
-	err := m.metaStore.Delete(ctx, key)
+	err = kv.Delete(ctx, key)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at removeUploadMeta when sessionStore.Delete()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at removeUploadMeta when sessionStore.Delete()", "err", err)
 		return err
 	}
 
@@ -385,48 +599,59 @@ func (m *MultiPartStore) removeUploadMeta(ctx context.Context, meta UploadMeta)
 
 // getUploadMeta fetches the KV entry for a multipart upload session, including
 // its current revision number for optimistic updates.
-func (m *MultiPartStore) getUploadMeta(ctx context.Context, sessionKey string) (jetstream.KeyValueEntry, error) {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("get upload meta: %s", sessionKey))
-	entry, err := m.metaStore.Get(ctx, sessionKey)
+func (m *MultiPartStore) getUploadMeta(ctx context.Context, bucket string, sessionKey string) (jetstream.KeyValueEntry, error) {
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("get upload meta: %s", sessionKey))
+	kv, err := m.sessions(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := kv.Get(ctx, sessionKey)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at getSession when kv.Get()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at getSession when kv.Get()", "err", err)
 		return nil, err
 	}
 	return entry, nil
 }
 
-// savePartData streams a part from the provided reader into the temporary
-// Object Store under the given part key and returns the stored object's info.
-func (m *MultiPartStore) savePartData(ctx context.Context, partKey string, dataReader *io.PipeReader) (*jetstream.ObjectInfo, error) {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("uploading part: %s", partKey))
-	obj, err := m.partObjectStore.Put(ctx, jetstream.ObjectMeta{Name: partKey}, dataReader)
+// savePartData streams a part from the provided reader into the object store
+// of its bucket under the given part key and returns the stored object's info.
+func (m *MultiPartStore) savePartData(ctx context.Context, bucket string, partKey string, dataReader *io.PipeReader) (*jetstream.ObjectInfo, error) {
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("uploading part: %s/%s", bucket, partKey))
+	os, err := m.partStore(ctx, bucket)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at savePartData when tempPartStore.Put()", "err", err)
 		return nil, err
 	}
+	partMeta := jetstream.ObjectMeta{Name: partKey, Headers: nats.Header{}}
+	requestHeaders(ctx, partMeta.Headers)
+	obj, err := os.Put(ctx, partMeta, dataReader)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at savePartData when ObjectStore.Put()", "err", err)
+		return nil, storeError(err)
+	}
 	return obj, nil
 }
 
-// getPartData return part from the temporary Object Store.
-func (m *MultiPartStore) getPartData(ctx context.Context, partKey string) (jetstream.ObjectResult, error) {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("get part upload: %s", partKey))
-	return m.partObjectStore.Get(ctx, partKey)
-}
-
-// removePartData delete part from the temporary Object Store.
-func (m *MultiPartStore) removePartData(ctx context.Context, partKey string) error {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("delete part upload: %s", partKey))
-	return m.partObjectStore.Delete(ctx, partKey)
+// removePartData deletes a part from the object store of its bucket.
+func (m *MultiPartStore) removePartData(ctx context.Context, bucket string, partKey string) error {
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("delete part upload: %s/%s", bucket, partKey))
+	os, err := m.partStore(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	return os.Delete(ctx, partKey)
 }
 
-// removeAllPartData delete all parts from the temporary Object Store.
-func (m *MultiPartStore) removeAllPartData(ctx context.Context, bucket, key, uploadID string, parts map[int]PartMeta) error {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("delete all part upload: bucket=%s key=%s uploadID=%s", bucket, key, uploadID))
+// removeUnusedPartData deletes the parts of an upload that are not part of
+// the completed object from the object store of its bucket.
+func (m *MultiPartStore) removeUnusedPartData(ctx context.Context, os jetstream.ObjectStore, uploadID string, parts map[int]PartMeta, used map[int]bool) error {
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("delete unused part upload: uploadID=%s", uploadID))
 	for pn := range parts {
-		partKey := partKey(bucket, key, uploadID, pn)
-		err := m.partObjectStore.Delete(ctx, partKey)
-		if err != nil {
-			logging.Error(m.logger, "msg", "Error at all when tempPartStore.Delete()", "err", err)
+		if used[pn] {
+			continue
+		}
+		err := os.Delete(ctx, partKey(uploadID, pn))
+		if err != nil && !errors.Is(err, jetstream.ErrObjectNotFound) {
+			logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at removeUnusedPartData when ObjectStore.Delete()", "err", err)
 			return err
 		}
 	}
@@ -434,18 +659,56 @@ func (m *MultiPartStore) removeAllPartData(ctx context.Context, bucket, key, upl
 	return nil
 }
 
+// partStore returns the object store of the bucket holding its parts.
+func (m *MultiPartStore) partStore(ctx context.Context, bucket string) (jetstream.ObjectStore, error) {
+	os, err := m.routes.JetStream(bucket).ObjectStore(ctx, bucket)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrBucketNotFound) {
+			return nil, ErrBucketNotFound
+		}
+		return nil, err
+	}
+	return os, nil
+}
+
+// sessions returns the Key-Value bucket holding the upload sessions of a
+// bucket.
+func (m *MultiPartStore) sessions(ctx context.Context, bucket string) (jetstream.KeyValue, error) {
+	kv, err := m.routes.KeyValue(ctx, bucket, MetaStoreName)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at sessions", "err", err)
+		return nil, err
+	}
+	return kv, nil
+}
+
+// partMetas returns the Key-Value bucket holding the part metadata of the
+// uploads of a bucket.
+func (m *MultiPartStore) partMetas(ctx context.Context, bucket string) (jetstream.KeyValue, error) {
+	kv, err := m.routes.KeyValue(ctx, bucket, PartMetaStoreName)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at partMetas", "err", err)
+		return nil, err
+	}
+	return kv, nil
+}
+
 // savePartMeta stores metadata for a single part in the KV store.
 func (m *MultiPartStore) savePartMeta(ctx context.Context, bucket, key, uploadID string, partMeta PartMeta) error {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("save part meta: bucket=%s key=%s uploadID=%s part=%d", bucket, key, uploadID, partMeta.Number))
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("save part meta: bucket=%s key=%s uploadID=%s part=%d", bucket, key, uploadID, partMeta.Number))
 	pm, err := json.Marshal(partMeta)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at savePartMeta when json.Marshal()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at savePartMeta when json.Marshal()", "err", err)
+		return err
+	}
+	kv, err := m.partMetas(ctx, bucket)
+	if err != nil {
 		return err
 	}
 	pmk := partMetaKey(bucket, key, uploadID, partMeta.Number)
-	_, err = m.partMetaStore.Put(ctx, pmk, pm)
+	_, err = kv.Put(ctx, pmk, pm)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at savePartMeta when sessionStore.Put()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at savePartMeta when sessionStore.Put()", "err", err)
 		return err
 	}
 	return nil
@@ -453,16 +716,20 @@ func (m *MultiPartStore) savePartMeta(ctx context.Context, bucket, key, uploadID
 
 // getPartMeta retrieves metadata for a single part from the KV store.
 func (m *MultiPartStore) getPartMeta(ctx context.Context, bucket, key, uploadID string, partNumber int) (*PartMeta, error) {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("get part meta: bucket=%s key=%s uploadID=%s part=%d", bucket, key, uploadID, partNumber))
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("get part meta: bucket=%s key=%s uploadID=%s part=%d", bucket, key, uploadID, partNumber))
+	kv, err := m.partMetas(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
 	partMetaKey := partMetaKey(bucket, key, uploadID, partNumber)
-	entry, err := m.partMetaStore.Get(ctx, partMetaKey)
+	entry, err := kv.Get(ctx, partMetaKey)
 	if err != nil {
-		logging.Error(m.logger, "msg", "Error at getPartMeta when sessionStore.Get()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at getPartMeta when sessionStore.Get()", "err", err)
 		return nil, err
 	}
 	var partMeta PartMeta
 	if err := json.Unmarshal(entry.Value(), &partMeta); err != nil {
-		logging.Error(m.logger, "msg", "Error at getPartMeta when json.Unmarshal()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at getPartMeta when json.Unmarshal()", "err", err)
 		return nil, err
 	}
 	return &partMeta, nil
@@ -470,31 +737,35 @@ func (m *MultiPartStore) getPartMeta(ctx context.Context, bucket, key, uploadID
 
 // getAllPartMeta retrieves all part metadata for a given upload session.
 func (m *MultiPartStore) getAllPartMeta(ctx context.Context, bucket, key, uploadID string) (map[int]PartMeta, error) {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("get all part meta: bucket=%s key=%s uploadID=%s", bucket, key, uploadID))
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("get all part meta: bucket=%s key=%s uploadID=%s", bucket, key, uploadID))
 	prefix := partMetaPrefix(bucket, key, uploadID)
 
-	keys, err := m.partMetaStore.Keys(ctx)
+	kv, err := m.partMetas(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := kv.Keys(ctx)
 	if err != nil {
 		// ErrNoKeysFound is expected when no parts have been uploaded yet
 		if errors.Is(err, jetstream.ErrNoKeysFound) {
-			logging.Debug(m.logger, "msg", "No parts found for upload session (empty upload)")
+			logging.Debug(logging.WithContext(ctx, m.logger), "msg", "No parts found for upload session (empty upload)")
 			return make(map[int]PartMeta), nil
 		}
-		logging.Error(m.logger, "msg", "Error at getAllPartMeta when partMetaStore.Keys()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at getAllPartMeta when partMetaStore.Keys()", "err", err)
 		return nil, err
 	}
 
 	parts := make(map[int]PartMeta)
 	for _, key := range keys {
 		if strings.HasPrefix(key, prefix) {
-			entry, err := m.partMetaStore.Get(ctx, key)
+			entry, err := kv.Get(ctx, key)
 			if err != nil {
-				logging.Warn(m.logger, "msg", "Error getting part metadata", "key", key, "err", err)
+				logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Error getting part metadata", "key", key, "err", err)
 				continue
 			}
 			var partMeta PartMeta
 			if err := json.Unmarshal(entry.Value(), &partMeta); err != nil {
-				logging.Warn(m.logger, "msg", "Error unmarshaling part metadata", "key", key, "err", err)
+				logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Error unmarshaling part metadata", "key", key, "err", err)
 				continue
 			}
 			parts[partMeta.Number] = partMeta
@@ -506,25 +777,29 @@ func (m *MultiPartStore) getAllPartMeta(ctx context.Context, bucket, key, upload
 
 // deleteAllPartMeta deletes all part metadata entries for a given upload session.
 func (m *MultiPartStore) deleteAllPartMeta(ctx context.Context, bucket, key, uploadID string) error {
-	logging.Debug(m.logger, "msg", fmt.Sprintf("delete all part meta: bucket=%s key=%s uploadID=%s", bucket, key, uploadID))
+	logging.Debug(logging.WithContext(ctx, m.logger), "msg", fmt.Sprintf("delete all part meta: bucket=%s key=%s uploadID=%s", bucket, key, uploadID))
 	prefix := partMetaPrefix(bucket, key, uploadID)
 
-	keys, err := m.partMetaStore.Keys(ctx)
+	kv, err := m.partMetas(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	keys, err := kv.Keys(ctx)
 	if err != nil {
 		// ErrNoKeysFound is expected when no parts exist - nothing to delete
 		if errors.Is(err, jetstream.ErrNoKeysFound) {
-			logging.Debug(m.logger, "msg", "No part metadata to delete (empty upload)")
+			logging.Debug(logging.WithContext(ctx, m.logger), "msg", "No part metadata to delete (empty upload)")
 			return nil
 		}
-		logging.Error(m.logger, "msg", "Error at deleteAllPartMeta when partMetaStore.Keys()", "err", err)
+		logging.Error(logging.WithContext(ctx, m.logger), "msg", "Error at deleteAllPartMeta when partMetaStore.Keys()", "err", err)
 		return err
 	}
 
 	for _, key := range keys {
 		if strings.HasPrefix(key, prefix) {
-			err := m.partMetaStore.Delete(ctx, key)
+			err := kv.Delete(ctx, key)
 			if err != nil {
-				logging.Warn(m.logger, "msg", "Error deleting part metadata", "key", key, "err", err)
+				logging.Warn(logging.WithContext(ctx, m.logger), "msg", "Error deleting part metadata", "key", key, "err", err)
 			}
 		}
 	}
@@ -541,11 +816,6 @@ func metaKey(bucket, key, uploadID string) string {
 		enc.EncodeToString([]byte(uploadID)))
 }
 
-// partKey constructs the Object Store key for a specific uploaded part
-func partKey(bucket, key, uploadID string, part int) string {
-	return fmt.Sprintf("multi_parts/%s/%s/%s/%06d", bucket, key, uploadID, part)
-}
-
 // partMetaKey builds the KV key for a single part's metadata.
 func partMetaKey(bucket, key, uploadID string, part int) string {
 	enc := base64.RawURLEncoding
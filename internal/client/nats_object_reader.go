@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxManifestSkip is the largest gap a read of a manifest object skips on
+// its open stream rather than opening the part holding the offset.
+const maxManifestSkip = 4 << 20
+
+// ObjectReader reads an object piecewise, such as the queries of
+// SelectObjectContent do. The object info, layout and data key are resolved
+// once when it is opened. Reads continuing where the previous one stopped
+// are served from the same stream, so reading the object sequentially
+// fetches it from JetStream once, while reads elsewhere reopen the stream
+// at their offset.
+type ObjectReader struct {
+	ctx    context.Context
+	store  jetstream.ObjectStore
+	info   *jetstream.ObjectInfo
+	layout *ObjectLayout
+	codec  objectCodec
+	size   int64
+	// data holds objects served from the cache
+	data []byte
+
+	mu     sync.Mutex
+	stream io.ReadCloser
+	pos    int64
+}
+
+// OpenObject returns a reader of an object, decrypting encrypted objects.
+// SSE-C objects require the customer key in sse. The reader must be closed.
+func (c *NatsObjectClient) OpenObject(ctx context.Context, bucket string, key string, sse *ServerSideEncryption) (_ *ObjectReader, err error) {
+	ctx, span := tracing.Start(ctx, "NatsObjectClient.OpenObject", attribute.String("aws.s3.bucket", bucket), attribute.String("aws.s3.key", key))
+	defer func() { tracing.End(span, err) }()
+	logging.Info(logging.WithContext(ctx, c.logger), "msg", fmt.Sprintf("Open object: [%s/%s]", bucket, key))
+	if isPartKey(key) {
+		return nil, ErrObjectNotFound
+	}
+	if info, data, ok := c.cache.lookup(bucket, key); ok {
+		if _, err := c.keyring.open(ctx, info.Metadata, sse); err != nil {
+			return nil, err
+		}
+		return &ObjectReader{ctx: ctx, info: info, size: int64(len(data)), data: data}, nil
+	}
+	obj, err := c.lookup(ctx, bucket, key)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at OpenObject", "err", err)
+		return nil, err
+	}
+	os, info := obj.store, obj.infoCopy()
+	dataKey, err := c.keyring.open(ctx, info.Metadata, sse)
+	if err != nil {
+		logging.Warn(logging.WithContext(ctx, c.logger), "msg", "Error at OpenObject", "err", err)
+		return nil, err
+	}
+	layout, err := readLayout(ctx, os, info)
+	if err != nil {
+		logging.Error(logging.WithContext(ctx, c.logger), "msg", "Error at OpenObject", "err", err)
+		return nil, err
+	}
+	o := &ObjectReader{
+		ctx:    ctx,
+		store:  os,
+		info:   info,
+		layout: layout,
+		codec:  objectCodec{dataKey: dataKey, compression: info.Metadata[MetaCompression]},
+	}
+	resolved := *info
+	resolveObjectInfo(&resolved)
+	o.size = int64(resolved.Size)
+	return o, nil
+}
+
+// Size returns the size of the object.
+func (o *ObjectReader) Size() int64 {
+	return o.size
+}
+
+// ReadAt implements the io.ReaderAt interface.
+func (o *ObjectReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	if o.data != nil {
+		n := copy(p, o.data[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.seek(off); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(o.stream, p[:min(int64(len(p)), o.size-off)])
+	o.pos += int64(n)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		o.closeStream()
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// seek positions the stream at off, skipping forward on the open stream
+// when that is cheaper than opening a new one.
+func (o *ObjectReader) seek(off int64) error {
+	if o.stream != nil && off >= o.pos && (o.layout == nil || off-o.pos <= maxManifestSkip) {
+		skipped, err := io.CopyN(io.Discard, o.stream, off-o.pos)
+		o.pos += skipped
+		if err == nil {
+			return nil
+		}
+		o.closeStream()
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	o.closeStream()
+	rc, err := o.open(off)
+	if err != nil {
+		return err
+	}
+	o.stream, o.pos = rc, off
+	return nil
+}
+
+// open returns a stream of the object data from off.
+func (o *ObjectReader) open(off int64) (io.ReadCloser, error) {
+	if o.layout != nil {
+		return openManifest(o.ctx, o.store, o.layout, o.codec, off), nil
+	}
+	res, err := o.store.Get(o.ctx, o.info.Name)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrObjectNotFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	current, err := res.Info()
+	if err != nil {
+		_ = res.Close()
+		return nil, err
+	}
+	if current.NUID != o.info.NUID {
+		_ = res.Close()
+		return nil, errObjectChanged
+	}
+	return o.codec.decode(res, off)
+}
+
+func (o *ObjectReader) closeStream() {
+	if o.stream != nil {
+		_ = o.stream.Close()
+		o.stream = nil
+	}
+}
+
+// Close releases the stream of the reader.
+func (o *ObjectReader) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closeStream()
+	return nil
+}
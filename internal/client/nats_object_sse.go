@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wpnpeiris/nats-s3/internal/encryption"
+	"github.com/wpnpeiris/nats-s3/internal/kms"
+)
+
+const (
+	// SSEAlgorithmAES256 selects encryption with a data key wrapped by the
+	// gateway master key (SSE-S3).
+	SSEAlgorithmAES256 = "AES256"
+	// SSEAlgorithmCustomer selects encryption with a data key wrapped by a
+	// key provided by the client on every request (SSE-C).
+	SSEAlgorithmCustomer = "SSE-C"
+	// SSEAlgorithmKMS selects encryption with a data key issued by the
+	// configured KMS under a named key (SSE-KMS).
+	SSEAlgorithmKMS = "aws:kms"
+
+	// MetaSSEAlgorithm records how the data of an object is encrypted.
+	MetaSSEAlgorithm = InternalMetaPrefix + "sse"
+	// MetaSSEDataKey records the wrapped data key of an encrypted object.
+	MetaSSEDataKey = InternalMetaPrefix + "sse-key"
+	// MetaSSECustomerKeyMD5 records the MD5 of the customer key of an SSE-C
+	// object, as sent by the client.
+	MetaSSECustomerKeyMD5 = InternalMetaPrefix + "sse-c-key-md5"
+	// MetaSSEKMSKeyID records the KMS key wrapping the data key of an
+	// SSE-KMS object.
+	MetaSSEKMSKeyID = InternalMetaPrefix + "sse-kms-key-id"
+)
+
+var ErrSSENotConfigured = errors.New("server side encryption is not configured")
+var ErrSSECustomerKeyRequired = errors.New("customer key required")
+var ErrSSECustomerKeyMismatch = errors.New("customer key does not match")
+var ErrSSECustomerKeyNotNeeded = errors.New("object is not encrypted with a customer key")
+
+// ServerSideEncryption describes the encryption requested for an object, or
+// the customer key supplied to read one.
+type ServerSideEncryption struct {
+	Algorithm      string
+	CustomerKey    []byte
+	CustomerKeyMD5 string
+	// KMSKeyID names the KMS key of SSE-KMS objects; empty selects the
+	// KMS default key
+	KMSKeyID string
+}
+
+// ObjectEncryption returns the SSE algorithm of an object and, for SSE-C
+// objects, the MD5 of the customer key. Unencrypted objects return "".
+func ObjectEncryption(metadata map[string]string) (algorithm string, customerKeyMD5 string) {
+	if metadata == nil {
+		return "", ""
+	}
+	return metadata[MetaSSEAlgorithm], metadata[MetaSSECustomerKeyMD5]
+}
+
+// ObjectKMSKeyID returns the KMS key of an SSE-KMS object, or "".
+func ObjectKMSKeyID(metadata map[string]string) string {
+	if metadata == nil {
+		return ""
+	}
+	return metadata[MetaSSEKMSKeyID]
+}
+
+// sseKeyring creates and opens the per-object data keys of encrypted objects.
+// Data keys are wrapped with the gateway master key (SSE-S3), with the
+// customer key (SSE-C) or by the KMS (SSE-KMS) and kept in the object
+// metadata.
+type sseKeyring struct {
+	masterKey []byte
+	kms       kms.KMS
+}
+
+func newSSEKeyring(masterKey []byte, k kms.KMS) (*sseKeyring, error) {
+	if masterKey != nil && len(masterKey) != encryption.KeySize {
+		return nil, encryption.ErrInvalidKey
+	}
+	return &sseKeyring{masterKey: masterKey, kms: k}, nil
+}
+
+// seal generates a data key for a new object and returns it together with
+// the metadata entries recording its wrapped form. A nil sse returns no key.
+func (k *sseKeyring) seal(ctx context.Context, sse *ServerSideEncryption) ([]byte, map[string]string, error) {
+	if sse == nil {
+		return nil, nil, nil
+	}
+	if sse.Algorithm == SSEAlgorithmKMS {
+		return k.sealKMS(ctx, sse)
+	}
+	kek, err := k.keyEncryptionKey(sse.Algorithm, sse)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := encryption.GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := encryption.WrapKey(kek, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := map[string]string{
+		MetaSSEAlgorithm: sse.Algorithm,
+		MetaSSEDataKey:   wrapped,
+	}
+	if sse.Algorithm == SSEAlgorithmCustomer {
+		meta[MetaSSECustomerKeyMD5] = sse.CustomerKeyMD5
+	}
+	return dataKey, meta, nil
+}
+
+// sealKMS asks the KMS for a data key under the requested key.
+func (k *sseKeyring) sealKMS(ctx context.Context, sse *ServerSideEncryption) ([]byte, map[string]string, error) {
+	if k.kms == nil {
+		return nil, nil, ErrSSENotConfigured
+	}
+	keyID := sse.KMSKeyID
+	if keyID == "" {
+		keyID = k.kms.DefaultKeyID()
+	}
+	dataKey, wrapped, err := k.kms.GenerateDataKey(ctx, keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, map[string]string{
+		MetaSSEAlgorithm: SSEAlgorithmKMS,
+		MetaSSEDataKey:   wrapped,
+		MetaSSEKMSKeyID:  keyID,
+	}, nil
+}
+
+// open returns the data key of an object from its metadata, or nil when the
+// object is not encrypted. SSE-C objects require the matching customer key.
+func (k *sseKeyring) open(ctx context.Context, metadata map[string]string, sse *ServerSideEncryption) ([]byte, error) {
+	algorithm, keyMD5 := ObjectEncryption(metadata)
+	customer := sse != nil && sse.Algorithm == SSEAlgorithmCustomer
+	if algorithm == "" {
+		if customer {
+			return nil, ErrSSECustomerKeyNotNeeded
+		}
+		return nil, nil
+	}
+	if algorithm == SSEAlgorithmCustomer {
+		if !customer {
+			return nil, ErrSSECustomerKeyRequired
+		}
+		if sse.CustomerKeyMD5 != keyMD5 {
+			return nil, ErrSSECustomerKeyMismatch
+		}
+	} else if customer {
+		return nil, ErrSSECustomerKeyNotNeeded
+	}
+	if algorithm == SSEAlgorithmKMS {
+		if k.kms == nil {
+			return nil, ErrSSENotConfigured
+		}
+		return k.kms.Decrypt(ctx, metadata[MetaSSEKMSKeyID], metadata[MetaSSEDataKey])
+	}
+	kek, err := k.keyEncryptionKey(algorithm, sse)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := encryption.UnwrapKey(kek, metadata[MetaSSEDataKey])
+	if err != nil {
+		if algorithm == SSEAlgorithmCustomer {
+			return nil, ErrSSECustomerKeyMismatch
+		}
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// keyEncryptionKey returns the key wrapping the data keys of the algorithm.
+func (k *sseKeyring) keyEncryptionKey(algorithm string, sse *ServerSideEncryption) ([]byte, error) {
+	switch algorithm {
+	case SSEAlgorithmAES256:
+		if k.masterKey == nil {
+			return nil, ErrSSENotConfigured
+		}
+		return k.masterKey, nil
+	case SSEAlgorithmCustomer:
+		if sse == nil || len(sse.CustomerKey) != encryption.KeySize {
+			return nil, ErrSSECustomerKeyRequired
+		}
+		return sse.CustomerKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported server side encryption %q", algorithm)
+	}
+}
+
+// decryptingReadCloser decrypts an object stream from the given plaintext
+// offset, closing the underlying stream on Close.
+func decryptingReadCloser(rc io.ReadCloser, dataKey []byte, offset int64) (io.ReadCloser, error) {
+	r, err := encryption.NewDecryptReaderAt(rc, dataKey, offset)
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
+}
+
+// skippingReadCloser discards the first offset bytes of a plain stream.
+func skippingReadCloser(rc io.ReadCloser, offset int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+			_ = rc.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
@@ -0,0 +1,233 @@
+// Package compression implements the framed compression format of objects
+// compressed at rest.
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed streams start with a short header followed by independently
+// compressed frames of at most frameSize bytes of plaintext, and end with an
+// empty frame. Readers starting at an offset skip whole frames without
+// decompressing them.
+//
+//	header = magic (4) | version (1) | algorithm (1)
+//	frame  = kind (1) | plain length (4) | stored length (4) | data
+//
+// Frames that do not shrink are stored as-is.
+const (
+	// AlgorithmZstd compresses with Zstandard, favouring ratio.
+	AlgorithmZstd = "zstd"
+	// AlgorithmS2 compresses with S2, favouring speed.
+	AlgorithmS2 = "s2"
+
+	frameSize       = 1024 * 1024
+	frameHeaderSize = 9
+	version         = 1
+	frameStored     = 0
+	frameCompressed = 1
+	frameEnd        = 2
+)
+
+var magic = [4]byte{'N', 'S', '3', 'Z'}
+
+var ErrUnsupportedAlgorithm = errors.New("unsupported compression algorithm")
+var ErrInvalidStream = errors.New("invalid compressed stream")
+
+var algorithmIDs = map[string]byte{AlgorithmZstd: 1, AlgorithmS2: 2}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// Supported reports whether algorithm names a known compression algorithm.
+func Supported(algorithm string) bool {
+	_, ok := algorithmIDs[algorithm]
+	return ok
+}
+
+// NewReader returns a reader yielding the compressed form of src.
+func NewReader(src io.Reader, algorithm string) (io.Reader, error) {
+	id, ok := algorithmIDs[algorithm]
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	header := make([]byte, 0, len(magic)+2)
+	header = append(header, magic[:]...)
+	header = append(header, version, id)
+	return &compressReader{
+		src:       src,
+		algorithm: algorithm,
+		plain:     make([]byte, frameSize),
+		out:       header,
+	}, nil
+}
+
+type compressReader struct {
+	src       io.Reader
+	algorithm string
+	plain     []byte
+	buf       []byte
+	out       []byte
+	done      bool
+}
+
+func (c *compressReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.compress(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// compress encodes the next frame, or the end frame once src is drained.
+func (c *compressReader) compress() error {
+	n, err := io.ReadFull(c.src, c.plain)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if n == 0 {
+		c.out = appendFrameHeader(c.buf[:0], frameEnd, 0, 0)
+		c.done = true
+		return nil
+	}
+	plain := c.plain[:n]
+	var packed []byte
+	switch c.algorithm {
+	case AlgorithmZstd:
+		packed = zstdEncoder.EncodeAll(plain, nil)
+	case AlgorithmS2:
+		packed = s2.Encode(nil, plain)
+	}
+	kind := byte(frameCompressed)
+	if len(packed) >= n {
+		kind, packed = frameStored, plain
+	}
+	c.buf = appendFrameHeader(c.buf[:0], kind, n, len(packed))
+	c.buf = append(c.buf, packed...)
+	c.out = c.buf
+	return nil
+}
+
+func appendFrameHeader(b []byte, kind byte, plainLen int, storedLen int) []byte {
+	b = append(b, kind)
+	b = binary.BigEndian.AppendUint32(b, uint32(plainLen))
+	return binary.BigEndian.AppendUint32(b, uint32(storedLen))
+}
+
+// NewDecompressReaderAt returns a reader yielding the plaintext of a
+// compressed stream starting at the given plaintext offset. Frames in front
+// of the offset are skipped without being decompressed.
+func NewDecompressReaderAt(src io.Reader, offset int64) (io.Reader, error) {
+	header := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, ErrInvalidStream
+	}
+	if [4]byte(header[:len(magic)]) != magic || header[len(magic)] != version {
+		return nil, ErrInvalidStream
+	}
+	d := &decompressReader{src: src, skip: offset}
+	for name, id := range algorithmIDs {
+		if id == header[len(magic)+1] {
+			d.algorithm = name
+		}
+	}
+	if d.algorithm == "" {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	return d, nil
+}
+
+type decompressReader struct {
+	src       io.Reader
+	algorithm string
+	skip      int64
+	buf       []byte
+	out       []byte
+	done      bool
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.decompress(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// decompress decodes the next frame, skipping it entirely when it lies in
+// front of the requested offset.
+func (d *decompressReader) decompress() error {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(d.src, header[:]); err != nil {
+		return ErrInvalidStream
+	}
+	kind := header[0]
+	plainLen := int64(binary.BigEndian.Uint32(header[1:5]))
+	storedLen := int64(binary.BigEndian.Uint32(header[5:9]))
+	if kind == frameEnd {
+		d.done = true
+		return nil
+	}
+	if plainLen > frameSize || storedLen > frameSize {
+		return ErrInvalidStream
+	}
+	if d.skip >= plainLen {
+		if _, err := io.CopyN(io.Discard, d.src, storedLen); err != nil {
+			return ErrInvalidStream
+		}
+		d.skip -= plainLen
+		return nil
+	}
+
+	if int64(cap(d.buf)) < storedLen {
+		d.buf = make([]byte, storedLen)
+	}
+	stored := d.buf[:storedLen]
+	if _, err := io.ReadFull(d.src, stored); err != nil {
+		return ErrInvalidStream
+	}
+	var plain []byte
+	var err error
+	switch kind {
+	case frameStored:
+		plain = stored
+	case frameCompressed:
+		switch d.algorithm {
+		case AlgorithmZstd:
+			plain, err = zstdDecoder.DecodeAll(stored, nil)
+		case AlgorithmS2:
+			plain, err = s2.Decode(nil, stored)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidStream, err)
+		}
+	default:
+		return ErrInvalidStream
+	}
+	if int64(len(plain)) != plainLen {
+		return ErrInvalidStream
+	}
+	d.out = plain[d.skip:]
+	d.skip = 0
+	return nil
+}
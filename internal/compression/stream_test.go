@@ -0,0 +1,60 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	text := []byte(strings.Repeat("2026-10-18,info,request served\n", 120000))
+	random := make([]byte, frameSize+123)
+	_, _ = rand.Read(random)
+
+	for _, algorithm := range []string{AlgorithmZstd, AlgorithmS2} {
+		for name, plain := range map[string][]byte{"empty": nil, "text": text, "random": random} {
+			cr, err := NewReader(bytes.NewReader(plain), algorithm)
+			if err != nil {
+				t.Fatalf("NewReader failed: %v", err)
+			}
+			packed, err := io.ReadAll(cr)
+			if err != nil {
+				t.Fatalf("%s/%s: compress failed: %v", algorithm, name, err)
+			}
+			if name == "text" && len(packed)*5 > len(plain) {
+				t.Fatalf("%s: expected text to compress, got %d of %d bytes", algorithm, len(packed), len(plain))
+			}
+
+			for _, off := range []int{0, len(plain) / 2, len(plain)} {
+				dr, err := NewDecompressReaderAt(bytes.NewReader(packed), int64(off))
+				if err != nil {
+					t.Fatalf("NewDecompressReaderAt failed: %v", err)
+				}
+				got, err := io.ReadAll(dr)
+				if err != nil || !bytes.Equal(got, plain[off:]) {
+					t.Fatalf("%s/%s offset %d: round trip mismatch (err=%v)", algorithm, name, off, err)
+				}
+			}
+		}
+	}
+}
+
+func TestStreamRejectsTruncation(t *testing.T) {
+	cr, _ := NewReader(strings.NewReader(strings.Repeat("x", 3*frameSize)), AlgorithmS2)
+	packed, _ := io.ReadAll(cr)
+
+	dr, err := NewDecompressReaderAt(bytes.NewReader(packed[:len(packed)-frameHeaderSize]), 0)
+	if err != nil {
+		t.Fatalf("NewDecompressReaderAt failed: %v", err)
+	}
+	if _, err := io.ReadAll(dr); !errors.Is(err, ErrInvalidStream) {
+		t.Fatalf("expected ErrInvalidStream for truncated stream, got %v", err)
+	}
+
+	if _, err := NewReader(strings.NewReader("x"), "gzip"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size in bytes of master, customer and data keys (AES-256).
+const KeySize = 32
+
+// GenerateKey returns a new random data key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// WrapKey seals a data key under a key-encryption key and returns it base64
+// encoded, ready to be stored next to the data it protects.
+func WrapKey(kek []byte, key []byte) (string, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return "", err
+	}
+	n := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(n); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(n, n, key, nil)), nil
+}
+
+// UnwrapKey opens a data key sealed by WrapKey. It fails with
+// ErrAuthentication when kek is not the key the data key was wrapped with.
+func UnwrapKey(kek []byte, wrapped string) ([]byte, error) {
+	aead, err := newAEAD(kek)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, ErrInvalidStream
+	}
+	key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return key, nil
+}
+
+// LoadKeyFile reads a 256-bit master key from a file. The key may be stored
+// as 32 raw bytes, or as hex or base64 text.
+func LoadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(data) == KeySize {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key file %s: %w", path, ErrInvalidKey)
+}
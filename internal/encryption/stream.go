@@ -0,0 +1,227 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encrypted streams start with a short header followed by a sequence of
+// AES-256-GCM sealed segments. Every segment but the last holds exactly
+// segmentSize bytes of plaintext and the last one always holds less, possibly
+// nothing, so the ciphertext position of any plaintext offset can be computed
+// without decrypting the segments in front of it.
+//
+//	header  = magic (4) | version (1) | nonce prefix (8)
+//	segment = GCM(plaintext) | tag (16)
+//
+// The nonce of segment i is the nonce prefix followed by i as a big endian
+// uint32, and the additional data flags the final segment so truncated
+// streams fail authentication.
+const (
+	segmentSize  = 64 * 1024
+	tagSize      = 16
+	prefixSize   = 8
+	headerSize   = len(magic) + 1 + prefixSize
+	sealedSize   = segmentSize + tagSize
+	version      = 1
+	maxSegments  = 1<<32 - 1
+	finalFlag    = 1
+	nonfinalFlag = 0
+)
+
+var magic = [4]byte{'N', 'S', '3', 'E'}
+
+var ErrInvalidKey = errors.New("encryption key must be 32 bytes")
+var ErrInvalidStream = errors.New("invalid encrypted stream")
+var ErrAuthentication = errors.New("message authentication failed")
+
+// EncryptedSize returns the size of the encrypted stream for a plaintext of
+// the given size.
+func EncryptedSize(size int64) int64 {
+	full := size / segmentSize
+	return int64(headerSize) + full*sealedSize + (size - full*segmentSize) + tagSize
+}
+
+// NewReader returns a reader yielding the encrypted form of src under key.
+func NewReader(src io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize, headerSize+sealedSize)
+	copy(header, magic[:])
+	header[len(magic)] = version
+	if _, err := rand.Read(header[len(magic)+1:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+	e := &encryptReader{
+		src:   src,
+		aead:  aead,
+		plain: make([]byte, segmentSize),
+		out:   header,
+	}
+	copy(e.prefix[:], header[len(magic)+1:])
+	return e, nil
+}
+
+type encryptReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	prefix [prefixSize]byte
+	index  uint32
+	plain  []byte
+	out    []byte
+	done   bool
+}
+
+func (e *encryptReader) Read(p []byte) (int, error) {
+	for len(e.out) == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		if err := e.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.out)
+	e.out = e.out[n:]
+	return n, nil
+}
+
+// seal encrypts the next segment. A short segment ends the stream.
+func (e *encryptReader) seal() error {
+	n, err := io.ReadFull(e.src, e.plain)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	final := n < segmentSize
+	if !final && e.index == maxSegments {
+		return fmt.Errorf("plaintext too large for encrypted stream")
+	}
+	e.out = e.aead.Seal(e.out[:0], nonce(e.prefix, e.index), e.plain[:n], additionalData(final))
+	e.index++
+	e.done = final
+	return nil
+}
+
+// NewDecryptReader returns a reader yielding the plaintext of an encrypted
+// stream read from src.
+func NewDecryptReader(src io.Reader, key []byte) (io.Reader, error) {
+	return NewDecryptReaderAt(src, key, 0)
+}
+
+// NewDecryptReaderAt returns a reader yielding the plaintext of an encrypted
+// stream starting at the given plaintext offset. The segments in front of the
+// offset are skipped without being decrypted.
+func NewDecryptReaderAt(src io.Reader, key []byte, offset int64) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, ErrInvalidStream
+	}
+	if [4]byte(header[:len(magic)]) != magic || header[len(magic)] != version {
+		return nil, ErrInvalidStream
+	}
+	d := &decryptReader{
+		src:    src,
+		aead:   aead,
+		sealed: make([]byte, sealedSize),
+	}
+	copy(d.prefix[:], header[len(magic)+1:])
+
+	skip := offset / segmentSize
+	if skip > 0 {
+		if _, err := io.CopyN(io.Discard, d.src, skip*sealedSize); err != nil {
+			return nil, ErrInvalidStream
+		}
+		d.index = uint32(skip)
+	}
+	d.skip = int(offset - skip*segmentSize)
+	return d, nil
+}
+
+type decryptReader struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	prefix [prefixSize]byte
+	index  uint32
+	sealed []byte
+	out    []byte
+	skip   int
+	done   bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// open authenticates and decrypts the next segment. A short segment ends the
+// stream.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.src, d.sealed)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	final := n < sealedSize
+	if n < tagSize {
+		return ErrInvalidStream
+	}
+	plain, err := d.aead.Open(d.sealed[:0], nonce(d.prefix, d.index), d.sealed[:n], additionalData(final))
+	if err != nil {
+		return ErrAuthentication
+	}
+	d.index++
+	d.done = final
+	if d.skip > 0 {
+		if d.skip > len(plain) {
+			return ErrInvalidStream
+		}
+		plain = plain[d.skip:]
+		d.skip = 0
+	}
+	d.out = plain
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix [prefixSize]byte, index uint32) []byte {
+	n := make([]byte, prefixSize+4)
+	copy(n, prefix[:])
+	binary.BigEndian.PutUint32(n[prefixSize:], index)
+	return n
+}
+
+func additionalData(final bool) []byte {
+	if final {
+		return []byte{finalFlag}
+	}
+	return []byte{nonfinalFlag}
+}
@@ -0,0 +1,97 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStreamRoundTrip(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		plain := make([]byte, size)
+		for i := range plain {
+			plain[i] = byte(i % 251)
+		}
+		er, err := NewReader(bytes.NewReader(plain), key)
+		if err != nil {
+			t.Fatalf("NewReader failed: %v", err)
+		}
+		sealed, err := io.ReadAll(er)
+		if err != nil {
+			t.Fatalf("encrypt %d bytes failed: %v", size, err)
+		}
+		if int64(len(sealed)) != EncryptedSize(int64(size)) {
+			t.Fatalf("size %d: encrypted size %d, want %d", size, len(sealed), EncryptedSize(int64(size)))
+		}
+
+		dr, err := NewDecryptReader(bytes.NewReader(sealed), key)
+		if err != nil {
+			t.Fatalf("NewDecryptReader failed: %v", err)
+		}
+		got, err := io.ReadAll(dr)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: round trip mismatch (err=%v)", size, err)
+		}
+
+		// Reads starting mid-stream skip the segments in front of the offset
+		for _, off := range []int{0, size / 2, size} {
+			dr, err := NewDecryptReaderAt(bytes.NewReader(sealed), key, int64(off))
+			if err != nil {
+				t.Fatalf("NewDecryptReaderAt failed: %v", err)
+			}
+			got, err := io.ReadAll(dr)
+			if err != nil || !bytes.Equal(got, plain[off:]) {
+				t.Fatalf("size %d offset %d: range mismatch (err=%v)", size, off, err)
+			}
+		}
+	}
+}
+
+func TestStreamRejectsTamperingAndTruncation(t *testing.T) {
+	key, _ := GenerateKey()
+	plain := bytes.Repeat([]byte("x"), 2*segmentSize)
+	er, _ := NewReader(bytes.NewReader(plain), key)
+	sealed, _ := io.ReadAll(er)
+
+	tampered := append([]byte{}, sealed...)
+	tampered[headerSize+10] ^= 1
+	dr, _ := NewDecryptReader(bytes.NewReader(tampered), key)
+	if _, err := io.ReadAll(dr); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("expected authentication error for tampered stream, got %v", err)
+	}
+
+	truncated := sealed[:headerSize+2*sealedSize]
+	dr, _ = NewDecryptReader(bytes.NewReader(truncated), key)
+	if _, err := io.ReadAll(dr); !errors.Is(err, ErrInvalidStream) {
+		t.Fatalf("expected invalid stream error for truncated stream, got %v", err)
+	}
+
+	other, _ := GenerateKey()
+	dr, _ = NewDecryptReader(bytes.NewReader(sealed), other)
+	if _, err := io.ReadAll(dr); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("expected authentication error for wrong key, got %v", err)
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	kek, _ := GenerateKey()
+	key, _ := GenerateKey()
+	wrapped, err := WrapKey(kek, key)
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	got, err := UnwrapKey(kek, wrapped)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("UnwrapKey mismatch (err=%v)", err)
+	}
+	other, _ := GenerateKey()
+	if _, err := UnwrapKey(other, wrapped); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("expected authentication error for wrong kek, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wpnpeiris/nats-s3/internal/encryption"
+)
+
+// DefaultKeyName is the key created when a new key file is initialized.
+const DefaultKeyName = "default"
+
+// reloadInterval bounds how often the key file is re-read to pick up changes
+// made by another process, such as the kms admin command.
+const reloadInterval = time.Second
+
+// keyFile is the JSON layout of a file backed key store.
+type keyFile struct {
+	DefaultKey string              `json:"default_key"`
+	Keys       map[string]*fileKey `json:"keys"`
+}
+
+// fileKey holds every version of a named key, oldest first. New data keys
+// are wrapped with the latest version; older versions only decrypt.
+type fileKey struct {
+	Versions []string `json:"versions"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+// FileKMS is a KMS backed by a local JSON key file. Key material never
+// leaves the gateway host.
+type FileKMS struct {
+	mu        sync.RWMutex
+	path      string
+	keys      keyFile
+	checkedAt time.Time
+}
+
+// NewFileKMS loads the key file at path, creating it with a single default
+// key when it does not exist.
+func NewFileKMS(path string) (*FileKMS, error) {
+	f := &FileKMS{path: path}
+	err := f.load()
+	if errors.Is(err, os.ErrNotExist) {
+		f.keys = keyFile{Keys: map[string]*fileKey{}}
+		if err := f.createKey(DefaultKeyName); err != nil {
+			return nil, err
+		}
+		f.keys.DefaultKey = DefaultKeyName
+		return f, f.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// DefaultKeyID returns the key used when a request names no key.
+func (f *FileKMS) DefaultKeyID() string {
+	f.reload()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.keys.DefaultKey
+}
+
+// GenerateDataKey returns a new data key wrapped with the latest version of
+// the named key. The ciphertext records the version it was wrapped with.
+func (f *FileKMS) GenerateDataKey(_ context.Context, keyID string) ([]byte, string, error) {
+	f.reload()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	key, err := f.key(keyID)
+	if err != nil {
+		return nil, "", err
+	}
+	version := len(key.Versions)
+	kek, err := decodeKey(key.Versions[version-1])
+	if err != nil {
+		return nil, "", err
+	}
+	dataKey, err := encryption.GenerateKey()
+	if err != nil {
+		return nil, "", err
+	}
+	wrapped, err := encryption.WrapKey(kek, dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, fmt.Sprintf("v%d:%s", version, wrapped), nil
+}
+
+// Decrypt unwraps a data key with the key version recorded in ciphertext.
+func (f *FileKMS) Decrypt(_ context.Context, keyID string, ciphertext string) ([]byte, error) {
+	f.reload()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	key, err := f.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	prefix, wrapped, ok := strings.Cut(ciphertext, ":")
+	if !ok || !strings.HasPrefix(prefix, "v") {
+		return nil, ErrInvalidCiphertext
+	}
+	version, err := strconv.Atoi(prefix[1:])
+	if err != nil || version < 1 || version > len(key.Versions) {
+		return nil, ErrInvalidCiphertext
+	}
+	kek, err := decodeKey(key.Versions[version-1])
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := encryption.UnwrapKey(kek, wrapped)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return dataKey, nil
+}
+
+// CreateKey adds a new named key.
+func (f *FileKMS) CreateKey(keyID string) error {
+	return f.update(func() error {
+		if _, ok := f.keys.Keys[keyID]; ok {
+			return fmt.Errorf("kms key %q already exists", keyID)
+		}
+		return f.createKey(keyID)
+	})
+}
+
+// RotateKey adds a new version to a key. Data keys wrapped with earlier
+// versions remain readable.
+func (f *FileKMS) RotateKey(keyID string) error {
+	return f.update(func() error {
+		key, ok := f.keys.Keys[keyID]
+		if !ok {
+			return ErrKeyNotFound
+		}
+		material, err := encryption.GenerateKey()
+		if err != nil {
+			return err
+		}
+		key.Versions = append(key.Versions, base64.StdEncoding.EncodeToString(material))
+		return nil
+	})
+}
+
+// SetKeyEnabled enables or disables a key. Disabled keys neither generate
+// nor decrypt data keys, making the objects encrypted under them unreadable.
+func (f *FileKMS) SetKeyEnabled(keyID string, enabled bool) error {
+	return f.update(func() error {
+		key, ok := f.keys.Keys[keyID]
+		if !ok {
+			return ErrKeyNotFound
+		}
+		key.Disabled = !enabled
+		return nil
+	})
+}
+
+// SetDefaultKey selects the key used when a request names no key.
+func (f *FileKMS) SetDefaultKey(keyID string) error {
+	return f.update(func() error {
+		if _, ok := f.keys.Keys[keyID]; !ok {
+			return ErrKeyNotFound
+		}
+		f.keys.DefaultKey = keyID
+		return nil
+	})
+}
+
+// update applies fn to the latest keys on disk and saves the result.
+func (f *FileKMS) update(fn func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.load(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return f.save()
+}
+
+// key returns an enabled key. Callers hold the lock.
+func (f *FileKMS) key(keyID string) (*fileKey, error) {
+	key, ok := f.keys.Keys[keyID]
+	if !ok || len(key.Versions) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	if key.Disabled {
+		return nil, ErrKeyDisabled
+	}
+	return key, nil
+}
+
+// createKey adds a key with a single version. Callers hold the lock.
+func (f *FileKMS) createKey(keyID string) error {
+	if keyID == "" || strings.ContainsAny(keyID, " :") {
+		return fmt.Errorf("invalid kms key id %q", keyID)
+	}
+	material, err := encryption.GenerateKey()
+	if err != nil {
+		return err
+	}
+	f.keys.Keys[keyID] = &fileKey{Versions: []string{base64.StdEncoding.EncodeToString(material)}}
+	return nil
+}
+
+// reload picks up changes made to the key file by another process.
+func (f *FileKMS) reload() {
+	f.mu.RLock()
+	due := time.Since(f.checkedAt) >= reloadInterval
+	f.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checkedAt = time.Now()
+	// Keep serving the keys already loaded when the file is unreadable
+	_ = f.load()
+}
+
+// load reads the key file. Callers hold the lock.
+func (f *FileKMS) load() error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read kms key file: %w", err)
+	}
+	var keys keyFile
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse kms key file: %w", err)
+	}
+	if keys.Keys == nil {
+		keys.Keys = map[string]*fileKey{}
+	}
+	for id, key := range keys.Keys {
+		for _, v := range key.Versions {
+			if _, err := decodeKey(v); err != nil {
+				return fmt.Errorf("kms key %q: %w", id, err)
+			}
+		}
+	}
+	if _, ok := keys.Keys[keys.DefaultKey]; keys.DefaultKey != "" && !ok {
+		return fmt.Errorf("kms default key %q is not defined", keys.DefaultKey)
+	}
+	f.keys = keys
+	f.checkedAt = time.Now()
+	return nil
+}
+
+// save writes the key file atomically. Callers hold the lock.
+func (f *FileKMS) save() error {
+	data, err := json.MarshalIndent(f.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".kms-*")
+	if err != nil {
+		return fmt.Errorf("failed to write kms key file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write kms key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write kms key file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to write kms key file: %w", err)
+	}
+	return nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != encryption.KeySize {
+		return nil, encryption.ErrInvalidKey
+	}
+	return key, nil
+}
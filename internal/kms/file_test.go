@@ -0,0 +1,87 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileKMS(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kms.json")
+
+	k, err := NewFileKMS(path)
+	if err != nil {
+		t.Fatalf("NewFileKMS failed: %v", err)
+	}
+	if k.DefaultKeyID() != DefaultKeyName {
+		t.Fatalf("unexpected default key %q", k.DefaultKeyID())
+	}
+
+	dataKey, ciphertext, err := k.GenerateDataKey(ctx, DefaultKeyName)
+	if err != nil {
+		t.Fatalf("GenerateDataKey failed: %v", err)
+	}
+	got, err := k.Decrypt(ctx, DefaultKeyName, ciphertext)
+	if err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("Decrypt mismatch (err=%v)", err)
+	}
+
+	// Data keys wrapped before a rotation stay readable
+	if err := k.RotateKey(DefaultKeyName); err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	if got, err := k.Decrypt(ctx, DefaultKeyName, ciphertext); err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("Decrypt after rotation failed: %v", err)
+	}
+	_, rotated, _ := k.GenerateDataKey(ctx, DefaultKeyName)
+	if rotated[:3] != "v2:" {
+		t.Fatalf("expected new data keys under version 2, got %q", rotated[:3])
+	}
+
+	if _, _, err := k.GenerateDataKey(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := k.Decrypt(ctx, DefaultKeyName, "v9:"+ciphertext[3:]); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Fatalf("expected ErrInvalidCiphertext, got %v", err)
+	}
+
+	if err := k.SetKeyEnabled(DefaultKeyName, false); err != nil {
+		t.Fatalf("SetKeyEnabled failed: %v", err)
+	}
+	if _, err := k.Decrypt(ctx, DefaultKeyName, ciphertext); !errors.Is(err, ErrKeyDisabled) {
+		t.Fatalf("expected ErrKeyDisabled, got %v", err)
+	}
+}
+
+func TestFileKMS_ReloadsChangesFromOtherProcesses(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kms.json")
+
+	gateway, err := NewFileKMS(path)
+	if err != nil {
+		t.Fatalf("NewFileKMS failed: %v", err)
+	}
+	admin, err := NewFileKMS(path)
+	if err != nil {
+		t.Fatalf("NewFileKMS failed: %v", err)
+	}
+	if err := admin.CreateKey("tenant-a"); err != nil {
+		t.Fatalf("CreateKey failed: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * reloadInterval)
+	for {
+		_, _, err := gateway.GenerateDataKey(ctx, "tenant-a")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("key created by another process not picked up: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,26 @@
+// Package kms provides the key management services backing SSE-KMS.
+//
+// A KMS holds named key-encryption keys and hands out data keys wrapped by
+// them. Objects keep the wrapped data key and the key ID; reading an object
+// asks the KMS to unwrap it again.
+package kms
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrKeyNotFound = errors.New("kms key not found")
+var ErrKeyDisabled = errors.New("kms key is disabled")
+var ErrInvalidCiphertext = errors.New("kms ciphertext is invalid")
+
+// KMS generates and decrypts data keys under named master keys.
+type KMS interface {
+	// DefaultKeyID returns the key used when a request names no key.
+	DefaultKeyID() string
+	// GenerateDataKey returns a new data key in plaintext and wrapped under
+	// the given key.
+	GenerateDataKey(ctx context.Context, keyID string) (plaintext []byte, ciphertext string, err error)
+	// Decrypt unwraps a data key produced by GenerateDataKey.
+	Decrypt(ctx context.Context, keyID string, ciphertext string) ([]byte, error)
+}
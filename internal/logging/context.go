@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the ID of the request it serves.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithContext returns l adding the request ID and trace ID of ctx, if any,
+// to every line.
+func WithContext(ctx context.Context, l log.Logger) log.Logger {
+	if id := RequestID(ctx); id != "" {
+		l = log.With(l, "request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		l = log.With(l, "trace_id", sc.TraceID().String())
+	}
+	return l
+}
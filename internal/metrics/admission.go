@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AdmissionMetrics records the queueing of transfers by admission control,
+// for sizing the concurrency limit and byte budget.
+type AdmissionMetrics struct {
+	queued    prometheus.Gauge
+	wait      *prometheus.HistogramVec
+	transfers prometheus.Gauge
+	bytes     prometheus.Gauge
+}
+
+// NewAdmissionMetrics registers the admission metrics with the registry.
+// Gateways created in the same process share the metrics.
+func NewAdmissionMetrics() (*AdmissionMetrics, error) {
+	m := &AdmissionMetrics{
+		queued: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "admission_queue_depth",
+			Help:      "The number of transfers waiting to start.",
+		}),
+		wait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "admission_wait_seconds",
+			Help:      "The time transfers waited to start, by outcome.",
+			Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"outcome"}),
+		transfers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "admission_transfers",
+			Help:      "The number of transfers running.",
+		}),
+		bytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "admission_bytes",
+			Help:      "The bytes of the byte budget reserved by running transfers.",
+		}),
+	}
+	if err := registry.Register(m); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, err
+		}
+		existing, ok := are.ExistingCollector.(*AdmissionMetrics)
+		if !ok {
+			return nil, err
+		}
+		m = existing
+	}
+	return m, nil
+}
+
+// Describe implements the prometheus.Collector interface.
+func (m *AdmissionMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.queued.Describe(ch)
+	m.wait.Describe(ch)
+	m.transfers.Describe(ch)
+	m.bytes.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (m *AdmissionMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.queued.Collect(ch)
+	m.wait.Collect(ch)
+	m.transfers.Collect(ch)
+	m.bytes.Collect(ch)
+}
+
+// Waiting adds delta to the number of queued transfers.
+func (m *AdmissionMetrics) Waiting(delta int) {
+	m.queued.Add(float64(delta))
+}
+
+// Waited records how long a transfer waited to start and the outcome.
+func (m *AdmissionMetrics) Waited(d time.Duration, outcome string) {
+	m.wait.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// InUse records the transfers running and the bytes they reserved.
+func (m *AdmissionMetrics) InUse(transfers int, bytes int64) {
+	m.transfers.Set(float64(transfers))
+	m.bytes.Set(float64(bytes))
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "nats"
+	subsystem = "s3"
+
+	// OtherBuckets labels the requests of buckets past the bucket label limit.
+	OtherBuckets = "_other"
+)
+
+// RequestMetrics records the requests served by the gateway per S3
+// operation. The bucket label is limited to a number of distinct buckets, so
+// that clients creating many buckets cannot grow the series without bound,
+// and only buckets known to exist take one, so that requests naming
+// arbitrary buckets cannot use up the limit.
+type RequestMetrics struct {
+	*requestVectors
+	buckets *bucketLabels
+}
+
+// requestVectors are the metric vectors of RequestMetrics, shared by the
+// gateways of a process.
+type requestVectors struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	received *prometheus.CounterVec
+	sent     *prometheus.CounterVec
+	inflight *prometheus.GaugeVec
+}
+
+// RequestObservation describes a request once it is served.
+type RequestObservation struct {
+	Operation string
+	Bucket    string
+	// Known reports the bucket exists and the caller was allowed to use it;
+	// the requests of other buckets are labeled OtherBuckets until then
+	Known  bool
+	Status int
+	// ErrorCode is the S3 error code of the response, empty on success
+	ErrorCode     string
+	Duration      time.Duration
+	BytesReceived int64
+	BytesSent     int64
+}
+
+// NewRequestMetrics registers the request metrics with the registry. At most
+// maxBuckets distinct buckets are used as label values, later ones being
+// reported as OtherBuckets; zero leaves the bucket label empty. Gateways
+// created in the same process share the metrics.
+func NewRequestMetrics(maxBuckets int) (*RequestMetrics, error) {
+	vectors := newRequestVectors()
+	if err := registry.Register(vectors); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, err
+		}
+		existing, ok := are.ExistingCollector.(*requestVectors)
+		if !ok {
+			return nil, err
+		}
+		vectors = existing
+	}
+	return &RequestMetrics{
+		requestVectors: vectors,
+		buckets:        &bucketLabels{max: maxBuckets, seen: make(map[string]struct{})},
+	}, nil
+}
+
+func newRequestVectors() *requestVectors {
+	return &requestVectors{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "The total number of S3 requests served.",
+		}, []string{"operation", "bucket", "status", "error_code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "The time taken to serve S3 requests.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+		}, []string{"operation"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_bytes_total",
+			Help:      "The total number of request body bytes received.",
+		}, []string{"operation", "bucket"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "response_bytes_total",
+			Help:      "The total number of response body bytes sent.",
+		}, []string{"operation", "bucket"}),
+		inflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_inflight",
+			Help:      "The number of S3 requests being served.",
+		}, []string{"operation"}),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (v *requestVectors) Describe(ch chan<- *prometheus.Desc) {
+	v.requests.Describe(ch)
+	v.duration.Describe(ch)
+	v.received.Describe(ch)
+	v.sent.Describe(ch)
+	v.inflight.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (v *requestVectors) Collect(ch chan<- prometheus.Metric) {
+	v.requests.Collect(ch)
+	v.duration.Collect(ch)
+	v.received.Collect(ch)
+	v.sent.Collect(ch)
+	v.inflight.Collect(ch)
+}
+
+// Started counts a request of the operation as in flight until the returned
+// function is called.
+func (m *RequestMetrics) Started(operation string) func() {
+	gauge := m.inflight.WithLabelValues(operation)
+	gauge.Inc()
+	return gauge.Dec
+}
+
+// Observe records a served request.
+func (m *RequestMetrics) Observe(o RequestObservation) {
+	bucket := m.buckets.label(o.Bucket, o.Known)
+	m.requests.WithLabelValues(o.Operation, bucket, strconv.Itoa(o.Status), o.ErrorCode).Inc()
+	m.duration.WithLabelValues(o.Operation).Observe(o.Duration.Seconds())
+	m.received.WithLabelValues(o.Operation, bucket).Add(float64(o.BytesReceived))
+	m.sent.WithLabelValues(o.Operation, bucket).Add(float64(o.BytesSent))
+}
+
+// bucketLabels admits the first max distinct known buckets as label values.
+type bucketLabels struct {
+	mu   sync.Mutex
+	max  int
+	seen map[string]struct{}
+}
+
+func (b *bucketLabels) label(bucket string, known bool) string {
+	if bucket == "" || b.max <= 0 {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.seen[bucket]; ok {
+		return bucket
+	}
+	if !known || len(b.seen) >= b.max {
+		return OtherBuckets
+	}
+	b.seen[bucket] = struct{}{}
+	return bucket
+}
@@ -27,6 +27,8 @@ const (
 	ErrInvalidMaxDeleteObjects
 	ErrInvalidPartNumberMarker
 	ErrInvalidPart
+	ErrInvalidPartOrder
+	ErrInvalidPartNumber
 	ErrInvalidRange
 	ErrInternalError
 	ErrInvalidCopyDest
@@ -88,6 +90,7 @@ const (
 	ErrSSECustomerKeyMD5Mismatch
 	ErrSSECustomerKeyMissing
 	ErrSSECustomerKeyNotNeeded
+	ErrSSENotConfigured
 
 	// SSE-KMS related errors
 	ErrKMSKeyNotFound
@@ -97,6 +100,50 @@ const (
 
 	// Bucket encryption errors
 	ErrNoSuchBucketEncryptionConfiguration
+
+	// Compression related errors
+	ErrInvalidCompressionAlgorithm
+
+	// Bucket options errors
+	ErrInvalidBucketOptions
+
+	// Bucket quota errors
+	ErrQuotaExceeded
+	ErrNoSuchBucketQuota
+
+	// Bucket replication errors
+	ErrReplicationConfigurationNotFound
+	ErrInvalidReplicationConfiguration
+
+	// Bucket logging errors
+	ErrInvalidTargetBucketForLogging
+
+	// Rate limit errors
+	ErrSlowDown
+
+	// S3 Select errors
+	ErrInvalidExpressionType
+	ErrObjectSerializationConflict
+	ErrInvalidFileHeaderInfo
+	ErrInvalidJSONType
+	ErrInvalidQuoteFields
+	ErrInvalidCompressionFormat
+	ErrInvalidRequestParameter
+	ErrUnsupportedSyntax
+	ErrUnsupportedFunction
+
+	// ACL errors
+	ErrInvalidACL
+	ErrMalformedACL
+	ErrUnresolvableGrant
+	ErrAccessControlListNotSupported
+	ErrOwnershipControlsNotFound
+	ErrInvalidOwnershipControls
+
+	// Website errors
+	ErrNoSuchWebsiteConfiguration
+	ErrInvalidWebsiteConfiguration
+	ErrInvalidRedirectLocation
 )
 
 // Error message constants for checksum validation
@@ -248,6 +295,18 @@ var errorCodeResponse = map[ErrorCode]APIError{
 		HTTPStatusCode: http.StatusBadRequest,
 	},
 
+	ErrInvalidPartOrder: {
+		Code:           "InvalidPartOrder",
+		Description:    "The list of parts was not in ascending order. The parts list must be specified in order by part number.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+
+	ErrInvalidPartNumber: {
+		Code:           "InvalidPartNumber",
+		Description:    "The requested partnumber is not satisfiable",
+		HTTPStatusCode: http.StatusRequestedRangeNotSatisfiable,
+	},
+
 	ErrInvalidCopyDest: {
 		Code:           "InvalidRequest",
 		Description:    "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.",
@@ -506,6 +565,11 @@ var errorCodeResponse = map[ErrorCode]APIError{
 		Description:    "The object was not encrypted with customer provided keys.",
 		HTTPStatusCode: http.StatusBadRequest,
 	},
+	ErrSSENotConfigured: {
+		Code:           "InvalidArgument",
+		Description:    "Server side encryption with gateway managed keys is not configured.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 
 	// SSE-KMS error responses
 	ErrKMSKeyNotFound: {
@@ -535,4 +599,146 @@ var errorCodeResponse = map[ErrorCode]APIError{
 		Description:    "The server side encryption configuration was not found.",
 		HTTPStatusCode: http.StatusNotFound,
 	},
+
+	// Compression error responses
+	ErrInvalidCompressionAlgorithm: {
+		Code:           "InvalidArgument",
+		Description:    "The compression algorithm specified is not supported.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+
+	// Bucket options error responses
+	ErrInvalidBucketOptions: {
+		Code:           "InvalidArgument",
+		Description:    "The bucket options specified are invalid or cannot be satisfied.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+
+	// Bucket quota error responses
+	ErrQuotaExceeded: {
+		Code:           "QuotaExceeded",
+		Description:    "The bucket quota has been exceeded.",
+		HTTPStatusCode: http.StatusForbidden,
+	},
+	ErrNoSuchBucketQuota: {
+		Code:           "NoSuchBucketQuota",
+		Description:    "The bucket has no quota configured.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrReplicationConfigurationNotFound: {
+		Code:           "ReplicationConfigurationNotFoundError",
+		Description:    "The replication configuration was not found.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrInvalidReplicationConfiguration: {
+		Code:           "InvalidRequest",
+		Description:    "The replication configuration is not supported.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidTargetBucketForLogging: {
+		Code:           "InvalidTargetBucketForLogging",
+		Description:    "The target bucket for logging does not exist.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrSlowDown: {
+		Code:           "SlowDown",
+		Description:    "Please reduce your request rate.",
+		HTTPStatusCode: http.StatusServiceUnavailable,
+	},
+
+	// S3 Select error responses
+	ErrInvalidExpressionType: {
+		Code:           "InvalidExpressionType",
+		Description:    "The ExpressionType is invalid. Only SQL expressions are supported.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrObjectSerializationConflict: {
+		Code:           "ObjectSerializationConflict",
+		Description:    "The input or output serialization must specify exactly one format.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidFileHeaderInfo: {
+		Code:           "InvalidFileHeaderInfo",
+		Description:    "The FileHeaderInfo is invalid. Only NONE, USE, and IGNORE are supported.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidJSONType: {
+		Code:           "InvalidJsonType",
+		Description:    "The JsonType is invalid. Only DOCUMENT and LINES are supported.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidQuoteFields: {
+		Code:           "InvalidQuoteFields",
+		Description:    "The QuoteFields is invalid. Only ALWAYS and ASNEEDED are supported.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidCompressionFormat: {
+		Code:           "InvalidCompressionFormat",
+		Description:    "The file is not in a supported compression format. Only GZIP and BZIP2 are supported.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidRequestParameter: {
+		Code:           "InvalidRequestParameter",
+		Description:    "The value of a parameter in the SelectRequest element is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsupportedSyntax: {
+		Code:           "UnsupportedSyntax",
+		Description:    "The SQL expression contains invalid or unsupported syntax.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnsupportedFunction: {
+		Code:           "UnsupportedFunction",
+		Description:    "The SQL expression contains an unsupported function.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+
+	// ACL error responses
+	ErrInvalidACL: {
+		Code:           "InvalidArgument",
+		Description:    "The ACL is invalid. Set either a known canned ACL or x-amz-grant-* headers, not both.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrMalformedACL: {
+		Code:           "MalformedACLError",
+		Description:    "The XML you provided was not well-formed or did not validate against our published schema.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrUnresolvableGrant: {
+		Code:           "UnresolvableGrantByEmailAddress",
+		Description:    "The grantee could not be resolved. Grant permissions to an access key ID or a group.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrAccessControlListNotSupported: {
+		Code:           "AccessControlListNotSupported",
+		Description:    "The bucket does not allow ACLs.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrOwnershipControlsNotFound: {
+		Code:           "OwnershipControlsNotFoundError",
+		Description:    "The bucket ownership controls were not found.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrInvalidOwnershipControls: {
+		Code:           "InvalidRequest",
+		Description:    "The ownership controls must hold one rule with an ObjectOwnership of BucketOwnerEnforced, BucketOwnerPreferred or ObjectWriter.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+
+	// Website error responses
+	ErrNoSuchWebsiteConfiguration: {
+		Code:           "NoSuchWebsiteConfiguration",
+		Description:    "The specified bucket does not have a website configuration.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrInvalidWebsiteConfiguration: {
+		Code:           "InvalidArgument",
+		Description:    "The website configuration is invalid.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrInvalidRedirectLocation: {
+		Code:           "InvalidRedirectLocation",
+		Description:    "The website redirect location must have a prefix of 'http://' or 'https://' or '/'.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 }
@@ -6,10 +6,15 @@ package model
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gorilla/mux"
+	"html"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -24,6 +29,12 @@ const (
 	MimeXML  mimeType = "application/xml"
 )
 
+// Headers identifying a request and the gateway that served it.
+const (
+	RequestIDHeader = "x-amz-request-id"
+	HostIDHeader    = "x-amz-id-2"
+)
+
 // RESTErrorResponse - error response format
 type RESTErrorResponse struct {
 	XMLName    xml.Name `xml:"Error" json:"-"`
@@ -31,6 +42,7 @@ type RESTErrorResponse struct {
 	Message    string   `xml:"Message" json:"Message"`
 	Resource   string   `xml:"Resource" json:"Resource"`
 	RequestID  string   `xml:"RequestId" json:"RequestId"`
+	HostID     string   `xml:"HostId,omitempty" json:"HostId,omitempty"`
 	Key        string   `xml:"Key,omitempty" json:"Key,omitempty"`
 	BucketName string   `xml:"BucketName,omitempty" json:"BucketName,omitempty"`
 
@@ -47,10 +59,28 @@ func NewRESTErrorResponse(err APIError, resource string, bucket, object string)
 		Key:        object,
 		Message:    err.Description,
 		Resource:   resource,
-		RequestID:  fmt.Sprintf("%d", time.Now().UnixNano()),
 	}
 }
 
+// NewRequestID returns a new request ID, 16 upper case hex characters like
+// those of S3.
+func NewRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
+
+// requestIDs returns the request and host IDs of a response, generating a
+// request ID unless a middleware assigned one.
+func requestIDs(w http.ResponseWriter) (string, string) {
+	id := w.Header().Get(RequestIDHeader)
+	if id == "" {
+		id = NewRequestID()
+		w.Header().Set(RequestIDHeader, id)
+	}
+	return id, w.Header().Get(HostIDHeader)
+}
+
 // SetEtag sets the ETag response header. If the provided value is unquoted,
 // quotes are added to match S3 behavior.
 func SetEtag(w http.ResponseWriter, etag string) {
@@ -129,12 +159,20 @@ type Checksum struct {
 
 // GetObjectAttributesParts contains multipart information
 type GetObjectAttributesParts struct {
-	IsTruncated          *bool  `xml:"IsTruncated,omitempty"`
-	MaxParts             *int64 `xml:"MaxParts,omitempty"`
-	NextPartNumberMarker *int64 `xml:"NextPartNumberMarker,omitempty"`
-	PartNumberMarker     *int64 `xml:"PartNumberMarker,omitempty"`
-	PartsCount           *int64 `xml:"PartsCount,omitempty"`
-	TotalPartsCount      *int64 `xml:"TotalPartsCount,omitempty"`
+	IsTruncated          *bool        `xml:"IsTruncated,omitempty"`
+	MaxParts             *int64       `xml:"MaxParts,omitempty"`
+	NextPartNumberMarker *int64       `xml:"NextPartNumberMarker,omitempty"`
+	PartNumberMarker     *int64       `xml:"PartNumberMarker,omitempty"`
+	PartsCount           *int64       `xml:"PartsCount,omitempty"`
+	TotalPartsCount      *int64       `xml:"TotalPartsCount,omitempty"`
+	Parts                []ObjectPart `xml:"Part,omitempty"`
+}
+
+// ObjectPart describes a single part of a multipart object in GetObjectAttributes
+type ObjectPart struct {
+	PartNumber    int64   `xml:"PartNumber"`
+	Size          int64   `xml:"Size"`
+	ChecksumCRC32 *string `xml:"ChecksumCRC32,omitempty"`
 }
 
 // WriteXMLResponse encodes the response as XML and writes it with the given
@@ -160,11 +198,11 @@ func EncodeXMLResponse(response interface{}) []byte {
 	return bytesBuffer.Bytes()
 }
 
-// setCommonHeaders sets shared S3-style headers, including a generated
-// x-amz-request-id and Accept-Ranges. Also configures permissive CORS if
-// the request includes an Origin header.
+// setCommonHeaders sets shared S3-style headers, including x-amz-request-id
+// and Accept-Ranges. Also configures permissive CORS if the request includes
+// an Origin header.
 func setCommonHeaders(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("x-amz-request-id", fmt.Sprintf("%d", time.Now().UnixNano()))
+	requestIDs(w)
 	w.Header().Set("Accept-Ranges", "bytes")
 	if r.Header.Get("Origin") != "" {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -177,16 +215,121 @@ func setCommonHeaders(w http.ResponseWriter, r *http.Request) {
 func WriteErrorResponse(w http.ResponseWriter, r *http.Request, errorCode ErrorCode) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
-	object := vars["object"]
+	object := vars["key"]
 	if strings.HasPrefix(object, "/") {
 		object = object[1:]
 	}
 
 	apiError := GetAPIError(errorCode)
+	recordErrorCode(r, apiError.Code)
 	errorResponse := NewRESTErrorResponse(apiError, r.URL.Path, bucket, object)
+	errorResponse.RequestID, errorResponse.HostID = requestIDs(w)
 	WriteXMLResponse(w, r, apiError.HTTPStatusCode, errorResponse)
 }
 
+// WriteHTMLErrorResponse writes the error of a website request as an HTML
+// page, as browsers display it.
+func WriteHTMLErrorResponse(w http.ResponseWriter, r *http.Request, errorCode ErrorCode) {
+	apiError := GetAPIError(errorCode)
+	recordErrorCode(r, apiError.Code)
+	requestID, hostID := requestIDs(w)
+	status := fmt.Sprintf("%d %s", apiError.HTTPStatusCode, http.StatusText(apiError.HTTPStatusCode))
+
+	var body strings.Builder
+	body.WriteString("<html>\n<head><title>" + status + "</title></head>\n<body>\n<h1>" + status + "</h1>\n<ul>\n")
+	for _, item := range [][2]string{
+		{"Code", apiError.Code},
+		{"Message", apiError.Description},
+		{"RequestId", requestID},
+		{"HostId", hostID},
+	} {
+		body.WriteString("<li>" + item[0] + ": " + html.EscapeString(item[1]) + "</li>\n")
+	}
+	body.WriteString("</ul>\n<hr/>\n</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(apiError.HTTPStatusCode)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.WriteString(w, body.String()); err != nil {
+		log.Printf("Error writing the response, %s", err)
+	}
+}
+
+// ResponseError holds the S3 error code written in response to a request,
+// for middleware reporting on requests once they are served.
+type ResponseError struct {
+	Code string
+}
+
+type responseErrorKey struct{}
+
+// WithResponseError returns a context in which the error responses written
+// for a request record their code in re.
+func WithResponseError(ctx context.Context, re *ResponseError) context.Context {
+	return context.WithValue(ctx, responseErrorKey{}, re)
+}
+
+// ResponseErrorFrom returns the ResponseError of a context, if any.
+func ResponseErrorFrom(ctx context.Context) (*ResponseError, bool) {
+	re, ok := ctx.Value(responseErrorKey{}).(*ResponseError)
+	return re, ok
+}
+
+// recordErrorCode records the error code in the ResponseError of the
+// request, if any.
+func recordErrorCode(r *http.Request, code string) {
+	if re, ok := ResponseErrorFrom(r.Context()); ok {
+		re.Code = code
+	}
+}
+
+// StartXMLResponse commits a 200 OK XML response whose body is written later,
+// sending the headers and the XML declaration right away. It is used by
+// long-running operations that keep the connection alive with whitespace
+// before writing the result with WriteXMLBody or WriteErrorBody.
+func StartXMLResponse(w http.ResponseWriter, r *http.Request) {
+	setCommonHeaders(w, r)
+	w.Header().Set("Content-Type", string(MimeXML))
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(xml.Header))
+	if err != nil {
+		log.Printf("Error writing the response, %s", err)
+		return
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// WriteXMLBody writes the XML encoding of the response, without the XML
+// declaration, to a response started with StartXMLResponse.
+func WriteXMLBody(w http.ResponseWriter, response interface{}) {
+	body := bytes.TrimPrefix(EncodeXMLResponse(response), []byte(xml.Header))
+	_, err := w.Write(body)
+	if err != nil {
+		log.Printf("Error writing the response, %s", err)
+		return
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// WriteErrorBody writes an S3 XML error as the body of a response started
+// with StartXMLResponse. The status code stays 200, as with S3.
+func WriteErrorBody(w http.ResponseWriter, r *http.Request, errorCode ErrorCode) {
+	vars := mux.Vars(r)
+	apiError := GetAPIError(errorCode)
+	recordErrorCode(r, apiError.Code)
+	object := strings.TrimPrefix(vars["key"], "/")
+	errorResponse := NewRESTErrorResponse(apiError, r.URL.Path, vars["bucket"], object)
+	errorResponse.RequestID, errorResponse.HostID = requestIDs(w)
+	WriteXMLBody(w, errorResponse)
+}
+
 // ObjectRetention represents object retention configuration
 type ObjectRetention struct {
 	XMLName         xml.Name `xml:"Retention"`
@@ -201,6 +344,328 @@ type ObjectRetentionResponse struct {
 	RetainUntilDate string   `xml:"RetainUntilDate"`
 }
 
+// ServerSideEncryptionConfiguration represents the default encryption of a
+// bucket, as used by the ?encryption subresource.
+type ServerSideEncryptionConfiguration struct {
+	XMLName xml.Name                   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ServerSideEncryptionConfiguration"`
+	Rules   []ServerSideEncryptionRule `xml:"Rule"`
+}
+
+// ServerSideEncryptionRule is a single default encryption rule.
+type ServerSideEncryptionRule struct {
+	ApplyServerSideEncryptionByDefault ServerSideEncryptionByDefault `xml:"ApplyServerSideEncryptionByDefault"`
+}
+
+// ServerSideEncryptionByDefault names the algorithm applied to new objects.
+type ServerSideEncryptionByDefault struct {
+	SSEAlgorithm   string `xml:"SSEAlgorithm"`
+	KMSMasterKeyID string `xml:"KMSMasterKeyID,omitempty"`
+}
+
+// CreateBucketConfiguration is the optional body of CreateBucket. Besides
+// the S3 LocationConstraint it carries the JetStream settings of the bucket.
+type CreateBucketConfiguration struct {
+	XMLName            xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CreateBucketConfiguration"`
+	LocationConstraint string           `xml:"LocationConstraint,omitempty"`
+	Storage            string           `xml:"Storage,omitempty"`
+	Replicas           int              `xml:"Replicas,omitempty"`
+	MaxBytes           int64            `xml:"MaxBytes,omitempty"`
+	TTL                string           `xml:"TTL,omitempty"`
+	StreamCompression  bool             `xml:"StreamCompression,omitempty"`
+	Description        string           `xml:"Description,omitempty"`
+	Placement          *BucketPlacement `xml:"Placement,omitempty"`
+}
+
+// BucketPlacement selects the JetStream cluster and server tags of a bucket.
+type BucketPlacement struct {
+	Cluster string   `xml:"Cluster,omitempty"`
+	Tags    []string `xml:"Tags>Tag,omitempty"`
+}
+
+// BucketInfo is the response of the ?info subresource, describing the
+// JetStream object store backing a bucket.
+type BucketInfo struct {
+	XMLName           xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ BucketInfo"`
+	Name              string           `xml:"Name"`
+	Storage           string           `xml:"Storage"`
+	Replicas          int              `xml:"Replicas"`
+	MaxBytes          int64            `xml:"MaxBytes"`
+	TTL               string           `xml:"TTL"`
+	StreamCompression bool             `xml:"StreamCompression"`
+	Description       string           `xml:"Description,omitempty"`
+	Placement         *BucketPlacement `xml:"Placement,omitempty"`
+	Size              uint64           `xml:"Size"`
+	Sealed            bool             `xml:"Sealed"`
+}
+
+// BucketQuota is the quota of a bucket, as used by the ?quota subresource.
+// Zero limits are disabled. Usage is only reported by GET.
+type BucketQuota struct {
+	XMLName     xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ BucketQuota"`
+	HardBytes   int64        `xml:"HardBytes,omitempty"`
+	SoftBytes   int64        `xml:"SoftBytes,omitempty"`
+	HardObjects int64        `xml:"HardObjects,omitempty"`
+	SoftObjects int64        `xml:"SoftObjects,omitempty"`
+	Usage       *BucketUsage `xml:"Usage,omitempty"`
+}
+
+// BucketUsage is the current size and object count of a bucket.
+type BucketUsage struct {
+	Bytes             int64 `xml:"Bytes"`
+	Objects           int64 `xml:"Objects"`
+	SoftLimitExceeded bool  `xml:"SoftLimitExceeded"`
+}
+
+// ReplicationConfiguration is the replication of a bucket, as used by the
+// ?replication subresource. nats-s3 supports a single enabled rule covering
+// the whole bucket.
+type ReplicationConfiguration struct {
+	XMLName xml.Name          `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ReplicationConfiguration"`
+	Role    string            `xml:"Role,omitempty"`
+	Rules   []ReplicationRule `xml:"Rule"`
+}
+
+// ReplicationRule replicates the objects of a bucket into a destination.
+type ReplicationRule struct {
+	ID          string                 `xml:"ID,omitempty"`
+	Status      string                 `xml:"Status"`
+	Priority    int                    `xml:"Priority,omitempty"`
+	Prefix      string                 `xml:"Prefix,omitempty"`
+	Filter      *ReplicationFilter     `xml:"Filter,omitempty"`
+	Destination ReplicationDestination `xml:"Destination"`
+}
+
+// ReplicationFilter selects the objects a rule replicates.
+type ReplicationFilter struct {
+	Prefix string `xml:"Prefix,omitempty"`
+}
+
+// ReplicationDestination is the bucket a rule replicates into. Domain,
+// Cluster and ReadFailover are nats-s3 extensions locating the bucket in
+// JetStream and serving reads from it while the source is unavailable.
+type ReplicationDestination struct {
+	Bucket       string `xml:"Bucket"`
+	Domain       string `xml:"Domain,omitempty"`
+	Cluster      string `xml:"Cluster,omitempty"`
+	ReadFailover bool   `xml:"ReadFailover,omitempty"`
+}
+
+// BucketLoggingStatus is the access logging of a bucket, as used by the
+// ?logging subresource. Logging is disabled without LoggingEnabled.
+type BucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ BucketLoggingStatus"`
+	LoggingEnabled *LoggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+// LoggingEnabled names the bucket and key prefix access logs are written to.
+type LoggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
+// SelectObjectContentRequest is the body of a SelectObjectContent request,
+// querying an object with SQL.
+type SelectObjectContentRequest struct {
+	XMLName             xml.Name            `xml:"http://s3.amazonaws.com/doc/2006-03-01/ SelectObjectContentRequest"`
+	Expression          string              `xml:"Expression"`
+	ExpressionType      string              `xml:"ExpressionType"`
+	RequestProgress     *RequestProgress    `xml:"RequestProgress,omitempty"`
+	InputSerialization  InputSerialization  `xml:"InputSerialization"`
+	OutputSerialization OutputSerialization `xml:"OutputSerialization"`
+	ScanRange           *ScanRange          `xml:"ScanRange,omitempty"`
+}
+
+// RequestProgress enables the Progress events of a SelectObjectContent
+// response.
+type RequestProgress struct {
+	Enabled bool `xml:"Enabled"`
+}
+
+// InputSerialization describes the format of the object queried.
+type InputSerialization struct {
+	CompressionType string        `xml:"CompressionType,omitempty"`
+	CSV             *CSVInput     `xml:"CSV,omitempty"`
+	JSON            *JSONInput    `xml:"JSON,omitempty"`
+	Parquet         *ParquetInput `xml:"Parquet,omitempty"`
+}
+
+// CSVInput describes CSV objects.
+type CSVInput struct {
+	AllowQuotedRecordDelimiter bool   `xml:"AllowQuotedRecordDelimiter,omitempty"`
+	Comments                   string `xml:"Comments,omitempty"`
+	FieldDelimiter             string `xml:"FieldDelimiter,omitempty"`
+	FileHeaderInfo             string `xml:"FileHeaderInfo,omitempty"`
+	QuoteCharacter             string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter       string `xml:"QuoteEscapeCharacter,omitempty"`
+	RecordDelimiter            string `xml:"RecordDelimiter,omitempty"`
+}
+
+// JSONInput describes JSON objects, a DOCUMENT or LINES.
+type JSONInput struct {
+	Type string `xml:"Type,omitempty"`
+}
+
+// ParquetInput describes Parquet objects. It has no options.
+type ParquetInput struct{}
+
+// OutputSerialization describes the format of the query results.
+type OutputSerialization struct {
+	CSV  *CSVOutput  `xml:"CSV,omitempty"`
+	JSON *JSONOutput `xml:"JSON,omitempty"`
+}
+
+// CSVOutput describes CSV results.
+type CSVOutput struct {
+	FieldDelimiter       string `xml:"FieldDelimiter,omitempty"`
+	QuoteCharacter       string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter,omitempty"`
+	QuoteFields          string `xml:"QuoteFields,omitempty"`
+	RecordDelimiter      string `xml:"RecordDelimiter,omitempty"`
+}
+
+// JSONOutput describes JSON results.
+type JSONOutput struct {
+	RecordDelimiter string `xml:"RecordDelimiter,omitempty"`
+}
+
+// ScanRange limits a query to the records starting within a byte range of
+// the object. Without Start, the range is the last End bytes.
+type ScanRange struct {
+	Start *int64 `xml:"Start,omitempty"`
+	End   *int64 `xml:"End,omitempty"`
+}
+
+// SelectStats is the payload of the Stats and Progress events of a
+// SelectObjectContent response.
+type SelectStats struct {
+	XMLName        xml.Name
+	BytesScanned   int64 `xml:"BytesScanned"`
+	BytesProcessed int64 `xml:"BytesProcessed"`
+	BytesReturned  int64 `xml:"BytesReturned"`
+}
+
+// AccessControlPolicy is the access control list of a bucket or object, as
+// returned by GetBucketAcl and GetObjectAcl and accepted by their Put
+// counterparts.
+type AccessControlPolicy struct {
+	XMLName           xml.Name          `xml:"http://s3.amazonaws.com/doc/2006-03-01/ AccessControlPolicy"`
+	Owner             Owner             `xml:"Owner"`
+	AccessControlList AccessControlList `xml:"AccessControlList"`
+}
+
+// Owner is the owner of a bucket or object.
+type Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+}
+
+// AccessControlList holds the grants of an AccessControlPolicy.
+type AccessControlList struct {
+	Grants []Grant `xml:"Grant"`
+}
+
+// Grant gives a permission to a grantee.
+type Grant struct {
+	Grantee    Grantee `xml:"Grantee"`
+	Permission string  `xml:"Permission"`
+}
+
+// XSINamespace qualifies the type attribute of grantees.
+const XSINamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// Grantee is the user or group of a grant. Type is CanonicalUser, Group or
+// AmazonCustomerByEmail.
+type Grantee struct {
+	XMLNSXSI     string `xml:"xmlns:xsi,attr"`
+	Type         string `xml:"xsi:type,attr"`
+	ID           string `xml:"ID,omitempty"`
+	DisplayName  string `xml:"DisplayName,omitempty"`
+	URI          string `xml:"URI,omitempty"`
+	EmailAddress string `xml:"EmailAddress,omitempty"`
+}
+
+// UnmarshalXML decodes a grantee, whose xsi:type attribute the field tags
+// used to encode it do not match.
+func (g *Grantee) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v struct {
+		Type         string `xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
+		ID           string `xml:"ID"`
+		DisplayName  string `xml:"DisplayName"`
+		URI          string `xml:"URI"`
+		EmailAddress string `xml:"EmailAddress"`
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	*g = Grantee{
+		XMLNSXSI:     XSINamespace,
+		Type:         v.Type,
+		ID:           v.ID,
+		DisplayName:  v.DisplayName,
+		URI:          v.URI,
+		EmailAddress: v.EmailAddress,
+	}
+	return nil
+}
+
+// OwnershipControls is the object ownership of a bucket.
+type OwnershipControls struct {
+	XMLName xml.Name                `xml:"http://s3.amazonaws.com/doc/2006-03-01/ OwnershipControls"`
+	Rules   []OwnershipControlsRule `xml:"Rule"`
+}
+
+// OwnershipControlsRule holds the ObjectOwnership of a bucket.
+type OwnershipControlsRule struct {
+	ObjectOwnership string `xml:"ObjectOwnership"`
+}
+
+// WebsiteConfiguration is the static website hosting of a bucket.
+type WebsiteConfiguration struct {
+	XMLName               xml.Name               `xml:"http://s3.amazonaws.com/doc/2006-03-01/ WebsiteConfiguration"`
+	RedirectAllRequestsTo *RedirectAllRequestsTo `xml:"RedirectAllRequestsTo,omitempty"`
+	IndexDocument         *IndexDocument         `xml:"IndexDocument,omitempty"`
+	ErrorDocument         *ErrorDocument         `xml:"ErrorDocument,omitempty"`
+	RoutingRules          []RoutingRule          `xml:"RoutingRules>RoutingRule,omitempty"`
+}
+
+// RedirectAllRequestsTo redirects every request of a website to another host.
+type RedirectAllRequestsTo struct {
+	HostName string `xml:"HostName"`
+	Protocol string `xml:"Protocol,omitempty"`
+}
+
+// IndexDocument is the suffix appended to requests for a directory.
+type IndexDocument struct {
+	Suffix string `xml:"Suffix"`
+}
+
+// ErrorDocument is the object returned when a website request fails.
+type ErrorDocument struct {
+	Key string `xml:"Key"`
+}
+
+// RoutingRule redirects the website requests matching its condition.
+type RoutingRule struct {
+	Condition *RoutingRuleCondition `xml:"Condition,omitempty"`
+	Redirect  RoutingRuleRedirect   `xml:"Redirect"`
+}
+
+// RoutingRuleCondition matches website requests by key prefix and by the
+// error they fail with.
+type RoutingRuleCondition struct {
+	KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+	HttpErrorCodeReturnedEquals string `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+}
+
+// RoutingRuleRedirect is where a routing rule redirects a request.
+type RoutingRuleRedirect struct {
+	HostName             string `xml:"HostName,omitempty"`
+	HttpRedirectCode     string `xml:"HttpRedirectCode,omitempty"`
+	Protocol             string `xml:"Protocol,omitempty"`
+	ReplaceKeyPrefixWith string `xml:"ReplaceKeyPrefixWith,omitempty"`
+	ReplaceKeyWith       string `xml:"ReplaceKeyWith,omitempty"`
+}
+
 // Tagging represents the root XML element for tagging operations
 type Tagging struct {
 	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ Tagging"`
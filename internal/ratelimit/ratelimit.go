@@ -0,0 +1,222 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+)
+
+// Operation classes limited separately.
+const (
+	ClassRead  = "read"
+	ClassWrite = "write"
+	ClassList  = "list"
+)
+
+// Rule limits the requests matching its access key, bucket and class, an
+// empty field matching any. Token buckets are kept per access key, bucket
+// and class, so a rule without an access key limits each access key on its
+// own.
+type Rule struct {
+	AccessKey string
+	Bucket    string
+	Class     string
+	// RequestsPerSecond is the sustained request rate; zero disables it
+	RequestsPerSecond float64
+	// Burst is the number of requests allowed at once; zero allows one
+	// second's worth
+	Burst int
+	// BytesPerSecond is the sustained bandwidth, counting request and
+	// response bodies; zero disables it
+	BytesPerSecond int64
+}
+
+// Validate reports whether the rule is usable.
+func (r *Rule) Validate() error {
+	switch r.Class {
+	case "", ClassRead, ClassWrite, ClassList:
+	default:
+		return fmt.Errorf("class must be %s, %s or %s, got %q", ClassRead, ClassWrite, ClassList, r.Class)
+	}
+	if r.RequestsPerSecond < 0 || r.Burst < 0 || r.BytesPerSecond < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if r.RequestsPerSecond == 0 && r.BytesPerSecond == 0 {
+		return errors.New("requestsPerSecond or bytesPerSecond is required")
+	}
+	return nil
+}
+
+func (r *Rule) matches(req Request) bool {
+	return (r.AccessKey == "" || r.AccessKey == req.AccessKey) &&
+		(r.Bucket == "" || r.Bucket == req.Bucket) &&
+		(r.Class == "" || r.Class == req.Class)
+}
+
+// requests returns the token bucket of the request rate.
+func (r *Rule) requests() Bucket {
+	burst := float64(r.Burst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(r.RequestsPerSecond))
+	}
+	return Bucket{Rate: r.RequestsPerSecond, Burst: burst}
+}
+
+// bytes returns the token bucket of the bandwidth, holding one second's
+// worth of bytes.
+func (r *Rule) bytes() Bucket {
+	return Bucket{Rate: float64(r.BytesPerSecond), Burst: float64(r.BytesPerSecond)}
+}
+
+// Request identifies a request being limited.
+type Request struct {
+	AccessKey string
+	Bucket    string
+	Class     string
+}
+
+// Class returns the class of an S3 operation such as GetObject or
+// ListObjectsV2.
+func Class(operation string) string {
+	switch {
+	case strings.HasPrefix(operation, "List"):
+		return ClassList
+	case strings.HasPrefix(operation, "Get"), strings.HasPrefix(operation, "Head"),
+		strings.HasPrefix(operation, "Options"), operation == "SelectObjectContent":
+		return ClassRead
+	}
+	return ClassWrite
+}
+
+// Limiter enforces rate limit rules.
+type Limiter struct {
+	logger log.Logger
+	rules  []Rule
+	state  State
+}
+
+// NewLimiter returns a Limiter enforcing rules with the token buckets of
+// state; nil keeps them in memory.
+func NewLimiter(logger log.Logger, rules []Rule, state State) *Limiter {
+	if state == nil {
+		state = NewLocalState()
+	}
+	return &Limiter{logger: logger, rules: rules, state: state}
+}
+
+// Enabled reports whether the limiter has rules to enforce.
+func (l *Limiter) Enabled() bool {
+	return l != nil && len(l.rules) > 0
+}
+
+// Allow reports whether a request may proceed. A request is refused while a
+// matching rule has no request tokens left or its bandwidth is overdrawn,
+// and the request tokens earlier rules took for it are given back.
+// The returned function charges the bytes the allowed request transferred.
+// Errors of the state are logged and let the request proceed.
+func (l *Limiter) Allow(ctx context.Context, req Request) (func(bytes int64), bool) {
+	var taken, charged []int
+	for i := range l.rules {
+		rule := &l.rules[i]
+		if !rule.matches(req) {
+			continue
+		}
+		if rule.RequestsPerSecond > 0 {
+			if !l.take(ctx, key(i, req, "requests"), rule.requests(), 1) {
+				l.refund(ctx, req, taken)
+				return nil, false
+			}
+			taken = append(taken, i)
+		}
+		if rule.BytesPerSecond > 0 {
+			if !l.take(ctx, key(i, req, "bytes"), rule.bytes(), 0) {
+				l.refund(ctx, req, taken)
+				return nil, false
+			}
+			charged = append(charged, i)
+		}
+	}
+	return func(bytes int64) {
+		if bytes <= 0 {
+			return
+		}
+		ctx := context.WithoutCancel(ctx)
+		for _, i := range charged {
+			if err := l.state.Charge(ctx, key(i, req, "bytes"), l.rules[i].bytes(), float64(bytes)); err != nil {
+				logging.Warn(logging.WithContext(ctx, l.logger), "msg", "Failed to charge rate limit", "err", err)
+			}
+		}
+	}, true
+}
+
+func (l *Limiter) take(ctx context.Context, key string, b Bucket, n float64) bool {
+	ok, err := l.state.Take(ctx, key, b, n)
+	if err != nil {
+		logging.Warn(logging.WithContext(ctx, l.logger), "msg", "Failed to check rate limit", "err", err)
+		return true
+	}
+	return ok
+}
+
+// refund gives back the request tokens the rules taken took for a refused
+// request.
+func (l *Limiter) refund(ctx context.Context, req Request, taken []int) {
+	for _, i := range taken {
+		if err := l.state.Charge(ctx, key(i, req, "requests"), l.rules[i].requests(), -1); err != nil {
+			logging.Warn(logging.WithContext(ctx, l.logger), "msg", "Failed to refund rate limit", "err", err)
+		}
+	}
+}
+
+// key names the token bucket of a rule for a request.
+func key(rule int, req Request, kind string) string {
+	return fmt.Sprintf("%d\x00%s\x00%s\x00%s\x00%s", rule, req.AccessKey, req.Bucket, req.Class, kind)
+}
+
+// Bucket is the refill rate per second and capacity of a token bucket.
+type Bucket struct {
+	Rate  float64
+	Burst float64
+}
+
+// State holds the token buckets of a Limiter.
+type State interface {
+	// Take removes n tokens from a bucket if it holds at least n, and at
+	// least one when n is zero, reporting whether it did.
+	Take(ctx context.Context, key string, b Bucket, n float64) (bool, error)
+	// Charge removes n tokens from a bucket, letting it go into debt.
+	Charge(ctx context.Context, key string, b Bucket, n float64) error
+}
+
+// tokens is the content of a token bucket.
+type tokens struct {
+	Tokens float64 `json:"tokens"`
+	// At is when the bucket was last refilled, in Unix nanoseconds
+	At int64 `json:"at"`
+}
+
+// refill adds the tokens accrued since the last refill. A new bucket starts
+// full.
+func (t *tokens) refill(now time.Time, b Bucket) {
+	if t.At == 0 {
+		t.Tokens = b.Burst
+	} else if elapsed := now.Sub(time.Unix(0, t.At)).Seconds(); elapsed > 0 {
+		t.Tokens = math.Min(b.Burst, t.Tokens+elapsed*b.Rate)
+	}
+	t.At = now.UnixNano()
+}
+
+// take removes n tokens if the bucket holds them.
+func (t *tokens) take(n float64) bool {
+	if t.Tokens < n || t.Tokens <= 0 {
+		return false
+	}
+	t.Tokens -= n
+	return true
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestClass(t *testing.T) {
+	for op, want := range map[string]string{
+		"GetObject":           ClassRead,
+		"HeadBucket":          ClassRead,
+		"SelectObjectContent": ClassRead,
+		"ListObjectsV2":       ClassList,
+		"ListBuckets":         ClassList,
+		"PutObject":           ClassWrite,
+		"DeleteObjects":       ClassWrite,
+		"UploadPartCopy":      ClassWrite,
+	} {
+		if got := Class(op); got != want {
+			t.Errorf("Class(%s) = %s, want %s", op, got, want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	kv, err := NewKVState(context.Background(), js, 1)
+	if err != nil {
+		t.Fatalf("kv state: %v", err)
+	}
+
+	for name, state := range map[string]State{"local": NewLocalState(), "kv": kv} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			l := NewLimiter(logging.NewLogger(logging.Config{Level: "error"}), []Rule{
+				{Class: ClassWrite, RequestsPerSecond: 0.001, Burst: 2},
+				{Bucket: "media", BytesPerSecond: 100},
+			}, state)
+
+			alice := Request{AccessKey: "alice-" + name, Bucket: "docs", Class: ClassWrite}
+			for i := 0; i < 2; i++ {
+				if _, ok := l.Allow(ctx, alice); !ok {
+					t.Fatalf("request %d refused within the burst", i)
+				}
+			}
+			if _, ok := l.Allow(ctx, alice); ok {
+				t.Fatal("request allowed past the burst")
+			}
+
+			// Limits apply per access key and class
+			if _, ok := l.Allow(ctx, Request{AccessKey: "bob-" + name, Bucket: "docs", Class: ClassWrite}); !ok {
+				t.Error("request of another access key refused")
+			}
+			if _, ok := l.Allow(ctx, Request{AccessKey: alice.AccessKey, Bucket: "docs", Class: ClassRead}); !ok {
+				t.Error("request of another class refused")
+			}
+
+			// Bandwidth is charged after the fact and refuses requests until
+			// the debt is repaid
+			media := Request{AccessKey: "carol-" + name, Bucket: "media", Class: ClassRead}
+			charge, ok := l.Allow(ctx, media)
+			if !ok {
+				t.Fatal("first request refused")
+			}
+			charge(10_000)
+			if _, ok := l.Allow(ctx, media); ok {
+				t.Error("request allowed with overdrawn bandwidth")
+			}
+
+			// A request refused by a later rule gives back the request
+			// tokens earlier rules took
+			dave := Request{AccessKey: "dave-" + name, Bucket: "media", Class: ClassWrite}
+			charge, ok = l.Allow(ctx, dave)
+			if !ok {
+				t.Fatal("first request refused")
+			}
+			charge(10_000)
+			for i := 0; i < 3; i++ {
+				if _, ok := l.Allow(ctx, dave); ok {
+					t.Fatal("request allowed with overdrawn bandwidth")
+				}
+			}
+			requests := NewLimiter(logging.NewLogger(logging.Config{Level: "error"}), l.rules[:1], state)
+			if _, ok := requests.Allow(ctx, dave); !ok {
+				t.Error("refused requests used up request tokens")
+			}
+		})
+	}
+}
+
+func TestLocalStatePrune(t *testing.T) {
+	s := NewLocalState()
+	ctx := context.Background()
+	slow := Bucket{Rate: 0.001, Burst: 1}
+	fast := Bucket{Rate: 1000, Burst: 5}
+	if ok, _ := s.Take(ctx, "slow", slow, 1); !ok {
+		t.Fatal("take from a new bucket refused")
+	}
+	if ok, _ := s.Take(ctx, "fast", fast, 0); !ok {
+		t.Fatal("take from a new bucket refused")
+	}
+
+	// Each bucket refills at its own rate, so the empty slow one is kept
+	s.prune(time.Now().Add(time.Second))
+	if _, ok := s.buckets["slow"]; !ok {
+		t.Error("empty bucket pruned")
+	}
+	if _, ok := s.buckets["fast"]; ok {
+		t.Error("full bucket kept")
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	for _, rule := range []Rule{
+		{Class: "delete", RequestsPerSecond: 1},
+		{RequestsPerSecond: -1},
+		{Bucket: "docs"},
+	} {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", rule)
+		}
+	}
+	rule := Rule{AccessKey: "alice", Class: ClassList, BytesPerSecond: 1 << 20}
+	if err := rule.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+)
+
+const (
+	// KVBucketName is the Key-Value bucket shared by gateways limiting
+	// requests together.
+	KVBucketName = "rate_limits"
+
+	// kvTTL expires the token buckets of idle keys
+	kvTTL = time.Hour
+	// maxKVAttempts bounds the retries of an update raced by other gateways
+	maxKVAttempts = 10
+	// maxLocalBuckets is the number of token buckets kept in memory before
+	// the full ones are dropped
+	maxLocalBuckets = 10000
+)
+
+// LocalState keeps token buckets in memory, limiting the requests of a
+// single gateway.
+type LocalState struct {
+	mu      sync.Mutex
+	buckets map[string]*localTokens
+}
+
+// localTokens is a token bucket kept in memory with the rate and capacity
+// it was last used with.
+type localTokens struct {
+	tokens
+	bucket Bucket
+}
+
+// NewLocalState returns an empty LocalState.
+func NewLocalState() *LocalState {
+	return &LocalState{buckets: make(map[string]*localTokens)}
+}
+
+// Take implements the State interface.
+func (s *LocalState) Take(_ context.Context, key string, b Bucket, n float64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bucket(key, b).take(n), nil
+}
+
+// Charge implements the State interface.
+func (s *LocalState) Charge(_ context.Context, key string, b Bucket, n float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bucket(key, b).Tokens -= n
+	return nil
+}
+
+// bucket returns the refilled token bucket of key.
+func (s *LocalState) bucket(key string, b Bucket) *tokens {
+	now := time.Now()
+	t, ok := s.buckets[key]
+	if !ok {
+		if len(s.buckets) >= maxLocalBuckets {
+			s.prune(now)
+		}
+		t = &localTokens{}
+		s.buckets[key] = t
+	}
+	t.bucket = b
+	t.refill(now, b)
+	return &t.tokens
+}
+
+// prune drops the token buckets that refilled completely, which behave as
+// new ones. Each is refilled at its own rate, as keys belong to different
+// rules.
+func (s *LocalState) prune(now time.Time) {
+	for key, t := range s.buckets {
+		t.refill(now, t.bucket)
+		if t.Tokens >= t.bucket.Burst {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// KVState keeps token buckets in a JetStream Key-Value bucket, so that
+// gateways sharing it enforce the limits together. Updates use optimistic
+// concurrency and are retried when another gateway raced them.
+type KVState struct {
+	kv jetstream.KeyValue
+}
+
+// NewKVState binds to the shared Key-Value bucket, creating it when it does
+// not exist yet.
+func NewKVState(ctx context.Context, js jetstream.JetStream, replicas int) (*KVState, error) {
+	kv, err := js.KeyValue(ctx, KVBucketName)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      KVBucketName,
+			Description: "Token buckets of the nats-s3 rate limits",
+			TTL:         kvTTL,
+			Replicas:    replicas,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to access rate limit store: %w", err)
+	}
+	return &KVState{kv: tracing.KeyValue(js, kv)}, nil
+}
+
+// Take implements the State interface.
+func (s *KVState) Take(ctx context.Context, key string, b Bucket, n float64) (bool, error) {
+	var ok bool
+	err := s.update(ctx, key, b, func(t *tokens) bool {
+		ok = t.take(n)
+		return ok
+	})
+	return ok, err
+}
+
+// Charge implements the State interface.
+func (s *KVState) Charge(ctx context.Context, key string, b Bucket, n float64) error {
+	return s.update(ctx, key, b, func(t *tokens) bool {
+		t.Tokens -= n
+		return true
+	})
+}
+
+// update refills the token bucket of key and applies fn to it, storing the
+// result when fn reports a change.
+func (s *KVState) update(ctx context.Context, key string, b Bucket, fn func(t *tokens) bool) error {
+	key = kvKey(key)
+	for attempt := 0; attempt < maxKVAttempts; attempt++ {
+		var t tokens
+		var rev uint64
+		entry, err := s.kv.Get(ctx, key)
+		switch {
+		case errors.Is(err, jetstream.ErrKeyNotFound):
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal(entry.Value(), &t); err != nil {
+				return err
+			}
+			rev = entry.Revision()
+		}
+
+		t.refill(time.Now(), b)
+		if !fn(&t) {
+			return nil
+		}
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		if rev == 0 {
+			_, err = s.kv.Create(ctx, key, data)
+		} else {
+			_, err = s.kv.Update(ctx, key, data, rev)
+		}
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			return err
+		}
+	}
+	return fmt.Errorf("rate limit of %s updated concurrently too often", key)
+}
+
+// kvKey turns a token bucket key into a valid Key-Value key.
+func kvKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:20])
+}
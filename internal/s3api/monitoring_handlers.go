@@ -6,9 +6,9 @@ import (
 )
 
 // Healthz returns 200 OK when the gateway has an active connection to NATS.
-// Returns 503 Service Unavailable when disconnected.
+// Returns 503 Service Unavailable when disconnected or shutting down.
 func (s *S3Gateway) Healthz(w http.ResponseWriter, r *http.Request) {
-	if s.client.IsConnected() {
+	if s.client.IsConnected() && !s.shuttingDown.Load() {
 		model.WriteEmptyResponse(w, r, http.StatusOK)
 		return
 	}
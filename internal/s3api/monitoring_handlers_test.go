@@ -1,8 +1,11 @@
 package s3api
 
 import (
+	"context"
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/wpnpeiris/nats-s3/internal/logging"
 	"github.com/wpnpeiris/nats-s3/internal/testutil"
@@ -15,7 +18,7 @@ func TestHealthz_OK(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -30,3 +33,52 @@ func TestHealthz_OK(t *testing.T) {
 		t.Fatalf("expected 200 from /healthz, got %d", rr.Code)
 	}
 }
+
+func TestHealthz_ShuttingDown(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	gw.BeginShutdown()
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 503 {
+		t.Fatalf("expected 503 from /healthz while shutting down, got %d", rr.Code)
+	}
+
+	// Requests already being served are reported as interrupted
+	release := make(chan struct{})
+	done := make(chan struct{})
+	blocking := gw.trackInflight(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	go func() {
+		defer close(done)
+		blocking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PUT", "/bucket/key", nil))
+	}()
+	for i := 0; i < 100 && len(gw.inflight.list()) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := gw.LogInterrupted(); n != 1 {
+		t.Fatalf("expected 1 interrupted request, got %d", n)
+	}
+	close(release)
+	<-done
+	if n := gw.LogInterrupted(); n != 0 {
+		t.Fatalf("expected no interrupted requests, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := gw.Drain(ctx); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+}
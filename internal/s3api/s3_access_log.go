@@ -0,0 +1,239 @@
+package s3api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/accesslog"
+	"github.com/wpnpeiris/nats-s3/internal/auth"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+)
+
+const (
+	// loggingTargetTTL is how long the logging configuration of a bucket is
+	// cached, and so how long other gateways take to pick up a change
+	loggingTargetTTL = 30 * time.Second
+	// maxLoggingTargets bounds the cached configurations, which requests for
+	// arbitrary bucket names would otherwise grow
+	maxLoggingTargets = 10000
+)
+
+// logAccess records each request in the access logs of its bucket.
+func (s *S3Gateway) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		started := time.Now()
+		r, re := withResponseError(r)
+		id := &auth.Identity{}
+		r = r.WithContext(auth.WithIdentity(r.Context(), id))
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		vars := mux.Vars(r)
+		bucket := vars["bucket"]
+		record := &accesslog.Record{
+			Bucket:           bucket,
+			Time:             started,
+			RemoteIP:         remoteIP(r),
+			Requester:        id.AccessKey,
+			RequestID:        logging.RequestID(r.Context()),
+			Operation:        logOperation(r),
+			Key:              vars["key"],
+			RequestURI:       r.Method + " " + r.URL.RequestURI() + " " + r.Proto,
+			Status:           rec.statusCode(),
+			ErrorCode:        re.Code,
+			BytesSent:        rec.bytes,
+			ObjectSize:       objectSize(r, rec, body.bytes),
+			TotalTime:        time.Since(started).Milliseconds(),
+			TurnAroundTime:   -1,
+			Referer:          r.Referer(),
+			UserAgent:        r.UserAgent(),
+			HostID:           s.hostID,
+			SignatureVersion: id.SignatureVersion,
+			AuthType:         id.AuthType,
+			HostHeader:       r.Host,
+		}
+		if !rec.wroteAt.IsZero() {
+			record.TurnAroundTime = rec.wroteAt.Sub(started).Milliseconds()
+		}
+		if r.TLS != nil {
+			record.CipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+			record.TLSVersion = strings.Replace(tls.VersionName(r.TLS.Version), "TLS ", "TLSv", 1)
+		}
+
+		var target *accesslog.Target
+		if bucket != "" {
+			target = s.loggingTarget(context.WithoutCancel(r.Context()), bucket)
+		}
+		s.accessLog.Log(target, record)
+	})
+}
+
+// loggingTarget returns where the access logs of a bucket are delivered, or
+// nil when its logging is disabled.
+func (s *S3Gateway) loggingTarget(ctx context.Context, bucket string) *accesslog.Target {
+	if target, ok := s.loggingTargets.get(bucket); ok {
+		return target
+	}
+	cfg, err := s.client.GetBucketConfig(ctx, bucket)
+	if err != nil {
+		if errors.Is(err, client.ErrBucketNotFound) {
+			s.loggingTargets.set(bucket, nil)
+		} else {
+			logging.Warn(logging.WithContext(ctx, s.logger), "msg", "Failed to read the logging configuration of a bucket", "bucket", bucket, "err", err)
+		}
+		return nil
+	}
+	var target *accesslog.Target
+	if cfg.Logging != nil {
+		target = &accesslog.Target{Bucket: cfg.Logging.TargetBucket, Prefix: cfg.Logging.TargetPrefix}
+	}
+	s.loggingTargets.set(bucket, target)
+	return target
+}
+
+// loggingTargets caches the logging configuration of buckets.
+type loggingTargets struct {
+	mu      sync.Mutex
+	entries map[string]loggingTargetEntry
+}
+
+type loggingTargetEntry struct {
+	target  *accesslog.Target
+	expires time.Time
+}
+
+func (t *loggingTargets) get(bucket string) (*accesslog.Target, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[bucket]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.target, true
+}
+
+func (t *loggingTargets) set(bucket string, target *accesslog.Target) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.entries == nil || len(t.entries) >= maxLoggingTargets {
+		t.entries = make(map[string]loggingTargetEntry)
+	}
+	t.entries[bucket] = loggingTargetEntry{target: target, expires: time.Now().Add(loggingTargetTTL)}
+}
+
+func (t *loggingTargets) invalidate(bucket string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, bucket)
+}
+
+// remoteIP returns the client address of a request without its port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// objectSize returns the size of the object a request read or wrote, or -1
+// when the request is not about an object.
+func objectSize(r *http.Request, rec *responseRecorder, received int64) int64 {
+	if _, ok := mux.Vars(r)["key"]; !ok {
+		return -1
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		// Range reads report the size of the whole object
+		if cr := rec.Header().Get("Content-Range"); cr != "" {
+			if i := strings.LastIndexByte(cr, '/'); i >= 0 {
+				if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+					return size
+				}
+			}
+		}
+		if size, err := strconv.ParseInt(rec.Header().Get("Content-Length"), 10, 64); err == nil {
+			return size
+		}
+	case http.MethodPut:
+		if received > 0 {
+			return received
+		}
+	}
+	return -1
+}
+
+// logResources name the bucket subresources in access log operations whose
+// names differ from the subresource.
+var logResources = map[string]string{
+	"logging":    "LOGGING_STATUS",
+	"delete":     "MULTI_OBJECT_DELETE",
+	"policy":     "BUCKETPOLICY",
+	"versioning": "BUCKETVERSIONING",
+}
+
+// logOperation names the operation of a request as S3 access logs do, such
+// as REST.PUT.OBJECT or REST.GET.LOGGING_STATUS.
+func logOperation(r *http.Request) string {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	method := r.Method
+	if r.Header.Get("x-amz-copy-source") != "" {
+		method = "COPY"
+	}
+
+	resource := "SERVICE"
+	if _, ok := vars["key"]; ok {
+		resource = "OBJECT"
+		switch {
+		case query.Has("uploadId") && query.Has("partNumber"):
+			resource = "PART"
+		case query.Has("uploadId"):
+			resource = "UPLOAD"
+		case query.Has("uploads"):
+			resource = "UPLOADS"
+		default:
+			for _, o := range objectOperations {
+				if query.Has(o.sub) {
+					resource = "OBJECT_" + logResourceName(o.sub)
+					break
+				}
+			}
+		}
+	} else if _, ok := vars["bucket"]; ok {
+		resource = "BUCKET"
+		for _, sub := range bucketSubresources {
+			if query.Has(sub) {
+				resource = logResourceName(sub)
+				if name, ok := logResources[sub]; ok {
+					resource = name
+				}
+				break
+			}
+		}
+	}
+	return "REST." + method + "." + resource
+}
+
+// logResourceName turns a subresource such as legal-hold into LEGAL_HOLD.
+func logResourceName(sub string) string {
+	return strings.ToUpper(strings.ReplaceAll(sub, "-", "_"))
+}
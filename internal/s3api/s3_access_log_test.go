@@ -0,0 +1,128 @@
+package s3api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestLogOperation(t *testing.T) {
+	r := mux.NewRouter()
+	var got string
+	capture := func(w http.ResponseWriter, r *http.Request) { got = logOperation(r) }
+	r.Path("/").HandlerFunc(capture)
+	r.Path("/{bucket}").HandlerFunc(capture)
+	r.Path("/{bucket}/{key:.+}").HandlerFunc(capture)
+
+	tests := []struct {
+		method, target, copySource, want string
+	}{
+		{http.MethodGet, "/", "", "REST.GET.SERVICE"},
+		{http.MethodPut, "/b", "", "REST.PUT.BUCKET"},
+		{http.MethodGet, "/b?logging=", "", "REST.GET.LOGGING_STATUS"},
+		{http.MethodPost, "/b?delete=", "", "REST.POST.MULTI_OBJECT_DELETE"},
+		{http.MethodPut, "/b?object-lock=", "", "REST.PUT.OBJECT_LOCK"},
+		{http.MethodPut, "/b/k", "", "REST.PUT.OBJECT"},
+		{http.MethodPut, "/b/k", "/src/k", "REST.COPY.OBJECT"},
+		{http.MethodGet, "/b/k?tagging=", "", "REST.GET.OBJECT_TAGGING"},
+		{http.MethodPost, "/b/k?uploads=", "", "REST.POST.UPLOADS"},
+		{http.MethodPut, "/b/k?partNumber=1&uploadId=u", "", "REST.PUT.PART"},
+		{http.MethodPost, "/b/k?uploadId=u", "", "REST.POST.UPLOAD"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.copySource != "" {
+			req.Header.Set("x-amz-copy-source", tc.copySource)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Errorf("%s %s: expected %s, got %s", tc.method, tc.target, tc.want, got)
+		}
+	}
+}
+
+func TestBucketLogging(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	for _, bucket := range []string{"/logged", "/access-logs"} {
+		if rr := do(http.MethodPut, bucket, ""); rr.Code != http.StatusOK {
+			t.Fatalf("create bucket %s: %d", bucket, rr.Code)
+		}
+	}
+
+	rr := do(http.MethodPut, "/logged?logging",
+		`<BucketLoggingStatus xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LoggingEnabled><TargetBucket>missing</TargetBucket></LoggingEnabled></BucketLoggingStatus>`)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "InvalidTargetBucketForLogging") {
+		t.Fatalf("expected InvalidTargetBucketForLogging, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = do(http.MethodPut, "/logged?logging",
+		`<BucketLoggingStatus xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LoggingEnabled><TargetBucket>access-logs</TargetBucket><TargetPrefix>logged/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("put bucket logging: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = do(http.MethodGet, "/logged?logging", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "<TargetBucket>access-logs</TargetBucket>") {
+		t.Fatalf("get bucket logging: %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := do(http.MethodPut, "/logged/key", "0123456789"); rr.Code != http.StatusOK {
+		t.Fatalf("put object: %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/logged/missing", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing object, got %d", rr.Code)
+	}
+	if err := gw.accessLog.Flush(context.Background()); err != nil {
+		t.Fatalf("flush access logs: %v", err)
+	}
+
+	objects, err := gw.client.ListObjects(context.Background(), "access-logs")
+	if err != nil {
+		t.Fatalf("list access logs: %v", err)
+	}
+	if len(objects) != 1 || !strings.HasPrefix(objects[0].Name, "logged/") {
+		t.Fatalf("expected one log object under logged/, got %d", len(objects))
+	}
+	_, data, err := gw.client.GetObject(context.Background(), "access-logs", objects[0].Name, nil)
+	if err != nil {
+		t.Fatalf("get access log: %v", err)
+	}
+	for _, want := range []string{
+		" logged [",
+		` REST.PUT.OBJECT key "PUT /logged/key HTTP/1.1" 200 - - 10 `,
+		` REST.GET.OBJECT missing "GET /logged/missing HTTP/1.1" 404 NoSuchKey `,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected access log to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	rr = do(http.MethodPut, "/logged?logging", `<BucketLoggingStatus xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></BucketLoggingStatus>`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("disable bucket logging: %d", rr.Code)
+	}
+	rr = do(http.MethodGet, "/logged?logging", "")
+	if strings.Contains(rr.Body.String(), "LoggingEnabled") {
+		t.Errorf("expected logging to be disabled, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,526 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/acl"
+	"github.com/wpnpeiris/nats-s3/internal/auth"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+// objectOwnershipHeader sets the ownership controls of a new bucket.
+const objectOwnershipHeader = "x-amz-object-ownership"
+
+// objectPermissions are the permissions object operations need on their
+// object.
+var objectPermissions = map[string]string{
+	"GetObject":           acl.PermRead,
+	"HeadObject":          acl.PermRead,
+	"GetObjectAttributes": acl.PermRead,
+	"GetObjectTagging":    acl.PermRead,
+	"GetObjectRetention":  acl.PermRead,
+	"GetObjectLegalHold":  acl.PermRead,
+	"SelectObjectContent": acl.PermRead,
+	"GetObjectAcl":        acl.PermReadACP,
+	"PutObjectAcl":        acl.PermWriteACP,
+	"PutObjectTagging":    acl.PermFullControl,
+	"DeleteObjectTagging": acl.PermFullControl,
+	"PutObjectRetention":  acl.PermFullControl,
+	"PutObjectLegalHold":  acl.PermFullControl,
+}
+
+// bucketPermissions are the permissions operations need on their bucket.
+// Other bucket operations need full control.
+var bucketPermissions = map[string]string{
+	"HeadBucket":              acl.PermRead,
+	"GetBucketLocation":       acl.PermRead,
+	"ListObjects":             acl.PermRead,
+	"ListObjectsV2":           acl.PermRead,
+	"ListObjectVersions":      acl.PermRead,
+	"ListMultipartUploads":    acl.PermRead,
+	"PutObject":               acl.PermWrite,
+	"CopyObject":              acl.PermWrite,
+	"DeleteObject":            acl.PermWrite,
+	"DeleteObjects":           acl.PermWrite,
+	"CreateMultipartUpload":   acl.PermWrite,
+	"UploadPart":              acl.PermWrite,
+	"UploadPartCopy":          acl.PermWrite,
+	"CompleteMultipartUpload": acl.PermWrite,
+	"AbortMultipartUpload":    acl.PermWrite,
+	"ListParts":               acl.PermWrite,
+	"GetBucketAcl":            acl.PermReadACP,
+	"PutBucketAcl":            acl.PermWriteACP,
+}
+
+// authorize checks the ACLs of the bucket and object of a request against
+// its caller, failing it with 403 AccessDenied when they do not allow the
+// operation. Buckets without an ACL, or whose ownership controls disable
+// ACLs, leave every authenticated caller in full control; the ACLs of their
+// objects can only grant access to more callers, such as anonymous ones.
+func (s *S3Gateway) authorize(next http.HandlerFunc) http.HandlerFunc {
+	if !s.iam.Enabled() {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		accessKey := requestAccessKey(r)
+		operation := s3Operation(r)
+		if errCode := s.checkAccess(r, operation, accessKey); errCode != model.ErrNone {
+			logging.Debug(logging.WithContext(r.Context(), s.logger), "msg", "Request not authorized",
+				"access_key", accessKey, "operation", operation, "bucket", mux.Vars(r)["bucket"])
+			model.WriteErrorResponse(w, r, errCode)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkAccess returns ErrNone when the caller may run the operation of a
+// request.
+func (s *S3Gateway) checkAccess(r *http.Request, operation, accessKey string) model.ErrorCode {
+	vars := mux.Vars(r)
+	bucket, key := vars["bucket"], vars["key"]
+	if bucket == "" || operation == "CreateBucket" {
+		return authenticated(accessKey)
+	}
+
+	if operation == "CopyObject" || operation == "UploadPartCopy" {
+		// Invalid copy sources are reported by the handler
+		if srcBucket, srcKey, err := parseCopySource(r.Header.Get("x-amz-copy-source")); err == nil {
+			if errCode := s.checkObjectAccess(r, srcBucket, srcKey, accessKey, acl.PermRead); errCode != model.ErrNone {
+				return errCode
+			}
+		}
+	}
+	if perm, ok := objectPermissions[operation]; ok {
+		return s.checkObjectAccess(r, bucket, key, accessKey, perm)
+	}
+
+	perm, ok := bucketPermissions[operation]
+	if !ok {
+		perm = acl.PermFullControl
+	}
+	cfg, errCode := s.accessConfig(r, bucket, accessKey)
+	if cfg == nil {
+		return errCode
+	}
+	if !bucketAllows(cfg, accessKey, perm) {
+		return model.ErrAccessDenied
+	}
+	return model.ErrNone
+}
+
+// checkObjectAccess returns ErrNone when the caller has a permission on an
+// object.
+func (s *S3Gateway) checkObjectAccess(r *http.Request, bucket, key, accessKey, perm string) model.ErrorCode {
+	cfg, errCode := s.accessConfig(r, bucket, accessKey)
+	if cfg == nil {
+		return errCode
+	}
+	var objectACL *acl.ACL
+	if cfg.ObjectOwnership != acl.BucketOwnerEnforced {
+		info, err := s.client.GetObjectInfo(r.Context(), bucket, key)
+		if err == nil {
+			objectACL, err = client.ObjectACL(info)
+		}
+		if err != nil && !errors.Is(err, client.ErrObjectNotFound) {
+			logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Error reading object ACL", "bucket", bucket, "key", key, "err", err)
+			return model.ErrInternalError
+		}
+	}
+	if !objectAllows(cfg, objectACL, accessKey, perm) {
+		return model.ErrAccessDenied
+	}
+	return model.ErrNone
+}
+
+// accessConfig returns the configuration of a bucket to check access
+// against. A nil configuration with ErrNone lets the request through, for
+// its handler to report a missing bucket to authenticated callers.
+func (s *S3Gateway) accessConfig(r *http.Request, bucket, accessKey string) (*client.BucketConfig, model.ErrorCode) {
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if errors.Is(err, client.ErrBucketNotFound) {
+		return nil, authenticated(accessKey)
+	}
+	if err != nil {
+		logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Error reading bucket ACL", "bucket", bucket, "err", err)
+		return nil, model.ErrInternalError
+	}
+	return cfg, model.ErrNone
+}
+
+// authenticated denies anonymous callers.
+func authenticated(accessKey string) model.ErrorCode {
+	if accessKey == "" {
+		return model.ErrAccessDenied
+	}
+	return model.ErrNone
+}
+
+// aclsEnforced reports whether the ACLs of a bucket and its objects decide
+// who may access them.
+func aclsEnforced(cfg *client.BucketConfig) bool {
+	return cfg.ACL != nil && cfg.ObjectOwnership != acl.BucketOwnerEnforced
+}
+
+// bucketAllows reports whether a caller has a permission on a bucket.
+func bucketAllows(cfg *client.BucketConfig, accessKey, perm string) bool {
+	if !aclsEnforced(cfg) {
+		return accessKey != ""
+	}
+	return cfg.ACL.Allows(accessKey, perm)
+}
+
+// objectAllows reports whether a caller has a permission on an object with
+// the given ACL, nil when it has none. Objects without an ACL in buckets
+// with one belong to the bucket owner.
+func objectAllows(cfg *client.BucketConfig, objectACL *acl.ACL, accessKey, perm string) bool {
+	switch {
+	case cfg.ObjectOwnership == acl.BucketOwnerEnforced:
+		return accessKey != ""
+	case cfg.ACL == nil:
+		return accessKey != "" || objectACL != nil && objectACL.Allows(accessKey, perm)
+	case objectACL != nil:
+		return objectACL.Allows(accessKey, perm)
+	}
+	return cfg.ACL.Allows(accessKey, acl.PermFullControl)
+}
+
+// requestAccessKey returns the access key of the caller of a request, empty
+// for anonymous callers and when authentication is disabled.
+func requestAccessKey(r *http.Request) string {
+	if id, ok := auth.IdentityFrom(r.Context()); ok {
+		return id.AccessKey
+	}
+	return ""
+}
+
+// aclErrorCode maps an error of an ACL set by a request to its S3 error.
+func aclErrorCode(err error) model.ErrorCode {
+	if errors.Is(err, acl.ErrUnsupportedGrantee) {
+		return model.ErrUnresolvableGrant
+	}
+	return model.ErrInvalidACL
+}
+
+// newObjectACL returns the ACL to record on an object written by a request:
+// the one its headers set, or one private to the writer in buckets with an
+// enforced ACL. Returns nil when the object gets none.
+func (s *S3Gateway) newObjectACL(r *http.Request, bucket string) (*acl.ACL, model.ErrorCode) {
+	if !acl.HasHeaders(r.Header) && !s.iam.Enabled() {
+		return nil, model.ErrNone
+	}
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if err != nil {
+		return nil, objectErrorCode(err)
+	}
+	accessKey := requestAccessKey(r)
+	a, err := acl.FromHeaders(r.Header, accessKey, cfg.Owner)
+	if err != nil {
+		return nil, aclErrorCode(err)
+	}
+
+	switch cfg.ObjectOwnership {
+	case acl.BucketOwnerEnforced:
+		if a != nil && !a.OwnerOnly(cfg.Owner) {
+			return nil, model.ErrAccessControlListNotSupported
+		}
+		return nil, model.ErrNone
+	case acl.BucketOwnerPreferred:
+		if r.Header.Get(acl.HeaderACL) == acl.BucketOwnerFullControl && cfg.Owner != "" {
+			a, _ = acl.Canned(acl.Private, cfg.Owner, "")
+		}
+	}
+	if a == nil && cfg.ACL != nil && accessKey != "" {
+		a, _ = acl.Canned(acl.Private, accessKey, cfg.Owner)
+	}
+	return a, model.ErrNone
+}
+
+// readBucketACL reads the ACL and object ownership set by the headers of a
+// CreateBucket request.
+func readBucketACL(r *http.Request, owner string) (*acl.ACL, string, model.ErrorCode) {
+	ownership := r.Header.Get(objectOwnershipHeader)
+	if ownership != "" && !acl.ValidOwnership(ownership) {
+		return nil, "", model.ErrInvalidOwnershipControls
+	}
+	a, err := acl.FromHeaders(r.Header, owner, "")
+	if err != nil {
+		return nil, "", aclErrorCode(err)
+	}
+	if a != nil && ownership == acl.BucketOwnerEnforced && !a.OwnerOnly("") {
+		return nil, "", model.ErrAccessControlListNotSupported
+	}
+	return a, ownership, model.ErrNone
+}
+
+// defaultACL describes the access to buckets and objects without an
+// enforced ACL: their owner and every authenticated caller in full control.
+func defaultACL(owner string) *acl.ACL {
+	a, _ := acl.Canned(acl.Private, owner, "")
+	a.Grants = append(a.Grants, acl.Grant{Grantee: acl.Grantee{URI: acl.AuthenticatedUsers}, Permission: acl.PermFullControl})
+	return a
+}
+
+// GetBucketAcl returns the access control list of a bucket.
+func (s *S3Gateway) GetBucketAcl(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	a := cfg.ACL
+	if !aclsEnforced(cfg) {
+		a = defaultACL(cfg.Owner)
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, accessControlPolicy(a))
+}
+
+// PutBucketAcl sets the access control list of a bucket from the x-amz-acl
+// or x-amz-grant-* headers, or an AccessControlPolicy body. Buckets created
+// before ACLs were supported have no owner, and are taken over by the
+// caller.
+func (s *S3Gateway) PutBucketAcl(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutBucketAcl: bucket=%s", bucket))
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	owner := cfg.Owner
+	if owner == "" {
+		owner = requestAccessKey(r)
+	}
+	a, errCode := readACL(r, owner, "")
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	if cfg.ObjectOwnership == acl.BucketOwnerEnforced && !a.OwnerOnly("") {
+		model.WriteErrorResponse(w, r, model.ErrAccessControlListNotSupported)
+		return
+	}
+
+	err = s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Owner = owner
+		cfg.ACL = a
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetObjectAcl returns the access control list of an object.
+func (s *S3Gateway) GetObjectAcl(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+	key := mux.Vars(r)["key"]
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	info, err := s.client.GetObjectInfo(r.Context(), bucket, key)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	a, err := client.ObjectACL(info)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	switch {
+	case cfg.ObjectOwnership == acl.BucketOwnerEnforced:
+		a = defaultACL(cfg.Owner)
+	case a != nil:
+	case cfg.ACL == nil:
+		a = defaultACL(cfg.Owner)
+	default:
+		a, _ = acl.Canned(acl.Private, cfg.Owner, "")
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, accessControlPolicy(a))
+}
+
+// PutObjectAcl sets the access control list of an object from the x-amz-acl
+// or x-amz-grant-* headers, or an AccessControlPolicy body. Objects without
+// an ACL are owned by the bucket owner, or by the caller in buckets without
+// an ACL.
+func (s *S3Gateway) PutObjectAcl(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+	key := mux.Vars(r)["key"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutObjectAcl: bucket=%s key=%s", bucket, key))
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	info, err := s.client.GetObjectInfo(r.Context(), bucket, key)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	current, err := client.ObjectACL(info)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	var owner string
+	switch {
+	case current != nil:
+		owner = current.Owner
+	case cfg.ACL != nil:
+		owner = cfg.Owner
+	default:
+		owner = requestAccessKey(r)
+	}
+	a, errCode := readACL(r, owner, cfg.Owner)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	if cfg.ObjectOwnership == acl.BucketOwnerEnforced {
+		if !a.OwnerOnly(cfg.Owner) {
+			model.WriteErrorResponse(w, r, model.ErrAccessControlListNotSupported)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if s.handleObjectError(w, r, s.client.PutObjectACL(r.Context(), bucket, key, a)) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// readACL reads the ACL set by a PutBucketAcl or PutObjectAcl request, from
+// its headers or else its AccessControlPolicy body.
+func readACL(r *http.Request, owner, bucketOwner string) (*acl.ACL, model.ErrorCode) {
+	if acl.HasHeaders(r.Header) {
+		a, err := acl.FromHeaders(r.Header, owner, bucketOwner)
+		if err != nil {
+			return nil, aclErrorCode(err)
+		}
+		return a, model.ErrNone
+	}
+
+	var policy model.AccessControlPolicy
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&policy); err != nil {
+		return nil, model.ErrMalformedACL
+	}
+	var grants []acl.Grant
+	for _, g := range policy.AccessControlList.Grants {
+		switch {
+		case g.Grantee.EmailAddress != "":
+			return nil, model.ErrUnresolvableGrant
+		case g.Grantee.ID != "" && g.Grantee.URI != "":
+			return nil, model.ErrMalformedACL
+		}
+		grants = append(grants, acl.Grant{
+			Grantee:    acl.Grantee{ID: g.Grantee.ID, URI: g.Grantee.URI},
+			Permission: g.Permission,
+		})
+	}
+	a, err := acl.New(owner, grants)
+	if errors.Is(err, acl.ErrUnsupportedGrantee) {
+		return nil, model.ErrUnresolvableGrant
+	}
+	if err != nil {
+		return nil, model.ErrMalformedACL
+	}
+	return a, model.ErrNone
+}
+
+// accessControlPolicy returns the AccessControlPolicy response of an ACL.
+func accessControlPolicy(a *acl.ACL) model.AccessControlPolicy {
+	policy := model.AccessControlPolicy{
+		Owner: model.Owner{ID: a.Owner, DisplayName: a.Owner},
+	}
+	for _, g := range a.Grants {
+		grantee := model.Grantee{XMLNSXSI: model.XSINamespace}
+		if g.Grantee.URI != "" {
+			grantee.Type = "Group"
+			grantee.URI = g.Grantee.URI
+		} else {
+			grantee.Type = "CanonicalUser"
+			grantee.ID = g.Grantee.ID
+			grantee.DisplayName = g.Grantee.ID
+		}
+		policy.AccessControlList.Grants = append(policy.AccessControlList.Grants, model.Grant{
+			Grantee:    grantee,
+			Permission: g.Permission,
+		})
+	}
+	return policy
+}
+
+// GetBucketOwnershipControls returns the object ownership of a bucket.
+func (s *S3Gateway) GetBucketOwnershipControls(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if cfg.ObjectOwnership == "" {
+		model.WriteErrorResponse(w, r, model.ErrOwnershipControlsNotFound)
+		return
+	}
+	response := model.OwnershipControls{
+		Rules: []model.OwnershipControlsRule{{ObjectOwnership: cfg.ObjectOwnership}},
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, response)
+}
+
+// PutBucketOwnershipControls sets the object ownership of a bucket.
+// BucketOwnerEnforced disables the ACLs of the bucket and its objects.
+func (s *S3Gateway) PutBucketOwnershipControls(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutBucketOwnershipControls: bucket=%s", bucket))
+
+	var controls model.OwnershipControls
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&controls); err != nil {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+	if len(controls.Rules) != 1 || !acl.ValidOwnership(controls.Rules[0].ObjectOwnership) {
+		model.WriteErrorResponse(w, r, model.ErrInvalidOwnershipControls)
+		return
+	}
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.ObjectOwnership = controls.Rules[0].ObjectOwnership
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteBucketOwnershipControls removes the object ownership of a bucket,
+// which then behaves as ObjectWriter.
+func (s *S3Gateway) DeleteBucketOwnershipControls(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("DeleteBucketOwnershipControls: bucket=%s", bucket))
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.ObjectOwnership = ""
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
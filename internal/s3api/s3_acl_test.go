@@ -0,0 +1,102 @@
+package s3api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/acl"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestACLs(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	creds := testCredentials{"alice": "alice-secret", "bob": "bob-secret"}
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, creds, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	// do sends a request signed by an access key, or anonymous when empty
+	do := func(accessKey, method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if accessKey != "" {
+			signer := v4.NewSigner(credentials.NewStaticCredentials(accessKey, creds[accessKey], ""))
+			signer.DisableURIPathEscaping = true
+			if _, err := signer.Sign(req, strings.NewReader(body), "s3", "us-east-1", time.Now()); err != nil {
+				t.Fatalf("sign failed: %v", err)
+			}
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	expect := func(rr *httptest.ResponseRecorder, code int, what string) {
+		t.Helper()
+		if rr.Code != code {
+			t.Fatalf("%s: expected %d, got %d %s", what, code, rr.Code, rr.Body.String())
+		}
+	}
+
+	expect(do("", "PUT", "/acls", "", nil), 403, "anonymous create bucket")
+	expect(do("alice", "PUT", "/acls", "", map[string]string{"x-amz-acl": acl.Private}), 200, "create bucket")
+	expect(do("alice", "PUT", "/acls/public.txt", "hello", map[string]string{"x-amz-acl": acl.PublicRead}), 200, "upload public object")
+	expect(do("alice", "PUT", "/acls/private.txt", "secret", nil), 200, "upload private object")
+
+	expect(do("", "GET", "/acls/public.txt", "", nil), 200, "anonymous read of public object")
+	expect(do("", "GET", "/acls/private.txt", "", nil), 403, "anonymous read of private object")
+	expect(do("bob", "GET", "/acls/private.txt", "", nil), 403, "read of private object by another key")
+	expect(do("bob", "GET", "/acls", "", nil), 403, "list of private bucket by another key")
+	expect(do("bob", "PUT", "/acls/new.txt", "data", nil), 403, "write to private bucket by another key")
+
+	rr := do("alice", "GET", "/acls/public.txt?acl", "", nil)
+	expect(rr, 200, "get object ACL")
+	for _, want := range []string{"<ID>alice</ID>", "<URI>" + acl.AllUsers + "</URI>", "<Permission>READ</Permission>", `xsi:type="Group"`} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Fatalf("expected object ACL to contain %s, got %s", want, rr.Body.String())
+		}
+	}
+
+	// Grants in an AccessControlPolicy body open the bucket to bob
+	policy := `<AccessControlPolicy xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Owner><ID>alice</ID></Owner><AccessControlList>` +
+		`<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="CanonicalUser"><ID>bob</ID></Grantee><Permission>READ</Permission></Grant>` +
+		`</AccessControlList></AccessControlPolicy>`
+	expect(do("bob", "PUT", "/acls?acl", policy, nil), 403, "put bucket ACL by another key")
+	expect(do("alice", "PUT", "/acls?acl", policy, nil), 200, "put bucket ACL")
+	expect(do("bob", "GET", "/acls", "", nil), 200, "list of bucket readable by bob")
+	expect(do("bob", "PUT", "/acls/new.txt", "data", nil), 403, "write to bucket only readable by bob")
+
+	expect(do("alice", "PUT", "/acls/private.txt?acl", "", map[string]string{"x-amz-grant-read": `id="bob"`}), 200, "put object ACL")
+	expect(do("bob", "GET", "/acls/private.txt", "", nil), 200, "read of object granted to bob")
+	expect(do("alice", "PUT", "/acls/private.txt?acl", "", map[string]string{"x-amz-grant-read": `emailAddress="bob@example.com"`}), 400, "grant to an email address")
+
+	// BucketOwnerEnforced disables ACLs, leaving authenticated keys in control
+	expect(do("alice", "GET", "/acls?ownershipControls", "", nil), 404, "get missing ownership controls")
+	controls := `<OwnershipControls xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>`
+	expect(do("alice", "PUT", "/acls?ownershipControls", controls, nil), 200, "put ownership controls")
+	rr = do("alice", "GET", "/acls?ownershipControls", "", nil)
+	expect(rr, 200, "get ownership controls")
+	if !strings.Contains(rr.Body.String(), "<ObjectOwnership>BucketOwnerEnforced</ObjectOwnership>") {
+		t.Fatalf("unexpected ownership controls: %s", rr.Body.String())
+	}
+	expect(do("bob", "PUT", "/acls/new.txt", "data", nil), 200, "write by another key with ACLs disabled")
+	expect(do("", "GET", "/acls/public.txt", "", nil), 403, "anonymous read with ACLs disabled")
+	expect(do("alice", "PUT", "/acls/other.txt", "data", map[string]string{"x-amz-acl": acl.PublicRead}), 400, "public ACL with ACLs disabled")
+
+	expect(do("alice", "DELETE", "/acls?ownershipControls", "", nil), 204, "delete ownership controls")
+	expect(do("", "GET", "/acls/public.txt", "", nil), 200, "anonymous read with ACLs enabled again")
+}
@@ -0,0 +1,98 @@
+package s3api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/streams"
+)
+
+// minTransferBytes is reserved from the byte budget by every transfer, as
+// the object store chunk it buffers
+const minTransferBytes = 128 * 1024
+
+// transferOperations are the operations moving object data, which are
+// subject to admission control.
+var transferOperations = map[string]bool{
+	"PutObject":               true,
+	"CopyObject":              true,
+	"GetObject":               true,
+	"UploadPart":              true,
+	"UploadPartCopy":          true,
+	"CompleteMultipartUpload": true,
+	"SelectObjectContent":     true,
+}
+
+// admit holds transfers back until they fit the concurrency limit and byte
+// budget of the gateway, failing them with 503 SlowDown when the queue is
+// full or they waited too long.
+func (s *S3Gateway) admit(next http.HandlerFunc) http.HandlerFunc {
+	if s.admission == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		operation := s3Operation(r)
+		if !transferOperations[operation] {
+			next(w, r)
+			return
+		}
+
+		size, r := s.transferBytes(r, operation)
+		release, err := s.admission.Acquire(r.Context(), size)
+		if err != nil {
+			logging.Info(logging.WithContext(r.Context(), s.logger), "msg", "Transfer not admitted", "operation", operation, "err", err)
+			model.WriteErrorResponse(w, r, model.ErrSlowDown)
+			return
+		}
+		defer release()
+		next(w, r)
+	}
+}
+
+// transferBytes returns the bytes a transfer reserves from the byte budget,
+// and at least one chunk: the declared body size of uploads and the size of
+// the object or range read or copied. A completion only writes a manifest
+// and reserves a chunk. Sizes that cannot be looked up are left to the
+// handler to report.
+//
+// The object read is looked up once: the returned request carries the
+// lookup, which the handler reads the object with.
+func (s *S3Gateway) transferBytes(r *http.Request, operation string) (int64, *http.Request) {
+	vars := mux.Vars(r)
+	var size int64
+	switch operation {
+	case "GetObject", "SelectObjectContent":
+		size, r = s.objectBytes(r, vars["bucket"], vars["key"], r.Header.Get("Range"))
+	case "CopyObject", "UploadPartCopy":
+		if bucket, key, err := parseCopySource(r.Header.Get("x-amz-copy-source")); err == nil {
+			size, r = s.objectBytes(r, bucket, key, r.Header.Get("x-amz-copy-source-range"))
+		}
+	case "CompleteMultipartUpload":
+	default:
+		size = r.ContentLength
+		if dl, ok := streams.DecodedContentLength(r); ok {
+			size = dl
+		}
+	}
+	return max(size, minTransferBytes), r
+}
+
+// objectBytes returns the size of an object, or of the range of it a
+// request reads, with the request carrying the lookup of the object.
+func (s *S3Gateway) objectBytes(r *http.Request, bucket, key, rangeHeader string) (int64, *http.Request) {
+	ctx, info, err := s.client.ResolveObject(r.Context(), bucket, key)
+	if err != nil {
+		return 0, r
+	}
+	r = r.WithContext(ctx)
+	if rangeHeader == "" {
+		return int64(info.Size), r
+	}
+	start, end, err := parseRangeHeader(rangeHeader, int(info.Size))
+	if err != nil {
+		return 0, r
+	}
+	return int64(end - start + 1), r
+}
@@ -0,0 +1,80 @@
+package s3api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestTransferBytes(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(method, target, bytes.NewReader(body)))
+		return rr
+	}
+	if rr := do(http.MethodPut, "/budget", nil); rr.Code != http.StatusOK {
+		t.Fatalf("create bucket: %d", rr.Code)
+	}
+	const size = 300 * 1024
+	if rr := do(http.MethodPut, "/budget/object", make([]byte, size)); rr.Code != http.StatusOK {
+		t.Fatalf("put object: %d", rr.Code)
+	}
+	rr := do(http.MethodPost, "/budget/multi?uploads=", nil)
+	var ir initResp
+	if err := xml.Unmarshal(rr.Body.Bytes(), &ir); err != nil {
+		t.Fatalf("initiate multipart upload: %v", err)
+	}
+	for part := 1; part <= 2; part++ {
+		target := fmt.Sprintf("/budget/multi?uploadId=%s&partNumber=%d", ir.UploadId, part)
+		if rr := do(http.MethodPut, target, make([]byte, size)); rr.Code != http.StatusOK {
+			t.Fatalf("upload part %d: %d", part, rr.Code)
+		}
+	}
+
+	request := func(method, key string, headers map[string]string, vars map[string]string) *http.Request {
+		req := httptest.NewRequest(method, "/budget/"+key, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		vars["bucket"], vars["key"] = "budget", key
+		return mux.SetURLVars(req, vars)
+	}
+	upload := httptest.NewRequest(http.MethodPut, "/budget/new", bytes.NewReader(make([]byte, size)))
+	upload = mux.SetURLVars(upload, map[string]string{"bucket": "budget", "key": "new"})
+
+	for _, tc := range []struct {
+		name      string
+		operation string
+		req       *http.Request
+		want      int64
+	}{
+		{"upload", "PutObject", upload, size},
+		{"get", "GetObject", request(http.MethodGet, "object", nil, map[string]string{}), size},
+		{"get range", "GetObject", request(http.MethodGet, "object", map[string]string{"Range": "bytes=0-199999"}, map[string]string{}), 200000},
+		{"get missing", "GetObject", request(http.MethodGet, "missing", nil, map[string]string{}), minTransferBytes},
+		{"copy", "CopyObject", request(http.MethodPut, "copy", map[string]string{"x-amz-copy-source": "/budget/object"}, map[string]string{}), size},
+		{"complete", "CompleteMultipartUpload", request(http.MethodPost, "multi", nil, map[string]string{"uploadId": ir.UploadId}), minTransferBytes},
+	} {
+		if got, _ := gw.transferBytes(tc.req, tc.operation); got != tc.want {
+			t.Errorf("%s: expected %d bytes, got %d", tc.name, tc.want, got)
+		}
+	}
+}
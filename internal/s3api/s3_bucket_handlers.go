@@ -5,15 +5,22 @@ import (
 	"encoding/xml"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gorilla/mux"
 	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/compression"
 	"github.com/wpnpeiris/nats-s3/internal/model"
 )
 
+const (
+	bucketCompressionHeader             = "x-nats-s3-compression"
+	bucketCompressionContentTypesHeader = "x-nats-s3-compression-content-types"
+)
+
 // BucketsResult is the XML envelope for ListBuckets responses.
 type BucketsResult struct {
 	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
@@ -28,19 +35,61 @@ type LocationConstraintResponse struct {
 }
 
 // CreateBucket handles S3 CreateBucket by creating a JetStream Object Store
-// bucket and returning a minimal S3-compatible XML response.
+// bucket and returning a minimal S3-compatible XML response. The JetStream
+// settings of the bucket (storage, replicas, limits and placement) are taken
+// from the CreateBucketConfiguration body and x-nats-* headers. Compression
+// at rest can be enabled for the bucket with the x-nats-s3-compression
+// header, optionally limited to the content types listed in
+// x-nats-s3-compression-content-types. The caller owns the bucket, whose ACL
+// and object ownership are set with the x-amz-acl, x-amz-grant-* and
+// x-amz-object-ownership headers.
 func (s *S3Gateway) CreateBucket(w http.ResponseWriter, r *http.Request) {
 	bucket := mux.Vars(r)["bucket"]
-	os, err := s.client.CreateBucket(r.Context(), bucket)
+	compression, errCode := readBucketCompression(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	opts, errCode := readBucketOptions(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	owner := requestAccessKey(r)
+	bucketACL, ownership, errCode := readBucketACL(r, owner)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+
+	os, err := s.client.CreateBucket(r.Context(), bucket, opts)
 	if err != nil {
 		if errors.Is(err, client.ErrBucketAlreadyExists) {
 			model.WriteErrorResponse(w, r, model.ErrBucketAlreadyOwnedByYou)
 			return
 		}
+		if errors.Is(err, client.ErrInvalidBucketOptions) {
+			model.WriteErrorResponse(w, r, model.ErrInvalidBucketOptions)
+			return
+		}
 		model.WriteErrorResponse(w, r, model.ErrInternalError)
 		return
 	}
 
+	if compression != nil || owner != "" || bucketACL != nil || ownership != "" {
+		err = s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+			cfg.Compression = compression
+			cfg.Owner = owner
+			cfg.ACL = bucketACL
+			cfg.ObjectOwnership = ownership
+			return nil
+		})
+		if err != nil {
+			model.WriteErrorResponse(w, r, model.ErrInternalError)
+			return
+		}
+	}
+
 	buckets := []*s3.Bucket{{
 		Name:         aws.String(os.Bucket()),
 		CreationDate: aws.Time(time.Now()),
@@ -170,3 +219,22 @@ func (s *S3Gateway) bucketExists(ctx context.Context, bucket string) error {
 	}
 	return nil
 }
+
+// readBucketCompression parses the compression headers of a CreateBucket
+// request. It returns nil when the request does not configure compression.
+func readBucketCompression(r *http.Request) (*client.CompressionOptions, model.ErrorCode) {
+	algorithm := r.Header.Get(bucketCompressionHeader)
+	if algorithm == "" {
+		return nil, model.ErrNone
+	}
+	if !compression.Supported(algorithm) {
+		return nil, model.ErrInvalidCompressionAlgorithm
+	}
+	opts := &client.CompressionOptions{Algorithm: algorithm}
+	for _, ct := range strings.Split(r.Header.Get(bucketCompressionContentTypesHeader), ",") {
+		if ct = strings.TrimSpace(ct); ct != "" {
+			opts.ContentTypes = append(opts.ContentTypes, ct)
+		}
+	}
+	return opts, model.ErrNone
+}
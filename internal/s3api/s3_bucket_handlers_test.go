@@ -1,15 +1,22 @@
 package s3api
 
 import (
+	"context"
 	"encoding/xml"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/wpnpeiris/nats-s3/internal/client"
 	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
 	"github.com/wpnpeiris/nats-s3/internal/testutil"
 
 	"github.com/gorilla/mux"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 func TestListBuckets(t *testing.T) {
@@ -17,7 +24,7 @@ func TestListBuckets(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -76,7 +83,7 @@ func TestCreateBucket(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -112,7 +119,7 @@ func TestCreateBucketDuplicateFails(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -147,7 +154,7 @@ func TestCreateBucket_Replicated(t *testing.T) {
 	s := servers[0]
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 3, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 3, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -191,3 +198,510 @@ func TestCreateBucket_Replicated(t *testing.T) {
 		t.Errorf("Expected 3 replicas, got %d", objStoreStatus.Replicas())
 	}
 }
+
+func TestCreateBucket_Compression(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	req := httptest.NewRequest("PUT", "/bad-bucket", nil)
+	req.Header.Set("x-nats-s3-compression", "gzip")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 400 {
+		t.Fatalf("expected 400 for unsupported compression, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	bucket := "compressed-bucket"
+	req = httptest.NewRequest("PUT", "/"+bucket, nil)
+	req.Header.Set("x-nats-s3-compression", "s2")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("unexpected status: got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	body := strings.Repeat("compressible line of text\n", 10000)
+	req = httptest.NewRequest("PUT", "/"+bucket+"/notes.txt", strings.NewReader(body))
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("unexpected put status: got %d body=%s", rr.Code, rr.Body.String())
+	}
+	etag := rr.Header().Get("ETag")
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	objStore, err := js.ObjectStore(bucket)
+	if err != nil {
+		t.Fatalf("open object store failed: %v", err)
+	}
+	raw, err := objStore.GetInfo("notes.txt")
+	if err != nil || raw.Size >= uint64(len(body)) {
+		t.Fatalf("expected compressed object data (err=%v)", err)
+	}
+
+	req = httptest.NewRequest("GET", "/"+bucket+"/notes.txt", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 || rr.Body.String() != body {
+		t.Fatalf("unexpected get: status %d, %d bytes", rr.Code, rr.Body.Len())
+	}
+	if rr.Header().Get("Content-Length") != strconv.Itoa(len(body)) || rr.Header().Get("ETag") != etag {
+		t.Fatalf("unexpected headers: %v", rr.Header())
+	}
+
+	req = httptest.NewRequest("GET", "/"+bucket+"/notes.txt", nil)
+	req.Header.Set("Range", "bytes=26-51")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 206 || rr.Body.String() != body[26:52] {
+		t.Fatalf("unexpected range get: status %d body=%q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateBucket_Options(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	body := `<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Storage>file</Storage>
+  <MaxBytes>1048576</MaxBytes>
+  <TTL>24h</TTL>
+  <Description>scratch space</Description>
+</CreateBucketConfiguration>`
+	req := httptest.NewRequest("PUT", "/scratch", strings.NewReader(body))
+	// Headers take precedence over the body
+	req.Header.Set("x-nats-storage", "memory")
+	req.Header.Set("x-nats-stream-compression", "true")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("unexpected status: got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/scratch?info", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("unexpected info status: got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var info struct {
+		Storage           string `xml:"Storage"`
+		Replicas          int    `xml:"Replicas"`
+		MaxBytes          int64  `xml:"MaxBytes"`
+		TTL               string `xml:"TTL"`
+		StreamCompression bool   `xml:"StreamCompression"`
+		Description       string `xml:"Description"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatalf("unmarshal xml failed: %v\nxml=%s", err, rr.Body.String())
+	}
+	if info.Storage != "memory" || info.Replicas != 1 || info.MaxBytes != 1048576 ||
+		info.TTL != "24h0m0s" || !info.StreamCompression || info.Description != "scratch space" {
+		t.Fatalf("unexpected bucket info: %+v", info)
+	}
+
+	// A single server cannot place a replicated bucket
+	req = httptest.NewRequest("PUT", "/critical", nil)
+	req.Header.Set("x-nats-replicas", "3")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 400 {
+		t.Fatalf("expected 400 for unplaceable replicas, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("PUT", "/bad", nil)
+	req.Header.Set("x-nats-storage", "tape")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 400 {
+		t.Fatalf("expected 400 for unknown storage, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/missing?info", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 404 {
+		t.Fatalf("expected 404 for missing bucket, got %d", rr.Code)
+	}
+}
+
+func TestBucketQuota(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do("PUT", "/quota-bucket", ""); rr.Code != 200 {
+		t.Fatalf("create bucket failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("GET", "/quota-bucket?quota", ""); rr.Code != 404 {
+		t.Fatalf("expected 404 without quota, got %d", rr.Code)
+	}
+	quota := `<BucketQuota xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <HardBytes>100</HardBytes>
+  <SoftBytes>50</SoftBytes>
+  <HardObjects>3</HardObjects>
+</BucketQuota>`
+	if rr := do("PUT", "/quota-bucket?quota", quota); rr.Code != 200 {
+		t.Fatalf("put quota failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	chunk := strings.Repeat("x", 40)
+	if rr := do("PUT", "/quota-bucket/a", chunk); rr.Code != 200 {
+		t.Fatalf("upload a failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("PUT", "/quota-bucket/b", chunk); rr.Code != 200 {
+		t.Fatalf("upload b failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do("GET", "/quota-bucket?quota", "")
+	var got struct {
+		HardBytes int64 `xml:"HardBytes"`
+		Usage     struct {
+			Bytes             int64 `xml:"Bytes"`
+			Objects           int64 `xml:"Objects"`
+			SoftLimitExceeded bool  `xml:"SoftLimitExceeded"`
+		} `xml:"Usage"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal xml failed: %v\nxml=%s", err, rr.Body.String())
+	}
+	if got.HardBytes != 100 || got.Usage.Bytes != 80 || got.Usage.Objects != 2 || !got.Usage.SoftLimitExceeded {
+		t.Fatalf("unexpected quota: %+v", got)
+	}
+
+	// The hard byte limit rejects the upload before accepting it
+	rr = do("PUT", "/quota-bucket/c", chunk)
+	if rr.Code != 403 || !strings.Contains(rr.Body.String(), "QuotaExceeded") {
+		t.Fatalf("expected QuotaExceeded, got %d %s", rr.Code, rr.Body.String())
+	}
+	// Replacing an object only counts the size difference
+	if rr := do("PUT", "/quota-bucket/b", strings.Repeat("y", 60)); rr.Code != 200 {
+		t.Fatalf("overwrite b failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := do("DELETE", "/quota-bucket?quota", ""); rr.Code != 204 {
+		t.Fatalf("delete quota failed: %d", rr.Code)
+	}
+	if rr := do("PUT", "/quota-bucket/c", chunk); rr.Code != 200 {
+		t.Fatalf("upload without quota failed: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestBucketQuota_MultipartAndChunked(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	quotaExceeded := func(rr *httptest.ResponseRecorder) bool {
+		return rr.Code == 403 && strings.Contains(rr.Body.String(), "QuotaExceeded")
+	}
+
+	if rr := do("PUT", "/mp-quota", ""); rr.Code != 200 {
+		t.Fatalf("create bucket failed: %d %s", rr.Code, rr.Body.String())
+	}
+	quota := `<BucketQuota xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <HardBytes>100</HardBytes>
+  <HardObjects>2</HardObjects>
+</BucketQuota>`
+	if rr := do("PUT", "/mp-quota?quota", quota); rr.Code != 200 {
+		t.Fatalf("put quota failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do("POST", "/mp-quota/big?uploads=", "")
+	var ir initResp
+	if err := xml.Unmarshal(rr.Body.Bytes(), &ir); err != nil || ir.UploadId == "" {
+		t.Fatalf("initiate failed: %d %s", rr.Code, rr.Body.String())
+	}
+	partURL := func(n int) string {
+		return "/mp-quota/big?uploadId=" + ir.UploadId + "&partNumber=" + strconv.Itoa(n)
+	}
+
+	// Parts add up towards the quota as they are uploaded
+	if rr := do("PUT", partURL(1), strings.Repeat("a", 60)); rr.Code != 200 {
+		t.Fatalf("upload part 1 failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("PUT", partURL(2), strings.Repeat("b", 60)); !quotaExceeded(rr) {
+		t.Fatalf("expected QuotaExceeded for part 2, got %d %s", rr.Code, rr.Body.String())
+	}
+	// Uploading a part again only counts the size difference
+	if rr := do("PUT", partURL(1), strings.Repeat("a", 30)); rr.Code != 200 {
+		t.Fatalf("re-upload part 1 failed: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = do("PUT", partURL(2), strings.Repeat("b", 60))
+	if rr.Code != 200 {
+		t.Fatalf("upload part 2 failed: %d %s", rr.Code, rr.Body.String())
+	}
+	complete := "<CompleteMultipartUpload><Part><PartNumber>2</PartNumber><ETag>" + rr.Header()["ETag"][0] + "</ETag></Part></CompleteMultipartUpload>"
+	if rr := do("POST", "/mp-quota/big?uploadId="+ir.UploadId, complete); rr.Code != 200 {
+		t.Fatalf("complete failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// The part left out of the object is released
+	rr = do("GET", "/mp-quota?quota", "")
+	var got struct {
+		Usage struct {
+			Bytes   int64 `xml:"Bytes"`
+			Objects int64 `xml:"Objects"`
+		} `xml:"Usage"`
+	}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal xml failed: %v\nxml=%s", err, rr.Body.String())
+	}
+	if got.Usage.Bytes != 60 || got.Usage.Objects != 1 {
+		t.Fatalf("unexpected usage: %+v", got.Usage)
+	}
+
+	// Streamed uploads without a decoded length are held to the bytes actually written
+	chunked := func(data string) *httptest.ResponseRecorder {
+		body := strconv.FormatInt(int64(len(data)), 16) + ";chunk-signature=0\r\n" + data + "\r\n0;chunk-signature=0\r\n\r\n"
+		req := httptest.NewRequest("PUT", "/mp-quota/chunked", strings.NewReader(body))
+		req.Header.Set("x-amz-content-sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := chunked(strings.Repeat("c", 50)); !quotaExceeded(rr) {
+		t.Fatalf("expected QuotaExceeded for streamed upload, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := chunked(strings.Repeat("c", 40)); rr.Code != 200 {
+		t.Fatalf("streamed upload within quota failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Completing an upload is checked against the object limit
+	rr = do("POST", "/mp-quota/third?uploads=", "")
+	if err := xml.Unmarshal(rr.Body.Bytes(), &ir); err != nil || ir.UploadId == "" {
+		t.Fatalf("initiate failed: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = do("PUT", "/mp-quota/third?uploadId="+ir.UploadId+"&partNumber=1", "")
+	if rr.Code != 200 {
+		t.Fatalf("upload part failed: %d %s", rr.Code, rr.Body.String())
+	}
+	complete = "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>" + rr.Header()["ETag"][0] + "</ETag></Part></CompleteMultipartUpload>"
+	if rr := do("POST", "/mp-quota/third?uploadId="+ir.UploadId, complete); !quotaExceeded(rr) {
+		t.Fatalf("expected QuotaExceeded on completion, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestBucketQuota_FullStream(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	req := httptest.NewRequest("PUT", "/small", nil)
+	req.Header.Set("x-nats-max-bytes", "10000")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("create bucket failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A full JetStream stream is reported as QuotaExceeded, not InternalError
+	req = httptest.NewRequest("PUT", "/small/big", strings.NewReader(strings.Repeat("x", 50000)))
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 403 || !strings.Contains(rr.Body.String(), "QuotaExceeded") {
+		t.Fatalf("expected QuotaExceeded, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestBucketReplication(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do("PUT", "/edge", ""); rr.Code != 200 {
+		t.Fatalf("create bucket failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("GET", "/edge?replication", ""); rr.Code != 404 {
+		t.Fatalf("expected 404 without replication, got %d", rr.Code)
+	}
+	if rr := do("PUT", "/edge/a.txt", "hello"); rr.Code != 200 {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	replication := `<ReplicationConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Rule>
+    <ID>to-core</ID>
+    <Status>Enabled</Status>
+    <Destination>
+      <Bucket>arn:aws:s3:::core</Bucket>
+      <ReadFailover>true</ReadFailover>
+    </Destination>
+  </Rule>
+</ReplicationConfiguration>`
+	if rr := do("PUT", "/edge?replication", replication); rr.Code != 200 {
+		t.Fatalf("put replication failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do("GET", "/edge?replication", "")
+	var got model.ReplicationConfiguration
+	if err := xml.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal xml failed: %v\nxml=%s", err, rr.Body.String())
+	}
+	if len(got.Rules) != 1 || got.Rules[0].ID != "to-core" || got.Rules[0].Destination.Bucket != "arn:aws:s3:::core" ||
+		!got.Rules[0].Destination.ReadFailover {
+		t.Fatalf("unexpected replication: %+v", got)
+	}
+
+	// Objects written before and after the replication started reach the replica
+	if rr := do("PUT", "/edge/b.txt", "world"); rr.Code != 200 {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, key := range []string{"a.txt", "b.txt"} {
+		var status string
+		for i := 0; i < 100 && status != "COMPLETED"; i++ {
+			status = do("HEAD", "/edge/"+key, "").Header().Get("x-amz-replication-status")
+			if status != "COMPLETED" {
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+		if status != "COMPLETED" {
+			t.Fatalf("expected %s to replicate, got status %q", key, status)
+		}
+	}
+
+	// A multipart object is replicated once the replica has caught up past its
+	// manifest, which its parts precede
+	rr = do("POST", "/edge/mp.txt?uploads=", "")
+	var ir initResp
+	if err := xml.Unmarshal(rr.Body.Bytes(), &ir); err != nil || ir.UploadId == "" {
+		t.Fatalf("initiate failed: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = do("PUT", "/edge/mp.txt?uploadId="+ir.UploadId+"&partNumber=1", "parts")
+	if rr.Code != 200 {
+		t.Fatalf("upload part failed: %d %s", rr.Code, rr.Body.String())
+	}
+	complete := "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>" + rr.Header()["ETag"][0] + "</ETag></Part></CompleteMultipartUpload>"
+	if rr := do("POST", "/edge/mp.txt?uploadId="+ir.UploadId, complete); rr.Code != 200 {
+		t.Fatalf("complete failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var status string
+	for i := 0; i < 100 && status != "COMPLETED"; i++ {
+		status = do("HEAD", "/edge/mp.txt", "").Header().Get("x-amz-replication-status")
+		if status != "COMPLETED" {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if status != "COMPLETED" {
+		t.Fatalf("expected mp.txt to replicate, got status %q", status)
+	}
+	rr = do("GET", "/core/b.txt", "")
+	if rr.Code != 200 || rr.Body.String() != "world" {
+		t.Fatalf("get replica failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if status := rr.Header().Get("x-amz-replication-status"); status != "REPLICA" {
+		t.Fatalf("expected REPLICA status, got %q", status)
+	}
+
+	// Reads fail over to the replica while the source stream cannot be reached
+	unreachable, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{
+		BucketRoutes: []client.BucketRoute{{Bucket: "edge", Domain: "unreachable"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	ur := mux.NewRouter()
+	unreachable.RegisterRoutes(ur)
+	req := httptest.NewRequest("GET", "/edge/a.txt", nil)
+	rr = httptest.NewRecorder()
+	ur.ServeHTTP(rr, req)
+	if rr.Code != 200 || rr.Body.String() != "hello" {
+		t.Fatalf("expected read failover, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A bucket that no longer exists is not failed over
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("jetstream failed: %v", err)
+	}
+	if err := js.DeleteStream(context.Background(), "OBJ_edge"); err != nil {
+		t.Fatalf("delete stream failed: %v", err)
+	}
+	if rr := do("GET", "/edge/a.txt", ""); rr.Code != 404 {
+		t.Fatalf("expected NoSuchBucket for a deleted bucket, got %d %s", rr.Code, rr.Body.String())
+	}
+}
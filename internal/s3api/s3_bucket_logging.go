@@ -0,0 +1,79 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+// GetBucketLogging returns the access logging of a bucket. A bucket without
+// logging returns an empty BucketLoggingStatus.
+func (s *S3Gateway) GetBucketLogging(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	response := model.BucketLoggingStatus{}
+	if cfg.Logging != nil {
+		response.LoggingEnabled = &model.LoggingEnabled{
+			TargetBucket: cfg.Logging.TargetBucket,
+			TargetPrefix: cfg.Logging.TargetPrefix,
+		}
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, response)
+}
+
+// PutBucketLogging delivers the access logs of a bucket to a target bucket
+// and prefix. A BucketLoggingStatus without LoggingEnabled disables logging.
+func (s *S3Gateway) PutBucketLogging(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutBucketLogging: bucket=%s", bucket))
+
+	var status model.BucketLoggingStatus
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&status); err != nil {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+
+	var target *client.BucketLogging
+	if enabled := status.LoggingEnabled; enabled != nil {
+		if enabled.TargetBucket == "" {
+			model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+			return
+		}
+		_, err := s.client.GetBucketConfig(r.Context(), enabled.TargetBucket)
+		if errors.Is(err, client.ErrBucketNotFound) {
+			model.WriteErrorResponse(w, r, model.ErrInvalidTargetBucketForLogging)
+			return
+		}
+		if s.handleObjectError(w, r, err) {
+			return
+		}
+		target = &client.BucketLogging{
+			TargetBucket: enabled.TargetBucket,
+			TargetPrefix: enabled.TargetPrefix,
+		}
+	}
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Logging = target
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	s.loggingTargets.invalidate(bucket)
+
+	w.WriteHeader(http.StatusOK)
+}
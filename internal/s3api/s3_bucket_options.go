@@ -0,0 +1,159 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+// Headers setting the JetStream options of a new bucket. They take
+// precedence over the CreateBucketConfiguration body. Stream compression is
+// applied by JetStream to the whole stream, unlike the per-object compression
+// of the x-nats-s3-compression header.
+const (
+	bucketStorageHeader          = "x-nats-storage"
+	bucketReplicasHeader         = "x-nats-replicas"
+	bucketMaxBytesHeader         = "x-nats-max-bytes"
+	bucketTTLHeader              = "x-nats-ttl"
+	bucketStreamCompressHeader   = "x-nats-stream-compression"
+	bucketDescriptionHeader      = "x-nats-description"
+	bucketPlacementClusterHeader = "x-nats-placement-cluster"
+	bucketPlacementTagsHeader    = "x-nats-placement-tags"
+)
+
+// readBucketOptions parses the JetStream options of a CreateBucket request
+// from its CreateBucketConfiguration body and x-nats-* headers.
+func readBucketOptions(r *http.Request) (client.BucketOptions, model.ErrorCode) {
+	var cfg model.CreateBucketConfiguration
+	if r.Body != nil {
+		err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&cfg)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return client.BucketOptions{}, model.ErrMalformedXML
+		}
+	}
+
+	h := r.Header
+	if v := h.Get(bucketStorageHeader); v != "" {
+		cfg.Storage = v
+	}
+	if v := h.Get(bucketReplicasHeader); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return client.BucketOptions{}, model.ErrInvalidBucketOptions
+		}
+		cfg.Replicas = n
+	}
+	if v := h.Get(bucketMaxBytesHeader); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return client.BucketOptions{}, model.ErrInvalidBucketOptions
+		}
+		cfg.MaxBytes = n
+	}
+	if v := h.Get(bucketTTLHeader); v != "" {
+		cfg.TTL = v
+	}
+	if v := h.Get(bucketStreamCompressHeader); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return client.BucketOptions{}, model.ErrInvalidBucketOptions
+		}
+		cfg.StreamCompression = b
+	}
+	if v := h.Get(bucketDescriptionHeader); v != "" {
+		cfg.Description = v
+	}
+	if v := h.Get(bucketPlacementClusterHeader); v != "" {
+		if cfg.Placement == nil {
+			cfg.Placement = &model.BucketPlacement{}
+		}
+		cfg.Placement.Cluster = v
+	}
+	if v := h.Get(bucketPlacementTagsHeader); v != "" {
+		if cfg.Placement == nil {
+			cfg.Placement = &model.BucketPlacement{}
+		}
+		cfg.Placement.Tags = nil
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				cfg.Placement.Tags = append(cfg.Placement.Tags, tag)
+			}
+		}
+	}
+
+	opts := client.BucketOptions{
+		Replicas:    cfg.Replicas,
+		MaxBytes:    cfg.MaxBytes,
+		Compression: cfg.StreamCompression,
+		Description: cfg.Description,
+	}
+	switch strings.ToLower(cfg.Storage) {
+	case "", "file":
+		opts.Storage = jetstream.FileStorage
+	case "memory":
+		opts.Storage = jetstream.MemoryStorage
+	default:
+		return client.BucketOptions{}, model.ErrInvalidBucketOptions
+	}
+	if cfg.TTL != "" {
+		ttl, err := time.ParseDuration(cfg.TTL)
+		if err != nil {
+			return client.BucketOptions{}, model.ErrInvalidBucketOptions
+		}
+		opts.TTL = ttl
+	}
+	if cfg.Placement != nil && (cfg.Placement.Cluster != "" || len(cfg.Placement.Tags) > 0) {
+		opts.Placement = &jetstream.Placement{Cluster: cfg.Placement.Cluster, Tags: cfg.Placement.Tags}
+	}
+	if err := opts.Validate(); err != nil {
+		return client.BucketOptions{}, model.ErrInvalidBucketOptions
+	}
+	return opts, model.ErrNone
+}
+
+// GetBucketInfo returns the JetStream settings and usage of a bucket.
+func (s *S3Gateway) GetBucketInfo(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("GetBucketInfo: bucket=%s", bucket))
+
+	info, err := s.client.GetBucketInfo(r.Context(), bucket)
+	if err != nil {
+		if errors.Is(err, client.ErrBucketNotFound) {
+			model.WriteErrorResponse(w, r, model.ErrNoSuchBucket)
+			return
+		}
+		model.WriteErrorResponse(w, r, model.ErrInternalError)
+		return
+	}
+
+	response := model.BucketInfo{
+		Name:              info.Name,
+		Storage:           strings.ToLower(info.Storage.String()),
+		Replicas:          info.Replicas,
+		MaxBytes:          info.MaxBytes,
+		TTL:               info.TTL.String(),
+		StreamCompression: info.Compression,
+		Description:       info.Description,
+		Size:              info.Size,
+		Sealed:            info.Sealed,
+	}
+	if info.Placement != nil {
+		response.Placement = &model.BucketPlacement{
+			Cluster: info.Placement.Cluster,
+			Tags:    info.Placement.Tags,
+		}
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,94 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+// GetBucketQuota returns the quota of a bucket together with its current
+// usage.
+func (s *S3Gateway) GetBucketQuota(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if cfg.Quota == nil {
+		model.WriteErrorResponse(w, r, model.ErrNoSuchBucketQuota)
+		return
+	}
+	usage, err := s.client.GetBucketUsage(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	response := model.BucketQuota{
+		HardBytes:   cfg.Quota.HardBytes,
+		SoftBytes:   cfg.Quota.SoftBytes,
+		HardObjects: cfg.Quota.HardObjects,
+		SoftObjects: cfg.Quota.SoftObjects,
+		Usage: &model.BucketUsage{
+			Bytes:             usage.Bytes,
+			Objects:           usage.Objects,
+			SoftLimitExceeded: cfg.Quota.SoftExceeded(*usage),
+		},
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, response)
+}
+
+// PutBucketQuota sets the hard and soft limits of a bucket.
+func (s *S3Gateway) PutBucketQuota(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutBucketQuota: bucket=%s", bucket))
+
+	var quota model.BucketQuota
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&quota); err != nil {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+	if quota.HardBytes < 0 || quota.SoftBytes < 0 || quota.HardObjects < 0 || quota.SoftObjects < 0 {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Quota = &client.BucketQuota{
+			HardBytes:   quota.HardBytes,
+			SoftBytes:   quota.SoftBytes,
+			HardObjects: quota.HardObjects,
+			SoftObjects: quota.SoftObjects,
+		}
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteBucketQuota removes the quota of a bucket.
+func (s *S3Gateway) DeleteBucketQuota(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("DeleteBucketQuota: bucket=%s", bucket))
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Quota = nil
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	model.WriteEmptyResponse(w, r, http.StatusNoContent)
+}
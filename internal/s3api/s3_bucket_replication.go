@@ -0,0 +1,122 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+const (
+	replicationStatusHeader = "x-amz-replication-status"
+	bucketARNPrefix         = "arn:aws:s3:::"
+)
+
+// Status of a replication rule.
+const (
+	replicationRuleEnabled  = "Enabled"
+	replicationRuleDisabled = "Disabled"
+)
+
+// GetBucketReplication returns the replication configuration of a bucket.
+func (s *S3Gateway) GetBucketReplication(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	rep, err := s.client.GetBucketReplication(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if rep == nil {
+		model.WriteErrorResponse(w, r, model.ErrReplicationConfigurationNotFound)
+		return
+	}
+
+	response := model.ReplicationConfiguration{
+		Rules: []model.ReplicationRule{{
+			ID:     rep.ID,
+			Status: replicationRuleEnabled,
+			Destination: model.ReplicationDestination{
+				Bucket:       bucketARNPrefix + rep.DestinationBucket,
+				Domain:       rep.Domain,
+				Cluster:      rep.Cluster,
+				ReadFailover: rep.ReadFailover,
+			},
+		}},
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, response)
+}
+
+// PutBucketReplication starts replicating a bucket into the destination of
+// its single rule. A disabled rule stops the replication.
+func (s *S3Gateway) PutBucketReplication(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutBucketReplication: bucket=%s", bucket))
+
+	var cfg model.ReplicationConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&cfg); err != nil {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+	if len(cfg.Rules) != 1 {
+		model.WriteErrorResponse(w, r, model.ErrInvalidReplicationConfiguration)
+		return
+	}
+	rule := cfg.Rules[0]
+	if rule.Prefix != "" || (rule.Filter != nil && rule.Filter.Prefix != "") {
+		model.WriteErrorResponse(w, r, model.ErrInvalidReplicationConfiguration)
+		return
+	}
+
+	var err error
+	switch rule.Status {
+	case replicationRuleEnabled:
+		err = s.client.PutBucketReplication(r.Context(), bucket, client.BucketReplication{
+			ID:                rule.ID,
+			DestinationBucket: strings.TrimPrefix(rule.Destination.Bucket, bucketARNPrefix),
+			Domain:            rule.Destination.Domain,
+			Cluster:           rule.Destination.Cluster,
+			ReadFailover:      rule.Destination.ReadFailover,
+		})
+	case replicationRuleDisabled:
+		err = s.client.DeleteBucketReplication(r.Context(), bucket)
+	default:
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteBucketReplication stops replicating a bucket. Objects already
+// replicated stay in the destination.
+func (s *S3Gateway) DeleteBucketReplication(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("DeleteBucketReplication: bucket=%s", bucket))
+
+	err := s.client.DeleteBucketReplication(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	model.WriteEmptyResponse(w, r, http.StatusNoContent)
+}
+
+// updateReplicationHeader writes the replication status of an object of a
+// replicated bucket.
+func (s *S3Gateway) updateReplicationHeader(r *http.Request, bucket string, obj *jetstream.ObjectInfo, w http.ResponseWriter) {
+	if status := s.client.ObjectReplicationStatus(r.Context(), bucket, obj); status != "" {
+		w.Header().Set(replicationStatusHeader, status)
+	}
+}
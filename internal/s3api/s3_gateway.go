@@ -1,30 +1,105 @@
 package s3api
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net/http"
+	"regexp"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/gorilla/mux"
 	"github.com/nats-io/nats.go"
+	"github.com/wpnpeiris/nats-s3/internal/accesslog"
+	"github.com/wpnpeiris/nats-s3/internal/admission"
 	"github.com/wpnpeiris/nats-s3/internal/auth"
 	"github.com/wpnpeiris/nats-s3/internal/client"
 	"github.com/wpnpeiris/nats-s3/internal/credential"
 	"github.com/wpnpeiris/nats-s3/internal/interceptor"
+	"github.com/wpnpeiris/nats-s3/internal/kms"
 	"github.com/wpnpeiris/nats-s3/internal/logging"
 	"github.com/wpnpeiris/nats-s3/internal/metrics"
+	"github.com/wpnpeiris/nats-s3/internal/ratelimit"
 )
 
 // S3Gateway registers S3-compatible HTTP routes (2006-03-01) and delegates
 // implemented operations to NATS JetStream-backed object storage.
 // Unimplemented endpoints intentionally respond with HTTP 501 Not Implemented.
 type S3Gateway struct {
+	natsClient     *client.Client
+	routes         *client.BucketRouter
 	client         *client.NatsObjectClient
 	multiPartStore *client.MultiPartStore
 	iam            *auth.IdentityAccessManagement
 	logger         log.Logger
 	started        time.Time
+	// keepAliveInterval paces the whitespace sent by long-running requests
+	keepAliveInterval time.Duration
+	// encryptionConfigured reports whether a master key for SSE-S3 is loaded
+	encryptionConfigured bool
+	// encryptByDefault applies SSE-S3 to objects written without encryption
+	encryptByDefault bool
+	// kmsConfigured reports whether a KMS for SSE-KMS is available
+	kmsConfigured bool
+	// domains serve virtual-hosted-style requests for <bucket>.<domain>
+	domains []string
+	// websiteDomains serve the websites of buckets for <bucket>.<domain>
+	websiteDomains []string
+	// inflight tracks the requests being served
+	inflight inflightRequests
+	// shuttingDown fails health checks once a shutdown began
+	shuttingDown atomic.Bool
+	// requestMetrics records the requests served per S3 operation
+	requestMetrics *metrics.RequestMetrics
+	// accessLog delivers the access logs of buckets with logging enabled
+	accessLog *accesslog.Logger
+	// loggingTargets caches the logging configuration of buckets
+	loggingTargets loggingTargets
+	// hostID identifies the gateway in x-amz-id-2
+	hostID string
+	// limiter enforces the rate limits of callers
+	limiter *ratelimit.Limiter
+	// admission limits the concurrent transfers; nil admits all
+	admission *admission.Controller
+	// cache serves reads of small objects; nil disables caching
+	cache *client.ObjectCache
+}
+
+// S3GatewayOptions holds optional gateway settings.
+type S3GatewayOptions struct {
+	// MasterKey wraps the data keys of SSE-S3 objects; nil disables SSE-S3
+	MasterKey []byte
+	// EncryptByDefault encrypts objects with SSE-S3 when neither the request
+	// nor the bucket asks for encryption. Requires MasterKey.
+	EncryptByDefault bool
+	// KMS issues the data keys of SSE-KMS objects; nil disables SSE-KMS
+	KMS kms.KMS
+	// Compression compresses objects at rest in buckets that do not
+	// configure compression themselves
+	Compression client.CompressionOptions
+	// BucketRoutes send matching buckets to other JetStream domains or NATS
+	// connections
+	BucketRoutes []client.BucketRoute
+	// Domains serve virtual-hosted-style requests for <bucket>.<domain>
+	Domains []string
+	// WebsiteDomains serve the websites of buckets for <bucket>.<domain>
+	WebsiteDomains []string
+	// MetricsMaxBuckets limits the distinct buckets labeling request
+	// metrics; zero leaves the bucket label empty
+	MetricsMaxBuckets int
+	// AccessLog configures the delivery of access logs
+	AccessLog accesslog.Options
+	// RateLimits limit the requests and bandwidth of callers
+	RateLimits []ratelimit.Rule
+	// RateLimitShared keeps the rate limit state in JetStream, so that all
+	// gateways enforce the limits together
+	RateLimitShared bool
+	// Admission limits the concurrent transfers and the bytes they reserve
+	Admission admission.Options
+	// Cache serves reads of small objects from memory or local disk
+	Cache client.CacheOptions
 }
 
 // NewS3Gateway creates a gateway instance and establishes a connection to
@@ -33,7 +108,12 @@ func NewS3Gateway(logger log.Logger,
 	natsServers string,
 	replicas int,
 	natsOptions []nats.Option,
-	credStore credential.Store) (*S3Gateway, error) {
+	credStore credential.Store,
+	opts S3GatewayOptions) (*S3Gateway, error) {
+
+	if opts.EncryptByDefault && opts.MasterKey == nil {
+		return nil, fmt.Errorf("encrypt by default requires a master key")
+	}
 
 	natsClient := client.NewClient("s3-gateway")
 	err := natsClient.SetupConnectionToNATS(natsServers, natsOptions...)
@@ -41,16 +121,40 @@ func NewS3Gateway(logger log.Logger,
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
 
+	routes, err := client.NewBucketRouter(logger, natsClient, opts.BucketRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize bucket routes: %w", err)
+	}
+
+	var cache *client.ObjectCache
+	if opts.Cache.Enabled() {
+		cache, err = client.NewObjectCache(logger, opts.Cache)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize object cache: %w", err)
+		}
+	}
+
 	oc, err := client.NewNatsObjectClient(logger,
 		natsClient,
 		client.NatsObjectClientOptions{
-			Replicas: replicas,
+			Replicas:    replicas,
+			MasterKey:   opts.MasterKey,
+			KMS:         opts.KMS,
+			Compression: opts.Compression,
+			Routes:      routes,
+			Cache:       cache,
 		})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize NATS object client: %w", err)
 	}
 
-	mps, err := client.NewMultiPartStore(logger, natsClient)
+	mps, err := client.NewMultiPartStore(logger, natsClient, client.MultiPartStoreOptions{
+		MasterKey:   opts.MasterKey,
+		KMS:         opts.KMS,
+		Compression: opts.Compression,
+		Routes:      routes,
+		Cache:       cache,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize multipart store: %w", err)
 	}
@@ -60,147 +164,232 @@ func NewS3Gateway(logger log.Logger,
 	if err != nil {
 		logging.Error(logger, "msg", "Error at registering metric collector", "err", err)
 	}
+	requestMetrics, err := metrics.NewRequestMetrics(opts.MetricsMaxBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register request metrics: %w", err)
+	}
+	accessLog := accesslog.NewLogger(logger, func(ctx context.Context, bucket, key string, data []byte) error {
+		_, err := oc.PutObjectStream(ctx, bucket, key, "text/plain", nil, bytes.NewReader(data), nil)
+		return err
+	}, opts.AccessLog)
+
+	var limiter *ratelimit.Limiter
+	if len(opts.RateLimits) > 0 {
+		var state ratelimit.State
+		if opts.RateLimitShared {
+			js, err := natsClient.Jetstream()
+			if err != nil {
+				return nil, fmt.Errorf("failed to access JetStream for rate limits: %w", err)
+			}
+			state, err = ratelimit.NewKVState(context.Background(), js, replicas)
+			if err != nil {
+				return nil, err
+			}
+		}
+		limiter = ratelimit.NewLimiter(logger, opts.RateLimits, state)
+	}
+
+	var admissionController *admission.Controller
+	if opts.Admission.Enabled() {
+		admissionMetrics, err := metrics.NewAdmissionMetrics()
+		if err != nil {
+			return nil, fmt.Errorf("failed to register admission metrics: %w", err)
+		}
+		admissionController = admission.NewController(opts.Admission, admissionMetrics)
+	}
 
 	return &S3Gateway{
-		client:         oc,
-		multiPartStore: mps,
-		iam:            auth.NewIdentityAccessManagement(credStore),
-		logger:         logger,
-		started:        time.Now().UTC(),
+		natsClient:           natsClient,
+		routes:               routes,
+		client:               oc,
+		multiPartStore:       mps,
+		iam:                  auth.NewIdentityAccessManagement(credStore),
+		logger:               logger,
+		started:              time.Now().UTC(),
+		keepAliveInterval:    defaultKeepAliveInterval,
+		encryptionConfigured: opts.MasterKey != nil,
+		encryptByDefault:     opts.EncryptByDefault,
+		kmsConfigured:        opts.KMS != nil,
+		domains:              opts.Domains,
+		websiteDomains:       opts.WebsiteDomains,
+		requestMetrics:       requestMetrics,
+		accessLog:            accessLog,
+		hostID:               newHostID(),
+		limiter:              limiter,
+		admission:            admissionController,
+		cache:                cache,
 	}, nil
 }
 
+// bucketHost is the host template of requests naming their bucket in a
+// subdomain of domain. The dot and the domain are matched literally.
+func bucketHost(domain string) string {
+	return "{bucket:.+}{domain:" + regexp.QuoteMeta("."+domain) + "}"
+}
+
 // RegisterRoutes wires the S3 REST API endpoints onto the provided mux router.
 func (s *S3Gateway) RegisterRoutes(router *mux.Router) {
 	r := router.PathPrefix("/").Subrouter()
+	s.useMiddleware(r)
 
-	// Apply cancellation and validation to all routes
-	cancel := &interceptor.RequestCancellation{}
-	r.Use(cancel.CancelIfDone)
-	validator := &interceptor.RequestValidator{}
-	r.Use(validator.Validate)
+	r.Methods(http.MethodOptions).HandlerFunc(s.auth(s.SetOptionHeaders))
+
+	// Website requests name the bucket in the Host header, under a website
+	// domain distinct from the S3 API domains
+	for _, domain := range s.websiteDomains {
+		s.registerWebsiteRoutes(r.Host(bucketHost(domain)).Subrouter())
+	}
+
+	// Virtual-hosted-style requests name the bucket in the Host header. They
+	// are matched first, so that their paths are not taken for path-style
+	// bucket names.
+	for _, domain := range s.domains {
+		s.registerBucketRoutes(r.Host(bucketHost(domain)).Subrouter())
+	}
 
 	// Unauthenticated monitoring endpoints
 	r.Methods(http.MethodGet).Path("/healthz").HandlerFunc(s.Healthz)
 
-	r.Methods(http.MethodOptions).HandlerFunc(s.iam.Auth(s.SetOptionHeaders))
-
 	// Service level
-	r.Methods(http.MethodGet).Path("/").HandlerFunc(s.iam.Auth(s.ListBuckets))
+	r.Methods(http.MethodGet).Path("/").HandlerFunc(s.auth(s.ListBuckets))
+
+	// Path-style routes relative to /{bucket}
+	s.registerBucketRoutes(r.PathPrefix("/{bucket}").Subrouter())
+}
 
-	// Routes relative to /{bucket}
-	bucket := r.PathPrefix("/{bucket}").Subrouter()
+// useMiddleware identifies, traces, cancels and validates all the routes of
+// a router, and records their metrics and access logs.
+func (s *S3Gateway) useMiddleware(r *mux.Router) {
+	r.Use(s.assignRequestID)
+	r.Use(s.traceRequest)
+	cancel := &interceptor.RequestCancellation{}
+	r.Use(cancel.CancelIfDone)
+	validator := &interceptor.RequestValidator{}
+	r.Use(validator.Validate)
+	r.Use(s.trackInflight)
+	r.Use(s.recordMetrics)
+	r.Use(s.logAccess)
+}
 
+// registerBucketRoutes wires the bucket and object endpoints onto a router
+// that resolves the bucket variable.
+func (s *S3Gateway) registerBucketRoutes(bucket *mux.Router) {
 	// 1: Object operations with query parameters
 	// These routes have both .Path("/{key:.+}") AND .Queries()
 
 	// Multipart upload operations
-	addObjectSubresource(bucket, http.MethodPost, "uploads", s.iam.Auth(s.InitiateMultipartUpload))
+	addObjectSubresource(bucket, http.MethodPost, "uploads", s.auth(s.InitiateMultipartUpload))
 	// Route SigV4 streaming-chunked parts to a dedicated handler
 	bucket.Methods(http.MethodPut).Path("/{key:.+}").
 		Queries("uploadId", "{uploadId}").
 		HeadersRegexp("x-amz-content-sha256", "(?i)^STREAMING-AWS4-HMAC-SHA256-PAYLOAD(?:-TRAILER)?$").
-		HandlerFunc(s.iam.Auth(s.StreamUploadPart))
+		HandlerFunc(s.auth(s.StreamUploadPart))
 	// Default multipart part upload handler (non-streaming)
-	bucket.Methods(http.MethodPut).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.iam.Auth(s.UploadPart))
-	bucket.Methods(http.MethodGet).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.iam.Auth(s.ListParts))
-	bucket.Methods(http.MethodPost).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.iam.Auth(s.CompleteMultipartUpload))
-	bucket.Methods(http.MethodDelete).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.iam.Auth(s.AbortMultipartUpload))
+	bucket.Methods(http.MethodPut).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.auth(s.UploadPart))
+	bucket.Methods(http.MethodGet).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.auth(s.ListParts))
+	bucket.Methods(http.MethodPost).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.auth(s.CompleteMultipartUpload))
+	bucket.Methods(http.MethodDelete).Path("/{key:.+}").Queries("uploadId", "{uploadId}").HandlerFunc(s.auth(s.AbortMultipartUpload))
 
 	// Object subresources
-	addObjectSubresource(bucket, http.MethodGet, "acl", s.iam.Auth(s.notImplemented))
-	addObjectSubresource(bucket, http.MethodPut, "acl", s.iam.Auth(s.notImplemented))
-	addObjectSubresource(bucket, http.MethodDelete, "acl", s.iam.Auth(s.notImplemented))
-	addObjectSubresource(bucket, http.MethodGet, "attributes", s.iam.Auth(s.GetObjectAttributes))
-	addObjectSubresource(bucket, http.MethodGet, "tagging", s.iam.Auth(s.GetObjectTagging))
-	addObjectSubresource(bucket, http.MethodPut, "tagging", s.iam.Auth(s.PutObjectTagging))
-	addObjectSubresource(bucket, http.MethodDelete, "tagging", s.iam.Auth(s.DeleteObjectTagging))
-	addObjectSubresource(bucket, http.MethodGet, "torrent", s.iam.Auth(s.notImplemented)) // deprecated
-	addObjectSubresource(bucket, http.MethodPost, "restore", s.iam.Auth(s.notImplemented))
-	addObjectSubresource(bucket, http.MethodGet, "legal-hold", s.iam.Auth(s.notImplemented))
-	addObjectSubresource(bucket, http.MethodPut, "legal-hold", s.iam.Auth(s.notImplemented))
-	addObjectSubresource(bucket, http.MethodGet, "retention", s.iam.Auth(s.GetObjectRetention))
-	addObjectSubresource(bucket, http.MethodPut, "retention", s.iam.Auth(s.UpdateObjectRetention))
+	addObjectSubresource(bucket, http.MethodGet, "acl", s.auth(s.GetObjectAcl))
+	addObjectSubresource(bucket, http.MethodPut, "acl", s.auth(s.PutObjectAcl))
+	addObjectSubresource(bucket, http.MethodDelete, "acl", s.auth(s.notImplemented))
+	addObjectSubresource(bucket, http.MethodGet, "attributes", s.auth(s.GetObjectAttributes))
+	addObjectSubresource(bucket, http.MethodGet, "tagging", s.auth(s.GetObjectTagging))
+	addObjectSubresource(bucket, http.MethodPut, "tagging", s.auth(s.PutObjectTagging))
+	addObjectSubresource(bucket, http.MethodDelete, "tagging", s.auth(s.DeleteObjectTagging))
+	addObjectSubresource(bucket, http.MethodGet, "torrent", s.auth(s.notImplemented)) // deprecated
+	addObjectSubresource(bucket, http.MethodPost, "restore", s.auth(s.notImplemented))
+	addObjectSubresource(bucket, http.MethodPost, "select", s.auth(s.SelectObjectContent))
+	addObjectSubresource(bucket, http.MethodGet, "legal-hold", s.auth(s.notImplemented))
+	addObjectSubresource(bucket, http.MethodPut, "legal-hold", s.auth(s.notImplemented))
+	addObjectSubresource(bucket, http.MethodGet, "retention", s.auth(s.GetObjectRetention))
+	addObjectSubresource(bucket, http.MethodPut, "retention", s.auth(s.UpdateObjectRetention))
 
 	// 2: Object operations without query parameters
 	// These routes have .Path("/{key:.+}") but NO .Queries()
-	bucket.Methods(http.MethodPut).Path("/{key:.+}").HeadersRegexp("x-amz-copy-source", ".+").HandlerFunc(s.iam.Auth(s.CopyObject))
+	bucket.Methods(http.MethodPut).Path("/{key:.+}").HeadersRegexp("x-amz-copy-source", ".+").HandlerFunc(s.auth(s.CopyObject))
 	// Route streaming SigV4 payload uploads to dedicated handler first
 	bucket.Methods(http.MethodPut).Path("/{key:.+}").
 		HeadersRegexp("x-amz-content-sha256", "(?i)^STREAMING-AWS4-HMAC-SHA256-PAYLOAD(?:-TRAILER)?$").
-		HandlerFunc(s.iam.Auth(s.StreamUpload))
+		HandlerFunc(s.auth(s.StreamUpload))
 	// Default single PUT handler
-	bucket.Methods(http.MethodPut).Path("/{key:.+}").HandlerFunc(s.iam.Auth(s.Upload))
-	bucket.Methods(http.MethodGet).Path("/{key:.+}").HandlerFunc(s.iam.Auth(s.Download))
-	bucket.Methods(http.MethodHead).Path("/{key:.+}").HandlerFunc(s.iam.Auth(s.HeadObject))
-	bucket.Methods(http.MethodDelete).Path("/{key:.+}").HandlerFunc(s.iam.Auth(s.DeleteObject))
-	bucket.Methods(http.MethodOptions).Path("/{key:.+}").HandlerFunc(s.iam.Auth(s.notImplemented))
+	bucket.Methods(http.MethodPut).Path("/{key:.+}").HandlerFunc(s.auth(s.Upload))
+	bucket.Methods(http.MethodGet).Path("/{key:.+}").HandlerFunc(s.auth(s.Download))
+	bucket.Methods(http.MethodHead).Path("/{key:.+}").HandlerFunc(s.auth(s.HeadObject))
+	bucket.Methods(http.MethodDelete).Path("/{key:.+}").HandlerFunc(s.auth(s.DeleteObject))
+	bucket.Methods(http.MethodOptions).Path("/{key:.+}").HandlerFunc(s.auth(s.notImplemented))
 
 	// 3: Bucket operations with query parameters
 	// These routes have .Queries() but NO .Path()
 	// Must be registered after object routes
-	addBucketSubresource(bucket, http.MethodGet, "acl", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "acl", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "cors", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "cors", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "cors", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "lifecycle", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "lifecycle", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "lifecycle", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "policy", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "policy", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "policy", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "replication", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "replication", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "versioning", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "versioning", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "website", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "website", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "website", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "tagging", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "tagging", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "tagging", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "logging", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "logging", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "notification", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "notification", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "encryption", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "encryption", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "encryption", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "object-lock", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "object-lock", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "ownershipControls", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "ownershipControls", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "ownershipControls", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "accelerate", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "accelerate", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "location", s.iam.Auth(s.GetBucketLocation))
-	addBucketSubresource(bucket, http.MethodGet, "uploads", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "versions", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "requestPayment", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "requestPayment", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "inventory", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "inventory", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "inventory", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "metrics", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "metrics", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "metrics", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "analytics", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "analytics", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "analytics", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodGet, "intelligent-tiering", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPut, "intelligent-tiering", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodDelete, "intelligent-tiering", s.iam.Auth(s.notImplemented))
-	addBucketSubresource(bucket, http.MethodPost, "delete", s.iam.Auth(s.DeleteObjects))
+	addBucketSubresource(bucket, http.MethodGet, "acl", s.auth(s.GetBucketAcl))
+	addBucketSubresource(bucket, http.MethodPut, "acl", s.auth(s.PutBucketAcl))
+	addBucketSubresource(bucket, http.MethodGet, "cors", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "cors", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "cors", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "lifecycle", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "lifecycle", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "lifecycle", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "policy", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "policy", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "policy", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "replication", s.auth(s.GetBucketReplication))
+	addBucketSubresource(bucket, http.MethodPut, "replication", s.auth(s.PutBucketReplication))
+	addBucketSubresource(bucket, http.MethodDelete, "replication", s.auth(s.DeleteBucketReplication))
+	addBucketSubresource(bucket, http.MethodGet, "versioning", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "versioning", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "website", s.auth(s.GetBucketWebsite))
+	addBucketSubresource(bucket, http.MethodPut, "website", s.auth(s.PutBucketWebsite))
+	addBucketSubresource(bucket, http.MethodDelete, "website", s.auth(s.DeleteBucketWebsite))
+	addBucketSubresource(bucket, http.MethodGet, "tagging", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "tagging", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "tagging", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "logging", s.auth(s.GetBucketLogging))
+	addBucketSubresource(bucket, http.MethodPut, "logging", s.auth(s.PutBucketLogging))
+	addBucketSubresource(bucket, http.MethodGet, "notification", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "notification", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "encryption", s.auth(s.GetBucketEncryption))
+	addBucketSubresource(bucket, http.MethodPut, "encryption", s.auth(s.PutBucketEncryption))
+	addBucketSubresource(bucket, http.MethodDelete, "encryption", s.auth(s.DeleteBucketEncryption))
+	addBucketSubresource(bucket, http.MethodGet, "object-lock", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "object-lock", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "ownershipControls", s.auth(s.GetBucketOwnershipControls))
+	addBucketSubresource(bucket, http.MethodPut, "ownershipControls", s.auth(s.PutBucketOwnershipControls))
+	addBucketSubresource(bucket, http.MethodDelete, "ownershipControls", s.auth(s.DeleteBucketOwnershipControls))
+	addBucketSubresource(bucket, http.MethodGet, "accelerate", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "accelerate", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "location", s.auth(s.GetBucketLocation))
+	addBucketSubresource(bucket, http.MethodGet, "info", s.auth(s.GetBucketInfo))
+	addBucketSubresource(bucket, http.MethodGet, "quota", s.auth(s.GetBucketQuota))
+	addBucketSubresource(bucket, http.MethodPut, "quota", s.auth(s.PutBucketQuota))
+	addBucketSubresource(bucket, http.MethodDelete, "quota", s.auth(s.DeleteBucketQuota))
+	addBucketSubresource(bucket, http.MethodGet, "uploads", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "versions", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "requestPayment", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "requestPayment", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "inventory", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "inventory", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "inventory", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "metrics", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "metrics", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "metrics", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "analytics", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "analytics", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "analytics", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodGet, "intelligent-tiering", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPut, "intelligent-tiering", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodDelete, "intelligent-tiering", s.auth(s.notImplemented))
+	addBucketSubresource(bucket, http.MethodPost, "delete", s.auth(s.DeleteObjects))
 
 	// 4: Bucket operations without query parameters
 	// These routes have neither .Path() nor .Queries()
 	// Must be registered last
-	bucket.Methods(http.MethodPut).HandlerFunc(s.iam.Auth(s.CreateBucket))
-	bucket.Methods(http.MethodHead).HandlerFunc(s.iam.Auth(s.HeadBucket))
-	bucket.Methods(http.MethodGet).HandlerFunc(s.iam.Auth(s.ListObjects))
-	bucket.Methods(http.MethodDelete).HandlerFunc(s.iam.Auth(s.DeleteBucket))
-	bucket.Methods(http.MethodOptions).HandlerFunc(s.iam.Auth(s.notImplemented))
+	bucket.Methods(http.MethodPut).HandlerFunc(s.auth(s.CreateBucket))
+	bucket.Methods(http.MethodHead).HandlerFunc(s.auth(s.HeadBucket))
+	bucket.Methods(http.MethodGet).HandlerFunc(s.auth(s.ListObjects))
+	bucket.Methods(http.MethodDelete).HandlerFunc(s.auth(s.DeleteBucket))
+	bucket.Methods(http.MethodOptions).HandlerFunc(s.auth(s.notImplemented))
 
 }
 
@@ -209,7 +398,7 @@ func (s *S3Gateway) notImplemented(w http.ResponseWriter, r *http.Request) {
 	if r.URL.RawQuery != "" {
 		endpoint = fmt.Sprintf("%s?%s", endpoint, r.URL.RawQuery)
 	}
-	logging.Info(s.logger, "msg", "Unimplemented endpoint", "endpoint", endpoint)
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", "Unimplemented endpoint", "endpoint", endpoint)
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
@@ -0,0 +1,92 @@
+package s3api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+// testCredentials is an in-memory credential store for signed requests.
+type testCredentials map[string]string
+
+func (c testCredentials) Get(accessKey string) (string, bool) {
+	secret, ok := c[accessKey]
+	return secret, ok
+}
+
+func (c testCredentials) GetName() string {
+	return "test"
+}
+
+func TestVirtualHostedStyle(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	creds := testCredentials{"AKIDEXAMPLE": "secret"}
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, creds, S3GatewayOptions{
+		Domains: []string{"s3.example.com", "s3.local"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	signer := v4.NewSigner(credentials.NewStaticCredentials("AKIDEXAMPLE", "secret", ""))
+	signer.DisableURIPathEscaping = true
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if _, err := signer.Sign(req, strings.NewReader(body), "s3", "us-east-1", time.Now()); err != nil {
+			t.Fatalf("sign failed: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do("PUT", "http://photos.s3.example.com/", ""); rr.Code != 200 {
+		t.Fatalf("create bucket failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("PUT", "http://photos.s3.example.com/2024/cat.jpg", "meow"); rr.Code != 200 {
+		t.Fatalf("upload failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// The object is the same whichever addressing style or domain reads it
+	for _, target := range []string{
+		"http://photos.s3.example.com/2024/cat.jpg",
+		"http://photos.s3.local:5222/2024/cat.jpg",
+		"http://s3.example.com/photos/2024/cat.jpg",
+	} {
+		rr := do("GET", target, "")
+		if rr.Code != 200 || rr.Body.String() != "meow" {
+			t.Fatalf("GET %s: %d %s", target, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := do("GET", "http://photos.s3.example.com/", "")
+	if rr.Code != 200 || !strings.Contains(rr.Body.String(), "<Key>2024/cat.jpg</Key>") {
+		t.Fatalf("list objects failed: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = do("GET", "http://s3.example.com/", "")
+	if rr.Code != 200 || !strings.Contains(rr.Body.String(), "<Name>photos</Name>") {
+		t.Fatalf("list buckets failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Hosts outside the configured domains stay path-style, including those
+	// only matching a domain with its dots taken as wildcards
+	for _, host := range []string{"photos.other.com", "photos.s3xexample.com", "photosxs3.local"} {
+		rr = do("GET", "http://"+host+"/2024/cat.jpg", "")
+		if rr.Code != 404 {
+			t.Fatalf("%s: expected path-style lookup of bucket 2024, got %d %s", host, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package s3api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+// defaultKeepAliveInterval is how long a long-running request may stay silent
+// before the gateway commits a 200 response and starts sending whitespace.
+const defaultKeepAliveInterval = 10 * time.Second
+
+// keepAliveResult carries the outcome of the work run by writeWithKeepAlive.
+type keepAliveResult struct {
+	response interface{}
+	errCode  model.ErrorCode
+}
+
+// writeWithKeepAlive runs work and writes its XML response. When work takes
+// longer than the keepalive interval, the gateway sends 200 OK right away and
+// trickles whitespace to keep load balancers and SDKs from timing out, then
+// writes either the result or an in-body <Error>, as S3 does for
+// CompleteMultipartUpload and CopyObject. The work must not touch w.
+func (s *S3Gateway) writeWithKeepAlive(w http.ResponseWriter, r *http.Request, work func() (interface{}, model.ErrorCode)) {
+	done := make(chan keepAliveResult, 1)
+	go func() {
+		response, errCode := work()
+		done <- keepAliveResult{response: response, errCode: errCode}
+	}()
+
+	ticker := time.NewTicker(s.keepAliveInterval)
+	defer ticker.Stop()
+
+	started := false
+	for {
+		select {
+		case res := <-done:
+			switch {
+			case !started && res.errCode != model.ErrNone:
+				model.WriteErrorResponse(w, r, res.errCode)
+			case !started:
+				model.WriteXMLResponse(w, r, http.StatusOK, res.response)
+			case res.errCode != model.ErrNone:
+				model.WriteErrorBody(w, r, res.errCode)
+			default:
+				model.WriteXMLBody(w, res.response)
+			}
+			return
+		case <-ticker.C:
+			if !started {
+				logging.Debug(logging.WithContext(r.Context(), s.logger), "msg", "Sending keepalive response", "path", r.URL.Path)
+				model.StartXMLResponse(w, r)
+				started = true
+				continue
+			}
+			if _, err := w.Write([]byte(" ")); err != nil {
+				logging.Warn(logging.WithContext(r.Context(), s.logger), "msg", "Error writing keepalive whitespace", "path", r.URL.Path, "err", err)
+				return
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+func TestWriteWithKeepAlive(t *testing.T) {
+	gw := &S3Gateway{
+		logger:            logging.NewLogger(logging.Config{Level: "debug"}),
+		keepAliveInterval: 5 * time.Millisecond,
+	}
+	slow := func(response interface{}, errCode model.ErrorCode) func() (interface{}, model.ErrorCode) {
+		return func() (interface{}, model.ErrorCode) {
+			time.Sleep(50 * time.Millisecond)
+			return response, errCode
+		}
+	}
+
+	// Fast work is answered like a regular response
+	rr := httptest.NewRecorder()
+	gw.writeWithKeepAlive(rr, httptest.NewRequest("POST", "/bucket/key", nil), func() (interface{}, model.ErrorCode) {
+		return nil, model.ErrNoSuchUpload
+	})
+	if rr.Code != 404 || !strings.Contains(rr.Body.String(), "<Code>NoSuchUpload</Code>") {
+		t.Fatalf("unexpected fast error response: status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Slow work commits 200 and trickles whitespace before the result
+	rr = httptest.NewRecorder()
+	gw.writeWithKeepAlive(rr, httptest.NewRequest("POST", "/bucket/key", nil),
+		slow(CopyObjectResult{ETag: `"abc"`}, model.ErrNone))
+	body := rr.Body.String()
+	if rr.Code != 200 || !strings.HasPrefix(body, xml.Header+" ") {
+		t.Fatalf("expected keepalive response, got status=%d body=%q", rr.Code, body)
+	}
+	var result CopyObjectResult
+	if err := xml.Unmarshal(rr.Body.Bytes(), &result); err != nil || result.ETag != `"abc"` {
+		t.Fatalf("unexpected keepalive result: %+v err=%v body=%q", result, err, body)
+	}
+
+	// Slow failures are reported as an in-body error
+	rr = httptest.NewRecorder()
+	gw.writeWithKeepAlive(rr, httptest.NewRequest("POST", "/bucket/key", nil),
+		slow(nil, model.ErrInvalidPart))
+	var errResp model.RESTErrorResponse
+	if err := xml.Unmarshal(rr.Body.Bytes(), &errResp); err != nil {
+		t.Fatalf("unmarshal in-body error failed: %v body=%q", err, rr.Body.String())
+	}
+	if rr.Code != 200 || errResp.Code != "InvalidPart" {
+		t.Fatalf("unexpected in-body error: status=%d code=%s", rr.Code, errResp.Code)
+	}
+}
@@ -0,0 +1,248 @@
+package s3api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/metrics"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+// responseRecorder records the status code and body size of a response,
+// and when it started.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	bytes   int64
+	wroteAt time.Time
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.wroteAt = time.Now()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+		w.wroteAt = time.Now()
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps the keepalive whitespace of long-running requests flowing.
+func (w *responseRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode returns the status sent, which is 200 when the handler wrote
+// nothing.
+func (w *responseRecorder) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.bytes += int64(n)
+	return n, err
+}
+
+// withResponseError returns the ResponseError recording the S3 error code of
+// the response, attaching one to the request unless an outer middleware did.
+func withResponseError(r *http.Request) (*http.Request, *model.ResponseError) {
+	if re, ok := model.ResponseErrorFrom(r.Context()); ok {
+		return r, re
+	}
+	re := &model.ResponseError{}
+	return r.WithContext(model.WithResponseError(r.Context(), re)), re
+}
+
+// recordMetrics records the request metrics of each S3 operation.
+func (s *S3Gateway) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := s3Operation(r)
+		done := s.requestMetrics.Started(operation)
+		defer done()
+
+		started := time.Now()
+		r, re := withResponseError(r)
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// Requests fail unless they are authorized and their bucket exists,
+		// so only served ones let a new bucket take a label
+		status := rec.statusCode()
+		s.requestMetrics.Observe(metrics.RequestObservation{
+			Operation:     operation,
+			Bucket:        mux.Vars(r)["bucket"],
+			Known:         status < http.StatusBadRequest,
+			Status:        status,
+			ErrorCode:     re.Code,
+			Duration:      time.Since(started),
+			BytesReceived: body.bytes,
+			BytesSent:     rec.bytes,
+		})
+	})
+}
+
+// objectOperations name the operations on object subresources by method.
+// uploadId comes first, as its routes take precedence.
+var objectOperations = []struct {
+	sub string
+	ops map[string]string
+}{
+	{"uploadId", map[string]string{
+		http.MethodPut:    "UploadPart",
+		http.MethodGet:    "ListParts",
+		http.MethodPost:   "CompleteMultipartUpload",
+		http.MethodDelete: "AbortMultipartUpload",
+	}},
+	{"uploads", map[string]string{http.MethodPost: "CreateMultipartUpload"}},
+	{"acl", map[string]string{http.MethodGet: "GetObjectAcl", http.MethodPut: "PutObjectAcl"}},
+	{"attributes", map[string]string{http.MethodGet: "GetObjectAttributes"}},
+	{"tagging", map[string]string{
+		http.MethodGet:    "GetObjectTagging",
+		http.MethodPut:    "PutObjectTagging",
+		http.MethodDelete: "DeleteObjectTagging",
+	}},
+	{"torrent", map[string]string{http.MethodGet: "GetObjectTorrent"}},
+	{"restore", map[string]string{http.MethodPost: "RestoreObject"}},
+	{"select", map[string]string{http.MethodPost: "SelectObjectContent"}},
+	{"legal-hold", map[string]string{http.MethodGet: "GetObjectLegalHold", http.MethodPut: "PutObjectLegalHold"}},
+	{"retention", map[string]string{http.MethodGet: "GetObjectRetention", http.MethodPut: "PutObjectRetention"}},
+}
+
+// bucketOperations name the bucket subresource operations whose names do
+// not follow <Method>Bucket<Subresource>.
+var bucketOperations = map[string]map[string]string{
+	"delete":   {http.MethodPost: "DeleteObjects"},
+	"uploads":  {http.MethodGet: "ListMultipartUploads"},
+	"versions": {http.MethodGet: "ListObjectVersions"},
+}
+
+// bucketSubresources are the bucket subresources routed by RegisterRoutes.
+var bucketSubresources = []string{
+	"acl", "cors", "lifecycle", "policy", "replication", "versioning", "website", "tagging", "logging",
+	"notification", "encryption", "object-lock", "ownershipControls", "accelerate", "location", "info",
+	"quota", "uploads", "versions", "requestPayment", "inventory", "metrics", "analytics",
+	"intelligent-tiering", "delete",
+}
+
+// methodPrefixes start the names of operations by method.
+var methodPrefixes = map[string]string{
+	http.MethodGet:     "Get",
+	http.MethodHead:    "Head",
+	http.MethodPut:     "Put",
+	http.MethodPost:    "Post",
+	http.MethodDelete:  "Delete",
+	http.MethodOptions: "Options",
+}
+
+// s3Operation names the S3 operation of a routed request, such as
+// PutObject or GetBucketEncryption.
+func s3Operation(r *http.Request) string {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	if _, ok := vars["key"]; ok {
+		for _, o := range objectOperations {
+			if query.Has(o.sub) {
+				if op, ok := o.ops[r.Method]; ok {
+					if op == "UploadPart" && r.Header.Get("x-amz-copy-source") != "" {
+						return "UploadPartCopy"
+					}
+					return op
+				}
+			}
+		}
+		switch r.Method {
+		case http.MethodPut:
+			if r.Header.Get("x-amz-copy-source") != "" {
+				return "CopyObject"
+			}
+			return "PutObject"
+		case http.MethodGet:
+			return "GetObject"
+		case http.MethodHead:
+			return "HeadObject"
+		case http.MethodDelete:
+			return "DeleteObject"
+		}
+		return "Object" + methodPrefixes[r.Method]
+	}
+
+	if _, ok := vars["bucket"]; ok {
+		for _, sub := range bucketSubresources {
+			if !query.Has(sub) {
+				continue
+			}
+			if op, ok := bucketOperations[sub][r.Method]; ok {
+				return op
+			}
+			return methodPrefixes[r.Method] + "Bucket" + subresourceName(sub)
+		}
+		switch r.Method {
+		case http.MethodPut:
+			return "CreateBucket"
+		case http.MethodHead:
+			return "HeadBucket"
+		case http.MethodGet:
+			if query.Get("list-type") == "2" {
+				return "ListObjectsV2"
+			}
+			return "ListObjects"
+		case http.MethodDelete:
+			return "DeleteBucket"
+		}
+		return "Bucket" + methodPrefixes[r.Method]
+	}
+
+	switch {
+	case r.URL.Path == "/healthz":
+		return "Healthz"
+	case r.Method == http.MethodGet && r.URL.Path == "/":
+		return "ListBuckets"
+	case r.Method == http.MethodOptions:
+		return "Options"
+	}
+	return "Other"
+}
+
+// subresourceName turns a subresource such as object-lock or
+// ownershipControls into ObjectLock or OwnershipControls.
+func subresourceName(sub string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(sub, "-") {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
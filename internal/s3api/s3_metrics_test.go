@@ -0,0 +1,110 @@
+package s3api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestS3Operation(t *testing.T) {
+	r := mux.NewRouter()
+	var got string
+	capture := func(w http.ResponseWriter, r *http.Request) { got = s3Operation(r) }
+	r.Path("/").HandlerFunc(capture)
+	r.Path("/{bucket}").HandlerFunc(capture)
+	r.Path("/{bucket}/{key:.+}").HandlerFunc(capture)
+
+	tests := []struct {
+		method, target, copySource, want string
+	}{
+		{http.MethodGet, "/", "", "ListBuckets"},
+		{http.MethodPut, "/b", "", "CreateBucket"},
+		{http.MethodGet, "/b?list-type=2", "", "ListObjectsV2"},
+		{http.MethodGet, "/b?encryption=", "", "GetBucketEncryption"},
+		{http.MethodPut, "/b?object-lock=", "", "PutBucketObjectLock"},
+		{http.MethodPost, "/b?delete=", "", "DeleteObjects"},
+		{http.MethodGet, "/b?uploads=", "", "ListMultipartUploads"},
+		{http.MethodPut, "/b/k", "", "PutObject"},
+		{http.MethodPut, "/b/k", "/src/k", "CopyObject"},
+		{http.MethodGet, "/b/dir/k", "", "GetObject"},
+		{http.MethodPost, "/b/k?uploads=", "", "CreateMultipartUpload"},
+		{http.MethodPut, "/b/k?partNumber=1&uploadId=u", "", "UploadPart"},
+		{http.MethodPut, "/b/k?partNumber=1&uploadId=u", "/src/k", "UploadPartCopy"},
+		{http.MethodPost, "/b/k?uploadId=u", "", "CompleteMultipartUpload"},
+		{http.MethodDelete, "/b/k?tagging=", "", "DeleteObjectTagging"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.target, nil)
+		if tc.copySource != "" {
+			req.Header.Set("x-amz-copy-source", tc.copySource)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tc.want {
+			t.Errorf("%s %s: expected %s, got %s", tc.method, tc.target, tc.want, got)
+		}
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{MetricsMaxBuckets: 1})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target, body string) int {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	// Missing buckets do not take the only bucket label
+	if code := do(http.MethodGet, "/missing-bucket", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing bucket, got %d", code)
+	}
+	if code := do(http.MethodPut, "/metrics-bucket", ""); code != http.StatusOK {
+		t.Fatalf("create bucket: %d", code)
+	}
+	if code := do(http.MethodPut, "/metrics-bucket/key", "0123456789"); code != http.StatusOK {
+		t.Fatalf("put object: %d", code)
+	}
+	if code := do(http.MethodGet, "/metrics-bucket/missing", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing object, got %d", code)
+	}
+	// Past the bucket limit
+	do(http.MethodPut, "/metrics-bucket-2", "")
+
+	data, err := promtestutil.CollectAndFormat(gw.requestMetrics, expfmt.TypeTextPlain,
+		"nats_s3_requests_total", "nats_s3_request_bytes_total", "nats_s3_request_duration_seconds", "nats_s3_requests_inflight")
+	if err != nil {
+		t.Fatalf("collect request metrics: %v", err)
+	}
+	exposition := string(data)
+
+	for _, want := range []string{
+		`nats_s3_requests_total{bucket="metrics-bucket",error_code="",operation="PutObject",status="200"} 1`,
+		`nats_s3_requests_total{bucket="metrics-bucket",error_code="NoSuchKey",operation="GetObject",status="404"} 1`,
+		`nats_s3_requests_total{bucket="_other",error_code="",operation="CreateBucket",status="200"}`,
+		`nats_s3_requests_total{bucket="_other",error_code="NoSuchBucket",operation="ListObjects",status="404"} 1`,
+		`nats_s3_requests_total{bucket="metrics-bucket",error_code="",operation="CreateBucket",status="200"} 1`,
+		`nats_s3_request_bytes_total{bucket="metrics-bucket",operation="PutObject"} 10`,
+		`nats_s3_request_duration_seconds_count{operation="GetObject"}`,
+		`nats_s3_requests_inflight{operation="PutObject"} 0`,
+	} {
+		if !strings.Contains(exposition, want) {
+			t.Errorf("expected metrics to contain %s", want)
+		}
+	}
+}
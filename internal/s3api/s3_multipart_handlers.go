@@ -21,6 +21,7 @@ import (
 const (
 	maxUploadsList = 10000 // Max number of uploads in a listUploadsResponse.
 	maxPartsList   = 10000 // Max number of parts in a listPartsResponse.
+	maxPartNumber  = 10000 // Highest part number of a multipart upload.
 
 	// S3-compatible size limits
 	maxPartSize    = 5 * 1024 * 1024 * 1024 // 5GB per part (S3 multipart limit)
@@ -34,11 +35,23 @@ func (s *S3Gateway) InitiateMultipartUpload(w http.ResponseWriter, r *http.Reque
 	bucket := mux.Vars(r)["bucket"]
 	key := mux.Vars(r)["key"]
 
-	err := s.multiPartStore.InitMultipartUpload(r.Context(), bucket, key, uploadID)
+	sse, errCode := s.writeEncryption(r, bucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	objectACL, errCode := s.newObjectACL(r, bucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+
+	err := s.multiPartStore.InitMultipartUpload(r.Context(), bucket, key, uploadID, r.Header.Get("Content-Type"), sse, objectACL)
 	if err != nil {
-		model.WriteErrorResponse(w, r, model.ErrInternalError)
+		model.WriteErrorResponse(w, r, objectErrorCode(err))
 		return
 	}
+	updateRequestedEncryptionHeaders(sse, w)
 
 	response := model.InitiateMultipartUploadResult{
 		CreateMultipartUploadOutput: s3.CreateMultipartUploadOutput{
@@ -90,6 +103,12 @@ func (s *S3Gateway) UploadPart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sse, errCode := readEncryption(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+
 	// Use LimitReader as defense-in-depth to ensure we never read more than maxPartSize
 	// Wrap it in a limitedReadCloser to satisfy io.ReadCloser interface
 	limitedBody := &limitedReadCloser{
@@ -97,16 +116,17 @@ func (s *S3Gateway) UploadPart(w http.ResponseWriter, r *http.Request) {
 		Closer: r.Body,
 	}
 
-	etag, err := s.multiPartStore.UploadPart(r.Context(), bucket, key, uploadID, partNum, limitedBody)
+	etag, err := s.multiPartStore.UploadPart(r.Context(), bucket, key, uploadID, partNum, limitedBody, sse)
 	if err != nil {
 		if errors.Is(err, client.ErrUploadNotFound) || errors.Is(err, client.ErrUploadCompleted) {
 			model.WriteErrorResponse(w, r, model.ErrNoSuchUpload)
 			return
 		}
-		model.WriteErrorResponse(w, r, model.ErrInternalError)
+		model.WriteErrorResponse(w, r, objectErrorCode(err))
 		return
 	}
 
+	updateRequestedEncryptionHeaders(sse, w)
 	model.SetEtag(w, etag)
 	model.WriteEmptyResponse(w, r, http.StatusOK)
 }
@@ -139,18 +159,25 @@ func (s *S3Gateway) StreamUploadPart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sse, errCode := readEncryption(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+
 	bodyReader := streams.NewLimitedSigV4StreamReader(r.Body, maxPartSize+1)
 
-	etag, err := s.multiPartStore.UploadPart(r.Context(), bucket, key, uploadID, partNum, bodyReader)
+	etag, err := s.multiPartStore.UploadPart(r.Context(), bucket, key, uploadID, partNum, bodyReader, sse)
 	if err != nil {
 		if errors.Is(err, client.ErrUploadNotFound) || errors.Is(err, client.ErrUploadCompleted) {
 			model.WriteErrorResponse(w, r, model.ErrNoSuchUpload)
 			return
 		}
-		model.WriteErrorResponse(w, r, model.ErrInternalError)
+		model.WriteErrorResponse(w, r, objectErrorCode(err))
 		return
 	}
 
+	updateRequestedEncryptionHeaders(sse, w)
 	model.SetEtag(w, etag)
 	model.WriteEmptyResponse(w, r, http.StatusOK)
 }
@@ -187,19 +214,32 @@ func (s *S3Gateway) CompleteMultipartUpload(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	sortedPartNumbers := parsePartNumbers(parts)
-	etag, err := s.multiPartStore.CompleteMultipartUpload(r.Context(), bucket, key, uploadID, sortedPartNumbers)
-	if err != nil {
-		model.WriteErrorResponse(w, r, model.ErrInternalError)
+	completed, errCode := parseCompletedParts(parts)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
 		return
 	}
+	s.writeWithKeepAlive(w, r, func() (interface{}, model.ErrorCode) {
+		etag, err := s.multiPartStore.CompleteMultipartUpload(r.Context(), bucket, key, uploadID, completed)
+		if err != nil {
+			switch {
+			case errors.Is(err, client.ErrUploadNotFound):
+				return nil, model.ErrNoSuchUpload
+			case errors.Is(err, client.ErrInvalidPart):
+				return nil, model.ErrInvalidPart
+			case errors.Is(err, client.ErrPartTooSmall):
+				return nil, model.ErrEntityTooSmall
+			default:
+				return nil, objectErrorCode(err)
+			}
+		}
 
-	response := model.CompleteMultipartUploadResult{
-		Bucket: aws.String(bucket),
-		ETag:   aws.String(etag),
-		Key:    objectKey(key),
-	}
-	model.WriteXMLResponse(w, r, http.StatusOK, response)
+		return model.CompleteMultipartUploadResult{
+			Bucket: aws.String(bucket),
+			ETag:   aws.String(etag),
+			Key:    objectKey(key),
+		}, model.ErrNone
+	})
 }
 
 // AbortMultipartUpload aborts a multipart upload, removing uploaded parts and
@@ -316,17 +356,24 @@ func (s *S3Gateway) ListParts(w http.ResponseWriter, r *http.Request) {
 	model.WriteXMLResponse(w, r, http.StatusOK, response)
 }
 
-func parsePartNumbers(parts *model.CompleteMultipartUpload) []int {
+// parseCompletedParts validates the part list of a CompleteMultipartUpload
+// request. Part numbers must be within range and strictly ascending.
+func parseCompletedParts(parts *model.CompleteMultipartUpload) ([]client.CompletedPart, model.ErrorCode) {
 	if parts == nil || len(parts.Parts) == 0 {
-		return nil
+		return nil, model.ErrMalformedXML
 	}
 
-	nums := make([]int, 0, len(parts.Parts))
-	for _, p := range parts.Parts {
-		nums = append(nums, p.PartNumber)
+	completed := make([]client.CompletedPart, 0, len(parts.Parts))
+	for i, p := range parts.Parts {
+		if p.PartNumber < 1 || p.PartNumber > maxPartNumber {
+			return nil, model.ErrInvalidPart
+		}
+		if i > 0 && p.PartNumber <= parts.Parts[i-1].PartNumber {
+			return nil, model.ErrInvalidPartOrder
+		}
+		completed = append(completed, client.CompletedPart{Number: p.PartNumber, ETag: p.ETag})
 	}
-	sort.Ints(nums)
-	return nums
+	return completed, model.ErrNone
 }
 
 func xmlDecoder(body io.Reader, v interface{}, size int64) error {
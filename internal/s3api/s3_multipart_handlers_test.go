@@ -5,6 +5,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/wpnpeiris/nats-s3/internal/logging"
@@ -26,7 +27,7 @@ func TestInitiateMultipartUpload_SucceedsAndPersistsSession(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -66,7 +67,7 @@ func TestListParts_PaginatesDeterministically(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -76,10 +77,17 @@ func TestListParts_PaginatesDeterministically(t *testing.T) {
 	bucket := "lpbucket"
 	key := "dir/large.txt"
 
-	// Initiate multipart upload
-	req := httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	req := httptest.NewRequest("PUT", "/"+bucket, nil)
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("create bucket status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Initiate multipart upload
+	req = httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
 	if rr.Code != 200 {
 		t.Fatalf("init status=%d body=%s", rr.Code, rr.Body.String())
 	}
@@ -155,7 +163,7 @@ func TestListParts_NoParts(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -203,7 +211,7 @@ func TestListParts_MarkerBeyondLast(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -213,10 +221,17 @@ func TestListParts_MarkerBeyondLast(t *testing.T) {
 	bucket := "markerbeyond"
 	key := "obj/key"
 
-	// Initiate multipart upload
-	req := httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	req := httptest.NewRequest("PUT", "/"+bucket, nil)
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("create bucket status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Initiate multipart upload
+	req = httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
 	if rr.Code != 200 {
 		t.Fatalf("init status=%d body=%s", rr.Code, rr.Body.String())
 	}
@@ -263,7 +278,7 @@ func TestListParts_NonContiguousParts(t *testing.T) {
 	defer s.Shutdown()
 
 	logger := logging.NewLogger(logging.Config{Level: "debug"})
-	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil)
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
 	if err != nil {
 		t.Fatalf("failed to create S3 gateway: %v", err)
 	}
@@ -273,10 +288,17 @@ func TestListParts_NonContiguousParts(t *testing.T) {
 	bucket := "noncontig"
 	key := "obj/noncontig"
 
-	// Initiate multipart upload
-	req := httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	req := httptest.NewRequest("PUT", "/"+bucket, nil)
 	rr := httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("create bucket status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Initiate multipart upload
+	req = httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
 	if rr.Code != 200 {
 		t.Fatalf("init status=%d body=%s", rr.Code, rr.Body.String())
 	}
@@ -337,3 +359,211 @@ func TestListParts_NonContiguousParts(t *testing.T) {
 		t.Fatalf("unexpected page2: %+v", p2)
 	}
 }
+
+func TestCompleteMultipartUpload_PartNumberReadsAndAttributes(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	bucket := "layoutbucket"
+	key := "dir/assembled.bin"
+
+	req := httptest.NewRequest("PUT", "/"+bucket, nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("create bucket status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("init status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var ir initResp
+	if err := xml.Unmarshal(rr.Body.Bytes(), &ir); err != nil {
+		t.Fatalf("unmarshal init xml failed: %v\nxml=%s", err, rr.Body.String())
+	}
+
+	parts := [][]byte{
+		bytes.Repeat([]byte("a"), 5*1024*1024),
+		bytes.Repeat([]byte("b"), 5*1024*1024),
+		[]byte("tail"),
+	}
+	complete := "<CompleteMultipartUpload>"
+	for i, data := range parts {
+		upr := httptest.NewRequest("PUT", fmt.Sprintf("/%s/%s?uploadId=%s&partNumber=%d", bucket, key, ir.UploadId, i+1), bytes.NewReader(data))
+		uprr := httptest.NewRecorder()
+		r.ServeHTTP(uprr, upr)
+		if uprr.Code != 200 {
+			t.Fatalf("upload part %d failed: status=%d body=%s", i+1, uprr.Code, uprr.Body.String())
+		}
+		complete += fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, uprr.Header()["ETag"][0])
+	}
+	complete += "</CompleteMultipartUpload>"
+
+	cr := httptest.NewRequest("POST", fmt.Sprintf("/%s/%s?uploadId=%s", bucket, key, ir.UploadId), bytes.NewBufferString(complete))
+	crr := httptest.NewRecorder()
+	r.ServeHTTP(crr, cr)
+	if crr.Code != 200 {
+		t.Fatalf("complete failed: status=%d body=%s", crr.Code, crr.Body.String())
+	}
+
+	// GET ?partNumber=2 returns exactly the second part
+	gr := httptest.NewRequest("GET", fmt.Sprintf("/%s/%s?partNumber=2", bucket, key), nil)
+	grr := httptest.NewRecorder()
+	r.ServeHTTP(grr, gr)
+	if grr.Code != 206 {
+		t.Fatalf("get part status=%d body=%s", grr.Code, grr.Body.String())
+	}
+	if got := grr.Header().Get("x-amz-mp-parts-count"); got != "3" {
+		t.Fatalf("unexpected parts count header: %q", got)
+	}
+	wantRange := fmt.Sprintf("bytes %d-%d/%d", 5*1024*1024, 10*1024*1024-1, 10*1024*1024+4)
+	if got := grr.Header().Get("Content-Range"); got != wantRange {
+		t.Fatalf("unexpected Content-Range: got %q want %q", got, wantRange)
+	}
+	if !bytes.Equal(grr.Body.Bytes(), parts[1]) {
+		t.Fatalf("part body mismatch: got %d bytes", grr.Body.Len())
+	}
+
+	// HEAD ?partNumber=3 reports the size of the last part
+	hr := httptest.NewRequest("HEAD", fmt.Sprintf("/%s/%s?partNumber=3", bucket, key), nil)
+	hrr := httptest.NewRecorder()
+	r.ServeHTTP(hrr, hr)
+	if hrr.Code != 206 || hrr.Header().Get("Content-Length") != "4" {
+		t.Fatalf("head part status=%d length=%q", hrr.Code, hrr.Header().Get("Content-Length"))
+	}
+
+	// A part number beyond the layout is rejected
+	br := httptest.NewRequest("GET", fmt.Sprintf("/%s/%s?partNumber=4", bucket, key), nil)
+	brr := httptest.NewRecorder()
+	r.ServeHTTP(brr, br)
+	if brr.Code != 416 {
+		t.Fatalf("expected 416 for part beyond layout, got %d body=%s", brr.Code, brr.Body.String())
+	}
+
+	// GetObjectAttributes paginates the recorded parts
+	ar := httptest.NewRequest("GET", fmt.Sprintf("/%s/%s?attributes=", bucket, key), nil)
+	ar.Header.Set("x-amz-object-attributes", "ObjectParts")
+	ar.Header.Set("x-amz-max-parts", "2")
+	arr := httptest.NewRecorder()
+	r.ServeHTTP(arr, ar)
+	if arr.Code != 200 {
+		t.Fatalf("attributes status=%d body=%s", arr.Code, arr.Body.String())
+	}
+	var attrs struct {
+		PartsCount           int   `xml:"ObjectParts>PartsCount"`
+		IsTruncated          bool  `xml:"ObjectParts>IsTruncated"`
+		NextPartNumberMarker int   `xml:"ObjectParts>NextPartNumberMarker"`
+		PartNumbers          []int `xml:"ObjectParts>Part>PartNumber"`
+		Sizes                []int `xml:"ObjectParts>Part>Size"`
+	}
+	if err := xml.Unmarshal(arr.Body.Bytes(), &attrs); err != nil {
+		t.Fatalf("unmarshal attributes xml failed: %v\nxml=%s", err, arr.Body.String())
+	}
+	if attrs.PartsCount != 3 || !attrs.IsTruncated || attrs.NextPartNumberMarker != 2 ||
+		len(attrs.PartNumbers) != 2 || attrs.Sizes[1] != 5*1024*1024 {
+		t.Fatalf("unexpected object parts: %+v", attrs)
+	}
+}
+
+func TestCompleteMultipartUpload_ValidatesPartList(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	bucket := "validatebucket"
+	key := "obj.bin"
+
+	req := httptest.NewRequest("PUT", "/"+bucket, nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("create bucket status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/"+bucket+"/"+key+"?uploads=", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("init status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var ir initResp
+	if err := xml.Unmarshal(rr.Body.Bytes(), &ir); err != nil {
+		t.Fatalf("unmarshal init xml failed: %v\nxml=%s", err, rr.Body.String())
+	}
+
+	parts := [][]byte{
+		[]byte("small"),
+		bytes.Repeat([]byte("b"), 5*1024*1024),
+		[]byte("tail"),
+	}
+	etags := make([]string, len(parts))
+	for i, data := range parts {
+		upr := httptest.NewRequest("PUT", fmt.Sprintf("/%s/%s?uploadId=%s&partNumber=%d", bucket, key, ir.UploadId, i+1), bytes.NewReader(data))
+		uprr := httptest.NewRecorder()
+		r.ServeHTTP(uprr, upr)
+		if uprr.Code != 200 {
+			t.Fatalf("upload part %d failed: status=%d body=%s", i+1, uprr.Code, uprr.Body.String())
+		}
+		etags[i] = uprr.Header()["ETag"][0]
+	}
+
+	part := func(n int, etag string) string {
+		return fmt.Sprintf("<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", n, etag)
+	}
+	tests := []struct {
+		name     string
+		parts    string
+		wantCode string
+	}{
+		{"out of order", part(3, etags[2]) + part(2, etags[1]), "InvalidPartOrder"},
+		{"duplicate", part(2, etags[1]) + part(2, etags[1]), "InvalidPartOrder"},
+		{"etag mismatch", part(2, etags[2]) + part(3, etags[2]), "InvalidPart"},
+		{"not uploaded", part(2, etags[1]) + part(4, etags[2]), "InvalidPart"},
+		{"out of range", part(0, etags[0]), "InvalidPart"},
+		{"too small", part(1, etags[0]) + part(3, etags[2]), "EntityTooSmall"},
+		{"empty", "", "MalformedXML"},
+	}
+	for _, tc := range tests {
+		body := "<CompleteMultipartUpload>" + tc.parts + "</CompleteMultipartUpload>"
+		cr := httptest.NewRequest("POST", fmt.Sprintf("/%s/%s?uploadId=%s", bucket, key, ir.UploadId), bytes.NewBufferString(body))
+		crr := httptest.NewRecorder()
+		r.ServeHTTP(crr, cr)
+		if crr.Code != 400 || !strings.Contains(crr.Body.String(), "<Code>"+tc.wantCode+"</Code>") {
+			t.Fatalf("%s: expected %s, got status=%d body=%s", tc.name, tc.wantCode, crr.Code, crr.Body.String())
+		}
+	}
+
+	// Completing with a subset of the uploaded parts succeeds
+	body := "<CompleteMultipartUpload>" + part(2, etags[1]) + part(3, etags[2]) + "</CompleteMultipartUpload>"
+	cr := httptest.NewRequest("POST", fmt.Sprintf("/%s/%s?uploadId=%s", bucket, key, ir.UploadId), bytes.NewBufferString(body))
+	crr := httptest.NewRecorder()
+	r.ServeHTTP(crr, cr)
+	if crr.Code != 200 {
+		t.Fatalf("complete subset failed: status=%d body=%s", crr.Code, crr.Body.String())
+	}
+
+	gr := httptest.NewRequest("GET", "/"+bucket+"/"+key, nil)
+	grr := httptest.NewRecorder()
+	r.ServeHTTP(grr, gr)
+	if grr.Code != 200 || grr.Body.Len() != 5*1024*1024+4 {
+		t.Fatalf("get assembled object status=%d size=%d", grr.Code, grr.Body.Len())
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/kms"
 	"github.com/wpnpeiris/nats-s3/internal/logging"
 	"github.com/wpnpeiris/nats-s3/internal/model"
 	"github.com/wpnpeiris/nats-s3/internal/streams"
@@ -94,31 +95,58 @@ func (s *S3Gateway) CopyObject(w http.ResponseWriter, r *http.Request) {
 		model.WriteErrorResponse(w, r, model.ErrInvalidCopySource)
 		return
 	}
-
-	log.Printf("CopyObject from %s/%s to %s/%s", sourceBucket, sourceKey, destBucket, destKey)
-
-	// Get source object
-	sourceObj, sourceData, err := s.client.GetObject(r.Context(), sourceBucket, sourceKey)
-	if s.handleObjectError(w, r, err) {
+	if errCode := checkRedirectLocation(r); errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
 		return
 	}
 
-	// Determine metadata handling based on x-amz-metadata-directive
-	contentType, metadata := determineMetadataForCopy(r, sourceObj)
-
-	// Put object at destination (stream with cancellation)
-	destInfo, err := s.client.PutObjectStream(r.Context(), destBucket, destKey, contentType, metadata, bytes.NewReader(sourceData))
-	if s.handleObjectError(w, r, err) {
+	sourceSSE, errCode := copySourceEncryption(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
 		return
 	}
-
-	// Return CopyObjectResult XML response
-	result := CopyObjectResult{
-		ETag:         formatETag(destInfo.Digest),
-		LastModified: destInfo.ModTime,
+	destSSE, errCode := s.writeEncryption(r, destBucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	destACL, errCode := s.newObjectACL(r, destBucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
 	}
 
-	model.WriteXMLResponse(w, r, http.StatusOK, result)
+	log.Printf("CopyObject from %s/%s to %s/%s", sourceBucket, sourceKey, destBucket, destKey)
+
+	updateRequestedEncryptionHeaders(destSSE, w)
+	s.writeWithKeepAlive(w, r, func() (interface{}, model.ErrorCode) {
+		// Get source object
+		sourceObj, sourceData, err := s.client.GetObject(r.Context(), sourceBucket, sourceKey, sourceSSE)
+		if err != nil {
+			return nil, objectErrorCode(err)
+		}
+
+		_, err = s.client.CheckBucketQuota(r.Context(), destBucket, destKey, int64(len(sourceData)))
+		if err != nil {
+			return nil, objectErrorCode(err)
+		}
+
+		// Determine metadata handling based on x-amz-metadata-directive
+		contentType, metadata := determineMetadataForCopy(r, sourceObj)
+		client.SetObjectACL(metadata, destACL)
+
+		// Put object at destination (stream with cancellation)
+		destInfo, err := s.client.PutObjectStream(r.Context(), destBucket, destKey, contentType, metadata, bytes.NewReader(sourceData), destSSE)
+		if err != nil {
+			return nil, objectErrorCode(err)
+		}
+
+		// Return CopyObjectResult XML response
+		return CopyObjectResult{
+			ETag:         formatETag(destInfo.Digest),
+			LastModified: destInfo.ModTime,
+		}, model.ErrNone
+	})
 }
 
 // DeleteObject deletes the specified object and responds with 204 No Content.
@@ -202,43 +230,39 @@ func (s *S3Gateway) Download(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	info, data, err := s.client.GetObject(r.Context(), bucket, key)
-	if s.handleObjectError(w, r, err) {
+	sse, errCode := readEncryption(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
 		return
 	}
 
-	// Set common headers
-	if info != nil {
-		updateLastModifiedHeader(info, w)
-		updateETagHeader(info, w)
-		updateContentTypeHeaders(info, w)
+	// Serve a single part of the object when ?partNumber= is given
+	if isPartRequest(r) {
+		s.writeObjectPart(w, r, bucket, key, sse)
+		return
 	}
 
 	// Check for Range header
 	rangeHeader := r.Header.Get("Range")
 	if rangeHeader != "" {
-		// Parse and handle range request
-		start, end, err := parseRangeHeader(rangeHeader, len(data))
-		if err != nil {
-			// Invalid range - return 416 Range Not Satisfiable
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
-			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
-			return
-		}
-
-		// Return partial content
-		rangeData := data[start : end+1]
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(rangeData)))
-		w.WriteHeader(http.StatusPartialContent)
+		s.writeObjectRange(w, r, bucket, key, sse, rangeHeader)
+		return
+	}
 
-		_, err = w.Write(rangeData)
-		if err != nil {
-			log.Printf("Error writing range response body for %s/%s: %s", bucket, key, err)
-		}
+	info, data, err := s.client.GetObject(r.Context(), bucket, key, sse)
+	if s.handleObjectError(w, r, err) {
 		return
 	}
 
+	// Set common headers
+	if info != nil {
+		updateLastModifiedHeader(info, w)
+		updateETagHeader(info, w)
+		updateContentTypeHeaders(info, w)
+		updateEncryptionHeaders(info, w)
+		s.updateReplicationHeader(r, bucket, info, w)
+	}
+
 	// No range header - return full content
 	if info != nil {
 		updateContentLength(info, w)
@@ -251,6 +275,48 @@ func (s *S3Gateway) Download(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// writeObjectRange serves a Range request, reading only the requested bytes
+// of the object.
+func (s *S3Gateway) writeObjectRange(w http.ResponseWriter, r *http.Request, bucket, key string, sse *client.ServerSideEncryption, rangeHeader string) {
+	info, err := s.client.GetObjectInfo(r.Context(), bucket, key)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if s.handleObjectError(w, r, s.client.CheckObjectKey(r.Context(), info, sse)) {
+		return
+	}
+
+	// Parse and handle range request
+	size := int(info.Size)
+	start, end, err := parseRangeHeader(rangeHeader, size)
+	if err != nil {
+		// Invalid range - return 416 Range Not Satisfiable
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	rangeData, err := s.client.GetObjectRange(r.Context(), bucket, key, sse, int64(start), int64(end-start+1))
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	// Return partial content
+	updateLastModifiedHeader(info, w)
+	updateETagHeader(info, w)
+	updateContentTypeHeaders(info, w)
+	updateEncryptionHeaders(info, w)
+	s.updateReplicationHeader(r, bucket, info, w)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(rangeData)))
+	w.WriteHeader(http.StatusPartialContent)
+
+	_, err = w.Write(rangeData)
+	if err != nil {
+		log.Printf("Error writing range response body for %s/%s: %s", bucket, key, err)
+	}
+}
+
 // GetObjectRetention returns object retention configuration (mode and retain-until-date)
 func (s *S3Gateway) GetObjectRetention(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -281,10 +347,19 @@ func (s *S3Gateway) HeadObject(w http.ResponseWriter, r *http.Request) {
 	bucket := mux.Vars(r)["bucket"]
 	key := mux.Vars(r)["key"]
 
+	sse, errCode := readEncryption(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+
 	res, err := s.client.GetObjectInfo(r.Context(), bucket, key)
 	if s.handleObjectError(w, r, err) {
 		return
 	}
+	if s.handleObjectError(w, r, s.client.CheckObjectKey(r.Context(), res, sse)) {
+		return
+	}
 
 	log.Printf("Head object %s/%s", bucket, key)
 	if res != nil {
@@ -293,6 +368,18 @@ func (s *S3Gateway) HeadObject(w http.ResponseWriter, r *http.Request) {
 		updateETagHeader(res, w)
 		updateContentTypeHeaders(res, w)
 		updateMetadataHeaders(res, w)
+		updateEncryptionHeaders(res, w)
+		s.updateReplicationHeader(r, bucket, res, w)
+	}
+
+	if isPartRequest(r) {
+		part, errCode := s.resolvePartRange(r, res)
+		if errCode != model.ErrNone {
+			model.WriteErrorResponse(w, r, errCode)
+			return
+		}
+		writePartHeaders(w, part)
+		w.WriteHeader(http.StatusPartialContent)
 	}
 }
 
@@ -340,13 +427,15 @@ func (s *S3Gateway) GetObjectAttributes(w http.ResponseWriter, r *http.Request)
 		result.Checksum = &model.Checksum{}
 	}
 
-	// ObjectParts - only relevant for multipart objects
-	// NATS Object Store doesn't expose multipart structure for completed objects
+	// ObjectParts - only relevant for multipart objects, resolved from the
+	// part layout recorded when the upload was completed
 	if attrMap["ObjectParts"] {
-		result.ObjectParts = &model.GetObjectAttributesParts{
-			PartsCount:      aws.Int64(0),
-			TotalPartsCount: aws.Int64(0),
+		parts, errCode := s.objectPartsAttributes(r, res)
+		if errCode != model.ErrNone {
+			model.WriteErrorResponse(w, r, errCode)
+			return
 		}
+		result.ObjectParts = parts
 	}
 
 	model.WriteXMLResponse(w, r, http.StatusOK, result)
@@ -506,13 +595,17 @@ func (s *S3Gateway) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if errCode := checkRedirectLocation(r); errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
 	contentType := extractContentType(r)
 	meta := extractMetadata(r)
 
 	// Extract tags from x-amz-tagging header if present
 	tagMetadata, err := extractTagMetadataFromRequest(r)
 	if err != nil {
-		logging.Error(s.logger, "msg", "Error processing tags", "err", err)
+		logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Error processing tags", "err", err)
 		model.WriteErrorResponse(w, r, model.ErrInvalidTag)
 		return
 	}
@@ -521,21 +614,34 @@ func (s *S3Gateway) Upload(w http.ResponseWriter, r *http.Request) {
 		meta[k] = v
 	}
 
+	sse, errCode := s.writeEncryption(r, bucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	objectACL, errCode := s.newObjectACL(r, bucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	client.SetObjectACL(meta, objectACL)
+
+	allowance, err := s.client.CheckBucketQuota(r.Context(), bucket, key, r.ContentLength)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
 	log.Println("Upload to", bucket, "with key", key, " with content-type", contentType, " with user-meta", meta)
 	// Stream the body directly to JetStream with strict size validation
-	limitedReader := newSizeLimitReader(r.Body, maxSinglePutSize)
-	res, err := s.client.PutObjectStream(r.Context(), bucket, key, contentType, meta, limitedReader)
-	if err != nil {
-		if errors.Is(err, client.ErrBucketNotFound) {
-			model.WriteErrorResponse(w, r, model.ErrNoSuchBucket)
-			return
-		}
-		model.WriteErrorResponse(w, r, model.ErrInternalError)
+	limitedReader := newSizeLimitReader(client.QuotaReader(r.Body, allowance), maxSinglePutSize)
+	res, err := s.client.PutObjectStream(r.Context(), bucket, key, contentType, meta, limitedReader, sse)
+	if s.handleObjectError(w, r, err) {
 		return
 	}
 	if res.Digest != "" {
 		w.Header().Set("ETag", formatETag(res.Digest))
 	}
+	updateEncryptionHeaders(res, w)
 	model.WriteEmptyResponse(w, r, http.StatusOK)
 }
 
@@ -561,13 +667,17 @@ func (s *S3Gateway) StreamUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if errCode := checkRedirectLocation(r); errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
 	contentType := extractContentType(r)
 	meta := extractMetadata(r)
 
 	// Extract tags from x-amz-tagging header if present
 	tagMetadata, err := extractTagMetadataFromRequest(r)
 	if err != nil {
-		logging.Error(s.logger, "msg", "Error processing tags", "err", err)
+		logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Error processing tags", "err", err)
 		model.WriteErrorResponse(w, r, model.ErrInvalidTag)
 		return
 	}
@@ -576,23 +686,36 @@ func (s *S3Gateway) StreamUpload(w http.ResponseWriter, r *http.Request) {
 		meta[k] = v
 	}
 
+	sse, errCode := s.writeEncryption(r, bucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	objectACL, errCode := s.newObjectACL(r, bucket)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	client.SetObjectACL(meta, objectACL)
+
+	allowance, err := s.client.CheckBucketQuota(r.Context(), bucket, key, decodedContentLength(r))
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
 	log.Println("StreamUpload to", bucket, "with key", key, " with content-type", contentType, " with user-meta", meta)
 	// Use SigV4 decoder with strict size validation
 	dec := streams.NewLimitedSigV4StreamReader(r.Body, maxSinglePutSize+1)
 	defer dec.Close()
-	limitedReader := newSizeLimitReader(dec, maxSinglePutSize)
-	res, err := s.client.PutObjectStream(r.Context(), bucket, key, contentType, meta, limitedReader)
-	if err != nil {
-		if errors.Is(err, client.ErrBucketNotFound) {
-			model.WriteErrorResponse(w, r, model.ErrNoSuchBucket)
-			return
-		}
-		model.WriteErrorResponse(w, r, model.ErrInternalError)
+	limitedReader := newSizeLimitReader(client.QuotaReader(dec, allowance), maxSinglePutSize)
+	res, err := s.client.PutObjectStream(r.Context(), bucket, key, contentType, meta, limitedReader, sse)
+	if s.handleObjectError(w, r, err) {
 		return
 	}
 	if res.Digest != "" {
 		w.Header().Set("ETag", formatETag(res.Digest))
 	}
+	updateEncryptionHeaders(res, w)
 	model.WriteEmptyResponse(w, r, http.StatusOK)
 }
 
@@ -622,6 +745,9 @@ func determineMetadataForCopy(r *http.Request, sourceObj *jetstream.ObjectInfo)
 		for k, v := range sourceObj.Metadata {
 			metadata[k] = v
 		}
+		// The copy is written as a single object, so gateway-owned entries
+		// such as the multipart layout do not carry over
+		stripInternalMetadata(metadata)
 	}
 
 	// Handle tagging directive separately
@@ -674,10 +800,24 @@ func extractMetadata(r *http.Request) map[string]string {
 		if ln == "x-amz-object-lock-mode" || ln == "x-amz-object-lock-retain-until-date" {
 			meta[ln] = strings.Join(vals, ",")
 		}
+		// Extract the website redirect of the object
+		if ln == websiteRedirectHeader {
+			meta[ln] = strings.Join(vals, ",")
+		}
 	}
 	return meta
 }
 
+// decodedContentLength returns the payload size of a SigV4 streaming upload,
+// or -1 when the client did not declare it.
+func decodedContentLength(r *http.Request) int64 {
+	dl, err := strconv.ParseInt(r.Header.Get("x-amz-decoded-content-length"), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return dl
+}
+
 // formatETag wraps a digest string in quotes to create an S3-compatible ETag value.
 func formatETag(digest string) string {
 	return fmt.Sprintf("\"%s\"", digest)
@@ -740,16 +880,46 @@ func (s *S3Gateway) handleObjectError(w http.ResponseWriter, r *http.Request, er
 	if err == nil {
 		return false
 	}
+	model.WriteErrorResponse(w, r, objectErrorCode(err))
+	return true
+}
+
+// objectErrorCode maps an object client error to its S3 error code.
+func objectErrorCode(err error) model.ErrorCode {
 	if errors.Is(err, client.ErrBucketNotFound) {
-		model.WriteErrorResponse(w, r, model.ErrNoSuchBucket)
-		return true
+		return model.ErrNoSuchBucket
 	}
 	if errors.Is(err, client.ErrObjectNotFound) {
-		model.WriteErrorResponse(w, r, model.ErrNoSuchKey)
-		return true
+		return model.ErrNoSuchKey
 	}
-	model.WriteErrorResponse(w, r, model.ErrInternalError)
-	return true
+	if errors.Is(err, client.ErrSSENotConfigured) {
+		return model.ErrSSENotConfigured
+	}
+	if errors.Is(err, client.ErrSSECustomerKeyRequired) {
+		return model.ErrSSECustomerKeyMissing
+	}
+	if errors.Is(err, client.ErrSSECustomerKeyMismatch) {
+		return model.ErrAccessDenied
+	}
+	if errors.Is(err, client.ErrSSECustomerKeyNotNeeded) {
+		return model.ErrSSECustomerKeyNotNeeded
+	}
+	if errors.Is(err, kms.ErrKeyNotFound) {
+		return model.ErrKMSKeyNotFound
+	}
+	if errors.Is(err, kms.ErrKeyDisabled) {
+		return model.ErrKMSDisabled
+	}
+	if errors.Is(err, kms.ErrInvalidCiphertext) {
+		return model.ErrKMSInvalidCiphertext
+	}
+	if errors.Is(err, client.ErrQuotaExceeded) {
+		return model.ErrQuotaExceeded
+	}
+	if errors.Is(err, client.ErrInvalidReplication) {
+		return model.ErrInvalidReplicationConfiguration
+	}
+	return model.ErrInternalError
 }
 
 // parseRangeHeader parses an HTTP Range header and returns start and end byte positions.
@@ -884,7 +1054,7 @@ func updateLastModifiedHeader(obj *jetstream.ObjectInfo, w http.ResponseWriter)
 func updateMetadataHeaders(obj *jetstream.ObjectInfo, w http.ResponseWriter) {
 	if obj.Metadata != nil {
 		for k, v := range obj.Metadata {
-			if k == "" {
+			if k == "" || client.IsInternalMetadata(k) {
 				continue
 			}
 			w.Header().Set(k, v)
@@ -0,0 +1,194 @@
+package s3api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+const (
+	// defaultAttributesMaxParts is the number of parts returned by
+	// GetObjectAttributes when x-amz-max-parts is not given.
+	defaultAttributesMaxParts = 1000
+)
+
+// partRange is the byte range of a single part of an object, as requested
+// with the ?partNumber= query parameter.
+type partRange struct {
+	start      int64
+	length     int64
+	size       int64
+	partsCount int
+}
+
+// contentRange formats the Content-Range header value of the part.
+func (p partRange) contentRange() string {
+	if p.length == 0 {
+		return fmt.Sprintf("bytes */%d", p.size)
+	}
+	return fmt.Sprintf("bytes %d-%d/%d", p.start, p.start+p.length-1, p.size)
+}
+
+// resolvePartRange resolves the ?partNumber= query parameter of a GET or HEAD
+// request against the object's part layout. Objects uploaded in a single part
+// are treated as having exactly one part.
+func (s *S3Gateway) resolvePartRange(r *http.Request, info *jetstream.ObjectInfo) (*partRange, model.ErrorCode) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return nil, model.ErrInvalidPartNumber
+	}
+	if r.Header.Get("Range") != "" {
+		return nil, model.ErrInvalidRequest
+	}
+
+	layout, err := s.client.GetObjectLayout(r.Context(), mux.Vars(r)["bucket"], info)
+	if err != nil {
+		return nil, model.ErrInternalError
+	}
+
+	size := int64(info.Size)
+	if layout == nil {
+		if partNumber != 1 {
+			return nil, model.ErrInvalidPartNumber
+		}
+		return &partRange{start: 0, length: size, size: size, partsCount: 1}, model.ErrNone
+	}
+
+	offset, length, err := layout.PartRange(partNumber)
+	if err != nil {
+		return nil, model.ErrInvalidPartNumber
+	}
+	return &partRange{
+		start:      int64(offset),
+		length:     int64(length),
+		size:       size,
+		partsCount: len(layout.Parts),
+	}, model.ErrNone
+}
+
+// writePartHeaders writes the headers describing a part response.
+func writePartHeaders(w http.ResponseWriter, part *partRange) {
+	w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(part.partsCount))
+	w.Header().Set("Content-Range", part.contentRange())
+	w.Header().Set("Content-Length", strconv.FormatInt(part.length, 10))
+}
+
+// writeObjectPart writes a single part of an object as a 206 Partial Content
+// response, reading only the bytes of that part.
+func (s *S3Gateway) writeObjectPart(w http.ResponseWriter, r *http.Request, bucket, key string, sse *client.ServerSideEncryption) {
+	info, err := s.client.GetObjectInfo(r.Context(), bucket, key)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if s.handleObjectError(w, r, s.client.CheckObjectKey(r.Context(), info, sse)) {
+		return
+	}
+	part, errCode := s.resolvePartRange(r, info)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	data, err := s.client.GetObjectRange(r.Context(), bucket, key, sse, part.start, part.length)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if int64(len(data)) != part.length {
+		logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Part layout exceeds object size", "bucket", bucket, "key", key)
+		model.WriteErrorResponse(w, r, model.ErrInternalError)
+		return
+	}
+
+	updateLastModifiedHeader(info, w)
+	updateETagHeader(info, w)
+	updateContentTypeHeaders(info, w)
+	updateEncryptionHeaders(info, w)
+	s.updateReplicationHeader(r, bucket, info, w)
+	writePartHeaders(w, part)
+	w.WriteHeader(http.StatusPartialContent)
+	if _, err := w.Write(data); err != nil {
+		logging.Warn(logging.WithContext(r.Context(), s.logger), "msg", "Error writing part response body", "bucket", bucket, "key", key, "err", err)
+	}
+}
+
+// objectPartsAttributes builds the ObjectParts section of GetObjectAttributes,
+// honoring the x-amz-max-parts and x-amz-part-number-marker headers.
+func (s *S3Gateway) objectPartsAttributes(r *http.Request, info *jetstream.ObjectInfo) (*model.GetObjectAttributesParts, model.ErrorCode) {
+	maxParts := defaultAttributesMaxParts
+	if v := r.Header.Get("x-amz-max-parts"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, model.ErrInvalidMaxParts
+		}
+		maxParts = n
+	}
+	marker := 0
+	if v := r.Header.Get("x-amz-part-number-marker"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, model.ErrInvalidPartNumberMarker
+		}
+		marker = n
+	}
+
+	layout, err := s.client.GetObjectLayout(r.Context(), mux.Vars(r)["bucket"], info)
+	if err != nil {
+		return nil, model.ErrInternalError
+	}
+	if layout == nil {
+		return &model.GetObjectAttributesParts{
+			PartsCount:      aws.Int64(0),
+			TotalPartsCount: aws.Int64(0),
+		}, model.ErrNone
+	}
+
+	result := &model.GetObjectAttributesParts{
+		MaxParts:         aws.Int64(int64(maxParts)),
+		PartNumberMarker: aws.Int64(int64(marker)),
+		PartsCount:       aws.Int64(int64(len(layout.Parts))),
+		TotalPartsCount:  aws.Int64(int64(len(layout.Parts))),
+	}
+
+	truncated := false
+	for _, p := range layout.Parts {
+		if p.Number <= marker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			truncated = true
+			break
+		}
+		part := model.ObjectPart{
+			PartNumber: int64(p.Number),
+			Size:       int64(p.Size),
+		}
+		if p.ChecksumCRC32 != "" {
+			part.ChecksumCRC32 = aws.String(p.ChecksumCRC32)
+		}
+		result.Parts = append(result.Parts, part)
+		result.NextPartNumberMarker = aws.Int64(int64(p.Number))
+	}
+	result.IsTruncated = aws.Bool(truncated)
+
+	return result, model.ErrNone
+}
+
+// isPartRequest reports whether a GET or HEAD targets a single part.
+func isPartRequest(r *http.Request) bool {
+	return r.URL.Query().Has("partNumber")
+}
+
+// stripInternalMetadata removes gateway-owned entries from a metadata map.
+func stripInternalMetadata(metadata map[string]string) {
+	for k := range metadata {
+		if client.IsInternalMetadata(k) {
+			delete(metadata, k)
+		}
+	}
+}
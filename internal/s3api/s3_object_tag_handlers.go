@@ -26,7 +26,7 @@ func (s *S3Gateway) GetObjectTagging(w http.ResponseWriter, r *http.Request) {
 	bucket := mux.Vars(r)["bucket"]
 	key := mux.Vars(r)["key"]
 
-	logging.Info(s.logger, "msg", fmt.Sprintf("GetObjectTagging: bucket=%s key=%s", bucket, key))
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("GetObjectTagging: bucket=%s key=%s", bucket, key))
 
 	// Get object info to retrieve metadata
 	info, err := s.client.GetObjectInfo(r.Context(), bucket, key)
@@ -52,19 +52,19 @@ func (s *S3Gateway) PutObjectTagging(w http.ResponseWriter, r *http.Request) {
 	bucket := mux.Vars(r)["bucket"]
 	key := mux.Vars(r)["key"]
 
-	logging.Info(s.logger, "msg", fmt.Sprintf("PutObjectTagging: bucket=%s key=%s", bucket, key))
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutObjectTagging: bucket=%s key=%s", bucket, key))
 
 	// Parse tagging XML from request body
 	var tagging model.Tagging
 	if err := xml.NewDecoder(r.Body).Decode(&tagging); err != nil {
-		logging.Error(s.logger, "msg", "Error decoding tagging XML", "err", err)
+		logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Error decoding tagging XML", "err", err)
 		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
 		return
 	}
 
 	// Validate tags
 	if err := validateTags(tagging.TagSet.Tags); err != nil {
-		logging.Error(s.logger, "msg", "Tag validation failed", "err", err)
+		logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Tag validation failed", "err", err)
 		model.WriteErrorResponse(w, r, model.ErrInvalidTag)
 		return
 	}
@@ -86,7 +86,7 @@ func (s *S3Gateway) DeleteObjectTagging(w http.ResponseWriter, r *http.Request)
 	bucket := mux.Vars(r)["bucket"]
 	key := mux.Vars(r)["key"]
 
-	logging.Info(s.logger, "msg", fmt.Sprintf("DeleteObjectTagging: bucket=%s key=%s", bucket, key))
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("DeleteObjectTagging: bucket=%s key=%s", bucket, key))
 
 	// Delete all tags from object
 	err := s.client.DeleteObjectTags(r.Context(), bucket, key)
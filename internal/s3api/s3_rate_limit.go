@@ -0,0 +1,50 @@
+package s3api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/auth"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/ratelimit"
+)
+
+// auth authenticates a request, applies the rate limits of its caller,
+// checks the ACLs of its bucket and object and then admission control.
+func (s *S3Gateway) auth(next http.HandlerFunc) http.HandlerFunc {
+	return s.iam.Auth(s.rateLimit(s.authorize(s.admit(next))))
+}
+
+// rateLimit refuses requests over the rate limits of their access key,
+// bucket and operation class with 503 SlowDown. The bytes an allowed request
+// transfers are charged to the bandwidth limits once it completes.
+func (s *S3Gateway) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	if !s.limiter.Enabled() {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := ratelimit.Request{
+			Bucket: mux.Vars(r)["bucket"],
+			Class:  ratelimit.Class(s3Operation(r)),
+		}
+		if id, ok := auth.IdentityFrom(r.Context()); ok {
+			req.AccessKey = id.AccessKey
+		}
+		charge, ok := s.limiter.Allow(r.Context(), req)
+		if !ok {
+			logging.Debug(logging.WithContext(r.Context(), s.logger), "msg", "Rate limit exceeded",
+				"access_key", req.AccessKey, "bucket", req.Bucket, "class", req.Class)
+			model.WriteErrorResponse(w, r, model.ErrSlowDown)
+			return
+		}
+
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		charge(body.bytes + rec.bytes)
+	}
+}
@@ -0,0 +1,69 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/ratelimit"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestRateLimit(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{
+		RateLimits: []ratelimit.Rule{
+			{Bucket: "limited", Class: ratelimit.ClassWrite, RequestsPerSecond: 0.001, Burst: 2},
+		},
+		RateLimitShared: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := do(http.MethodPut, "/limited", ""); rr.Code != http.StatusOK {
+		t.Fatalf("create bucket: %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/limited/a", "hello"); rr.Code != http.StatusOK {
+		t.Fatalf("put within the burst: %d", rr.Code)
+	}
+
+	rr := do(http.MethodPut, "/limited/b", "hello")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 past the burst, got %d", rr.Code)
+	}
+	var errorResponse model.RESTErrorResponse
+	if err := xml.Unmarshal(rr.Body.Bytes(), &errorResponse); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	if errorResponse.Code != "SlowDown" {
+		t.Errorf("expected SlowDown, got %s", errorResponse.Code)
+	}
+
+	// Reads and other buckets are not limited
+	if rr := do(http.MethodGet, "/limited/a", ""); rr.Code != http.StatusOK {
+		t.Errorf("get: %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/other", ""); rr.Code != http.StatusOK {
+		t.Errorf("create other bucket: %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/healthz", ""); rr.Code != http.StatusOK {
+		t.Errorf("healthz: %d", rr.Code)
+	}
+}
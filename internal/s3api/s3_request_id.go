@@ -0,0 +1,36 @@
+package s3api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// assignRequestID gives each request a new ID, returned in x-amz-request-id
+// and error bodies and added to the log lines and NATS headers of the
+// request. The trace context of an incoming W3C traceparent header is kept
+// in the request context, so that its trace ID is logged next to the
+// request ID even when tracing is disabled.
+func (s *S3Gateway) assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := model.NewRequestID()
+		w.Header().Set(model.RequestIDHeader, id)
+		w.Header().Set(model.HostIDHeader, s.hostID)
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(ctx, id)))
+	})
+}
+
+// newHostID derives the x-amz-id-2 of the gateway from its host name, so
+// that responses can be traced to the gateway that served them without
+// revealing the host name.
+func newHostID() string {
+	host, _ := os.Hostname()
+	sum := sha256.Sum256([]byte(host))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package s3api
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestRequestID(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	var logs bytes.Buffer
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(&logs))
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	do := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := do(http.MethodPut, "/ids", "", nil); rr.Code != http.StatusOK {
+		t.Fatalf("create bucket: %d", rr.Code)
+	}
+
+	rr := do(http.MethodGet, "/ids/missing", "", nil)
+	id := rr.Header().Get(model.RequestIDHeader)
+	if !regexp.MustCompile(`^[0-9A-F]{16}$`).MatchString(id) {
+		t.Fatalf("expected a generated request ID, got %q", id)
+	}
+	hostID := rr.Header().Get(model.HostIDHeader)
+	if hostID == "" {
+		t.Fatal("expected a host ID")
+	}
+	var errorResponse model.RESTErrorResponse
+	if err := xml.Unmarshal(rr.Body.Bytes(), &errorResponse); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	if errorResponse.RequestID != id || errorResponse.HostID != hostID || errorResponse.Key != "missing" {
+		t.Errorf("unexpected error body %+v, expected request ID %s and host ID %s", errorResponse, id, hostID)
+	}
+
+	// Requests joining a trace get a new request ID, logged with the trace ID
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceparent := map[string]string{"traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"}
+	rr = do(http.MethodPut, "/ids/key", "hello", traceparent)
+	traced := rr.Header().Get(model.RequestIDHeader)
+	if !regexp.MustCompile(`^[0-9A-F]{16}$`).MatchString(traced) || traced == id {
+		t.Fatalf("expected a new request ID, got %q", traced)
+	}
+	if !strings.Contains(logs.String(), "request_id="+traced+" trace_id="+traceID) {
+		t.Errorf("expected the log lines of %s to carry trace ID %s", traced, traceID)
+	}
+	info, err := gw.client.GetObjectInfo(context.Background(), "ids", "key")
+	if err != nil {
+		t.Fatalf("get object info: %v", err)
+	}
+	if got := info.Headers.Get(client.RequestIDHeader); got != traced {
+		t.Errorf("expected the object to record request ID %s, got %q", traced, got)
+	}
+	if rr = do(http.MethodHead, "/ids/key", "", traceparent); rr.Header().Get(model.RequestIDHeader) == traced {
+		t.Errorf("expected requests of the same trace to get distinct request IDs")
+	}
+
+	// Request IDs sent by clients are not trusted to be unique
+	rr = do(http.MethodHead, "/ids/key", "", map[string]string{"X-Request-Id": "proxy-123"})
+	if got := rr.Header().Get(model.RequestIDHeader); !regexp.MustCompile(`^[0-9A-F]{16}$`).MatchString(got) {
+		t.Errorf("expected a generated request ID, got %q", got)
+	}
+}
@@ -0,0 +1,281 @@
+package s3api
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/private/protocol/eventstream"
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/s3select"
+)
+
+// selectErrorCodes maps the errors of an invalid query to their S3 errors.
+var selectErrorCodes = map[string]model.ErrorCode{
+	s3select.CodeUnsupportedSyntax:           model.ErrUnsupportedSyntax,
+	s3select.CodeUnsupportedFunction:         model.ErrUnsupportedFunction,
+	s3select.CodeInvalidFileHeaderInfo:       model.ErrInvalidFileHeaderInfo,
+	s3select.CodeInvalidJSONType:             model.ErrInvalidJSONType,
+	s3select.CodeInvalidQuoteFields:          model.ErrInvalidQuoteFields,
+	s3select.CodeInvalidCompressionFormat:    model.ErrInvalidCompressionFormat,
+	s3select.CodeObjectSerializationConflict: model.ErrObjectSerializationConflict,
+	s3select.CodeInvalidRequestParameter:     model.ErrInvalidRequestParameter,
+}
+
+// SelectObjectContent runs an SQL query over a CSV, JSON or Parquet object
+// and streams the results as an AWS event stream of Records events,
+// followed by Stats and End, or by an error event when the query fails
+// midway.
+func (s *S3Gateway) SelectObjectContent(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	key := vars["key"]
+
+	sse, errCode := readEncryption(r)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+
+	var body model.SelectObjectContentRequest
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&body); err != nil {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+	if !strings.EqualFold(body.ExpressionType, "SQL") {
+		model.WriteErrorResponse(w, r, model.ErrInvalidExpressionType)
+		return
+	}
+	req, errCode := selectRequest(&body)
+	if errCode != model.ErrNone {
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+	query, err := s3select.New(req)
+	if err != nil {
+		errCode := model.ErrInvalidRequest
+		var selectErr *s3select.Error
+		if errors.As(err, &selectErr) {
+			if code, ok := selectErrorCodes[selectErr.Code]; ok {
+				errCode = code
+			}
+		}
+		logging.Debug(logging.WithContext(r.Context(), s.logger), "msg", "Invalid select query", "bucket", bucket, "key", key, "err", err)
+		model.WriteErrorResponse(w, r, errCode)
+		return
+	}
+
+	// The object is opened once for the whole query, so that its data key
+	// and layout are not resolved again by each read
+	src, err := s.client.OpenObject(r.Context(), bucket, key, sse)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	defer src.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	events := &selectEventWriter{w: w}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	progress := body.RequestProgress != nil && body.RequestProgress.Enabled
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		events.keepAlive(ctx, s.keepAliveInterval, query, progress)
+	}()
+
+	err = query.Run(ctx, src, src.Size(), events)
+	cancel()
+	<-stopped
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		code, message := "InternalError", "We encountered an internal error, please try again."
+		var selectErr *s3select.Error
+		if errors.As(err, &selectErr) {
+			code, message = selectErr.Code, selectErr.Message
+		} else {
+			logging.Error(logging.WithContext(r.Context(), s.logger), "msg", "Error running select query", "bucket", bucket, "key", key, "err", err)
+		}
+		events.send(eventstream.Headers{
+			{Name: ":message-type", Value: eventstream.StringValue("error")},
+			{Name: ":error-code", Value: eventstream.StringValue(code)},
+			{Name: ":error-message", Value: eventstream.StringValue(message)},
+		}, nil)
+		return
+	}
+	events.stats("Stats", query.Stats())
+	events.event("End", "", nil)
+}
+
+// selectRequest converts the body of a SelectObjectContent request to a
+// query, checking that it names exactly one input and one output format.
+func selectRequest(body *model.SelectObjectContentRequest) (s3select.Request, model.ErrorCode) {
+	req := s3select.Request{Expression: body.Expression}
+
+	in := body.InputSerialization
+	req.Input.Compression = strings.ToUpper(in.CompressionType)
+	formats := 0
+	if in.CSV != nil {
+		formats++
+		req.Input.Format = s3select.FormatCSV
+		req.Input.CSV = s3select.CSVInput{
+			FileHeaderInfo:       strings.ToUpper(in.CSV.FileHeaderInfo),
+			Comments:             in.CSV.Comments,
+			QuoteEscapeCharacter: in.CSV.QuoteEscapeCharacter,
+			RecordDelimiter:      in.CSV.RecordDelimiter,
+			FieldDelimiter:       in.CSV.FieldDelimiter,
+			QuoteCharacter:       in.CSV.QuoteCharacter,
+		}
+	}
+	if in.JSON != nil {
+		formats++
+		req.Input.Format = s3select.FormatJSON
+		req.Input.JSON.Type = strings.ToUpper(in.JSON.Type)
+	}
+	if in.Parquet != nil {
+		formats++
+		req.Input.Format = s3select.FormatParquet
+	}
+	if formats != 1 {
+		return req, model.ErrObjectSerializationConflict
+	}
+
+	out := body.OutputSerialization
+	switch {
+	case out.CSV != nil && out.JSON != nil, out.CSV == nil && out.JSON == nil:
+		return req, model.ErrObjectSerializationConflict
+	case out.CSV != nil:
+		req.Output.Format = s3select.FormatCSV
+		req.Output.CSV = s3select.CSVOutput{
+			QuoteFields:          strings.ToUpper(out.CSV.QuoteFields),
+			QuoteEscapeCharacter: out.CSV.QuoteEscapeCharacter,
+			RecordDelimiter:      out.CSV.RecordDelimiter,
+			FieldDelimiter:       out.CSV.FieldDelimiter,
+			QuoteCharacter:       out.CSV.QuoteCharacter,
+		}
+	default:
+		req.Output.Format = s3select.FormatJSON
+		req.Output.JSON.RecordDelimiter = out.JSON.RecordDelimiter
+	}
+
+	if sr := body.ScanRange; sr != nil {
+		req.ScanRange = &s3select.ScanRange{Start: -1, End: -1}
+		if sr.Start != nil {
+			req.ScanRange.Start = *sr.Start
+		}
+		if sr.End != nil {
+			req.ScanRange.End = *sr.End
+		}
+	}
+	return req, model.ErrNone
+}
+
+// selectEventWriter writes the messages of a SelectObjectContent event
+// stream. Each Write is sent as a Records event.
+type selectEventWriter struct {
+	mu   sync.Mutex
+	w    http.ResponseWriter
+	err  error
+	last time.Time
+}
+
+func (e *selectEventWriter) Write(p []byte) (int, error) {
+	if err := e.event("Records", "application/octet-stream", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// event sends an event message of the given type.
+func (e *selectEventWriter) event(eventType, contentType string, payload []byte) error {
+	headers := eventstream.Headers{
+		{Name: ":message-type", Value: eventstream.StringValue("event")},
+		{Name: ":event-type", Value: eventstream.StringValue(eventType)},
+	}
+	if contentType != "" {
+		headers = append(headers, eventstream.Header{Name: ":content-type", Value: eventstream.StringValue(contentType)})
+	}
+	return e.send(headers, payload)
+}
+
+// stats sends a Stats or Progress event.
+func (e *selectEventWriter) stats(eventType string, stats s3select.Stats) error {
+	payload, err := xml.Marshal(model.SelectStats{
+		XMLName:        xml.Name{Local: eventType},
+		BytesScanned:   stats.BytesScanned,
+		BytesProcessed: stats.BytesProcessed,
+		BytesReturned:  stats.BytesReturned,
+	})
+	if err != nil {
+		return err
+	}
+	return e.event(eventType, "text/xml", payload)
+}
+
+// send encodes a message and flushes it to the client. Once a write fails,
+// every later send fails too.
+func (e *selectEventWriter) send(headers eventstream.Headers, payload []byte) error {
+	var buf bytes.Buffer
+	if err := eventstream.NewEncoder(&buf).Encode(eventstream.Message{Headers: headers, Payload: payload}); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	if _, err := e.w.Write(buf.Bytes()); err != nil {
+		e.err = err
+		return err
+	}
+	if flusher, ok := e.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	e.last = time.Now()
+	return nil
+}
+
+// keepAlive sends Progress events at every interval while the query runs,
+// when requested, and otherwise Cont events when no message was sent for an
+// interval, keeping clients and load balancers from timing out.
+func (e *selectEventWriter) keepAlive(ctx context.Context, interval time.Duration, query *s3select.Select, progress bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var err error
+		switch {
+		case progress:
+			err = e.stats("Progress", query.Stats())
+		case e.idle(interval):
+			err = e.event("Cont", "", nil)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// idle reports whether no message was sent for the interval.
+func (e *selectEventWriter) idle(interval time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Since(e.last) >= interval
+}
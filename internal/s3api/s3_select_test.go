@@ -0,0 +1,142 @@
+package s3api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/private/protocol/eventstream"
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func selectBody(sql, input, output string) string {
+	return `<SelectObjectContentRequest xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+		`<Expression>` + sql + `</Expression><ExpressionType>SQL</ExpressionType>` +
+		`<InputSerialization>` + input + `</InputSerialization>` +
+		`<OutputSerialization>` + output + `</OutputSerialization>` +
+		`</SelectObjectContentRequest>`
+}
+
+// readEvents decodes an event stream into its messages.
+func readEvents(t *testing.T, body []byte) []eventstream.Message {
+	t.Helper()
+	dec := eventstream.NewDecoder(bytes.NewReader(body))
+	var msgs []eventstream.Message
+	for {
+		msg, err := dec.Decode(nil)
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("decode event stream failed: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func header(msg eventstream.Message, name string) string {
+	if v := msg.Headers.Get(name); v != nil {
+		return v.String()
+	}
+	return ""
+}
+
+func TestSelectObjectContent(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	bucket := "tselect"
+	if _, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket}); err != nil {
+		t.Fatalf("create object store failed: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	put := httptest.NewRecorder()
+	r.ServeHTTP(put, httptest.NewRequest("PUT", "/"+bucket+"/people.csv", strings.NewReader("name,age\nann,31\nben,25\ncat,40\n")))
+	if put.Code != 200 {
+		t.Fatalf("PUT unexpected status: %d body=%s", put.Code, put.Body.String())
+	}
+
+	csvInput := `<CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV>`
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/"+bucket+"/people.csv?select&select-type=2",
+		strings.NewReader(selectBody("SELECT s.name FROM S3Object s WHERE CAST(s.age AS INT) &gt; 30", csvInput, `<JSON/>`))))
+	if rec.Code != 200 {
+		t.Fatalf("select unexpected status: %d body=%s", rec.Code, rec.Body.String())
+	}
+	var records string
+	var stats *model.SelectStats
+	msgs := readEvents(t, rec.Body.Bytes())
+	for _, msg := range msgs {
+		switch header(msg, ":event-type") {
+		case "Records":
+			records += string(msg.Payload)
+		case "Stats":
+			stats = &model.SelectStats{}
+			if err := xml.Unmarshal(msg.Payload, stats); err != nil {
+				t.Fatalf("unmarshal stats failed: %v", err)
+			}
+		}
+	}
+	if want := "{\"name\":\"ann\"}\n{\"name\":\"cat\"}\n"; records != want {
+		t.Fatalf("expected records %q, got %q", want, records)
+	}
+	if stats == nil || stats.BytesScanned != 30 || stats.BytesReturned != int64(len(records)) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if last := msgs[len(msgs)-1]; header(last, ":event-type") != "End" {
+		t.Fatalf("expected the stream to end with an End event, got %q", header(last, ":event-type"))
+	}
+
+	// Invalid queries fail before the stream starts
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/"+bucket+"/people.csv?select&select-type=2",
+		strings.NewReader(selectBody("SELECT FROM S3Object", csvInput, `<CSV/>`))))
+	if rec.Code != 400 || !strings.Contains(rec.Body.String(), "<Code>UnsupportedSyntax</Code>") {
+		t.Fatalf("expected UnsupportedSyntax, got status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	// Failures while running are reported as an error event
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/"+bucket+"/people.csv?select&select-type=2",
+		strings.NewReader(selectBody("SELECT CAST(name AS INT) FROM S3Object", csvInput, `<CSV/>`))))
+	msgs = readEvents(t, rec.Body.Bytes())
+	if rec.Code != 200 || len(msgs) == 0 {
+		t.Fatalf("expected an event stream, got status=%d", rec.Code)
+	}
+	if last := msgs[len(msgs)-1]; header(last, ":message-type") != "error" || header(last, ":error-code") != "CastFailed" {
+		t.Fatalf("expected a CastFailed error event, got %v", last.Headers)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("POST", "/"+bucket+"/missing.csv?select&select-type=2",
+		strings.NewReader(selectBody("SELECT * FROM S3Object", csvInput, `<CSV/>`))))
+	if rec.Code != 404 {
+		t.Fatalf("expected 404 for a missing object, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package s3api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+)
+
+// inflightRequest describes a request being served.
+type inflightRequest struct {
+	method  string
+	path    string
+	remote  string
+	started time.Time
+}
+
+// inflightRequests tracks the requests being served, so that those cut
+// short by a shutdown can be reported.
+type inflightRequests struct {
+	mu       sync.Mutex
+	next     uint64
+	requests map[uint64]inflightRequest
+}
+
+func (t *inflightRequests) add(r *http.Request) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.requests == nil {
+		t.requests = make(map[uint64]inflightRequest)
+	}
+	t.next++
+	t.requests[t.next] = inflightRequest{
+		method:  r.Method,
+		path:    r.URL.Path,
+		remote:  r.RemoteAddr,
+		started: time.Now(),
+	}
+	return t.next
+}
+
+func (t *inflightRequests) remove(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.requests, id)
+}
+
+func (t *inflightRequests) list() []inflightRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]inflightRequest, 0, len(t.requests))
+	for _, req := range t.requests {
+		res = append(res, req)
+	}
+	return res
+}
+
+// trackInflight records each request for the time it is being served.
+func (s *S3Gateway) trackInflight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := s.inflight.add(r)
+		defer s.inflight.remove(id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BeginShutdown reports the gateway as unhealthy, so that load balancers stop
+// sending it new requests while the in-flight ones complete.
+func (s *S3Gateway) BeginShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+// LogInterrupted logs the requests still being served, such as uploads a
+// shutdown is about to cut short, and returns how many there are.
+func (s *S3Gateway) LogInterrupted() int {
+	requests := s.inflight.list()
+	for _, req := range requests {
+		logging.Warn(s.logger, "msg", "Interrupting in-flight request", "method", req.method,
+			"path", req.path, "remote", req.remote, "duration", time.Since(req.started).String())
+	}
+	return len(requests)
+}
+
+// Drain drains the NATS connections of the gateway, letting pending writes
+// reach JetStream before they are closed. The pending access logs are
+// delivered first; failures to do so are logged. The read cache is cleared.
+func (s *S3Gateway) Drain(ctx context.Context) error {
+	s.accessLog.Close(ctx)
+	s.cache.Close()
+	routesErr := s.routes.Drain(ctx)
+	return errors.Join(routesErr, s.natsClient.Drain(ctx))
+}
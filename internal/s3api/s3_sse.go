@@ -0,0 +1,238 @@
+package s3api
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/encryption"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+)
+
+// Server side encryption headers
+const (
+	sseHeader                   = "x-amz-server-side-encryption"
+	sseCustomerHeaderPrefix     = "x-amz-server-side-encryption-customer-"
+	sseCopyCustomerHeaderPrefix = "x-amz-copy-source-server-side-encryption-customer-"
+	sseCustomerAlgorithmHeader  = sseCustomerHeaderPrefix + "algorithm"
+	sseCustomerKeyMD5Header     = sseCustomerHeaderPrefix + "key-MD5"
+	sseKMSKeyIDHeader           = "x-amz-server-side-encryption-aws-kms-key-id"
+)
+
+// parseCustomerKey reads the SSE-C headers starting with prefix. Requests
+// without SSE-C headers return nil.
+func parseCustomerKey(h http.Header, prefix string) (*client.ServerSideEncryption, model.ErrorCode) {
+	algorithm := h.Get(prefix + "algorithm")
+	key := h.Get(prefix + "key")
+	keyMD5 := h.Get(prefix + "key-MD5")
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return nil, model.ErrNone
+	}
+	if algorithm != client.SSEAlgorithmAES256 {
+		return nil, model.ErrInvalidEncryptionAlgorithm
+	}
+	if key == "" {
+		return nil, model.ErrSSECustomerKeyMissing
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != encryption.KeySize {
+		return nil, model.ErrInvalidEncryptionKey
+	}
+	sum := md5.Sum(raw)
+	expected := base64.StdEncoding.EncodeToString(sum[:])
+	if keyMD5 != "" && keyMD5 != expected {
+		return nil, model.ErrSSECustomerKeyMD5Mismatch
+	}
+	return &client.ServerSideEncryption{
+		Algorithm:      client.SSEAlgorithmCustomer,
+		CustomerKey:    raw,
+		CustomerKeyMD5: expected,
+	}, model.ErrNone
+}
+
+// readEncryption returns the customer key supplied to read an object.
+func readEncryption(r *http.Request) (*client.ServerSideEncryption, model.ErrorCode) {
+	return parseCustomerKey(r.Header, sseCustomerHeaderPrefix)
+}
+
+// copySourceEncryption returns the customer key supplied to read the source
+// of a copy.
+func copySourceEncryption(r *http.Request) (*client.ServerSideEncryption, model.ErrorCode) {
+	return parseCustomerKey(r.Header, sseCopyCustomerHeaderPrefix)
+}
+
+// writeEncryption resolves the encryption of an object written to bucket.
+// Explicit request headers win over the bucket default, which wins over the
+// gateway default.
+func (s *S3Gateway) writeEncryption(r *http.Request, bucket string) (*client.ServerSideEncryption, model.ErrorCode) {
+	sse, errCode := readEncryption(r)
+	if errCode != model.ErrNone {
+		return nil, errCode
+	}
+	algorithm := r.Header.Get(sseHeader)
+	keyID := r.Header.Get(sseKMSKeyIDHeader)
+	if keyID != "" && algorithm != client.SSEAlgorithmKMS {
+		return nil, model.ErrInvalidEncryptionAlgorithm
+	}
+	if sse != nil {
+		if algorithm != "" {
+			return nil, model.ErrInvalidEncryptionAlgorithm
+		}
+		return sse, model.ErrNone
+	}
+	switch algorithm {
+	case "":
+	case client.SSEAlgorithmAES256:
+		return &client.ServerSideEncryption{Algorithm: client.SSEAlgorithmAES256}, model.ErrNone
+	case client.SSEAlgorithmKMS:
+		return &client.ServerSideEncryption{Algorithm: client.SSEAlgorithmKMS, KMSKeyID: keyID}, model.ErrNone
+	default:
+		return nil, model.ErrInvalidEncryptionAlgorithm
+	}
+
+	// A missing bucket is reported by the write itself
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if err != nil && !errors.Is(err, client.ErrBucketNotFound) {
+		return nil, objectErrorCode(err)
+	}
+	if cfg != nil && cfg.Encryption != nil {
+		return &client.ServerSideEncryption{
+			Algorithm: cfg.Encryption.Algorithm,
+			KMSKeyID:  cfg.Encryption.KMSKeyID,
+		}, model.ErrNone
+	}
+	if s.encryptByDefault {
+		return &client.ServerSideEncryption{Algorithm: client.SSEAlgorithmAES256}, model.ErrNone
+	}
+	return nil, model.ErrNone
+}
+
+// updateEncryptionHeaders writes the encryption headers of an object.
+func updateEncryptionHeaders(obj *jetstream.ObjectInfo, w http.ResponseWriter) {
+	algorithm, keyMD5 := client.ObjectEncryption(obj.Metadata)
+	switch algorithm {
+	case client.SSEAlgorithmAES256:
+		w.Header().Set(sseHeader, client.SSEAlgorithmAES256)
+	case client.SSEAlgorithmKMS:
+		w.Header().Set(sseHeader, client.SSEAlgorithmKMS)
+		w.Header().Set(sseKMSKeyIDHeader, client.ObjectKMSKeyID(obj.Metadata))
+	case client.SSEAlgorithmCustomer:
+		w.Header().Set(sseCustomerAlgorithmHeader, client.SSEAlgorithmAES256)
+		w.Header().Set(sseCustomerKeyMD5Header, keyMD5)
+	}
+}
+
+// updateRequestedEncryptionHeaders echoes the encryption requested for an
+// object before it is stored, e.g. for multipart uploads.
+func updateRequestedEncryptionHeaders(sse *client.ServerSideEncryption, w http.ResponseWriter) {
+	if sse == nil {
+		return
+	}
+	switch sse.Algorithm {
+	case client.SSEAlgorithmAES256:
+		w.Header().Set(sseHeader, client.SSEAlgorithmAES256)
+	case client.SSEAlgorithmKMS:
+		w.Header().Set(sseHeader, client.SSEAlgorithmKMS)
+		if sse.KMSKeyID != "" {
+			w.Header().Set(sseKMSKeyIDHeader, sse.KMSKeyID)
+		}
+	case client.SSEAlgorithmCustomer:
+		w.Header().Set(sseCustomerAlgorithmHeader, client.SSEAlgorithmAES256)
+		w.Header().Set(sseCustomerKeyMD5Header, sse.CustomerKeyMD5)
+	}
+}
+
+// GetBucketEncryption returns the default encryption of a bucket.
+func (s *S3Gateway) GetBucketEncryption(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if cfg.Encryption == nil {
+		model.WriteErrorResponse(w, r, model.ErrNoSuchBucketEncryptionConfiguration)
+		return
+	}
+
+	response := model.ServerSideEncryptionConfiguration{
+		Rules: []model.ServerSideEncryptionRule{{
+			ApplyServerSideEncryptionByDefault: model.ServerSideEncryptionByDefault{
+				SSEAlgorithm:   cfg.Encryption.Algorithm,
+				KMSMasterKeyID: cfg.Encryption.KMSKeyID,
+			},
+		}},
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, response)
+}
+
+// PutBucketEncryption sets the default encryption of a bucket to SSE-S3
+// (AES256) or SSE-KMS (aws:kms).
+func (s *S3Gateway) PutBucketEncryption(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutBucketEncryption: bucket=%s", bucket))
+
+	var config model.ServerSideEncryptionConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil || len(config.Rules) != 1 {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+	rule := config.Rules[0].ApplyServerSideEncryptionByDefault
+	switch rule.SSEAlgorithm {
+	case client.SSEAlgorithmAES256:
+		if rule.KMSMasterKeyID != "" {
+			model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+			return
+		}
+		if !s.encryptionConfigured {
+			model.WriteErrorResponse(w, r, model.ErrSSENotConfigured)
+			return
+		}
+	case client.SSEAlgorithmKMS:
+		if !s.kmsConfigured {
+			model.WriteErrorResponse(w, r, model.ErrSSENotConfigured)
+			return
+		}
+	default:
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Encryption = &client.BucketEncryption{
+			Algorithm: rule.SSEAlgorithm,
+			KMSKeyID:  rule.KMSMasterKeyID,
+		}
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteBucketEncryption removes the default encryption of a bucket.
+func (s *S3Gateway) DeleteBucketEncryption(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("DeleteBucketEncryption: bucket=%s", bucket))
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Encryption = nil
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+
+	model.WriteEmptyResponse(w, r, http.StatusNoContent)
+}
@@ -0,0 +1,258 @@
+package s3api
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go"
+	"github.com/wpnpeiris/nats-s3/internal/encryption"
+	"github.com/wpnpeiris/nats-s3/internal/kms"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestServerSideEncryption(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	masterKey, _ := encryption.GenerateKey()
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{MasterKey: masterKey})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	nc, err := nats.Connect(s.Addr().String())
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	nc.SetClosedHandler(func(_ *nats.Conn) {})
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("JetStream failed: %v", err)
+	}
+	bucket := "tsse"
+	obs, err := js.CreateObjectStore(&nats.ObjectStoreConfig{Bucket: bucket})
+	if err != nil {
+		t.Fatalf("create object store failed: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	data := strings.Repeat("plaintext-", 20000)
+
+	// SSE-S3: stored encrypted, read back transparently
+	putReq := httptest.NewRequest("PUT", "/"+bucket+"/s3.txt", strings.NewReader(data))
+	putReq.Header.Set(sseHeader, "AES256")
+	putRec := httptest.NewRecorder()
+	r.ServeHTTP(putRec, putReq)
+	if putRec.Code != 200 || putRec.Header().Get(sseHeader) != "AES256" {
+		t.Fatalf("PUT status=%d sse=%q body=%s", putRec.Code, putRec.Header().Get(sseHeader), putRec.Body.String())
+	}
+	raw, err := obs.GetBytes("s3.txt")
+	if err != nil {
+		t.Fatalf("raw get failed: %v", err)
+	}
+	if bytes.Contains(raw, []byte("plaintext-")) {
+		t.Fatalf("object stored in plaintext")
+	}
+
+	getRec := httptest.NewRecorder()
+	r.ServeHTTP(getRec, httptest.NewRequest("GET", "/"+bucket+"/s3.txt", nil))
+	if getRec.Code != 200 || getRec.Body.String() != data {
+		t.Fatalf("GET status=%d, body mismatch=%v", getRec.Code, getRec.Body.String() != data)
+	}
+
+	headRec := httptest.NewRecorder()
+	r.ServeHTTP(headRec, httptest.NewRequest("HEAD", "/"+bucket+"/s3.txt", nil))
+	if headRec.Header().Get("Content-Length") != "200000" || headRec.Header().Get(sseHeader) != "AES256" {
+		t.Fatalf("HEAD Content-Length=%q sse=%q", headRec.Header().Get("Content-Length"), headRec.Header().Get(sseHeader))
+	}
+
+	rangeReq := httptest.NewRequest("GET", "/"+bucket+"/s3.txt", nil)
+	rangeReq.Header.Set("Range", "bytes=100000-100009")
+	rangeRec := httptest.NewRecorder()
+	r.ServeHTTP(rangeRec, rangeReq)
+	if rangeRec.Code != 206 || rangeRec.Body.String() != data[100000:100010] {
+		t.Fatalf("Range status=%d body=%q", rangeRec.Code, rangeRec.Body.String())
+	}
+
+	// SSE-C: the customer key is required on every read
+	customerKey, _ := encryption.GenerateKey()
+	sum := md5.Sum(customerKey)
+	setCustomerKey := func(req interface{ Set(string, string) }, key []byte) {
+		req.Set(sseCustomerAlgorithmHeader, "AES256")
+		req.Set(sseCustomerHeaderPrefix+"key", base64.StdEncoding.EncodeToString(key))
+		req.Set(sseCustomerKeyMD5Header, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	putReq = httptest.NewRequest("PUT", "/"+bucket+"/c.txt", strings.NewReader(data))
+	setCustomerKey(putReq.Header, customerKey)
+	putRec = httptest.NewRecorder()
+	r.ServeHTTP(putRec, putReq)
+	if putRec.Code != 200 || putRec.Header().Get(sseCustomerKeyMD5Header) == "" {
+		t.Fatalf("SSE-C PUT status=%d body=%s", putRec.Code, putRec.Body.String())
+	}
+
+	getRec = httptest.NewRecorder()
+	r.ServeHTTP(getRec, httptest.NewRequest("GET", "/"+bucket+"/c.txt", nil))
+	if getRec.Code != 400 {
+		t.Fatalf("SSE-C GET without key: expected 400, got %d", getRec.Code)
+	}
+
+	getReq := httptest.NewRequest("GET", "/"+bucket+"/c.txt", nil)
+	setCustomerKey(getReq.Header, customerKey)
+	getRec = httptest.NewRecorder()
+	r.ServeHTTP(getRec, getReq)
+	if getRec.Code != 200 || getRec.Body.String() != data {
+		t.Fatalf("SSE-C GET status=%d", getRec.Code)
+	}
+
+	// Copy an SSE-C object to a plain one using the copy-source key headers
+	copyReq := httptest.NewRequest("PUT", "/"+bucket+"/copy.txt", nil)
+	copyReq.Header.Set("x-amz-copy-source", "/"+bucket+"/c.txt")
+	copyReq.Header.Set(sseCopyCustomerHeaderPrefix+"algorithm", "AES256")
+	copyReq.Header.Set(sseCopyCustomerHeaderPrefix+"key", base64.StdEncoding.EncodeToString(customerKey))
+	copyRec := httptest.NewRecorder()
+	r.ServeHTTP(copyRec, copyReq)
+	if copyRec.Code != 200 || strings.Contains(copyRec.Body.String(), "<Error>") {
+		t.Fatalf("copy status=%d body=%s", copyRec.Code, copyRec.Body.String())
+	}
+	getRec = httptest.NewRecorder()
+	r.ServeHTTP(getRec, httptest.NewRequest("GET", "/"+bucket+"/copy.txt", nil))
+	if getRec.Code != 200 || getRec.Body.String() != data {
+		t.Fatalf("copy GET status=%d", getRec.Code)
+	}
+}
+
+func TestBucketEncryption(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	masterKey, _ := encryption.GenerateKey()
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{MasterKey: masterKey})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	bucket := "tbenc"
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("PUT", "/"+bucket, nil))
+	if rec.Code != 200 {
+		t.Fatalf("create bucket status=%d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/"+bucket+"?encryption", nil))
+	if rec.Code != 404 {
+		t.Fatalf("GET encryption before PUT: expected 404, got %d", rec.Code)
+	}
+
+	config := `<ServerSideEncryptionConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("PUT", "/"+bucket+"?encryption", strings.NewReader(config)))
+	if rec.Code != 200 {
+		t.Fatalf("PUT encryption status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/"+bucket+"?encryption", nil))
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "<SSEAlgorithm>AES256</SSEAlgorithm>") {
+		t.Fatalf("GET encryption status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	// Objects written without headers pick up the bucket default
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("PUT", "/"+bucket+"/obj", strings.NewReader("data")))
+	if rec.Code != 200 || rec.Header().Get(sseHeader) != "AES256" {
+		t.Fatalf("PUT object status=%d sse=%q", rec.Code, rec.Header().Get(sseHeader))
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("DELETE", "/"+bucket+"?encryption", nil))
+	if rec.Code != 204 {
+		t.Fatalf("DELETE encryption status=%d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/"+bucket+"?encryption", nil))
+	if rec.Code != 404 {
+		t.Fatalf("GET encryption after DELETE: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestServerSideEncryptionKMS(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	keyService, err := kms.NewFileKMS(filepath.Join(t.TempDir(), "kms.json"))
+	if err != nil {
+		t.Fatalf("NewFileKMS failed: %v", err)
+	}
+	if err := keyService.CreateKey("tenant-a"); err != nil {
+		t.Fatalf("CreateKey failed: %v", err)
+	}
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{KMS: keyService})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	bucket := "tkms"
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("PUT", "/"+bucket, nil))
+	if rec.Code != 200 {
+		t.Fatalf("create bucket status=%d", rec.Code)
+	}
+
+	putReq := httptest.NewRequest("PUT", "/"+bucket+"/obj", strings.NewReader("kms-data"))
+	putReq.Header.Set(sseHeader, "aws:kms")
+	putReq.Header.Set(sseKMSKeyIDHeader, "tenant-a")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, putReq)
+	if rec.Code != 200 || rec.Header().Get(sseKMSKeyIDHeader) != "tenant-a" {
+		t.Fatalf("PUT status=%d key=%q body=%s", rec.Code, rec.Header().Get(sseKMSKeyIDHeader), rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("HEAD", "/"+bucket+"/obj", nil))
+	if rec.Header().Get(sseHeader) != "aws:kms" || rec.Header().Get(sseKMSKeyIDHeader) != "tenant-a" {
+		t.Fatalf("HEAD sse=%q key=%q", rec.Header().Get(sseHeader), rec.Header().Get(sseKMSKeyIDHeader))
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/"+bucket+"/obj", nil))
+	if rec.Code != 200 || rec.Body.String() != "kms-data" {
+		t.Fatalf("GET status=%d body=%q", rec.Code, rec.Body.String())
+	}
+
+	// Unknown keys and disabled keys map to the KMS errors
+	putReq = httptest.NewRequest("PUT", "/"+bucket+"/other", strings.NewReader("x"))
+	putReq.Header.Set(sseHeader, "aws:kms")
+	putReq.Header.Set(sseKMSKeyIDHeader, "missing")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, putReq)
+	if rec.Code != 400 || !strings.Contains(rec.Body.String(), "KMSKeyNotFoundException") {
+		t.Fatalf("PUT with unknown key status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	if err := keyService.SetKeyEnabled("tenant-a", false); err != nil {
+		t.Fatalf("SetKeyEnabled failed: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/"+bucket+"/obj", nil))
+	if rec.Code != 400 || !strings.Contains(rec.Body.String(), "KMSKeyDisabledException") {
+		t.Fatalf("GET with disabled key status=%d body=%s", rec.Code, rec.Body.String())
+	}
+}
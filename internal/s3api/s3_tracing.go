@@ -0,0 +1,43 @@
+package s3api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// traceRequest starts the span of each request, named after its S3
+// operation, continuing the trace of the client when it sends one.
+func (s *S3Gateway) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		vars := mux.Vars(r)
+		ctx, span := tracing.StartServer(ctx, s3Operation(r),
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+			attribute.String("aws.s3.bucket", vars["bucket"]),
+			attribute.String("aws.s3.key", vars["key"]),
+			attribute.String("aws.request_id", logging.RequestID(r.Context())),
+		)
+		defer span.End()
+
+		r, re := withResponseError(r.WithContext(ctx))
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.statusCode()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if re.Code != "" {
+			span.SetAttributes(attribute.String("aws.s3.error_code", re.Code))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, re.Code)
+		}
+	})
+}
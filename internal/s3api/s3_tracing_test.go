@@ -0,0 +1,119 @@
+package s3api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceRequest(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	stop, err := tracing.Setup(context.Background(), tracing.Config{SampleRatio: 1, ServiceName: "test", Exporter: exporter})
+	if err != nil {
+		t.Fatalf("setup tracing: %v", err)
+	}
+	defer stop(context.Background())
+
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/trace-bucket", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create bucket: %d", rr.Code)
+	}
+	exporter.Reset()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPut, "/trace-bucket/key", strings.NewReader("hello"))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("put object: %d", rr.Code)
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	server, ok := byName["PutObject"]
+	if !ok {
+		t.Fatalf("expected a PutObject span, got %v", spans)
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("expected server span, got %v", server.SpanKind)
+	}
+	for _, name := range []string{"PutObject", "NatsObjectClient.PutObjectStream"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("expected a %s span", name)
+			continue
+		}
+		if got := span.SpanContext.TraceID().String(); got != traceID {
+			t.Errorf("%s: expected trace %s, got %s", name, traceID, got)
+		}
+	}
+	if put := byName["NatsObjectClient.PutObjectStream"]; put.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Errorf("expected PutObjectStream to be a child of the request span")
+	}
+
+	info, err := gw.client.GetObjectInfo(context.Background(), "trace-bucket", "key")
+	if err != nil {
+		t.Fatalf("get object info: %v", err)
+	}
+	// Object metadata is not a place for the trace context
+	if tp := info.Headers.Get("traceparent"); tp != "" {
+		t.Errorf("expected object headers without the trace, got %q", tp)
+	}
+
+	// The Key-Value writes of a request carry it in their message headers
+	req = httptest.NewRequest(http.MethodPost, "/trace-bucket/multi?uploads", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("initiate multipart upload: %d", rr.Code)
+	}
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	stream, err := js.Stream(context.Background(), "KV_"+client.MetaStoreName)
+	if err != nil {
+		t.Fatalf("multipart session stream: %v", err)
+	}
+	msg, err := stream.GetLastMsgForSubject(context.Background(), "$KV."+client.MetaStoreName+".>")
+	if err != nil {
+		t.Fatalf("get multipart session: %v", err)
+	}
+	if tp := msg.Header.Get("traceparent"); !strings.Contains(tp, traceID) {
+		t.Errorf("expected the multipart session message to carry the trace, got %q", tp)
+	}
+}
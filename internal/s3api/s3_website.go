@@ -0,0 +1,322 @@
+package s3api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/acl"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/model"
+	"github.com/wpnpeiris/nats-s3/internal/website"
+)
+
+// websiteRedirectHeader redirects website requests for an object to
+// another object of the bucket or to a URL.
+const websiteRedirectHeader = "x-amz-website-redirect-location"
+
+// maxRedirectLocationSize is the longest website redirect location, as
+// with S3.
+const maxRedirectLocationSize = 2048
+
+// GetBucketWebsite returns the website configuration of a bucket.
+func (s *S3Gateway) GetBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	if cfg.Website == nil {
+		model.WriteErrorResponse(w, r, model.ErrNoSuchWebsiteConfiguration)
+		return
+	}
+	model.WriteXMLResponse(w, r, http.StatusOK, websiteConfiguration(cfg.Website))
+}
+
+// PutBucketWebsite hosts a static website from a bucket.
+func (s *S3Gateway) PutBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("PutBucketWebsite: bucket=%s", bucket))
+
+	var body model.WebsiteConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxXMLBodySize)).Decode(&body); err != nil {
+		model.WriteErrorResponse(w, r, model.ErrMalformedXML)
+		return
+	}
+	site, err := websiteConfig(&body)
+	if err == nil {
+		err = site.Validate()
+	}
+	if err != nil {
+		logging.Debug(logging.WithContext(r.Context(), s.logger), "msg", "Invalid website configuration", "bucket", bucket, "err", err)
+		model.WriteErrorResponse(w, r, model.ErrInvalidWebsiteConfiguration)
+		return
+	}
+
+	err = s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Website = site
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteBucketWebsite stops hosting a website from a bucket.
+func (s *S3Gateway) DeleteBucketWebsite(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+
+	logging.Info(logging.WithContext(r.Context(), s.logger), "msg", fmt.Sprintf("DeleteBucketWebsite: bucket=%s", bucket))
+
+	err := s.client.UpdateBucketConfig(r.Context(), bucket, func(cfg *client.BucketConfig) error {
+		cfg.Website = nil
+		return nil
+	})
+	if s.handleObjectError(w, r, err) {
+		return
+	}
+	model.WriteEmptyResponse(w, r, http.StatusNoContent)
+}
+
+// websiteConfig converts a WebsiteConfiguration body to the configuration
+// stored with the bucket.
+func websiteConfig(body *model.WebsiteConfiguration) (*website.Config, error) {
+	site := &website.Config{}
+	if body.IndexDocument != nil {
+		site.IndexDocument = body.IndexDocument.Suffix
+	}
+	if body.ErrorDocument != nil {
+		site.ErrorDocument = body.ErrorDocument.Key
+	}
+	if all := body.RedirectAllRequestsTo; all != nil {
+		site.RedirectAll = &website.RedirectAll{HostName: all.HostName, Protocol: all.Protocol}
+	}
+	for _, rule := range body.RoutingRules {
+		var res website.RoutingRule
+		if cond := rule.Condition; cond != nil {
+			res.Condition = &website.Condition{KeyPrefixEquals: cond.KeyPrefixEquals}
+			if cond.HttpErrorCodeReturnedEquals != "" {
+				code, err := strconv.Atoi(cond.HttpErrorCodeReturnedEquals)
+				if err != nil {
+					return nil, fmt.Errorf("%w: error code %q", website.ErrInvalidConfig, cond.HttpErrorCodeReturnedEquals)
+				}
+				res.Condition.HTTPErrorCodeReturnedEquals = code
+			}
+		}
+		res.Redirect = website.Redirect{
+			HostName:             rule.Redirect.HostName,
+			Protocol:             rule.Redirect.Protocol,
+			ReplaceKeyPrefixWith: rule.Redirect.ReplaceKeyPrefixWith,
+			ReplaceKeyWith:       rule.Redirect.ReplaceKeyWith,
+		}
+		if rule.Redirect.HttpRedirectCode != "" {
+			code, err := strconv.Atoi(rule.Redirect.HttpRedirectCode)
+			if err != nil {
+				return nil, fmt.Errorf("%w: redirect code %q", website.ErrInvalidConfig, rule.Redirect.HttpRedirectCode)
+			}
+			res.Redirect.HTTPRedirectCode = code
+		}
+		site.RoutingRules = append(site.RoutingRules, res)
+	}
+	return site, nil
+}
+
+// websiteConfiguration returns the WebsiteConfiguration response of a
+// website.
+func websiteConfiguration(site *website.Config) model.WebsiteConfiguration {
+	var res model.WebsiteConfiguration
+	if site.IndexDocument != "" {
+		res.IndexDocument = &model.IndexDocument{Suffix: site.IndexDocument}
+	}
+	if site.ErrorDocument != "" {
+		res.ErrorDocument = &model.ErrorDocument{Key: site.ErrorDocument}
+	}
+	if all := site.RedirectAll; all != nil {
+		res.RedirectAllRequestsTo = &model.RedirectAllRequestsTo{HostName: all.HostName, Protocol: all.Protocol}
+	}
+	for _, rule := range site.RoutingRules {
+		var out model.RoutingRule
+		if cond := rule.Condition; cond != nil {
+			out.Condition = &model.RoutingRuleCondition{KeyPrefixEquals: cond.KeyPrefixEquals}
+			if cond.HTTPErrorCodeReturnedEquals != 0 {
+				out.Condition.HttpErrorCodeReturnedEquals = strconv.Itoa(cond.HTTPErrorCodeReturnedEquals)
+			}
+		}
+		out.Redirect = model.RoutingRuleRedirect{
+			HostName:             rule.Redirect.HostName,
+			Protocol:             rule.Redirect.Protocol,
+			ReplaceKeyPrefixWith: rule.Redirect.ReplaceKeyPrefixWith,
+			ReplaceKeyWith:       rule.Redirect.ReplaceKeyWith,
+		}
+		if rule.Redirect.HTTPRedirectCode != 0 {
+			out.Redirect.HttpRedirectCode = strconv.Itoa(rule.Redirect.HTTPRedirectCode)
+		}
+		res.RoutingRules = append(res.RoutingRules, out)
+	}
+	return res
+}
+
+// checkRedirectLocation validates the website redirect location set on an
+// object written by a request.
+func checkRedirectLocation(r *http.Request) model.ErrorCode {
+	loc := r.Header.Get(websiteRedirectHeader)
+	if loc == "" {
+		return model.ErrNone
+	}
+	if len(loc) > maxRedirectLocationSize ||
+		!strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "http://") && !strings.HasPrefix(loc, "https://") {
+		return model.ErrInvalidRedirectLocation
+	}
+	return model.ErrNone
+}
+
+// RegisterWebsiteRoutes wires the website endpoints of buckets onto the
+// router of a dedicated website listener. The first label of the host name
+// of a request names its bucket, so that docs.example.com serves the bucket
+// docs.
+func (s *S3Gateway) RegisterWebsiteRoutes(router *mux.Router) {
+	r := router.PathPrefix("/").Subrouter()
+	s.useMiddleware(r)
+	s.registerWebsiteRoutes(r.Host("{bucket:[^.]+}{domain:.*}").Subrouter())
+}
+
+// registerWebsiteRoutes wires the website endpoint onto a router that
+// resolves the bucket variable. Website requests are rate limited and
+// admitted like GetObject, but never authenticated.
+func (s *S3Gateway) registerWebsiteRoutes(bucket *mux.Router) {
+	bucket.Path("/{key:.*}").HandlerFunc(s.rateLimit(s.admit(s.ServeWebsite)))
+}
+
+// ServeWebsite serves a website request for a bucket: anonymous GET and
+// HEAD requests for its objects, resolving directories to their index
+// document. Failed requests are redirected by the matching routing rule,
+// or answered with the error document of the bucket or an HTML error page.
+func (s *S3Gateway) ServeWebsite(w http.ResponseWriter, r *http.Request) {
+	bucket := mux.Vars(r)["bucket"]
+	key := mux.Vars(r)["key"]
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		model.WriteHTMLErrorResponse(w, r, model.ErrMethodNotAllowed)
+		return
+	}
+	cfg, err := s.client.GetBucketConfig(r.Context(), bucket)
+	if err != nil {
+		model.WriteHTMLErrorResponse(w, r, objectErrorCode(err))
+		return
+	}
+	site := cfg.Website
+	if site == nil {
+		model.WriteHTMLErrorResponse(w, r, model.ErrNoSuchWebsiteConfiguration)
+		return
+	}
+
+	scheme := requestScheme(r)
+	if site.RedirectAll != nil {
+		http.Redirect(w, r, site.RedirectAll.Location(key, scheme), http.StatusMovedPermanently)
+		return
+	}
+	if rule := site.Match(key, 0); rule != nil {
+		http.Redirect(w, r, rule.Location(key, scheme, r.Host), rule.StatusCode())
+		return
+	}
+
+	errCode := s.serveWebsiteObject(w, r, bucket, site.IndexKey(key), cfg, http.StatusOK)
+	if errCode == model.ErrNone {
+		return
+	}
+	// Requests for a directory without the trailing slash are sent to it
+	if errCode == model.ErrNoSuchKey && key != "" && !strings.HasSuffix(key, "/") {
+		if _, indexErr := s.websiteObject(r, bucket, key+"/"+site.IndexDocument, cfg); indexErr == model.ErrNone {
+			http.Redirect(w, r, "/"+key+"/", http.StatusFound)
+			return
+		}
+	}
+
+	status := model.GetAPIError(errCode).HTTPStatusCode
+	if rule := site.Match(key, status); rule != nil {
+		http.Redirect(w, r, rule.Location(key, scheme, r.Host), rule.StatusCode())
+		return
+	}
+	if site.ErrorDocument != "" {
+		if s.serveWebsiteObject(w, r, bucket, site.ErrorDocument, cfg, status) == model.ErrNone {
+			return
+		}
+	}
+	model.WriteHTMLErrorResponse(w, r, errCode)
+}
+
+// serveWebsiteObject writes an object readable by anonymous callers with
+// the given status, following its website redirect location when it is
+// served as is. Returns the error of the request when nothing was written.
+func (s *S3Gateway) serveWebsiteObject(w http.ResponseWriter, r *http.Request, bucket, key string, cfg *client.BucketConfig, status int) model.ErrorCode {
+	info, errCode := s.websiteObject(r, bucket, key, cfg)
+	if errCode != model.ErrNone {
+		return errCode
+	}
+	if loc := info.Metadata[websiteRedirectHeader]; loc != "" && status == http.StatusOK {
+		http.Redirect(w, r, loc, http.StatusMovedPermanently)
+		return model.ErrNone
+	}
+
+	var data []byte
+	if r.Method == http.MethodGet {
+		var err error
+		info, data, err = s.client.GetObject(r.Context(), bucket, key, nil)
+		if err != nil {
+			return objectErrorCode(err)
+		}
+	}
+	updateLastModifiedHeader(info, w)
+	updateETagHeader(info, w)
+	updateContentTypeHeaders(info, w)
+	updateContentLength(info, w)
+	w.WriteHeader(status)
+	if data != nil {
+		if _, err := w.Write(data); err != nil {
+			logging.Debug(logging.WithContext(r.Context(), s.logger), "msg", "Error writing website response", "bucket", bucket, "key", key, "err", err)
+		}
+	}
+	return model.ErrNone
+}
+
+// websiteObject returns the info of an object served by a website, which
+// anonymous callers must be allowed to read when authentication is enabled.
+// Objects encrypted with customer keys cannot be served.
+func (s *S3Gateway) websiteObject(r *http.Request, bucket, key string, cfg *client.BucketConfig) (*jetstream.ObjectInfo, model.ErrorCode) {
+	info, err := s.client.GetObjectInfo(r.Context(), bucket, key)
+	if err != nil {
+		return nil, objectErrorCode(err)
+	}
+	if s.iam.Enabled() {
+		var objectACL *acl.ACL
+		if cfg.ObjectOwnership != acl.BucketOwnerEnforced {
+			if objectACL, err = client.ObjectACL(info); err != nil {
+				return nil, objectErrorCode(err)
+			}
+		}
+		if !objectAllows(cfg, objectACL, "", acl.PermRead) {
+			return nil, model.ErrAccessDenied
+		}
+	}
+	if err := s.client.CheckObjectKey(r.Context(), info, nil); err != nil {
+		return nil, objectErrorCode(err)
+	}
+	return info, model.ErrNone
+}
+
+// requestScheme returns the scheme a request was sent with.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,144 @@
+package s3api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/gorilla/mux"
+	"github.com/wpnpeiris/nats-s3/internal/acl"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestBucketWebsite(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	creds := testCredentials{"alice": "alice-secret"}
+	gw, err := NewS3Gateway(logger, s.ClientURL(), 1, nil, creds, S3GatewayOptions{
+		WebsiteDomains: []string{"website.local"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	r := mux.NewRouter()
+	gw.RegisterRoutes(r)
+	site := mux.NewRouter()
+	gw.RegisterWebsiteRoutes(site)
+
+	signer := v4.NewSigner(credentials.NewStaticCredentials("alice", "alice-secret", ""))
+	signer.DisableURIPathEscaping = true
+	do := func(method, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if _, err := signer.Sign(req, strings.NewReader(body), "s3", "us-east-1", time.Now()); err != nil {
+			t.Fatalf("sign failed: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	// visit sends an anonymous request to the website listener
+	visit := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		site.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+	expect := func(rr *httptest.ResponseRecorder, code int, what string) {
+		t.Helper()
+		if rr.Code != code {
+			t.Fatalf("%s: expected %d, got %d %s", what, code, rr.Code, rr.Body.String())
+		}
+	}
+	public := map[string]string{"x-amz-acl": acl.PublicRead}
+
+	expect(do("PUT", "/docs", "", nil), 200, "create bucket")
+	expect(do("GET", "/docs?website", "", nil), 404, "get missing website")
+	rr := visit("GET", "http://docs.example.com/")
+	expect(rr, 404, "website not configured")
+	if !strings.Contains(rr.Header().Get("Content-Type"), "text/html") || !strings.Contains(rr.Body.String(), "NoSuchWebsiteConfiguration") {
+		t.Fatalf("expected an HTML error page, got %s %s", rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	expect(do("PUT", "/docs?website", `<WebsiteConfiguration><IndexDocument><Suffix>a/b</Suffix></IndexDocument></WebsiteConfiguration>`, nil), 400, "invalid index document")
+	config := `<WebsiteConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">` +
+		`<IndexDocument><Suffix>index.html</Suffix></IndexDocument><ErrorDocument><Key>404.html</Key></ErrorDocument>` +
+		`<RoutingRules>` +
+		`<RoutingRule><Condition><KeyPrefixEquals>old/</KeyPrefixEquals></Condition><Redirect><ReplaceKeyPrefixWith>guide/</ReplaceKeyPrefixWith></Redirect></RoutingRule>` +
+		`<RoutingRule><Condition><KeyPrefixEquals>api/</KeyPrefixEquals><HttpErrorCodeReturnedEquals>404</HttpErrorCodeReturnedEquals></Condition>` +
+		`<Redirect><HostName>api.example.com</HostName><HttpRedirectCode>302</HttpRedirectCode></Redirect></RoutingRule>` +
+		`</RoutingRules></WebsiteConfiguration>`
+	expect(do("PUT", "/docs?website", config, nil), 200, "put website")
+	rr = do("GET", "/docs?website", "", nil)
+	expect(rr, 200, "get website")
+	for _, want := range []string{"<Suffix>index.html</Suffix>", "<Key>404.html</Key>", "<ReplaceKeyPrefixWith>guide/</ReplaceKeyPrefixWith>", "<HttpErrorCodeReturnedEquals>404</HttpErrorCodeReturnedEquals>"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Fatalf("expected website configuration to contain %s, got %s", want, rr.Body.String())
+		}
+	}
+
+	expect(do("PUT", "/docs/index.html", "home", public), 200, "upload index")
+	expect(do("PUT", "/docs/guide/index.html", "guide", public), 200, "upload guide index")
+	expect(do("PUT", "/docs/404.html", "not found", public), 200, "upload error document")
+	expect(do("PUT", "/docs/private.html", "secret", nil), 200, "upload private page")
+	expect(do("PUT", "/docs/moved.html", "", map[string]string{"x-amz-acl": acl.PublicRead, websiteRedirectHeader: "/guide/"}), 200, "upload redirect object")
+	expect(do("PUT", "/docs/bad.html", "", map[string]string{websiteRedirectHeader: "elsewhere"}), 400, "invalid redirect location")
+
+	// Directories are served by their index document, on either endpoint
+	for target, body := range map[string]string{
+		"http://docs.example.com/":           "home",
+		"http://docs.example.com/guide/":     "guide",
+		"http://docs.website.local/guide/":   "guide",
+		"http://docs.example.com:8080/":      "home",
+		"http://docs.example.com/index.html": "home",
+	} {
+		var rr *httptest.ResponseRecorder
+		if strings.Contains(target, "website.local") {
+			rr = httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		} else {
+			rr = visit("GET", target)
+		}
+		if rr.Code != 200 || rr.Body.String() != body {
+			t.Fatalf("GET %s: %d %s", target, rr.Code, rr.Body.String())
+		}
+	}
+	rr = visit("HEAD", "http://docs.example.com/guide/")
+	if rr.Code != 200 || rr.Body.Len() != 0 || rr.Header().Get("Content-Length") != "5" {
+		t.Fatalf("HEAD directory: %d %v %s", rr.Code, rr.Header(), rr.Body.String())
+	}
+
+	expectRedirect := func(target string, code int, location string) {
+		t.Helper()
+		rr := visit("GET", target)
+		if rr.Code != code || rr.Header().Get("Location") != location {
+			t.Fatalf("GET %s: expected %d to %s, got %d %s", target, code, location, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+	expectRedirect("http://docs.example.com/guide", 302, "/guide/")
+	expectRedirect("http://docs.example.com/old/intro.html", 301, "http://docs.example.com/guide/intro.html")
+	expectRedirect("http://docs.example.com/api/v1", 302, "http://api.example.com/api/v1")
+	expectRedirect("http://docs.example.com/moved.html", 301, "/guide/")
+
+	// Missing and unreadable objects are answered with the error document
+	rr = visit("GET", "http://docs.example.com/missing.html")
+	if rr.Code != 404 || rr.Body.String() != "not found" {
+		t.Fatalf("expected the error document, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = visit("GET", "http://docs.example.com/private.html")
+	if rr.Code != 403 || rr.Body.String() != "not found" {
+		t.Fatalf("expected the error document for a private page, got %d %s", rr.Code, rr.Body.String())
+	}
+	expect(visit("PUT", "http://docs.example.com/index.html"), 405, "write to website")
+
+	expect(do("DELETE", "/docs?website", "", nil), 204, "delete website")
+	expect(visit("GET", "http://docs.example.com/"), 404, "deleted website")
+}
@@ -0,0 +1,451 @@
+package s3select
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// record is a row of the input.
+type record interface {
+	// get returns the value of a field, null when it is missing
+	get(path []pathElem) Value
+	// fields returns the names and values of the fields, for SELECT *
+	fields() ([]string, []Value)
+}
+
+// env evaluates expressions against a record, or against the accumulated
+// aggregates once all records were read.
+type env struct {
+	q    *query
+	rec  record
+	aggs []accumulator
+}
+
+func (e *env) eval(x expr) (Value, error) {
+	switch x := x.(type) {
+	case *literal:
+		return x.value, nil
+	case *column:
+		return e.column(x), nil
+	case *aggregate:
+		return e.aggs[x.index].result(), nil
+	case *unaryExpr:
+		v, err := e.eval(x.x)
+		if err != nil || v.isNull() {
+			return null, err
+		}
+		if x.op == "NOT" {
+			if v.kind != kindBool {
+				return null, evalError("NOT expects a boolean, got %s", v.typeName())
+			}
+			return boolValue(!v.b), nil
+		}
+		v, err = arithmetic("-", intValue(0), v)
+		if err != nil {
+			return null, evalError("%s", err)
+		}
+		return v, nil
+	case *binaryExpr:
+		return e.binary(x)
+	case *likeExpr:
+		return e.like(x)
+	case *isExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return null, err
+		}
+		return boolValue(v.isNull() != x.not), nil
+	case *inExpr:
+		v, err := e.eval(x.x)
+		if err != nil || v.isNull() {
+			return null, err
+		}
+		result := boolValue(false)
+		for _, item := range x.list {
+			w, err := e.eval(item)
+			if err != nil {
+				return null, err
+			}
+			if w.isNull() {
+				result = null
+				continue
+			}
+			if c, ok := compare(v, w); ok && c == 0 {
+				result = boolValue(true)
+				break
+			}
+		}
+		if x.not && !result.isNull() {
+			result.b = !result.b
+		}
+		return result, nil
+	case *betweenExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return null, err
+		}
+		lo, err := e.eval(x.lo)
+		if err != nil {
+			return null, err
+		}
+		hi, err := e.eval(x.hi)
+		if err != nil {
+			return null, err
+		}
+		if v.isNull() || lo.isNull() || hi.isNull() {
+			return null, nil
+		}
+		c1, ok1 := compare(v, lo)
+		c2, ok2 := compare(v, hi)
+		if !ok1 || !ok2 {
+			return null, nil
+		}
+		return boolValue((c1 >= 0 && c2 <= 0) != x.not), nil
+	case *castExpr:
+		v, err := e.eval(x.x)
+		if err != nil {
+			return null, err
+		}
+		v, err = cast(v, x.typ)
+		if err != nil {
+			return null, &Error{Code: CodeCastFailed, Message: err.Error()}
+		}
+		return v, nil
+	case *callExpr:
+		args := make([]Value, len(x.args))
+		for i, arg := range x.args {
+			v, err := e.eval(arg)
+			if err != nil {
+				return null, err
+			}
+			args[i] = v
+		}
+		return functions[x.name](args)
+	}
+	return null, evalError("unsupported expression %T", x)
+}
+
+// column resolves a column reference, dropping the alias of S3Object.
+func (e *env) column(c *column) Value {
+	path := c.path
+	if len(path) > 1 && path[0].name != "" && e.matchesAlias(path[0]) {
+		path = path[1:]
+	}
+	return e.rec.get(path)
+}
+
+func (e *env) matchesAlias(p pathElem) bool {
+	if e.q.alias != "" {
+		if p.quoted {
+			return p.name == e.q.alias
+		}
+		return strings.EqualFold(p.name, e.q.alias)
+	}
+	return strings.EqualFold(p.name, "S3Object")
+}
+
+func (e *env) binary(x *binaryExpr) (Value, error) {
+	l, err := e.eval(x.l)
+	if err != nil {
+		return null, err
+	}
+	// AND and OR follow three-valued logic, short-circuiting when the left
+	// operand decides the result
+	switch x.op {
+	case "AND", "OR":
+		if !l.isNull() && l.kind != kindBool {
+			return null, evalError("%s expects booleans, got %s", x.op, l.typeName())
+		}
+		if !l.isNull() && l.b == (x.op == "OR") {
+			return l, nil
+		}
+		r, err := e.eval(x.r)
+		if err != nil {
+			return null, err
+		}
+		if !r.isNull() && r.kind != kindBool {
+			return null, evalError("%s expects booleans, got %s", x.op, r.typeName())
+		}
+		switch {
+		case !r.isNull() && r.b == (x.op == "OR"):
+			return r, nil
+		case l.isNull() || r.isNull():
+			return null, nil
+		}
+		return r, nil
+	}
+
+	r, err := e.eval(x.r)
+	if err != nil {
+		return null, err
+	}
+	if l.isNull() || r.isNull() {
+		return null, nil
+	}
+	switch x.op {
+	case "+", "-", "*", "/", "%":
+		v, err := arithmetic(x.op, l, r)
+		if err != nil {
+			return null, evalError("%s", err)
+		}
+		return v, nil
+	case "||":
+		return stringValue(l.text() + r.text()), nil
+	}
+	// Values that cannot be compared, such as an empty CSV field and a
+	// number, compare as unknown
+	c, ok := compare(l, r)
+	if !ok {
+		return null, nil
+	}
+	switch x.op {
+	case "=":
+		return boolValue(c == 0), nil
+	case "<>":
+		return boolValue(c != 0), nil
+	case "<":
+		return boolValue(c < 0), nil
+	case "<=":
+		return boolValue(c <= 0), nil
+	case ">":
+		return boolValue(c > 0), nil
+	case ">=":
+		return boolValue(c >= 0), nil
+	}
+	return null, evalError("unsupported operator %s", x.op)
+}
+
+func (e *env) like(x *likeExpr) (Value, error) {
+	v, err := e.eval(x.x)
+	if err != nil {
+		return null, err
+	}
+	pattern, err := e.eval(x.pattern)
+	if err != nil {
+		return null, err
+	}
+	escape := rune(-1)
+	if x.escape != nil {
+		esc, err := e.eval(x.escape)
+		if err != nil {
+			return null, err
+		}
+		if utf8.RuneCountInString(esc.text()) != 1 {
+			return null, evalError("ESCAPE must be a single character")
+		}
+		escape, _ = utf8.DecodeRuneInString(esc.text())
+	}
+	if v.isNull() || pattern.isNull() {
+		return null, nil
+	}
+	return boolValue(matchLike([]rune(v.text()), []rune(pattern.text()), escape) != x.not), nil
+}
+
+// matchLike matches s against a LIKE pattern, where % matches any sequence
+// of characters and _ any single character.
+func matchLike(s, pattern []rune, escape rune) bool {
+	for len(pattern) > 0 {
+		c := pattern[0]
+		switch {
+		case c == escape && len(pattern) > 1:
+			if len(s) == 0 || s[0] != pattern[1] {
+				return false
+			}
+			s, pattern = s[1:], pattern[2:]
+		case c == '%':
+			for len(pattern) > 0 && pattern[0] == '%' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchLike(s[i:], pattern, escape) {
+					return true
+				}
+			}
+			return false
+		case c == '_':
+			if len(s) == 0 {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		default:
+			if len(s) == 0 || s[0] != c {
+				return false
+			}
+			s, pattern = s[1:], pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// functions are the supported scalar functions.
+var functions = map[string]func(args []Value) (Value, error){
+	"LOWER":            stringFunction("LOWER", strings.ToLower),
+	"UPPER":            stringFunction("UPPER", strings.ToUpper),
+	"TRIM":             stringFunction("TRIM", func(s string) string { return strings.Trim(s, " ") }),
+	"CHAR_LENGTH":      charLength,
+	"CHARACTER_LENGTH": charLength,
+	"SUBSTRING":        substring,
+	"COALESCE": func(args []Value) (Value, error) {
+		for _, v := range args {
+			if !v.isNull() {
+				return v, nil
+			}
+		}
+		return null, nil
+	},
+	"NULLIF": func(args []Value) (Value, error) {
+		if len(args) != 2 {
+			return null, evalError("NULLIF expects 2 arguments")
+		}
+		if args[0].isNull() || args[1].isNull() {
+			return args[0], nil
+		}
+		if c, ok := compare(args[0], args[1]); ok && c == 0 {
+			return null, nil
+		}
+		return args[0], nil
+	},
+}
+
+func stringFunction(name string, fn func(string) string) func(args []Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		if len(args) != 1 {
+			return null, evalError("%s expects 1 argument", name)
+		}
+		if args[0].isNull() {
+			return null, nil
+		}
+		return stringValue(fn(args[0].text())), nil
+	}
+}
+
+func charLength(args []Value) (Value, error) {
+	if len(args) != 1 {
+		return null, evalError("CHAR_LENGTH expects 1 argument")
+	}
+	if args[0].isNull() {
+		return null, nil
+	}
+	return intValue(int64(utf8.RuneCountInString(args[0].text()))), nil
+}
+
+// substring returns the characters of a string from a 1-based position, for
+// an optional length.
+func substring(args []Value) (Value, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return null, evalError("SUBSTRING expects 2 or 3 arguments")
+	}
+	for _, arg := range args {
+		if arg.isNull() {
+			return null, nil
+		}
+	}
+	s := []rune(args[0].text())
+	start, ok := args[1].number()
+	if !ok {
+		return null, evalError("SUBSTRING position must be a number")
+	}
+	from := int64(start.float()) - 1
+	to := int64(len(s))
+	if len(args) == 3 {
+		n, ok := args[2].number()
+		if !ok || n.float() < 0 {
+			return null, evalError("SUBSTRING length must be a non-negative number")
+		}
+		to = from + int64(n.float())
+	}
+	from = max(from, 0)
+	to = min(to, int64(len(s)))
+	if from >= to {
+		return stringValue(""), nil
+	}
+	return stringValue(string(s[from:to])), nil
+}
+
+// accumulator folds the values of an aggregate.
+type accumulator struct {
+	name  string
+	count int64
+	sum   Value
+	best  Value
+}
+
+func (a *accumulator) add(v Value) error {
+	if v.isNull() {
+		return nil
+	}
+	switch a.name {
+	case "SUM", "AVG":
+		n, ok := v.number()
+		if !ok {
+			return evalError("%s expects numbers, got %s %q", a.name, v.typeName(), v.text())
+		}
+		if a.count == 0 {
+			a.sum = n
+		} else {
+			sum, err := arithmetic("+", a.sum, n)
+			if err != nil {
+				return evalError("%s", err)
+			}
+			a.sum = sum
+		}
+	case "MIN", "MAX":
+		// CSV fields holding numbers compare as numbers
+		if n, ok := v.number(); ok {
+			v = n
+		}
+		if a.count == 0 {
+			a.best = v
+			break
+		}
+		c, ok := compare(v, a.best)
+		if !ok {
+			return evalError("%s cannot compare %s with %s", a.name, v.typeName(), a.best.typeName())
+		}
+		if a.name == "MIN" && c < 0 || a.name == "MAX" && c > 0 {
+			a.best = v
+		}
+	}
+	a.count++
+	return nil
+}
+
+func (a *accumulator) result() Value {
+	switch a.name {
+	case "COUNT":
+		return intValue(a.count)
+	case "SUM":
+		return a.sum
+	case "AVG":
+		if a.count == 0 {
+			return null
+		}
+		return floatValue(a.sum.float() / float64(a.count))
+	}
+	return a.best
+}
+
+// columnName returns the output name of a projection: its alias, the name
+// of the column it references or its position.
+func columnName(p projection, i int) string {
+	if p.alias != "" {
+		return p.alias
+	}
+	if c, ok := p.expr.(*column); ok {
+		for j := len(c.path) - 1; j >= 0; j-- {
+			if c.path[j].name != "" {
+				return c.path[j].name
+			}
+		}
+	}
+	return "_" + strconv.Itoa(i+1)
+}
+
+func evalError(format string, args ...any) *Error {
+	return &Error{Code: CodeEvaluation, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,499 @@
+package s3select
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// readBufferSize is the size of the reads of CSV and JSON objects.
+const readBufferSize = 1 << 20
+
+// rowReader reads the records of an object.
+type rowReader interface {
+	// next returns the next record, or io.EOF
+	next() (record, error)
+}
+
+// open returns the reader of the records of the object.
+func (s *Select) open(src io.ReaderAt, size int64) (rowReader, error) {
+	in := s.req.Input
+	if in.Format == FormatParquet {
+		return newParquetReader(src, size, s.req.ScanRange, &s.scanned, &s.processed)
+	}
+
+	begin, end := int64(0), size
+	if r := s.req.ScanRange; r != nil {
+		var err error
+		delimiter := byte('\n')
+		if in.Format == FormatCSV {
+			delimiter = in.CSV.RecordDelimiter[len(in.CSV.RecordDelimiter)-1]
+		}
+		if begin, end, err = scanBounds(src, size, r, delimiter); err != nil {
+			return nil, err
+		}
+	}
+
+	var r io.Reader = &countingReader{r: io.NewSectionReader(src, begin, end-begin), n: &s.scanned}
+	r = bufio.NewReaderSize(r, readBufferSize)
+	switch in.Compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, &Error{Code: CodeInvalidCompressionFormat, Message: err.Error()}
+		}
+		r = gz
+	case CompressionBzip2:
+		r = bzip2.NewReader(r)
+	}
+	r = &countingReader{r: r, n: &s.processed}
+
+	if in.Format == FormatJSON {
+		return newJSONReader(r, in.JSON.Type), nil
+	}
+	return newCSVReader(r, in.CSV)
+}
+
+// scanBounds returns the bytes holding the records that start within a scan
+// range: from the first record starting at or after its start, to the end of
+// the record holding its last byte.
+func scanBounds(src io.ReaderAt, size int64, r *ScanRange, delimiter byte) (int64, int64, error) {
+	start, last := r.Start, r.End
+	switch {
+	case start < 0:
+		start, last = max(size-r.End, 0), size-1
+	case last < 0 || last >= size:
+		last = size - 1
+	}
+	if start >= size {
+		return size, size, nil
+	}
+
+	begin := int64(0)
+	if start > 0 {
+		// A record starts at start when the byte before ends a record
+		i, err := indexByte(src, size, start-1, delimiter)
+		if err != nil {
+			return 0, 0, err
+		}
+		begin = i + 1
+	}
+	end, err := indexByte(src, size, last, delimiter)
+	if err != nil {
+		return 0, 0, err
+	}
+	return begin, min(end+1, size), nil
+}
+
+// indexByte returns the offset of the first c at or after offset, or size.
+func indexByte(src io.ReaderAt, size, offset int64, c byte) (int64, error) {
+	buf := make([]byte, 64<<10)
+	for offset < size {
+		n, err := src.ReadAt(buf[:min(int64(len(buf)), size-offset)], offset)
+		if i := bytes.IndexByte(buf[:n], c); i >= 0 {
+			return offset + int64(i), nil
+		}
+		offset += int64(n)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return size, nil
+}
+
+// columnRecord is a record of CSV or Parquet columns.
+type columnRecord struct {
+	// names are the column names, when known
+	names  []string
+	values []Value
+}
+
+func (r *columnRecord) get(path []pathElem) Value {
+	if len(path) != 1 || path[0].name == "" {
+		return null
+	}
+	name := path[0].name
+	for i, n := range r.names {
+		if n == name || !path[0].quoted && strings.EqualFold(n, name) {
+			if i < len(r.values) {
+				return r.values[i]
+			}
+			return null
+		}
+	}
+	// _1, _2, ... select columns by position
+	if strings.HasPrefix(name, "_") {
+		if i, err := strconv.Atoi(name[1:]); err == nil && i >= 1 && i <= len(r.values) {
+			return r.values[i-1]
+		}
+	}
+	return null
+}
+
+func (r *columnRecord) fields() ([]string, []Value) {
+	names := make([]string, len(r.values))
+	for i := range r.values {
+		if i < len(r.names) {
+			names[i] = r.names[i]
+		} else {
+			names[i] = "_" + strconv.Itoa(i+1)
+		}
+	}
+	return names, r.values
+}
+
+// csvReader reads CSV records with configurable delimiters, quote, escape
+// and comment characters.
+type csvReader struct {
+	r        *bufio.Reader
+	record   []byte
+	field    rune
+	quote    rune
+	escape   rune
+	comment  rune
+	names    []string
+	line     int
+	fieldBuf strings.Builder
+}
+
+func newCSVReader(r io.Reader, opts CSVInput) (*csvReader, error) {
+	c := &csvReader{
+		r:       bufio.NewReader(r),
+		record:  []byte(opts.RecordDelimiter),
+		comment: -1,
+	}
+	c.field, _ = utf8.DecodeRuneInString(opts.FieldDelimiter)
+	c.quote, _ = utf8.DecodeRuneInString(opts.QuoteCharacter)
+	c.escape, _ = utf8.DecodeRuneInString(opts.QuoteEscapeCharacter)
+	if opts.Comments != "" {
+		c.comment, _ = utf8.DecodeRuneInString(opts.Comments)
+	}
+	if opts.FileHeaderInfo != HeaderNone {
+		header, err := c.readRecord()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if opts.FileHeaderInfo == HeaderUse {
+			c.names = header
+		}
+	}
+	return c, nil
+}
+
+func (c *csvReader) next() (record, error) {
+	fields, err := c.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Value, len(fields))
+	for i, f := range fields {
+		values[i] = stringValue(f)
+	}
+	return &columnRecord{names: c.names, values: values}, nil
+}
+
+// readRecord returns the fields of the next record, skipping comments and
+// empty lines.
+func (c *csvReader) readRecord() ([]string, error) {
+	for {
+		fields, empty, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !empty {
+			return fields, nil
+		}
+	}
+}
+
+// readLine reads a record, reporting whether it was empty or a comment.
+func (c *csvReader) readLine() ([]string, bool, error) {
+	c.line++
+	var fields []string
+	c.fieldBuf.Reset()
+	quoted, fieldStart, read := false, true, false
+	for {
+		ch, _, err := c.r.ReadRune()
+		if errors.Is(err, io.EOF) {
+			if !read {
+				return nil, false, io.EOF
+			}
+			if quoted {
+				return nil, false, &Error{Code: CodeCSVParsing, Message: fmt.Sprintf("unterminated quoted field on line %d", c.line)}
+			}
+			return append(fields, c.fieldBuf.String()), false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if !read && ch == c.comment {
+			return nil, true, c.skipLine()
+		}
+		read = true
+
+		if quoted {
+			switch {
+			case ch == c.escape && c.escape != c.quote:
+				next, _, err := c.r.ReadRune()
+				if err != nil {
+					return nil, false, &Error{Code: CodeCSVParsing, Message: fmt.Sprintf("unterminated quoted field on line %d", c.line)}
+				}
+				if next != c.quote && next != c.escape {
+					c.fieldBuf.WriteRune(ch)
+				}
+				c.fieldBuf.WriteRune(next)
+			case ch == c.quote:
+				// A doubled quote is a literal quote
+				if next, _, err := c.r.ReadRune(); err == nil {
+					if next == c.quote {
+						c.fieldBuf.WriteRune(c.quote)
+						continue
+					}
+					_ = c.r.UnreadRune()
+				}
+				quoted = false
+			default:
+				c.fieldBuf.WriteRune(ch)
+			}
+			continue
+		}
+
+		switch {
+		case ch == c.quote && fieldStart:
+			quoted = true
+			fieldStart = false
+		case ch == c.field:
+			fields = append(fields, c.fieldBuf.String())
+			c.fieldBuf.Reset()
+			fieldStart = true
+		case c.isRecordDelimiter(ch):
+			fields = append(fields, c.fieldBuf.String())
+			return fields, len(fields) == 1 && fields[0] == "", nil
+		case ch == '\r' && string(c.record) == "\n" && c.peekIs('\n'):
+			// Lines ending with CRLF are read as lines ending with LF
+		default:
+			c.fieldBuf.WriteRune(ch)
+			fieldStart = false
+		}
+	}
+}
+
+// isRecordDelimiter reports whether ch starts the record delimiter,
+// consuming the rest of the delimiter.
+func (c *csvReader) isRecordDelimiter(ch rune) bool {
+	first, size := utf8.DecodeRune(c.record)
+	if ch != first {
+		return false
+	}
+	rest := c.record[size:]
+	if len(rest) == 0 {
+		return true
+	}
+	if b, err := c.r.Peek(len(rest)); err != nil || !bytes.Equal(b, rest) {
+		return false
+	}
+	_, _ = c.r.Discard(len(rest))
+	return true
+}
+
+func (c *csvReader) peekIs(b byte) bool {
+	next, err := c.r.Peek(1)
+	return err == nil && next[0] == b
+}
+
+// skipLine skips a comment up to the record delimiter.
+func (c *csvReader) skipLine() error {
+	for {
+		ch, _, err := c.r.ReadRune()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c.isRecordDelimiter(ch) {
+			return nil
+		}
+	}
+}
+
+// jsonObject is a JSON object keeping the order of its keys.
+type jsonObject struct {
+	keys   []string
+	values []any
+}
+
+// lookup returns the value of a key, matching unquoted names case
+// insensitively when there is no exact match.
+func (o *jsonObject) lookup(name string, quoted bool) (any, bool) {
+	for i, k := range o.keys {
+		if k == name {
+			return o.values[i], true
+		}
+	}
+	if !quoted {
+		for i, k := range o.keys {
+			if strings.EqualFold(k, name) {
+				return o.values[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// jsonRecord is a JSON value read from the input.
+type jsonRecord struct {
+	value any
+}
+
+func (r *jsonRecord) get(path []pathElem) Value {
+	v := r.value
+	for _, p := range path {
+		switch node := v.(type) {
+		case *jsonObject:
+			if p.name == "" {
+				return null
+			}
+			var ok bool
+			if v, ok = node.lookup(p.name, p.quoted); !ok {
+				return null
+			}
+		case []any:
+			if p.name != "" || p.index >= len(node) {
+				return null
+			}
+			v = node[p.index]
+		default:
+			return null
+		}
+	}
+	return jsonValue(v)
+}
+
+func (r *jsonRecord) fields() ([]string, []Value) {
+	obj, ok := r.value.(*jsonObject)
+	if !ok {
+		return []string{"_1"}, []Value{jsonValue(r.value)}
+	}
+	values := make([]Value, len(obj.values))
+	for i, v := range obj.values {
+		values[i] = jsonValue(v)
+	}
+	return obj.keys, values
+}
+
+// jsonValue converts a decoded JSON value.
+func jsonValue(v any) Value {
+	switch v := v.(type) {
+	case nil:
+		return null
+	case bool:
+		return boolValue(v)
+	case string:
+		return stringValue(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return intValue(i)
+		}
+		f, _ := v.Float64()
+		return floatValue(f)
+	}
+	return objectValue(v)
+}
+
+// jsonReader reads a sequence of JSON values. The elements of top-level
+// arrays of JSON documents are read as records.
+type jsonReader struct {
+	dec      *json.Decoder
+	document bool
+	pending  []any
+}
+
+func newJSONReader(r io.Reader, typ string) *jsonReader {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &jsonReader{dec: dec, document: typ == JSONDocument}
+}
+
+func (j *jsonReader) next() (record, error) {
+	for len(j.pending) == 0 {
+		v, err := decodeJSON(j.dec)
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, &Error{Code: CodeJSONParsing, Message: err.Error()}
+		}
+		if array, ok := v.([]any); ok && j.document {
+			j.pending = array
+			continue
+		}
+		return &jsonRecord{value: v}, nil
+	}
+	v := j.pending[0]
+	j.pending = j.pending[1:]
+	return &jsonRecord{value: v}, nil
+}
+
+// decodeJSON decodes the next JSON value, keeping the order of object keys.
+func decodeJSON(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := t.(json.Delim)
+	if !ok {
+		return t, nil
+	}
+	v, err := decodeJSONValue(dec, delim)
+	if errors.Is(err, io.EOF) {
+		// Only the end of the input between values is not an error
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// decodeJSONValue decodes the rest of an object or array.
+func decodeJSONValue(dec *json.Decoder, delim json.Delim) (any, error) {
+	switch delim {
+	case '{':
+		obj := &jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.keys = append(obj.keys, key.(string))
+			obj.values = append(obj.values, value)
+		}
+		_, err := dec.Token()
+		return obj, err
+	case '[':
+		array := []any{}
+		for dec.More() {
+			value, err := decodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := dec.Token()
+		return array, err
+	}
+	return nil, fmt.Errorf("unexpected %s", delim)
+}
@@ -0,0 +1,134 @@
+package s3select
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// outputWriter serializes result records.
+type outputWriter interface {
+	write(names []string, values []Value) error
+}
+
+func newOutputWriter(opts Output, w io.Writer) outputWriter {
+	if opts.Format == FormatJSON {
+		return &jsonWriter{w: w, delimiter: opts.JSON.RecordDelimiter}
+	}
+	return &csvWriter{w: w, opts: opts.CSV}
+}
+
+// csvWriter writes records as CSV, quoting fields always or when they hold
+// delimiters or quotes.
+type csvWriter struct {
+	w    io.Writer
+	opts CSVOutput
+	buf  bytes.Buffer
+}
+
+func (c *csvWriter) write(_ []string, values []Value) error {
+	c.buf.Reset()
+	for i, v := range values {
+		if i > 0 {
+			c.buf.WriteString(c.opts.FieldDelimiter)
+		}
+		s := v.text()
+		if c.opts.QuoteFields != QuoteAlways && !c.needsQuotes(s) {
+			c.buf.WriteString(s)
+			continue
+		}
+		c.buf.WriteString(c.opts.QuoteCharacter)
+		for _, ch := range s {
+			if string(ch) == c.opts.QuoteCharacter || string(ch) == c.opts.QuoteEscapeCharacter {
+				c.buf.WriteString(c.opts.QuoteEscapeCharacter)
+			}
+			c.buf.WriteRune(ch)
+		}
+		c.buf.WriteString(c.opts.QuoteCharacter)
+	}
+	c.buf.WriteString(c.opts.RecordDelimiter)
+	_, err := c.w.Write(c.buf.Bytes())
+	return err
+}
+
+func (c *csvWriter) needsQuotes(s string) bool {
+	return strings.Contains(s, c.opts.FieldDelimiter) || strings.Contains(s, c.opts.QuoteCharacter) ||
+		strings.Contains(s, c.opts.RecordDelimiter) || strings.ContainsAny(s, "\r\n")
+}
+
+// jsonWriter writes records as JSON objects.
+type jsonWriter struct {
+	w         io.Writer
+	delimiter string
+	buf       []byte
+}
+
+func (j *jsonWriter) write(names []string, values []Value) error {
+	obj := &jsonObject{keys: names, values: make([]any, len(values))}
+	for i, v := range values {
+		obj.values[i] = v.json()
+	}
+	j.buf = append(appendJSON(j.buf[:0], obj), j.delimiter...)
+	_, err := j.w.Write(j.buf)
+	return err
+}
+
+// json returns the value as decoded JSON.
+func (v Value) json() any {
+	switch v.kind {
+	case kindBool:
+		return v.b
+	case kindInt, kindFloat:
+		return json.Number(v.text())
+	case kindString:
+		return v.s
+	case kindObject:
+		return v.o
+	}
+	return nil
+}
+
+// appendJSON appends the JSON encoding of a decoded JSON value.
+func appendJSON(buf []byte, v any) []byte {
+	switch v := v.(type) {
+	case *jsonObject:
+		buf = append(buf, '{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, key)
+			buf = append(buf, ':')
+			buf = appendJSON(buf, v.values[i])
+		}
+		return append(buf, '}')
+	case []any:
+		buf = append(buf, '[')
+		for i, item := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSON(buf, item)
+		}
+		return append(buf, ']')
+	case string:
+		return appendJSONString(buf, v)
+	case json.Number:
+		return append(buf, v...)
+	case bool:
+		if v {
+			return append(buf, "true"...)
+		}
+		return append(buf, "false"...)
+	}
+	return append(buf, "null"...)
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return append(buf, bytes.TrimSuffix(b.Bytes(), []byte("\n"))...)
+}
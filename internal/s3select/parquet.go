@@ -0,0 +1,784 @@
+package s3select
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The Parquet reader supports flat schemas of required and optional
+// columns, PLAIN and dictionary encodings, data pages v1 and v2, and the
+// UNCOMPRESSED, SNAPPY, GZIP and ZSTD codecs. Each column chunk of a row
+// group is read with a single ranged read of the object.
+
+const parquetMagic = "PAR1"
+
+// Parquet physical types.
+const (
+	parquetBoolean = iota
+	parquetInt32
+	parquetInt64
+	parquetInt96
+	parquetFloat
+	parquetDouble
+	parquetByteArray
+	parquetFixedLenByteArray
+)
+
+// Parquet converted types used to interpret physical values.
+const (
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+)
+
+// Parquet repetitions, page types, encodings and codecs.
+const (
+	repetitionOptional = 1
+	repetitionRepeated = 2
+
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+
+	encodingPlain         = 0
+	encodingPlainDict     = 2
+	encodingRLEDictionary = 8
+
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+	codecZstd         = 6
+)
+
+// maxParquetFooterLength bounds the size of the metadata read
+const maxParquetFooterLength = 64 << 20
+
+// parquetColumn is a leaf of a flat Parquet schema.
+type parquetColumn struct {
+	name      string
+	physical  int
+	typeLen   int
+	optional  bool
+	converted int
+	scale     int
+	// timeUnit is the unit of TIMESTAMP logical types, zero otherwise
+	timeUnit time.Duration
+}
+
+type parquetReader struct {
+	src       io.ReaderAt
+	columns   []parquetColumn
+	names     []string
+	groups    []tstruct
+	values    [][]Value
+	row, rows int
+	scanned   *atomic.Int64
+	processed *atomic.Int64
+}
+
+func parquetError(format string, args ...any) *Error {
+	return &Error{Code: CodeParquetParsing, Message: fmt.Sprintf(format, args...)}
+}
+
+func newParquetReader(src io.ReaderAt, size int64, scan *ScanRange, scanned, processed *atomic.Int64) (*parquetReader, error) {
+	if size < int64(2*len(parquetMagic)+4) {
+		return nil, parquetError("object is too small to be a Parquet file")
+	}
+	tail := make([]byte, 8)
+	if _, err := src.ReadAt(tail, size-8); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != parquetMagic {
+		return nil, parquetError("object is not a Parquet file")
+	}
+	length := int64(binary.LittleEndian.Uint32(tail))
+	if length > maxParquetFooterLength || length > size-12 {
+		return nil, parquetError("invalid footer length %d", length)
+	}
+	footer := make([]byte, length)
+	if _, err := src.ReadAt(footer, size-8-length); err != nil {
+		return nil, err
+	}
+	scanned.Add(length + 8)
+	processed.Add(length + 8)
+
+	meta, err := (&thriftReader{buf: footer}).readStruct()
+	if err != nil {
+		return nil, parquetError("invalid metadata: %v", err)
+	}
+	p := &parquetReader{src: src, scanned: scanned, processed: processed}
+	if err := p.readSchema(meta.list(2)); err != nil {
+		return nil, err
+	}
+
+	start, end := int64(0), size-1
+	if scan != nil {
+		start, end = scan.Start, scan.End
+		switch {
+		case start < 0:
+			start, end = max(size-scan.End, 0), size-1
+		case end < 0:
+			end = size - 1
+		}
+	}
+	for _, g := range meta.list(4) {
+		group, ok := g.(tstruct)
+		if !ok {
+			return nil, parquetError("invalid row group")
+		}
+		// Row groups belong to the scan range holding their first byte
+		if chunks := group.list(1); len(chunks) > 0 {
+			if chunk, ok := chunks[0].(tstruct); ok {
+				offset, _ := chunkBounds(chunk.strct(3))
+				if offset < start || offset > end {
+					continue
+				}
+			}
+		}
+		p.groups = append(p.groups, group)
+	}
+	return p, nil
+}
+
+// readSchema reads the columns of a flat schema.
+func (p *parquetReader) readSchema(elements []any) error {
+	if len(elements) < 2 {
+		return parquetError("schema has no columns")
+	}
+	for _, e := range elements[1:] {
+		el, ok := e.(tstruct)
+		if !ok {
+			return parquetError("invalid schema")
+		}
+		if el.int(5) > 0 {
+			return parquetError("nested column %s is not supported", el.bytes(4))
+		}
+		if el.int(3) == repetitionRepeated {
+			return parquetError("repeated column %s is not supported", el.bytes(4))
+		}
+		col := parquetColumn{
+			name:      string(el.bytes(4)),
+			physical:  int(el.int(1)),
+			typeLen:   int(el.int(2)),
+			optional:  el.int(3) == repetitionOptional,
+			converted: -1,
+			scale:     int(el.int(7)),
+		}
+		if _, ok := el[6]; ok {
+			col.converted = int(el.int(6))
+		}
+		if logical := el.strct(10); logical != nil {
+			switch {
+			case logical.strct(5) != nil:
+				col.converted = convertedDecimal
+				col.scale = int(logical.strct(5).int(1))
+			case logical.strct(6) != nil:
+				col.converted = convertedDate
+			case logical.strct(8) != nil:
+				unit := logical.strct(8).strct(2)
+				switch {
+				case unit.strct(1) != nil:
+					col.timeUnit = time.Millisecond
+				case unit.strct(2) != nil:
+					col.timeUnit = time.Microsecond
+				case unit.strct(3) != nil:
+					col.timeUnit = time.Nanosecond
+				}
+			}
+		}
+		switch col.converted {
+		case convertedTimestampMillis:
+			col.timeUnit = time.Millisecond
+		case convertedTimestampMicros:
+			col.timeUnit = time.Microsecond
+		}
+		p.columns = append(p.columns, col)
+		p.names = append(p.names, col.name)
+	}
+	return nil
+}
+
+func (p *parquetReader) next() (record, error) {
+	for p.row >= p.rows {
+		if len(p.groups) == 0 {
+			return nil, io.EOF
+		}
+		if err := p.readGroup(p.groups[0]); err != nil {
+			return nil, err
+		}
+		p.groups = p.groups[1:]
+	}
+	values := make([]Value, len(p.columns))
+	for i := range p.columns {
+		values[i] = p.values[i][p.row]
+	}
+	p.row++
+	return &columnRecord{names: p.names, values: values}, nil
+}
+
+// readGroup decodes the columns of a row group.
+func (p *parquetReader) readGroup(group tstruct) error {
+	rows := int(group.int(3))
+	chunks := group.list(1)
+	if len(chunks) != len(p.columns) {
+		return parquetError("row group has %d columns, expected %d", len(chunks), len(p.columns))
+	}
+	p.values = make([][]Value, len(p.columns))
+	for i, c := range chunks {
+		chunk, ok := c.(tstruct)
+		if !ok || chunk.strct(3) == nil {
+			return parquetError("invalid column chunk")
+		}
+		values, err := p.readChunk(&p.columns[i], chunk.strct(3), rows)
+		if err != nil {
+			return err
+		}
+		p.values[i] = values
+	}
+	p.row, p.rows = 0, rows
+	return nil
+}
+
+// chunkBounds returns the offset and length of a column chunk.
+func chunkBounds(meta tstruct) (int64, int64) {
+	offset := meta.int(9)
+	if dict, ok := meta[11]; ok {
+		if d, _ := dict.(int64); d > 0 && d < offset {
+			offset = d
+		}
+	}
+	return offset, meta.int(7)
+}
+
+// readChunk decodes the values of a column chunk.
+func (p *parquetReader) readChunk(col *parquetColumn, meta tstruct, rows int) ([]Value, error) {
+	offset, length := chunkBounds(meta)
+	if length < 0 || length > math.MaxInt32 {
+		return nil, parquetError("invalid column chunk length %d", length)
+	}
+	buf := make([]byte, length)
+	if _, err := p.src.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	p.scanned.Add(length)
+	codec := int(meta.int(4))
+
+	var dict []Value
+	values := make([]Value, 0, rows)
+	r := &thriftReader{buf: buf}
+	for len(values) < rows && r.pos < len(buf) {
+		header, err := r.readStruct()
+		if err != nil {
+			return nil, parquetError("invalid page header: %v", err)
+		}
+		size := int(header.int(3))
+		if size < 0 || r.pos+size > len(buf) {
+			return nil, parquetError("page of column %s overflows its chunk", col.name)
+		}
+		page := buf[r.pos : r.pos+size]
+		r.pos += size
+
+		switch header.int(1) {
+		case pageDictionary:
+			data, err := decompress(codec, page, int(header.int(2)))
+			if err != nil {
+				return nil, err
+			}
+			p.processed.Add(int64(len(data)))
+			dict, err = col.decodePlain(data, int(header.strct(7).int(1)))
+			if err != nil {
+				return nil, err
+			}
+		case pageData:
+			data, err := decompress(codec, page, int(header.int(2)))
+			if err != nil {
+				return nil, err
+			}
+			p.processed.Add(int64(len(data)))
+			h := header.strct(5)
+			n := int(h.int(1))
+			var levels []uint32
+			if col.optional {
+				if len(data) < 4 {
+					return nil, parquetError("truncated definition levels")
+				}
+				l := int(binary.LittleEndian.Uint32(data))
+				if 4+l > len(data) {
+					return nil, parquetError("truncated definition levels")
+				}
+				if levels, err = decodeHybrid(data[4:4+l], 1, n); err != nil {
+					return nil, err
+				}
+				data = data[4+l:]
+			}
+			if values, err = col.appendPage(values, data, n, levels, int(h.int(2)), dict); err != nil {
+				return nil, err
+			}
+		case pageDataV2:
+			h := header.strct(8)
+			n := int(h.int(1))
+			defLen, repLen := int(h.int(5)), int(h.int(6))
+			if defLen < 0 || repLen < 0 || repLen+defLen > len(page) {
+				return nil, parquetError("truncated levels")
+			}
+			var levels []uint32
+			if col.optional {
+				if levels, err = decodeHybrid(page[repLen:repLen+defLen], 1, n); err != nil {
+					return nil, err
+				}
+			}
+			data := page[repLen+defLen:]
+			if compressed, ok := h[7].(bool); !ok || compressed {
+				if data, err = decompress(codec, data, int(header.int(2))-repLen-defLen); err != nil {
+					return nil, err
+				}
+			}
+			p.processed.Add(int64(len(data) + repLen + defLen))
+			if values, err = col.appendPage(values, data, n, levels, int(h.int(4)), dict); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(values) != rows {
+		return nil, parquetError("column %s has %d values, expected %d", col.name, len(values), rows)
+	}
+	return values, nil
+}
+
+// appendPage appends the n values of a data page, with nulls where the
+// definition levels are zero.
+func (col *parquetColumn) appendPage(values []Value, data []byte, n int, levels []uint32, encoding int, dict []Value) ([]Value, error) {
+	present := n
+	if levels != nil {
+		present = 0
+		for _, l := range levels {
+			present += int(l)
+		}
+	}
+
+	var decoded []Value
+	switch encoding {
+	case encodingPlain:
+		var err error
+		if decoded, err = col.decodePlain(data, present); err != nil {
+			return nil, err
+		}
+	case encodingPlainDict, encodingRLEDictionary:
+		if dict == nil {
+			return nil, parquetError("column %s has no dictionary", col.name)
+		}
+		if len(data) < 1 {
+			return nil, parquetError("truncated dictionary indices")
+		}
+		indices, err := decodeHybrid(data[1:], int(data[0]), present)
+		if err != nil {
+			return nil, err
+		}
+		decoded = make([]Value, present)
+		for i, index := range indices {
+			if int(index) >= len(dict) {
+				return nil, parquetError("dictionary index %d out of range", index)
+			}
+			decoded[i] = dict[index]
+		}
+	default:
+		return nil, parquetError("encoding %d of column %s is not supported", encoding, col.name)
+	}
+
+	if levels == nil {
+		return append(values, decoded...), nil
+	}
+	for _, l := range levels {
+		if l == 0 {
+			values = append(values, null)
+			continue
+		}
+		values = append(values, decoded[0])
+		decoded = decoded[1:]
+	}
+	return values, nil
+}
+
+// decodePlain decodes n PLAIN values.
+func (col *parquetColumn) decodePlain(data []byte, n int) ([]Value, error) {
+	values := make([]Value, n)
+	pos := 0
+	need := func(size int) error {
+		if size < 0 || pos+size > len(data) {
+			return parquetError("truncated values of column %s", col.name)
+		}
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		switch col.physical {
+		case parquetBoolean:
+			if i/8 >= len(data) {
+				return nil, parquetError("truncated values of column %s", col.name)
+			}
+			values[i] = boolValue(data[i/8]>>(i%8)&1 == 1)
+		case parquetInt32:
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			values[i] = col.intValue(int64(int32(binary.LittleEndian.Uint32(data[pos:]))))
+			pos += 4
+		case parquetInt64:
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			values[i] = col.intValue(int64(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case parquetInt96:
+			if err := need(12); err != nil {
+				return nil, err
+			}
+			nanos := int64(binary.LittleEndian.Uint64(data[pos:]))
+			day := int64(binary.LittleEndian.Uint32(data[pos+8:]))
+			// Days are Julian days, 2440588 being the Unix epoch
+			t := time.Unix((day-2440588)*86400, nanos).UTC()
+			values[i] = stringValue(t.Format(time.RFC3339Nano))
+			pos += 12
+		case parquetFloat:
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			values[i] = floatValue(float64(math.Float32frombits(binary.LittleEndian.Uint32(data[pos:]))))
+			pos += 4
+		case parquetDouble:
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			values[i] = floatValue(math.Float64frombits(binary.LittleEndian.Uint64(data[pos:])))
+			pos += 8
+		case parquetByteArray:
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			size := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if err := need(size); err != nil {
+				return nil, err
+			}
+			values[i] = col.bytesValue(data[pos : pos+size])
+			pos += size
+		case parquetFixedLenByteArray:
+			if err := need(col.typeLen); err != nil {
+				return nil, err
+			}
+			values[i] = col.bytesValue(data[pos : pos+col.typeLen])
+			pos += col.typeLen
+		default:
+			return nil, parquetError("type %d of column %s is not supported", col.physical, col.name)
+		}
+	}
+	return values, nil
+}
+
+// intValue interprets an INT32 or INT64 value.
+func (col *parquetColumn) intValue(v int64) Value {
+	switch {
+	case col.converted == convertedDecimal:
+		return floatValue(float64(v) / math.Pow10(col.scale))
+	case col.converted == convertedDate:
+		return stringValue(time.Unix(v*86400, 0).UTC().Format(time.DateOnly))
+	case col.timeUnit != 0:
+		return stringValue(time.Unix(0, v*int64(col.timeUnit)).UTC().Format(time.RFC3339Nano))
+	}
+	return intValue(v)
+}
+
+// bytesValue interprets a BYTE_ARRAY or FIXED_LEN_BYTE_ARRAY value.
+func (col *parquetColumn) bytesValue(b []byte) Value {
+	if col.converted == convertedDecimal {
+		// Decimals are big endian two's complement integers
+		n := new(big.Int).SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+		}
+		f, _ := new(big.Float).Quo(new(big.Float).SetInt(n), new(big.Float).SetFloat64(math.Pow10(col.scale))).Float64()
+		return floatValue(f)
+	}
+	return stringValue(string(b))
+}
+
+// decodeHybrid decodes n values of the RLE/bit-packing hybrid encoding.
+func decodeHybrid(data []byte, bitWidth, n int) ([]uint32, error) {
+	if bitWidth > 32 {
+		return nil, parquetError("invalid bit width %d", bitWidth)
+	}
+	values := make([]uint32, 0, n)
+	pos := 0
+	for len(values) < n {
+		header, size := binary.Uvarint(data[pos:])
+		if size <= 0 {
+			return nil, parquetError("truncated RLE data")
+		}
+		pos += size
+		if header&1 == 0 {
+			// A run of a repeated value
+			count := int(header >> 1)
+			width := (bitWidth + 7) / 8
+			if pos+width > len(data) {
+				return nil, parquetError("truncated RLE run")
+			}
+			var v uint32
+			for i := 0; i < width; i++ {
+				v |= uint32(data[pos+i]) << (8 * i)
+			}
+			pos += width
+			for i := 0; i < count && len(values) < n; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+		// Groups of 8 bit-packed values, least significant bits first
+		groups := int(header >> 1)
+		size = groups * bitWidth
+		if pos+size > len(data) {
+			return nil, parquetError("truncated bit-packed run")
+		}
+		packed := data[pos : pos+size]
+		pos += size
+		mask := uint64(1)<<bitWidth - 1
+		for i := 0; i < groups*8 && len(values) < n; i++ {
+			bit := i * bitWidth
+			var word uint64
+			for b := bit / 8; b < len(packed) && b <= (bit+bitWidth)/8; b++ {
+				word |= uint64(packed[b]) << (8 * (b - bit/8))
+			}
+			values = append(values, uint32(word>>(bit%8)&mask))
+		}
+	}
+	return values, nil
+}
+
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// decompress decompresses a page.
+func decompress(codec int, data []byte, size int) ([]byte, error) {
+	var out []byte
+	var err error
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		out, err = snappy.Decode(nil, data)
+	case codecGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			out, err = io.ReadAll(io.LimitReader(zr, int64(size)+1))
+		}
+	case codecZstd:
+		out, err = zstdDecoder.DecodeAll(data, make([]byte, 0, size))
+	default:
+		return nil, parquetError("compression codec %d is not supported", codec)
+	}
+	if err != nil {
+		return nil, parquetError("failed to decompress page: %v", err)
+	}
+	if len(out) != size {
+		return nil, parquetError("page decompressed to %d bytes, expected %d", len(out), size)
+	}
+	return out, nil
+}
+
+// tstruct is a Thrift struct decoded generically, by field ID.
+type tstruct map[int16]any
+
+func (s tstruct) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s tstruct) bytes(id int16) []byte {
+	v, _ := s[id].([]byte)
+	return v
+}
+
+func (s tstruct) list(id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+func (s tstruct) strct(id int16) tstruct {
+	v, _ := s[id].(tstruct)
+	return v
+}
+
+// Thrift compact protocol types.
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+
+	maxThriftDepth = 32
+)
+
+var errThriftTruncated = errors.New("truncated thrift data")
+
+// thriftReader decodes the Thrift compact protocol used by Parquet
+// metadata.
+type thriftReader struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+func (t *thriftReader) byte() (byte, error) {
+	if t.pos >= len(t.buf) {
+		return 0, errThriftTruncated
+	}
+	b := t.buf[t.pos]
+	t.pos++
+	return b, nil
+}
+
+func (t *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(t.buf[t.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	t.pos += n
+	return v, nil
+}
+
+func (t *thriftReader) varint() (int64, error) {
+	v, err := t.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (t *thriftReader) readStruct() (tstruct, error) {
+	if t.depth++; t.depth > maxThriftDepth {
+		return nil, errors.New("thrift data nested too deeply")
+	}
+	defer func() { t.depth-- }()
+	s := tstruct{}
+	var id int16
+	for {
+		b, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return s, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := t.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		if s[id], err = t.readValue(b & 0x0f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *thriftReader) readValue(typ byte) (any, error) {
+	switch typ {
+	case thriftBoolTrue:
+		return true, nil
+	case thriftBoolFalse:
+		return false, nil
+	case thriftByte:
+		b, err := t.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return t.varint()
+	case thriftDouble:
+		if t.pos+8 > len(t.buf) {
+			return nil, errThriftTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(t.buf[t.pos:]))
+		t.pos += 8
+		return v, nil
+	case thriftBinary:
+		n, err := t.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(t.buf)-t.pos) {
+			return nil, errThriftTruncated
+		}
+		b := t.buf[t.pos : t.pos+int(n)]
+		t.pos += int(n)
+		return b, nil
+	case thriftList, thriftSet:
+		h, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = t.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(t.buf)-t.pos) {
+			return nil, errThriftTruncated
+		}
+		list := make([]any, n)
+		for i := range list {
+			if list[i], err = t.readElem(h & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case thriftMap:
+		n, err := t.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		types, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := t.readElem(types >> 4); err != nil {
+				return nil, err
+			}
+			if _, err := t.readElem(types & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		// Maps are not used by the metadata read
+		return nil, nil
+	case thriftStruct:
+		return t.readStruct()
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
+
+// readElem reads an element of a list, set or map, where booleans take a
+// byte each.
+func (t *thriftReader) readElem(typ byte) (any, error) {
+	if typ == thriftBoolTrue || typ == thriftBoolFalse {
+		b, err := t.byte()
+		return b == thriftBoolTrue, err
+	}
+	return t.readValue(typ)
+}
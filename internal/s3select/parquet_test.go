@@ -0,0 +1,328 @@
+package s3select
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// thriftWriter writes the Thrift compact protocol, for building Parquet
+// files in tests.
+type thriftWriter struct {
+	bytes.Buffer
+	last []int16
+}
+
+func (w *thriftWriter) varint(v int64) {
+	w.Write(binary.AppendUvarint(nil, uint64(v<<1^v>>63)))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	top := &w.last[len(w.last)-1]
+	if delta := id - *top; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	*top = id
+}
+
+func (w *thriftWriter) int(id int16, typ byte, v int64) {
+	w.field(id, typ)
+	w.varint(v)
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.WriteString(s)
+}
+
+func (w *thriftWriter) listHeader(id int16, n int, elem byte) {
+	w.field(id, thriftList)
+	w.WriteByte(byte(n)<<4 | elem)
+}
+
+// body writes the fields of a struct and its stop byte.
+func (w *thriftWriter) body(fn func()) {
+	w.last = append(w.last, 0)
+	fn()
+	w.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) strct(id int16, fn func()) {
+	w.field(id, thriftStruct)
+	w.body(fn)
+}
+
+type parquetRow struct {
+	id    int64
+	name  *string
+	score *float64
+}
+
+// writeParquet writes a file with a column per field of parquetRow: id is
+// PLAIN in a v1 page, name dictionary encoded and SNAPPY compressed, and
+// score gzip compressed in a v2 page. Returns the offsets of the row groups.
+func writeParquet(groups [][]parquetRow) ([]byte, []int64) {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	type chunk struct {
+		typ, codec                 int64
+		name                       string
+		dictOffset, offset, length int64
+	}
+	var metas [][]chunk
+	var offsets []int64
+	page := func(header func(w *thriftWriter), data []byte) {
+		w := &thriftWriter{last: []int16{0}}
+		header(w)
+		w.WriteByte(0)
+		file.Write(w.Bytes())
+		file.Write(data)
+	}
+
+	for _, rows := range groups {
+		offsets = append(offsets, int64(file.Len()))
+		var chunks []chunk
+
+		// id: required INT64, PLAIN
+		start := int64(file.Len())
+		var ids []byte
+		for _, r := range rows {
+			ids = binary.LittleEndian.AppendUint64(ids, uint64(r.id))
+		}
+		page(func(w *thriftWriter) {
+			w.int(1, thriftI32, pageData)
+			w.int(2, thriftI32, int64(len(ids)))
+			w.int(3, thriftI32, int64(len(ids)))
+			w.strct(5, func() {
+				w.int(1, thriftI32, int64(len(rows)))
+				w.int(2, thriftI32, encodingPlain)
+				w.int(3, thriftI32, 3)
+				w.int(4, thriftI32, 3)
+			})
+		}, ids)
+		chunks = append(chunks, chunk{typ: parquetInt64, name: "id", offset: start, length: int64(file.Len()) - start})
+
+		// name: optional BYTE_ARRAY, dictionary encoded, SNAPPY
+		start = int64(file.Len())
+		var dict []string
+		var indices []int
+		present := make([]bool, len(rows))
+		for i, r := range rows {
+			if r.name == nil {
+				continue
+			}
+			present[i] = true
+			index := -1
+			for j, d := range dict {
+				if d == *r.name {
+					index = j
+				}
+			}
+			if index < 0 {
+				index = len(dict)
+				dict = append(dict, *r.name)
+			}
+			indices = append(indices, index)
+		}
+		var plain []byte
+		for _, d := range dict {
+			plain = binary.LittleEndian.AppendUint32(plain, uint32(len(d)))
+			plain = append(plain, d...)
+		}
+		packed := snappy.Encode(nil, plain)
+		page(func(w *thriftWriter) {
+			w.int(1, thriftI32, pageDictionary)
+			w.int(2, thriftI32, int64(len(plain)))
+			w.int(3, thriftI32, int64(len(packed)))
+			w.strct(7, func() {
+				w.int(1, thriftI32, int64(len(dict)))
+				w.int(2, thriftI32, encodingPlain)
+			})
+		}, packed)
+		levels := bitPack(boolsToInts(present), 1)
+		data := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+		data = append(data, levels...)
+		data = append(data, 2)
+		data = append(data, bitPack(indices, 2)...)
+		packed = snappy.Encode(nil, data)
+		dataStart := int64(file.Len())
+		page(func(w *thriftWriter) {
+			w.int(1, thriftI32, pageData)
+			w.int(2, thriftI32, int64(len(data)))
+			w.int(3, thriftI32, int64(len(packed)))
+			w.strct(5, func() {
+				w.int(1, thriftI32, int64(len(rows)))
+				w.int(2, thriftI32, encodingRLEDictionary)
+				w.int(3, thriftI32, 3)
+				w.int(4, thriftI32, 3)
+			})
+		}, packed)
+		chunks = append(chunks, chunk{typ: parquetByteArray, codec: codecSnappy, name: "name", dictOffset: start, offset: dataStart, length: int64(file.Len()) - start})
+
+		// score: optional DOUBLE, v2 page, GZIP
+		start = int64(file.Len())
+		nulls := 0
+		var values []byte
+		for i, r := range rows {
+			present[i] = r.score != nil
+			if r.score == nil {
+				nulls++
+				continue
+			}
+			values = binary.LittleEndian.AppendUint64(values, math.Float64bits(*r.score))
+		}
+		levels = bitPack(boolsToInts(present), 1)
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		_, _ = zw.Write(values)
+		_ = zw.Close()
+		page(func(w *thriftWriter) {
+			w.int(1, thriftI32, pageDataV2)
+			w.int(2, thriftI32, int64(len(levels)+len(values)))
+			w.int(3, thriftI32, int64(len(levels)+gz.Len()))
+			w.strct(8, func() {
+				w.int(1, thriftI32, int64(len(rows)))
+				w.int(2, thriftI32, int64(nulls))
+				w.int(3, thriftI32, int64(len(rows)))
+				w.int(4, thriftI32, encodingPlain)
+				w.int(5, thriftI32, int64(len(levels)))
+				w.int(6, thriftI32, 0)
+			})
+		}, append(levels, gz.Bytes()...))
+		chunks = append(chunks, chunk{typ: parquetDouble, codec: codecGzip, name: "score", offset: start, length: int64(file.Len()) - start})
+		metas = append(metas, chunks)
+	}
+
+	w := &thriftWriter{last: []int16{0}}
+	w.int(1, thriftI32, 1)
+	w.listHeader(2, 4, thriftStruct)
+	w.body(func() {
+		w.binary(4, "schema")
+		w.int(5, thriftI32, 3)
+	})
+	for _, leaf := range []struct {
+		typ, repetition int64
+		name            string
+	}{{parquetInt64, 0, "id"}, {parquetByteArray, repetitionOptional, "name"}, {parquetDouble, repetitionOptional, "score"}} {
+		w.body(func() {
+			w.int(1, thriftI32, leaf.typ)
+			w.int(3, thriftI32, leaf.repetition)
+			w.binary(4, leaf.name)
+		})
+	}
+	total := 0
+	for _, rows := range groups {
+		total += len(rows)
+	}
+	w.int(3, thriftI64, int64(total))
+	w.listHeader(4, len(groups), thriftStruct)
+	for g, chunks := range metas {
+		w.body(func() {
+			w.listHeader(1, len(chunks), thriftStruct)
+			for _, c := range chunks {
+				w.body(func() {
+					w.int(2, thriftI64, c.offset)
+					w.strct(3, func() {
+						w.int(1, thriftI32, c.typ)
+						w.listHeader(2, 1, thriftI32)
+						w.varint(encodingPlain)
+						w.listHeader(3, 1, thriftBinary)
+						w.Write(binary.AppendUvarint(nil, uint64(len(c.name))))
+						w.WriteString(c.name)
+						w.int(4, thriftI32, c.codec)
+						w.int(5, thriftI64, int64(len(groups[g])))
+						w.int(6, thriftI64, c.length)
+						w.int(7, thriftI64, c.length)
+						w.int(9, thriftI64, c.offset)
+						if c.dictOffset > 0 {
+							w.int(11, thriftI64, c.dictOffset)
+						}
+					})
+				})
+			}
+			w.int(2, thriftI64, 0)
+			w.int(3, thriftI64, int64(len(groups[g])))
+		})
+	}
+	w.WriteByte(0)
+
+	file.Write(w.Bytes())
+	_ = binary.Write(&file, binary.LittleEndian, uint32(w.Len()))
+	file.WriteString(parquetMagic)
+	return file.Bytes(), offsets
+}
+
+func boolsToInts(bs []bool) []int {
+	ints := make([]int, len(bs))
+	for i, b := range bs {
+		if b {
+			ints[i] = 1
+		}
+	}
+	return ints
+}
+
+// bitPack encodes values as a single bit-packed run of the hybrid encoding.
+func bitPack(values []int, width int) []byte {
+	groups := (len(values) + 7) / 8
+	out := binary.AppendUvarint(nil, uint64(groups<<1|1))
+	packed := make([]byte, groups*width)
+	for i, v := range values {
+		for b := 0; b < width; b++ {
+			if v>>b&1 == 1 {
+				bit := i*width + b
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return append(out, packed...)
+}
+
+func TestSelectParquet(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(f float64) *float64 { return &f }
+	data, offsets := writeParquet([][]parquetRow{
+		{{1, str("ann"), num(1.5)}, {2, nil, num(2.5)}, {3, str("ann"), nil}},
+		{{4, str("ben"), num(4)}, {5, str("cat"), num(0.5)}},
+	})
+
+	tests := []struct {
+		sql    string
+		output Output
+		scan   *ScanRange
+		want   string
+	}{
+		{"SELECT * FROM S3Object", Output{Format: FormatCSV}, nil, "1,ann,1.5\n2,,2.5\n3,ann,\n4,ben,4\n5,cat,0.5\n"},
+		{"SELECT s.id, s.name FROM S3Object s WHERE s.score > 1 AND s.name IS NOT NULL", Output{Format: FormatJSON}, nil,
+			"{\"id\":1,\"name\":\"ann\"}\n{\"id\":4,\"name\":\"ben\"}\n"},
+		{"SELECT COUNT(*), SUM(id), AVG(score), MAX(name) FROM S3Object", Output{Format: FormatCSV}, nil, "5,15,2.125,cat\n"},
+		{"SELECT id FROM S3Object", Output{Format: FormatCSV}, &ScanRange{Start: offsets[1], End: -1}, "4\n5\n"},
+		{"SELECT id FROM S3Object", Output{Format: FormatCSV}, &ScanRange{Start: 0, End: offsets[1] - 1}, "1\n2\n3\n"},
+	}
+	for _, tt := range tests {
+		req := Request{Expression: tt.sql, Input: Input{Format: FormatParquet}, Output: tt.output, ScanRange: tt.scan}
+		got, err := runSelect(t, req, data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.sql, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.sql, tt.want, got)
+		}
+	}
+
+	_, err := runSelect(t, Request{Expression: "SELECT * FROM S3Object", Input: Input{Format: FormatParquet}, Output: Output{Format: FormatCSV}}, []byte(people))
+	if selectErr, ok := err.(*Error); !ok || selectErr.Code != CodeParquetParsing {
+		t.Fatalf("expected %s for a CSV object, got %v", CodeParquetParsing, err)
+	}
+}
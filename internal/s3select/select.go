@@ -0,0 +1,420 @@
+// Package s3select runs the SQL queries of S3 SelectObjectContent over CSV,
+// JSON and Parquet objects.
+//
+// The supported SQL is a subset of the S3 Select language: SELECT with
+// projections or *, FROM S3Object with an optional alias, WHERE, LIMIT and
+// the COUNT, SUM, AVG, MIN and MAX aggregates. Expressions support
+// comparisons, AND, OR, NOT, arithmetic, ||, LIKE, IS NULL, IN, BETWEEN,
+// CAST and the LOWER, UPPER, TRIM, CHAR_LENGTH, SUBSTRING, COALESCE and
+// NULLIF functions.
+package s3select
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Input and output formats.
+const (
+	FormatCSV     = "CSV"
+	FormatJSON    = "JSON"
+	FormatParquet = "Parquet"
+)
+
+// Compression types of CSV and JSON input.
+const (
+	CompressionNone  = "NONE"
+	CompressionGzip  = "GZIP"
+	CompressionBzip2 = "BZIP2"
+)
+
+// FileHeaderInfo values of CSV input.
+const (
+	HeaderUse    = "USE"
+	HeaderIgnore = "IGNORE"
+	HeaderNone   = "NONE"
+)
+
+// Types of JSON input.
+const (
+	JSONDocument = "DOCUMENT"
+	JSONLines    = "LINES"
+)
+
+// QuoteFields values of CSV output.
+const (
+	QuoteAlways   = "ALWAYS"
+	QuoteAsNeeded = "ASNEEDED"
+)
+
+// Error codes of failed queries, as reported by S3.
+const (
+	CodeUnsupportedSyntax           = "UnsupportedSyntax"
+	CodeUnsupportedFunction         = "UnsupportedFunction"
+	CodeCastFailed                  = "CastFailed"
+	CodeEvaluation                  = "EvaluatorInvalidArguments"
+	CodeInvalidFileHeaderInfo       = "InvalidFileHeaderInfo"
+	CodeInvalidJSONType             = "InvalidJsonType"
+	CodeInvalidQuoteFields          = "InvalidQuoteFields"
+	CodeInvalidCompressionFormat    = "InvalidCompressionFormat"
+	CodeObjectSerializationConflict = "ObjectSerializationConflict"
+	CodeInvalidRequestParameter     = "InvalidRequestParameter"
+	CodeCSVParsing                  = "CSVParsingError"
+	CodeJSONParsing                 = "JSONParsingError"
+	CodeParquetParsing              = "ParquetParsingError"
+)
+
+// Error is a query failure, with the S3 error code reported for it.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func syntaxError(format string, args ...any) *Error {
+	return &Error{Code: CodeUnsupportedSyntax, Message: fmt.Sprintf(format, args...)}
+}
+
+// Request describes a query.
+type Request struct {
+	Expression string
+	Input      Input
+	Output     Output
+	// ScanRange, when set, limits the query to the records starting within
+	// a byte range of the object
+	ScanRange *ScanRange
+}
+
+// Input is the format of the object.
+type Input struct {
+	// Format is FormatCSV, FormatJSON or FormatParquet
+	Format string
+	// Compression of CSV and JSON input; empty means CompressionNone
+	Compression string
+	CSV         CSVInput
+	JSON        JSONInput
+}
+
+// CSVInput describes CSV input. Empty fields take the S3 defaults.
+type CSVInput struct {
+	FileHeaderInfo       string
+	Comments             string
+	QuoteEscapeCharacter string
+	RecordDelimiter      string
+	FieldDelimiter       string
+	QuoteCharacter       string
+}
+
+// JSONInput describes JSON input.
+type JSONInput struct {
+	// Type is JSONDocument or JSONLines
+	Type string
+}
+
+// Output is the format of the results.
+type Output struct {
+	// Format is FormatCSV or FormatJSON
+	Format string
+	CSV    CSVOutput
+	JSON   JSONOutput
+}
+
+// CSVOutput describes CSV results. Empty fields take the S3 defaults.
+type CSVOutput struct {
+	QuoteFields          string
+	QuoteEscapeCharacter string
+	RecordDelimiter      string
+	FieldDelimiter       string
+	QuoteCharacter       string
+}
+
+// JSONOutput describes JSON results.
+type JSONOutput struct {
+	RecordDelimiter string
+}
+
+// ScanRange is an inclusive byte range of the object. A negative Start
+// selects the last End bytes, and a negative End the bytes from Start on.
+type ScanRange struct {
+	Start int64
+	End   int64
+}
+
+// Stats counts the bytes of a query.
+type Stats struct {
+	// BytesScanned is the size of the object data read
+	BytesScanned int64
+	// BytesProcessed is the size of the data read once decompressed
+	BytesProcessed int64
+	// BytesReturned is the size of the results
+	BytesReturned int64
+}
+
+// recordsBufferSize is the size of the result chunks written to Run's writer.
+const recordsBufferSize = 64 << 10
+
+// Select is a query validated and ready to run.
+type Select struct {
+	req   Request
+	query *query
+
+	scanned   atomic.Int64
+	processed atomic.Int64
+	returned  atomic.Int64
+}
+
+// New parses the expression of a request and validates its serialization.
+// Errors are of type *Error.
+func New(req Request) (*Select, error) {
+	if err := normalize(&req); err != nil {
+		return nil, err
+	}
+	q, err := parse(req.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return &Select{req: req, query: q}, nil
+}
+
+// normalize validates a request and fills in the defaults.
+func normalize(req *Request) error {
+	in := &req.Input
+	if in.Compression == "" {
+		in.Compression = CompressionNone
+	}
+	switch in.Compression {
+	case CompressionNone, CompressionGzip, CompressionBzip2:
+	default:
+		return &Error{Code: CodeInvalidCompressionFormat, Message: fmt.Sprintf("unsupported compression %s", in.Compression)}
+	}
+
+	switch in.Format {
+	case FormatCSV:
+		c := &in.CSV
+		if c.FileHeaderInfo == "" {
+			c.FileHeaderInfo = HeaderNone
+		}
+		switch c.FileHeaderInfo {
+		case HeaderUse, HeaderIgnore, HeaderNone:
+		default:
+			return &Error{Code: CodeInvalidFileHeaderInfo, Message: fmt.Sprintf("invalid FileHeaderInfo %s", c.FileHeaderInfo)}
+		}
+		if err := defaultCSV(&c.RecordDelimiter, &c.FieldDelimiter, &c.QuoteCharacter, &c.QuoteEscapeCharacter); err != nil {
+			return err
+		}
+		if c.Comments != "" && utf8.RuneCountInString(c.Comments) != 1 {
+			return &Error{Code: CodeInvalidRequestParameter, Message: "Comments must be a single character"}
+		}
+	case FormatJSON:
+		switch in.JSON.Type {
+		case JSONDocument, JSONLines:
+		default:
+			return &Error{Code: CodeInvalidJSONType, Message: fmt.Sprintf("invalid JSON type %q", in.JSON.Type)}
+		}
+	case FormatParquet:
+		if in.Compression != CompressionNone {
+			return &Error{Code: CodeInvalidCompressionFormat, Message: "Parquet input must not be compressed"}
+		}
+	default:
+		return &Error{Code: CodeObjectSerializationConflict, Message: "input serialization must specify one of CSV, JSON or Parquet"}
+	}
+
+	out := &req.Output
+	switch out.Format {
+	case FormatCSV:
+		c := &out.CSV
+		if c.QuoteFields == "" {
+			c.QuoteFields = QuoteAsNeeded
+		}
+		if c.QuoteFields != QuoteAlways && c.QuoteFields != QuoteAsNeeded {
+			return &Error{Code: CodeInvalidQuoteFields, Message: fmt.Sprintf("invalid QuoteFields %s", c.QuoteFields)}
+		}
+		if err := defaultCSV(&c.RecordDelimiter, &c.FieldDelimiter, &c.QuoteCharacter, &c.QuoteEscapeCharacter); err != nil {
+			return err
+		}
+	case FormatJSON:
+		if out.JSON.RecordDelimiter == "" {
+			out.JSON.RecordDelimiter = "\n"
+		}
+	default:
+		return &Error{Code: CodeObjectSerializationConflict, Message: "output serialization must specify one of CSV or JSON"}
+	}
+
+	if r := req.ScanRange; r != nil {
+		switch {
+		case r.Start < 0 && r.End < 0, r.Start >= 0 && r.End >= 0 && r.End < r.Start:
+			return &Error{Code: CodeInvalidRequestParameter, Message: "invalid ScanRange"}
+		case in.Compression != CompressionNone:
+			return &Error{Code: CodeInvalidRequestParameter, Message: "ScanRange is not supported with compressed input"}
+		case in.Format == FormatJSON && in.JSON.Type == JSONDocument:
+			return &Error{Code: CodeInvalidRequestParameter, Message: "ScanRange is not supported with JSON documents"}
+		}
+	}
+	return nil
+}
+
+// defaultCSV fills in the default CSV delimiters and characters.
+func defaultCSV(record, field, quote, escape *string) error {
+	if *record == "" {
+		*record = "\n"
+	}
+	if *field == "" {
+		*field = ","
+	}
+	if *quote == "" {
+		*quote = `"`
+	}
+	if *escape == "" {
+		*escape = *quote
+	}
+	if utf8.RuneCountInString(*field) != 1 || utf8.RuneCountInString(*quote) != 1 || utf8.RuneCountInString(*escape) != 1 {
+		return &Error{Code: CodeInvalidRequestParameter, Message: "field delimiters, quote and escape characters must be single characters"}
+	}
+	return nil
+}
+
+// Stats returns the bytes scanned, processed and returned so far. It may be
+// called while the query runs.
+func (s *Select) Stats() Stats {
+	return Stats{
+		BytesScanned:   s.scanned.Load(),
+		BytesProcessed: s.processed.Load(),
+		BytesReturned:  s.returned.Load(),
+	}
+}
+
+// Run runs the query over an object of the given size, writing the results
+// to w in chunks. Query failures are of type *Error; other errors come from
+// reading the object or writing the results.
+func (s *Select) Run(ctx context.Context, src io.ReaderAt, size int64, w io.Writer) error {
+	rows, err := s.open(src, size)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(&countingWriter{w: w, n: &s.returned}, recordsBufferSize)
+	out := newOutputWriter(s.req.Output, bw)
+
+	q := s.query
+	e := &env{q: q, aggs: make([]accumulator, len(q.aggregates))}
+	for i, agg := range q.aggregates {
+		e.aggs[i].name = agg.name
+	}
+	var names []string
+	if !q.star {
+		names = make([]string, len(q.projections))
+		for i, p := range q.projections {
+			names[i] = columnName(p, i)
+		}
+	}
+
+	var emitted int64
+	for n := 0; q.limit < 0 || len(q.aggregates) > 0 || emitted < q.limit; n++ {
+		if n%1000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rec, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		e.rec = rec
+		if q.where != nil {
+			v, err := e.eval(q.where)
+			if err != nil {
+				return err
+			}
+			if !v.isTrue() {
+				continue
+			}
+		}
+		if len(q.aggregates) > 0 {
+			if err := s.accumulate(e); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.project(e, out, names); err != nil {
+			return err
+		}
+		emitted++
+	}
+	if len(q.aggregates) > 0 && q.limit != 0 {
+		e.rec = emptyRecord{}
+		if err := s.project(e, out, names); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// accumulate adds the values of a record to the aggregates.
+func (s *Select) accumulate(e *env) error {
+	for i, agg := range s.query.aggregates {
+		v := boolValue(true)
+		if agg.arg != nil {
+			var err error
+			if v, err = e.eval(agg.arg); err != nil {
+				return err
+			}
+		}
+		if err := e.aggs[i].add(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// project writes the projections of the current record.
+func (s *Select) project(e *env, out outputWriter, names []string) error {
+	if s.query.star {
+		return out.write(e.rec.fields())
+	}
+	values := make([]Value, len(s.query.projections))
+	for i, p := range s.query.projections {
+		v, err := e.eval(p.expr)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	return out.write(names, values)
+}
+
+type emptyRecord struct{}
+
+func (emptyRecord) get([]pathElem) Value        { return null }
+func (emptyRecord) fields() ([]string, []Value) { return nil, nil }
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
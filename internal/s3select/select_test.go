@@ -0,0 +1,245 @@
+package s3select
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"testing"
+)
+
+const people = `name,age,city
+Alice,30,Paris
+Bob,25,"New York, NY"
+Carol,41,Berlin
+# a comment
+Dave,,Paris
+`
+
+func runSelect(t *testing.T, req Request, data []byte) (string, error) {
+	t.Helper()
+	s, err := New(req)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	err = s.Run(context.Background(), bytes.NewReader(data), int64(len(data)), &out)
+	if stats := s.Stats(); err == nil && stats.BytesReturned != int64(out.Len()) {
+		t.Errorf("expected %d bytes returned, got %d", out.Len(), stats.BytesReturned)
+	}
+	return out.String(), err
+}
+
+func TestSelectCSV(t *testing.T) {
+	input := Input{Format: FormatCSV, CSV: CSVInput{FileHeaderInfo: HeaderUse, Comments: "#"}}
+	csvOut := Output{Format: FormatCSV}
+	jsonOut := Output{Format: FormatJSON}
+
+	tests := []struct {
+		sql    string
+		output Output
+		want   string
+	}{
+		{"SELECT * FROM S3Object", csvOut, "Alice,30,Paris\nBob,25,\"New York, NY\"\nCarol,41,Berlin\nDave,,Paris\n"},
+		{"SELECT s.name FROM S3Object s WHERE s.city = 'Paris'", csvOut, "Alice\nDave\n"},
+		{"SELECT name, age + 1 FROM S3Object WHERE age <> '' AND CAST(age AS INT) > 26", jsonOut, "{\"name\":\"Alice\",\"_2\":31}\n{\"name\":\"Carol\",\"_2\":42}\n"},
+		{"SELECT _1 AS who FROM S3Object LIMIT 2", jsonOut, "{\"who\":\"Alice\"}\n{\"who\":\"Bob\"}\n"},
+		{"SELECT COUNT(*), MIN(name), MAX(city) FROM S3Object", csvOut, "4,Alice,Paris\n"},
+		{"SELECT SUM(age), MAX(age), MIN(age) FROM S3Object WHERE age <> ''", csvOut, "96,41,25\n"},
+		{"SELECT AVG(age) FROM S3Object WHERE age <> ''", csvOut, "32\n"},
+		{"SELECT UPPER(name) FROM S3Object WHERE name LIKE '_a%' OR city IN ('Berlin')", csvOut, "CAROL\nDAVE\n"},
+		{"SELECT name FROM S3Object WHERE age BETWEEN 26 AND 40 AND NOT city LIKE 'B%'", csvOut, "Alice\n"},
+		{"SELECT name || '@' || LOWER(city) FROM S3Object WHERE NULLIF(age, '') IS NULL", csvOut, "Dave@paris\n"},
+		{"SELECT SUBSTRING(city FROM 1 FOR 3), CHAR_LENGTH(city) FROM S3Object WHERE s3object.name = 'Bob'", csvOut, "New,12\n"},
+		{`SELECT "name" FROM S3Object WHERE "city" = 'Berlin'`, csvOut, "Carol\n"},
+	}
+	for _, tt := range tests {
+		got, err := runSelect(t, Request{Expression: tt.sql, Input: input, Output: tt.output}, []byte(people))
+		if err != nil {
+			t.Errorf("%s: %v", tt.sql, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.sql, tt.want, got)
+		}
+	}
+}
+
+func TestSelectCSVOptions(t *testing.T) {
+	data := "1|'a|b'|x\r\n2|'it''s'|y\r\n"
+	req := Request{
+		Expression: "SELECT _2, _3 FROM S3Object WHERE _1 >= 1",
+		Input: Input{Format: FormatCSV, CSV: CSVInput{
+			RecordDelimiter: "\r\n",
+			FieldDelimiter:  "|",
+			QuoteCharacter:  "'",
+		}},
+		Output: Output{Format: FormatCSV, CSV: CSVOutput{QuoteFields: QuoteAlways, FieldDelimiter: ";", RecordDelimiter: "\n"}},
+	}
+	got, err := runSelect(t, req, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\"a|b\";\"x\"\n\"it's\";\"y\"\n"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte(people))
+	_ = zw.Close()
+	req = Request{
+		Expression: "SELECT COUNT(*) FROM S3Object",
+		Input:      Input{Format: FormatCSV, Compression: CompressionGzip, CSV: CSVInput{FileHeaderInfo: HeaderIgnore}},
+		Output:     Output{Format: FormatCSV},
+	}
+	s, err := New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := s.Run(context.Background(), bytes.NewReader(gz.Bytes()), int64(gz.Len()), &out); err != nil {
+		t.Fatal(err)
+	}
+	// Without a comment character, the comment line is a record
+	if out.String() != "5\n" {
+		t.Fatalf("expected 5 records, got %q", out.String())
+	}
+	stats := s.Stats()
+	if stats.BytesScanned != int64(gz.Len()) || stats.BytesProcessed != int64(len(people)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSelectJSON(t *testing.T) {
+	lines := `{"id":1,"user":{"name":"ann","tags":["a","b"]},"score":9.5}
+{"id":2,"user":{"name":"ben","tags":[]},"score":null}
+{"id":3,"user":{"name":"cat","tags":["c"]},"score":7}
+`
+	tests := []struct {
+		sql, typ, data string
+		output         Output
+		want           string
+	}{
+		{"SELECT * FROM S3Object[*] s WHERE s.id = 2", JSONLines, lines, Output{Format: FormatJSON},
+			"{\"id\":2,\"user\":{\"name\":\"ben\",\"tags\":[]},\"score\":null}\n"},
+		{"SELECT s.user.name, s.user.tags[0] AS tag FROM S3Object s WHERE s.score > 8", JSONLines, lines, Output{Format: FormatJSON},
+			"{\"name\":\"ann\",\"tag\":\"a\"}\n"},
+		{"SELECT s.user.name FROM S3Object s WHERE s.score IS NULL", JSONLines, lines, Output{Format: FormatCSV}, "ben\n"},
+		{"SELECT SUM(s.score), COUNT(s.score) FROM S3Object s", JSONLines, lines, Output{Format: FormatCSV}, "16.5,2\n"},
+		{"SELECT s.id, s.user FROM S3Object s LIMIT 1", JSONLines, lines, Output{Format: FormatCSV},
+			"1,\"{\"\"name\"\":\"\"ann\"\",\"\"tags\"\":[\"\"a\"\",\"\"b\"\"]}\"\n"},
+		{"SELECT s.v FROM S3Object[*] s", JSONDocument, `[{"v":1},{"v":2}]`, Output{Format: FormatJSON, JSON: JSONOutput{RecordDelimiter: ","}},
+			`{"v":1},{"v":2},`},
+	}
+	for _, tt := range tests {
+		req := Request{Expression: tt.sql, Input: Input{Format: FormatJSON, JSON: JSONInput{Type: tt.typ}}, Output: tt.output}
+		got, err := runSelect(t, req, []byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.sql, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.sql, tt.want, got)
+		}
+	}
+}
+
+func TestSelectScanRange(t *testing.T) {
+	data := "a,1\nbb,2\nccc,3\ndddd,4\n"
+	tests := []struct {
+		start, end int64
+		want       string
+	}{
+		{0, 3, "a\n"},
+		{1, 4, "bb\n"},
+		{4, 9, "bb\nccc\n"},
+		{5, -1, "ccc\ndddd\n"},
+		{-1, 7, "dddd\n"},
+		{40, -1, ""},
+	}
+	for _, tt := range tests {
+		req := Request{
+			Expression: "SELECT _1 FROM S3Object",
+			Input:      Input{Format: FormatCSV},
+			Output:     Output{Format: FormatCSV},
+			ScanRange:  &ScanRange{Start: tt.start, End: tt.end},
+		}
+		got, err := runSelect(t, req, []byte(data))
+		if err != nil {
+			t.Fatalf("%d-%d: %v", tt.start, tt.end, err)
+		}
+		if got != tt.want {
+			t.Errorf("%d-%d: expected %q, got %q", tt.start, tt.end, tt.want, got)
+		}
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	csv := Input{Format: FormatCSV}
+	out := Output{Format: FormatCSV}
+	tests := []struct {
+		req  Request
+		code string
+	}{
+		{Request{Expression: "SELECT FROM S3Object", Input: csv, Output: out}, CodeUnsupportedSyntax},
+		{Request{Expression: "SELECT * FROM table", Input: csv, Output: out}, CodeUnsupportedSyntax},
+		{Request{Expression: "SELECT * FROM S3Object WHERE", Input: csv, Output: out}, CodeUnsupportedSyntax},
+		{Request{Expression: "SELECT _1, COUNT(*) FROM S3Object", Input: csv, Output: out}, CodeUnsupportedSyntax},
+		{Request{Expression: "SELECT * FROM S3Object WHERE COUNT(*) > 1", Input: csv, Output: out}, CodeUnsupportedSyntax},
+		{Request{Expression: "SELECT MD5(_1) FROM S3Object", Input: csv, Output: out}, CodeUnsupportedFunction},
+		{Request{Expression: "SELECT * FROM S3Object", Input: Input{Format: FormatCSV, CSV: CSVInput{FileHeaderInfo: "FIRST"}}, Output: out}, CodeInvalidFileHeaderInfo},
+		{Request{Expression: "SELECT * FROM S3Object", Input: Input{Format: FormatJSON}, Output: out}, CodeInvalidJSONType},
+		{Request{Expression: "SELECT * FROM S3Object", Input: Input{Format: FormatCSV, Compression: "ZIP"}, Output: out}, CodeInvalidCompressionFormat},
+		{Request{Expression: "SELECT * FROM S3Object", Input: csv, Output: Output{Format: FormatCSV, CSV: CSVOutput{QuoteFields: "NEVER"}}}, CodeInvalidQuoteFields},
+		{Request{Expression: "SELECT * FROM S3Object", Input: Input{}, Output: out}, CodeObjectSerializationConflict},
+		{Request{Expression: "SELECT * FROM S3Object", Input: Input{Format: FormatCSV, Compression: CompressionGzip}, Output: out,
+			ScanRange: &ScanRange{Start: 0, End: 10}}, CodeInvalidRequestParameter},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.req)
+		var selectErr *Error
+		if !errors.As(err, &selectErr) || selectErr.Code != tt.code {
+			t.Errorf("%q: expected %s, got %v", tt.req.Expression, tt.code, err)
+		}
+	}
+
+	runtime := []struct {
+		sql, data, code string
+	}{
+		{"SELECT CAST(_1 AS INT) FROM S3Object", "abc\n", CodeCastFailed},
+		{"SELECT _1 / 0 FROM S3Object", "1\n", CodeEvaluation},
+		{"SELECT _1 FROM S3Object", "\"open\n", CodeCSVParsing},
+	}
+	for _, tt := range runtime {
+		_, err := runSelect(t, Request{Expression: tt.sql, Input: csv, Output: out}, []byte(tt.data))
+		var selectErr *Error
+		if !errors.As(err, &selectErr) || selectErr.Code != tt.code {
+			t.Errorf("%q: expected %s, got %v", tt.sql, tt.code, err)
+		}
+	}
+	_, err := runSelect(t, Request{Expression: "SELECT * FROM S3Object", Input: Input{Format: FormatJSON, JSON: JSONInput{Type: JSONLines}}, Output: out}, []byte("{\"a\":"))
+	var selectErr *Error
+	if !errors.As(err, &selectErr) || selectErr.Code != CodeJSONParsing {
+		t.Errorf("expected %s, got %v", CodeJSONParsing, err)
+	}
+}
+
+func TestMatchLike(t *testing.T) {
+	tests := []struct {
+		s, pattern string
+		want       bool
+	}{
+		{"hello", "h%o", true},
+		{"hello", "h_llo", true},
+		{"hello", "h_lo", false},
+		{"100%", `100\%`, true},
+		{"1000", `100\%`, false},
+		{"", "%", true},
+	}
+	for _, tt := range tests {
+		if got := matchLike([]rune(tt.s), []rune(tt.pattern), '\\'); got != tt.want {
+			t.Errorf("%q LIKE %q: expected %v", tt.s, tt.pattern, tt.want)
+		}
+	}
+}
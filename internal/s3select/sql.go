@@ -0,0 +1,756 @@
+package s3select
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// token kinds of the SQL lexer.
+const (
+	tokEOF = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+// is reports whether the token is the keyword or symbol s.
+func (t token) is(s string) bool {
+	switch t.kind {
+	case tokIdent:
+		return strings.EqualFold(t.text, s)
+	case tokSymbol:
+		return t.text == s
+	}
+	return false
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("'%s'", t.text)
+	case tokQuotedIdent:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return t.text
+}
+
+// lex splits an SQL expression into tokens.
+func lex(sql string) ([]token, error) {
+	var tokens []token
+	runes := []rune(sql)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '\'' || c == '"':
+			// Quotes are escaped by doubling them
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, syntaxError("unterminated quoted string at position %d", start)
+				}
+				if runes[i] == c {
+					if i+1 < len(runes) && runes[i+1] == c {
+						b.WriteRune(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			kind := tokString
+			if c == '"' {
+				kind = tokQuotedIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: b.String(), pos: start})
+			continue
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+				i++
+				if i < len(runes) && (runes[i] == '+' || runes[i] == '-') {
+					i++
+				}
+				for i < len(runes) && unicode.IsDigit(runes[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+			continue
+		case unicode.IsLetter(c) || c == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+			continue
+		}
+		if i+1 < len(runes) {
+			switch two := string(runes[i : i+2]); two {
+			case "<=", ">=", "<>", "!=", "||":
+				tokens = append(tokens, token{kind: tokSymbol, text: two, pos: start})
+				i += 2
+				continue
+			}
+		}
+		if !strings.ContainsRune("=<>+-*/%(),.[]", c) {
+			return nil, syntaxError("unexpected character %q at position %d", c, start)
+		}
+		tokens = append(tokens, token{kind: tokSymbol, text: string(c), pos: start})
+		i++
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(runes)}), nil
+}
+
+// reserved are the keywords that cannot be used as unquoted aliases.
+var reserved = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "LIMIT": true, "AS": true, "AND": true, "OR": true,
+	"NOT": true, "LIKE": true, "ESCAPE": true, "IS": true, "NULL": true, "MISSING": true, "IN": true,
+	"BETWEEN": true, "TRUE": true, "FALSE": true, "CAST": true,
+}
+
+// expr is a node of a parsed SQL expression.
+type expr interface{}
+
+type literal struct{ value Value }
+
+// column references a field of the record, by name, position (_1, _2, ...)
+// or JSON path.
+type column struct {
+	path []pathElem
+}
+
+type pathElem struct {
+	name string
+	// quoted names match case sensitively
+	quoted bool
+	// index selects an array element when name is empty
+	index int
+}
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binaryExpr struct {
+	op   string
+	l, r expr
+}
+
+type likeExpr struct {
+	x, pattern, escape expr
+	not                bool
+}
+
+type isExpr struct {
+	x   expr
+	not bool
+}
+
+type inExpr struct {
+	x    expr
+	list []expr
+	not  bool
+}
+
+type betweenExpr struct {
+	x, lo, hi expr
+	not       bool
+}
+
+type castExpr struct {
+	x   expr
+	typ string
+}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+// aggregate is an aggregate function call, accumulated over the records
+// matching the WHERE clause.
+type aggregate struct {
+	name string
+	// arg is nil for COUNT(*)
+	arg   expr
+	index int
+}
+
+type projection struct {
+	expr  expr
+	alias string
+}
+
+// query is a parsed SELECT statement.
+type query struct {
+	star        bool
+	projections []projection
+	// alias names S3Object in column references
+	alias      string
+	where      expr
+	limit      int64
+	aggregates []*aggregate
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	query  *query
+	// inAggregate is set while parsing the argument of an aggregate
+	inAggregate bool
+}
+
+// parse parses a SELECT statement.
+func parse(sql string) (*query, error) {
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, query: &query{limit: -1}}
+	if err := p.parseSelect(); err != nil {
+		return nil, err
+	}
+	return p.query, nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the keyword or symbol s.
+func (p *parser) accept(s string) bool {
+	if p.peek().is(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.unexpected(s)
+	}
+	return nil
+}
+
+func (p *parser) unexpected(want string) error {
+	t := p.peek()
+	return syntaxError("expected %s but found %s at position %d", want, t, t.pos)
+}
+
+func (p *parser) parseSelect() error {
+	q := p.query
+	if err := p.expect("SELECT"); err != nil {
+		return err
+	}
+	if p.accept("*") {
+		q.star = true
+	} else {
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return err
+			}
+			proj := projection{expr: e}
+			if p.accept("AS") {
+				if proj.alias, err = p.parseName(); err != nil {
+					return err
+				}
+			} else if t := p.peek(); t.kind == tokQuotedIdent || t.kind == tokIdent && !reserved[strings.ToUpper(t.text)] {
+				proj.alias = p.next().text
+			}
+			q.projections = append(q.projections, proj)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expect("FROM"); err != nil {
+		return err
+	}
+	if !p.accept("S3Object") {
+		return p.unexpected("S3Object")
+	}
+	if p.accept("[") {
+		if err := p.expect("*"); err != nil {
+			return err
+		}
+		if err := p.expect("]"); err != nil {
+			return err
+		}
+	}
+	if p.accept("AS") {
+		alias, err := p.parseName()
+		if err != nil {
+			return err
+		}
+		q.alias = alias
+	} else if t := p.peek(); t.kind == tokQuotedIdent || t.kind == tokIdent && !reserved[strings.ToUpper(t.text)] {
+		q.alias = p.next().text
+	}
+
+	if p.accept("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		if containsAggregate(where) {
+			return syntaxError("aggregate functions are not allowed in WHERE")
+		}
+		q.where = where
+	}
+	if p.accept("LIMIT") {
+		t := p.next()
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if t.kind != tokNumber || err != nil || n < 0 {
+			return syntaxError("LIMIT must be a non-negative integer, found %s", t)
+		}
+		q.limit = n
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return syntaxError("unexpected %s at position %d", t, t.pos)
+	}
+
+	if len(q.aggregates) > 0 {
+		for _, proj := range q.projections {
+			if !isAggregated(proj.expr) {
+				return syntaxError("columns must be used within aggregate functions when the query aggregates")
+			}
+		}
+	}
+	return nil
+}
+
+// parseName parses an alias.
+func (p *parser) parseName() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent && t.kind != tokQuotedIdent {
+		p.pos--
+		return "", p.unexpected("a name")
+	}
+	return t.text, nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "OR", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "AND", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "NOT", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	l, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == tokSymbol && strings.Contains(" = != <> < <= > >= ", " "+t.text+" "):
+		p.next()
+		r, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		op := t.text
+		if op == "!=" {
+			op = "<>"
+		}
+		return &binaryExpr{op: op, l: l, r: r}, nil
+	case t.is("IS"):
+		p.next()
+		not := p.accept("NOT")
+		if !p.accept("NULL") && !p.accept("MISSING") {
+			return nil, p.unexpected("NULL")
+		}
+		return &isExpr{x: l, not: not}, nil
+	}
+
+	not := p.accept("NOT")
+	switch {
+	case p.accept("LIKE"):
+		pattern, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		e := &likeExpr{x: l, pattern: pattern, not: not}
+		if p.accept("ESCAPE") {
+			if e.escape, err = p.parseConcat(); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+	case p.accept("IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		e := &inExpr{x: l, not: not}
+		for {
+			item, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			e.list = append(e.list, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return e, p.expect(")")
+	case p.accept("BETWEEN"):
+		lo, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		return &betweenExpr{x: l, lo: lo, hi: hi, not: not}, nil
+	}
+	if not {
+		return nil, p.unexpected("LIKE, IN or BETWEEN")
+	}
+	return l, nil
+}
+
+func (p *parser) parseConcat() (expr, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAdditive() (expr, error) {
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("+") || p.peek().is("-") {
+		op := p.next().text
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseMultiplicative() (expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("*") || p.peek().is("/") || p.peek().is("%") {
+		op := p.next().text
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "-", x: x}, nil
+	}
+	p.accept("+")
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literal{intValue(i)}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, syntaxError("invalid number %s", t.text)
+		}
+		return &literal{floatValue(f)}, nil
+	case tokString:
+		return &literal{stringValue(t.text)}, nil
+	case tokQuotedIdent:
+		return p.parseColumn(pathElem{name: t.text, quoted: true})
+	case tokSymbol:
+		if t.text == "(" {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	case tokIdent:
+		switch upper := strings.ToUpper(t.text); {
+		case upper == "NULL" || upper == "MISSING":
+			return &literal{null}, nil
+		case upper == "TRUE" || upper == "FALSE":
+			return &literal{boolValue(upper == "TRUE")}, nil
+		case upper == "CAST":
+			return p.parseCast()
+		case p.peek().is("("):
+			return p.parseCall(upper)
+		case !reserved[upper]:
+			return p.parseColumn(pathElem{name: t.text})
+		}
+	}
+	p.pos--
+	return nil, p.unexpected("an expression")
+}
+
+// parseColumn parses the rest of a column reference, such as s.a.b[0].
+func (p *parser) parseColumn(first pathElem) (expr, error) {
+	c := &column{path: []pathElem{first}}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent && t.kind != tokQuotedIdent {
+				p.pos--
+				return nil, p.unexpected("a field name")
+			}
+			c.path = append(c.path, pathElem{name: t.text, quoted: t.kind == tokQuotedIdent})
+		case p.accept("["):
+			t := p.next()
+			index, err := strconv.Atoi(t.text)
+			if t.kind != tokNumber || err != nil || index < 0 {
+				return nil, syntaxError("array index must be a non-negative integer, found %s", t)
+			}
+			c.path = append(c.path, pathElem{index: index})
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		default:
+			return c, nil
+		}
+	}
+}
+
+func (p *parser) parseCast() (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("AS"); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	if t.kind != tokIdent {
+		p.pos--
+		return nil, p.unexpected("a type")
+	}
+	typ := strings.ToUpper(t.text)
+	if !castTypes[typ] {
+		return nil, syntaxError("unsupported type %s", t.text)
+	}
+	return &castExpr{x: x, typ: typ}, p.expect(")")
+}
+
+// aggregates are the supported aggregate functions.
+var aggregates = map[string]bool{"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true}
+
+func (p *parser) parseCall(name string) (expr, error) {
+	p.next() // (
+	if aggregates[name] {
+		if p.inAggregate {
+			return nil, syntaxError("aggregate functions cannot be nested")
+		}
+		agg := &aggregate{name: name, index: len(p.query.aggregates)}
+		if name == "COUNT" && p.accept("*") {
+			p.query.aggregates = append(p.query.aggregates, agg)
+			return agg, p.expect(")")
+		}
+		p.inAggregate = true
+		arg, err := p.parseExpr()
+		p.inAggregate = false
+		if err != nil {
+			return nil, err
+		}
+		agg.arg = arg
+		p.query.aggregates = append(p.query.aggregates, agg)
+		return agg, p.expect(")")
+	}
+	if _, ok := functions[name]; !ok {
+		return nil, &Error{Code: CodeUnsupportedFunction, Message: fmt.Sprintf("unsupported function %s", name)}
+	}
+
+	c := &callExpr{name: name}
+	if p.accept(")") {
+		return c, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+		// SUBSTRING(s FROM n FOR m) is the same as SUBSTRING(s, n, m)
+		if name == "SUBSTRING" && (p.accept("FROM") || p.accept("FOR")) {
+			continue
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+	return c, p.expect(")")
+}
+
+// containsAggregate reports whether an expression calls an aggregate
+// function.
+func containsAggregate(e expr) bool {
+	found := false
+	walk(e, func(e expr) {
+		if _, ok := e.(*aggregate); ok {
+			found = true
+		}
+	})
+	return found
+}
+
+// isAggregated reports whether an expression only references columns within
+// aggregate functions.
+func isAggregated(e expr) bool {
+	switch e.(type) {
+	case *aggregate, *literal:
+		return true
+	case *column:
+		return false
+	}
+	ok := true
+	children(e, func(c expr) {
+		ok = ok && isAggregated(c)
+	})
+	return ok
+}
+
+// walk calls fn for an expression and all its sub-expressions.
+func walk(e expr, fn func(expr)) {
+	fn(e)
+	children(e, func(c expr) { walk(c, fn) })
+}
+
+// children calls fn for the direct sub-expressions of an expression.
+func children(e expr, fn func(expr)) {
+	switch e := e.(type) {
+	case *unaryExpr:
+		fn(e.x)
+	case *binaryExpr:
+		fn(e.l)
+		fn(e.r)
+	case *likeExpr:
+		fn(e.x)
+		fn(e.pattern)
+		if e.escape != nil {
+			fn(e.escape)
+		}
+	case *isExpr:
+		fn(e.x)
+	case *inExpr:
+		fn(e.x)
+		for _, item := range e.list {
+			fn(item)
+		}
+	case *betweenExpr:
+		fn(e.x)
+		fn(e.lo)
+		fn(e.hi)
+	case *castExpr:
+		fn(e.x)
+	case *callExpr:
+		for _, arg := range e.args {
+			fn(arg)
+		}
+	case *aggregate:
+		if e.arg != nil {
+			fn(e.arg)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package s3select
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// kind is the type of a Value.
+type kind int
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+	// kindObject holds a JSON object or array
+	kindObject
+)
+
+// Value is the result of an expression. CSV fields are strings, which are
+// converted to numbers when compared with or combined with numbers.
+type Value struct {
+	kind kind
+	b    bool
+	i    int64
+	f    float64
+	s    string
+	o    any
+}
+
+var null = Value{}
+
+func boolValue(b bool) Value     { return Value{kind: kindBool, b: b} }
+func intValue(i int64) Value     { return Value{kind: kindInt, i: i} }
+func floatValue(f float64) Value { return Value{kind: kindFloat, f: f} }
+func stringValue(s string) Value { return Value{kind: kindString, s: s} }
+func objectValue(o any) Value    { return Value{kind: kindObject, o: o} }
+
+func (v Value) isNull() bool     { return v.kind == kindNull }
+func (v Value) isNumber() bool   { return v.kind == kindInt || v.kind == kindFloat }
+func (v Value) isTrue() bool     { return v.kind == kindBool && v.b }
+func (v Value) typeName() string { return kindNames[v.kind] }
+
+var kindNames = map[kind]string{
+	kindNull:   "NULL",
+	kindBool:   "BOOL",
+	kindInt:    "INT",
+	kindFloat:  "FLOAT",
+	kindString: "STRING",
+	kindObject: "OBJECT",
+}
+
+// text formats a value for CSV output and string functions.
+func (v Value) text() string {
+	switch v.kind {
+	case kindBool:
+		return strconv.FormatBool(v.b)
+	case kindInt:
+		return strconv.FormatInt(v.i, 10)
+	case kindFloat:
+		return strconv.FormatFloat(v.f, 'f', -1, 64)
+	case kindString:
+		return v.s
+	case kindObject:
+		return string(appendJSON(nil, v.o))
+	}
+	return ""
+}
+
+// number converts a value to a number, parsing strings.
+func (v Value) number() (Value, bool) {
+	switch v.kind {
+	case kindInt, kindFloat:
+		return v, true
+	case kindString:
+		s := strings.TrimSpace(v.s)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return intValue(i), true
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return floatValue(f), true
+		}
+	}
+	return null, false
+}
+
+func (v Value) float() float64 {
+	if v.kind == kindInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// compare orders two non-null values, returning false when they cannot be
+// compared. Numbers compare with strings holding numbers.
+func compare(a, b Value) (int, bool) {
+	if a.isNumber() || b.isNumber() {
+		x, ok := a.number()
+		if !ok {
+			return 0, false
+		}
+		y, ok := b.number()
+		if !ok {
+			return 0, false
+		}
+		if x.kind == kindInt && y.kind == kindInt {
+			return cmp(x.i, y.i), true
+		}
+		return cmp(x.float(), y.float()), true
+	}
+	if a.kind == kindBool && b.kind == kindBool {
+		switch {
+		case a.b == b.b:
+			return 0, true
+		case b.b:
+			return -1, true
+		}
+		return 1, true
+	}
+	if a.kind == kindString && b.kind == kindString {
+		return strings.Compare(a.s, b.s), true
+	}
+	if a.kind == kindBool && b.kind == kindString || a.kind == kindString && b.kind == kindBool {
+		return strings.Compare(strings.ToLower(a.text()), strings.ToLower(b.text())), true
+	}
+	return 0, false
+}
+
+func cmp[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// arithmetic applies +, -, *, / or % to two values.
+func arithmetic(op string, a, b Value) (Value, error) {
+	if a.isNull() || b.isNull() {
+		return null, nil
+	}
+	x, ok := a.number()
+	if !ok {
+		return null, fmt.Errorf("cannot use %s value %q in arithmetic", a.typeName(), a.text())
+	}
+	y, ok := b.number()
+	if !ok {
+		return null, fmt.Errorf("cannot use %s value %q in arithmetic", b.typeName(), b.text())
+	}
+	if x.kind == kindInt && y.kind == kindInt {
+		switch op {
+		case "+":
+			return intValue(x.i + y.i), nil
+		case "-":
+			return intValue(x.i - y.i), nil
+		case "*":
+			return intValue(x.i * y.i), nil
+		case "/", "%":
+			if y.i == 0 {
+				return null, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return intValue(x.i / y.i), nil
+			}
+			return intValue(x.i % y.i), nil
+		}
+	}
+	f, g := x.float(), y.float()
+	switch op {
+	case "+":
+		return floatValue(f + g), nil
+	case "-":
+		return floatValue(f - g), nil
+	case "*":
+		return floatValue(f * g), nil
+	case "/":
+		if g == 0 {
+			return null, fmt.Errorf("division by zero")
+		}
+		return floatValue(f / g), nil
+	case "%":
+		if g == 0 {
+			return null, fmt.Errorf("division by zero")
+		}
+		return floatValue(math.Mod(f, g)), nil
+	}
+	return null, fmt.Errorf("unknown operator %s", op)
+}
+
+// castTypes are the types of CAST.
+var castTypes = map[string]bool{
+	"INT": true, "INTEGER": true, "FLOAT": true, "DECIMAL": true, "NUMERIC": true, "DOUBLE": true,
+	"REAL": true, "STRING": true, "VARCHAR": true, "CHAR": true, "BOOL": true, "BOOLEAN": true,
+}
+
+// cast converts a value to a SQL type.
+func cast(v Value, typ string) (Value, error) {
+	if v.isNull() {
+		return null, nil
+	}
+	switch typ {
+	case "INT", "INTEGER":
+		switch v.kind {
+		case kindInt:
+			return v, nil
+		case kindFloat:
+			return intValue(int64(v.f)), nil
+		case kindBool:
+			if v.b {
+				return intValue(1), nil
+			}
+			return intValue(0), nil
+		}
+		if n, ok := v.number(); ok {
+			return cast(n, typ)
+		}
+	case "FLOAT", "DECIMAL", "NUMERIC", "DOUBLE", "REAL":
+		switch v.kind {
+		case kindInt, kindFloat:
+			return floatValue(v.float()), nil
+		}
+		if n, ok := v.number(); ok {
+			return floatValue(n.float()), nil
+		}
+	case "STRING", "VARCHAR", "CHAR":
+		return stringValue(v.text()), nil
+	case "BOOL", "BOOLEAN":
+		switch v.kind {
+		case kindBool:
+			return v, nil
+		case kindInt:
+			return boolValue(v.i != 0), nil
+		case kindString:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v.s)); err == nil {
+				return boolValue(b), nil
+			}
+		}
+	}
+	return null, fmt.Errorf("cannot cast %s value %q to %s", v.typeName(), v.text(), typ)
+}
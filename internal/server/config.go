@@ -0,0 +1,508 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	yaml "go.yaml.in/yaml/v2"
+
+	"github.com/wpnpeiris/nats-s3/internal/compression"
+	"github.com/wpnpeiris/nats-s3/internal/ratelimit"
+)
+
+// EnvPrefix prefixes the environment variables overriding flags.
+const EnvPrefix = "NATS_S3_"
+
+// envFileSuffix marks an environment variable naming a file that holds the
+// value, such as a mounted secret.
+const envFileSuffix = "_FILE"
+
+// BucketRouteOptions routes matching buckets to a JetStream domain and/or
+// their own NATS connection, as listed in a bucket routes file or inline in
+// the config file.
+type BucketRouteOptions struct {
+	Bucket       string `json:"bucket" yaml:"bucket"`
+	Prefix       string `json:"prefix" yaml:"prefix"`
+	Domain       string `json:"domain" yaml:"domain"`
+	Servers      string `json:"servers" yaml:"servers"`
+	User         string `json:"user" yaml:"user"`
+	Password     string `json:"password" yaml:"password"`
+	PasswordFile string `json:"passwordFile" yaml:"passwordFile"`
+	Token        string `json:"token" yaml:"token"`
+	TokenFile    string `json:"tokenFile" yaml:"tokenFile"`
+	NkeyFile     string `json:"nkeyFile" yaml:"nkeyFile"`
+	CredsFile    string `json:"credsFile" yaml:"credsFile"`
+}
+
+// RateLimitRuleOptions limits the requests and bandwidth of the callers
+// matching it, as listed in a rate limits file or inline in the config file.
+type RateLimitRuleOptions struct {
+	AccessKey         string  `json:"accessKey" yaml:"accessKey"`
+	Bucket            string  `json:"bucket" yaml:"bucket"`
+	Class             string  `json:"class" yaml:"class"`
+	RequestsPerSecond float64 `json:"requestsPerSecond" yaml:"requestsPerSecond"`
+	Burst             int     `json:"burst" yaml:"burst"`
+	BytesPerSecond    int64   `json:"bytesPerSecond" yaml:"bytesPerSecond"`
+}
+
+// rule returns the rate limit rule of the options.
+func (o *RateLimitRuleOptions) rule() ratelimit.Rule {
+	return ratelimit.Rule{
+		AccessKey:         o.AccessKey,
+		Bucket:            o.Bucket,
+		Class:             o.Class,
+		RequestsPerSecond: o.RequestsPerSecond,
+		Burst:             o.Burst,
+		BytesPerSecond:    o.BytesPerSecond,
+	}
+}
+
+// fileConfig is the layout of the YAML config file. Each setting has the
+// meaning of the flag it is applied to.
+type fileConfig struct {
+	Listen   string `yaml:"listen"`
+	Replicas *int   `yaml:"replicas"`
+	NATS     struct {
+		Servers      string `yaml:"servers"`
+		User         string `yaml:"user"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"passwordFile"`
+		Token        string `yaml:"token"`
+		TokenFile    string `yaml:"tokenFile"`
+		NkeyFile     string `yaml:"nkeyFile"`
+		CredsFile    string `yaml:"credsFile"`
+		TLS          struct {
+			Cert string `yaml:"cert"`
+			Key  string `yaml:"key"`
+			CA   string `yaml:"ca"`
+		} `yaml:"tls"`
+	} `yaml:"nats"`
+	S3 struct {
+		Credentials          string               `yaml:"credentials"`
+		EncryptionKey        string               `yaml:"encryptionKey"`
+		EncryptByDefault     *bool                `yaml:"encryptByDefault"`
+		KMSKeyFile           string               `yaml:"kmsKeyFile"`
+		Compression          string               `yaml:"compression"`
+		CompressContentTypes []string             `yaml:"compressContentTypes"`
+		Domains              []string             `yaml:"domains"`
+		BucketRoutes         string               `yaml:"bucketRoutes"`
+		Routes               []BucketRouteOptions `yaml:"routes"`
+	} `yaml:"s3"`
+	Website struct {
+		Listen  string   `yaml:"listen"`
+		Domains []string `yaml:"domains"`
+	} `yaml:"website"`
+	TLS struct {
+		Cert     string `yaml:"cert"`
+		Key      string `yaml:"key"`
+		ClientCA string `yaml:"clientCA"`
+	} `yaml:"tls"`
+	Metrics struct {
+		MaxBuckets *int `yaml:"maxBuckets"`
+	} `yaml:"metrics"`
+	Tracing struct {
+		Endpoint    string   `yaml:"endpoint"`
+		Insecure    *bool    `yaml:"insecure"`
+		SampleRatio *float64 `yaml:"sampleRatio"`
+		ServiceName string   `yaml:"serviceName"`
+	} `yaml:"tracing"`
+	AccessLog struct {
+		FlushInterval string `yaml:"flushInterval"`
+		Stdout        *bool  `yaml:"stdout"`
+	} `yaml:"accessLog"`
+	RateLimit struct {
+		RulesFile string                 `yaml:"rulesFile"`
+		Rules     []RateLimitRuleOptions `yaml:"rules"`
+		Shared    *bool                  `yaml:"shared"`
+	} `yaml:"rateLimit"`
+	Admission struct {
+		MaxTransfers *int   `yaml:"maxTransfers"`
+		MaxBytes     *int64 `yaml:"maxBytes"`
+		QueueSize    *int   `yaml:"queueSize"`
+		QueueTimeout string `yaml:"queueTimeout"`
+	} `yaml:"admission"`
+	Cache struct {
+		MemoryBytes   *int64 `yaml:"memoryBytes"`
+		Dir           string `yaml:"dir"`
+		DiskBytes     *int64 `yaml:"diskBytes"`
+		MaxObjectSize *int64 `yaml:"maxObjectSize"`
+		TTL           string `yaml:"ttl"`
+	} `yaml:"cache"`
+	Log struct {
+		Format string `yaml:"format"`
+		Level  string `yaml:"level"`
+	} `yaml:"log"`
+	HTTP struct {
+		ReadTimeout       string `yaml:"readTimeout"`
+		WriteTimeout      string `yaml:"writeTimeout"`
+		IdleTimeout       string `yaml:"idleTimeout"`
+		ReadHeaderTimeout string `yaml:"readHeaderTimeout"`
+		ShutdownDelay     string `yaml:"shutdownDelay"`
+		ShutdownTimeout   string `yaml:"shutdownTimeout"`
+	} `yaml:"http"`
+}
+
+// loadConfigFile parses the config file at path.
+func loadConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+	var cfg fileConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// flagValues returns the settings of the file keyed by flag name. Secrets
+// given as file references are read.
+func (c *fileConfig) flagValues() (map[string]string, error) {
+	res := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			res[name] = value
+		}
+	}
+	set("listen", c.Listen)
+	if c.Replicas != nil {
+		set("replicas", strconv.Itoa(*c.Replicas))
+	}
+
+	set("natsServers", c.NATS.Servers)
+	set("natsUser", c.NATS.User)
+	set("natsPassword", c.NATS.Password)
+	set("natsToken", c.NATS.Token)
+	set("natsNKeyFile", c.NATS.NkeyFile)
+	set("natsCredsFile", c.NATS.CredsFile)
+	set("natsTLSCert", c.NATS.TLS.Cert)
+	set("natsTLSKey", c.NATS.TLS.Key)
+	set("natsTLSCA", c.NATS.TLS.CA)
+	for name, path := range map[string]string{"natsPassword": c.NATS.PasswordFile, "natsToken": c.NATS.TokenFile} {
+		if path == "" {
+			continue
+		}
+		secret, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %s: %w", name, err)
+		}
+		set(name, secret)
+	}
+
+	set("s3.credentials", c.S3.Credentials)
+	set("s3.encryption-key", c.S3.EncryptionKey)
+	if c.S3.EncryptByDefault != nil {
+		set("s3.encrypt-by-default", strconv.FormatBool(*c.S3.EncryptByDefault))
+	}
+	set("s3.kms-key-file", c.S3.KMSKeyFile)
+	set("s3.compression", c.S3.Compression)
+	set("s3.compress-content-types", strings.Join(c.S3.CompressContentTypes, ","))
+	set("s3.domain", strings.Join(c.S3.Domains, ","))
+	set("s3.bucket-routes", c.S3.BucketRoutes)
+	set("website.listen", c.Website.Listen)
+	set("website.domain", strings.Join(c.Website.Domains, ","))
+
+	set("tls.cert", c.TLS.Cert)
+	set("tls.key", c.TLS.Key)
+	set("tls.client-ca", c.TLS.ClientCA)
+	if c.Metrics.MaxBuckets != nil {
+		set("metrics.max-buckets", strconv.Itoa(*c.Metrics.MaxBuckets))
+	}
+	set("tracing.endpoint", c.Tracing.Endpoint)
+	if c.Tracing.Insecure != nil {
+		set("tracing.insecure", strconv.FormatBool(*c.Tracing.Insecure))
+	}
+	if c.Tracing.SampleRatio != nil {
+		set("tracing.sample-ratio", strconv.FormatFloat(*c.Tracing.SampleRatio, 'g', -1, 64))
+	}
+	set("tracing.service-name", c.Tracing.ServiceName)
+	set("access-log.flush-interval", c.AccessLog.FlushInterval)
+	if c.AccessLog.Stdout != nil {
+		set("access-log.stdout", strconv.FormatBool(*c.AccessLog.Stdout))
+	}
+	set("ratelimit.rules", c.RateLimit.RulesFile)
+	if c.RateLimit.Shared != nil {
+		set("ratelimit.shared", strconv.FormatBool(*c.RateLimit.Shared))
+	}
+	if c.Admission.MaxTransfers != nil {
+		set("admission.max-transfers", strconv.Itoa(*c.Admission.MaxTransfers))
+	}
+	if c.Admission.MaxBytes != nil {
+		set("admission.max-bytes", strconv.FormatInt(*c.Admission.MaxBytes, 10))
+	}
+	if c.Admission.QueueSize != nil {
+		set("admission.queue-size", strconv.Itoa(*c.Admission.QueueSize))
+	}
+	set("admission.queue-timeout", c.Admission.QueueTimeout)
+	if c.Cache.MemoryBytes != nil {
+		set("cache.memory-bytes", strconv.FormatInt(*c.Cache.MemoryBytes, 10))
+	}
+	set("cache.dir", c.Cache.Dir)
+	if c.Cache.DiskBytes != nil {
+		set("cache.disk-bytes", strconv.FormatInt(*c.Cache.DiskBytes, 10))
+	}
+	if c.Cache.MaxObjectSize != nil {
+		set("cache.max-object-size", strconv.FormatInt(*c.Cache.MaxObjectSize, 10))
+	}
+	set("cache.ttl", c.Cache.TTL)
+	set("log.format", c.Log.Format)
+	set("log.level", c.Log.Level)
+	set("http.read-timeout", c.HTTP.ReadTimeout)
+	set("http.write-timeout", c.HTTP.WriteTimeout)
+	set("http.idle-timeout", c.HTTP.IdleTimeout)
+	set("http.read-header-timeout", c.HTTP.ReadHeaderTimeout)
+	set("http.shutdown-delay", c.HTTP.ShutdownDelay)
+	set("http.shutdown-timeout", c.HTTP.ShutdownTimeout)
+	return res, nil
+}
+
+// applyConfig sets the flags not given on the command line from environment
+// variables and then the config file, so that flags win over the
+// environment and the environment over the file. Every flag is overridden by
+// EnvName(flag), or by the file named by EnvName(flag)+"_FILE".
+func applyConfig(fs *flag.FlagSet, opts *Options) error {
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	var errs []error
+	fromEnv := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		if onCommandLine[f.Name] || informationalFlags[f.Name] {
+			return
+		}
+		value, ok, err := lookupEnv(EnvName(f.Name))
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if !ok {
+			return
+		}
+		fromEnv[f.Name] = true
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q: %w", EnvName(f.Name), value, err))
+		}
+	})
+
+	// The config file itself may be named by NATS_S3_CONFIG
+	configFile := opts.ConfigFile
+	if configFile != "" {
+		cfg, err := loadConfigFile(configFile)
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		values, err := cfg.flagValues()
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		for name, value := range values {
+			if onCommandLine[name] || fromEnv[name] {
+				continue
+			}
+			if err := fs.Set(name, value); err != nil {
+				errs = append(errs, fmt.Errorf("config file %s: %s: invalid value %q: %w", configFile, name, value, err))
+			}
+		}
+		opts.BucketRoutes = cfg.S3.Routes
+		opts.RateLimitRules = cfg.RateLimit.Rules
+	}
+	return errors.Join(errs...)
+}
+
+// envNames spells the environment variables of flags whose names do not
+// split into words by case.
+var envNames = map[string]string{
+	"natsNKeyFile": "NATS_NKEY_FILE",
+	"natsTLSCA":    "NATS_TLS_CA",
+}
+
+// informationalFlags print information and exit, so they take no
+// environment overrides.
+var informationalFlags = map[string]bool{"h": true, "help": true, "v": true, "version": true}
+
+// lookupEnv returns the value of an environment variable, or the trimmed
+// contents of the file named by the variable with the _FILE suffix.
+func lookupEnv(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	path, ok := os.LookupEnv(name + envFileSuffix)
+	if !ok {
+		return "", false, nil
+	}
+	value, err := readSecretFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s%s: %w", name, envFileSuffix, err)
+	}
+	return value, true, nil
+}
+
+// readSecretFile reads a secret from a file, dropping surrounding whitespace
+// such as the trailing newline of mounted secrets.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// EnvName returns the environment variable overriding a flag: the flag name
+// in upper snake case with the NATS_S3_ prefix, so that natsPassword is
+// NATS_S3_NATS_PASSWORD and http.read-timeout is NATS_S3_HTTP_READ_TIMEOUT.
+func EnvName(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return EnvPrefix + name
+	}
+	runes := []rune(flagName)
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, r := range runes {
+		switch {
+		case r == '.' || r == '-':
+			b.WriteByte('_')
+		case unicode.IsUpper(r):
+			// Start a word at lower-to-upper changes and at the last
+			// capital of an acronym, as in TLSCert
+			if i > 0 && (unicode.IsLower(runes[i-1]) ||
+				(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// Validate checks the options for mistakes that would otherwise surface
+// only after connecting, and reports all of them at once.
+func (o *Options) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	exists := func(flagName, path string) {
+		if path == "" {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			fail("%s: %v", flagName, err)
+		}
+	}
+
+	if o.CredentialsFile == "" {
+		fail("s3.credentials: an S3 credentials file is required")
+	}
+	exists("s3.credentials", o.CredentialsFile)
+	exists("s3.encryption-key", o.EncryptionKeyFile)
+	exists("s3.bucket-routes", o.BucketRoutesFile)
+	exists("ratelimit.rules", o.RateLimitFile)
+	exists("natsNKeyFile", o.NkeyFile)
+	exists("natsCredsFile", o.CredsFile)
+	exists("natsTLSCert", o.NatsTLSCert)
+	exists("natsTLSKey", o.NatsTLSKey)
+	exists("natsTLSCA", o.NatsTLSCA)
+	exists("tls.cert", o.TLSCert)
+	exists("tls.key", o.TLSKey)
+	exists("tls.client-ca", o.TLSClientCA)
+
+	if o.EncryptByDefault && o.EncryptionKeyFile == "" {
+		fail("s3.encrypt-by-default: requires s3.encryption-key")
+	}
+	if o.Compression != "" && !compression.Supported(o.Compression) {
+		fail("s3.compression: unsupported algorithm %q", o.Compression)
+	}
+	if (o.User == "") != (o.Password == "") {
+		fail("natsUser, natsPassword: both are required for basic auth")
+	}
+	if (o.NatsTLSCert == "") != (o.NatsTLSKey == "") {
+		fail("natsTLSCert, natsTLSKey: both are required for mutual TLS")
+	}
+	if (o.TLSCert == "") != (o.TLSKey == "") {
+		fail("tls.cert, tls.key: both are required for HTTPS")
+	}
+	if o.TLSClientCA != "" && o.TLSCert == "" {
+		fail("tls.client-ca: requires tls.cert and tls.key")
+	}
+	if o.WebsiteListen != "" && o.WebsiteListen == o.ServerListen {
+		fail("website.listen: must differ from listen")
+	}
+	for _, domain := range o.WebsiteDomains {
+		if slices.Contains(o.Domains, domain) {
+			fail("website.domain: %s is also an S3 domain", domain)
+		}
+	}
+	if o.Replicas < 1 || o.Replicas > 5 {
+		fail("replicas: must be between 1 and 5, got %d", o.Replicas)
+	}
+	if o.MetricsMaxBuckets < 0 {
+		fail("metrics.max-buckets: must not be negative")
+	}
+	if o.TracingSample < 0 || o.TracingSample > 1 {
+		fail("tracing.sample-ratio: must be between 0 and 1, got %g", o.TracingSample)
+	}
+	for name, n := range map[string]int64{
+		"admission.max-transfers": int64(o.MaxTransfers),
+		"admission.max-bytes":     o.MaxTransferBytes,
+		"admission.queue-size":    int64(o.TransferQueue),
+		"admission.queue-timeout": int64(o.TransferTimeout),
+		"cache.memory-bytes":      o.CacheMemoryBytes,
+		"cache.disk-bytes":        o.CacheDiskBytes,
+		"cache.max-object-size":   o.CacheMaxObject,
+		"cache.ttl":               int64(o.CacheTTL),
+	} {
+		if n < 0 {
+			fail("%s: must not be negative", name)
+		}
+	}
+	if (o.CacheDir == "") != (o.CacheDiskBytes == 0) {
+		fail("cache.dir, cache.disk-bytes: both are required for the disk cache")
+	}
+	if o.AccessLogFlush <= 0 {
+		fail("access-log.flush-interval: must be positive")
+	}
+	switch o.LogFormat {
+	case "logfmt", "json":
+	default:
+		fail("log.format: must be logfmt or json, got %q", o.LogFormat)
+	}
+	switch o.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("log.level: must be debug, info, warn or error, got %q", o.LogLevel)
+	}
+	for i, route := range o.BucketRoutes {
+		if (route.Bucket == "") == (route.Prefix == "") {
+			fail("s3.routes[%d]: exactly one of bucket and prefix is required", i)
+		}
+	}
+	for i, opts := range o.RateLimitRules {
+		rule := opts.rule()
+		if err := rule.Validate(); err != nil {
+			fail("rateLimit.rules[%d]: %v", i, err)
+		}
+	}
+	for name, d := range map[string]int64{
+		"http.read-timeout":        int64(o.ReadTimeout),
+		"http.write-timeout":       int64(o.WriteTimeout),
+		"http.idle-timeout":        int64(o.IdleTimeout),
+		"http.read-header-timeout": int64(o.ReadHeaderTimeout),
+		"http.shutdown-delay":      int64(o.ShutdownDelay),
+		"http.shutdown-timeout":    int64(o.ShutdownTimeout),
+	} {
+		if d < 0 {
+			fail("%s: must not be negative", name)
+		}
+	}
+	return errors.Join(errs...)
+}
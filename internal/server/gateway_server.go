@@ -1,18 +1,35 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/gorilla/mux"
 	"github.com/nats-io/nats.go"
+	"github.com/wpnpeiris/nats-s3/internal/accesslog"
+	"github.com/wpnpeiris/nats-s3/internal/admission"
+	"github.com/wpnpeiris/nats-s3/internal/client"
+	"github.com/wpnpeiris/nats-s3/internal/compression"
 	"github.com/wpnpeiris/nats-s3/internal/credential"
+	"github.com/wpnpeiris/nats-s3/internal/encryption"
+	"github.com/wpnpeiris/nats-s3/internal/kms"
 	"github.com/wpnpeiris/nats-s3/internal/logging"
 	"github.com/wpnpeiris/nats-s3/internal/metrics"
+	"github.com/wpnpeiris/nats-s3/internal/ratelimit"
 	"github.com/wpnpeiris/nats-s3/internal/s3api"
+	"github.com/wpnpeiris/nats-s3/internal/tlsutil"
+	"github.com/wpnpeiris/nats-s3/internal/tracing"
 )
 
 type GatewayServerOptions struct {
@@ -26,12 +43,23 @@ type Config struct {
 	WriteTimeout      time.Duration
 	IdleTimeout       time.Duration
 	ReadHeaderTimeout time.Duration
+	// WebsiteEndpoint serves bucket websites when set
+	WebsiteEndpoint string
+	// TLS serves HTTPS when set
+	TLS *tls.Config
+	// ShutdownDelay is how long health checks fail before the listener
+	// closes, giving load balancers time to stop sending requests
+	ShutdownDelay time.Duration
+	// ShutdownTimeout is the grace period of in-flight requests
+	ShutdownTimeout time.Duration
 }
 
 type GatewayServer struct {
 	logger    log.Logger
 	config    Config
 	s3Gateway *s3api.S3Gateway
+	// stopTracing flushes the spans not yet exported
+	stopTracing func(context.Context) error
 }
 
 // LogAndExit logs an error message to stderr and exits with status code 1.
@@ -48,13 +76,47 @@ func NewGatewayServer(opts *Options) (*GatewayServer, error) {
 		Level:  opts.LogLevel,
 	})
 
-	natsOptions := loadNatsOptions(logger, opts)
+	stopTracing := setupTracing(logger, opts)
+	natsAuthOptions := loadNatsOptions(logger, opts)
+	natsTLSOptions := loadNatsTLSOptions(logger, opts)
+	natsOptions := slices.Concat(natsAuthOptions, natsTLSOptions)
 	credStore := initializeCredentialStore(logger, opts)
+	masterKey := loadEncryptionKey(logger, opts)
+	keyService := loadKMS(logger, opts)
+	compressionOpts := loadCompression(logger, opts)
+	bucketRoutes := loadBucketRoutes(logger, opts, natsAuthOptions, natsTLSOptions)
+	rateLimits := loadRateLimits(logger, opts)
 	s3Gateway, err := s3api.NewS3Gateway(logger,
 		opts.NatsServers,
 		opts.Replicas,
 		natsOptions,
-		credStore)
+		credStore,
+		s3api.S3GatewayOptions{
+			MasterKey:         masterKey,
+			EncryptByDefault:  opts.EncryptByDefault,
+			KMS:               keyService,
+			Compression:       compressionOpts,
+			BucketRoutes:      bucketRoutes,
+			Domains:           opts.Domains,
+			WebsiteDomains:    opts.WebsiteDomains,
+			MetricsMaxBuckets: opts.MetricsMaxBuckets,
+			AccessLog:         loadAccessLog(opts),
+			RateLimits:        rateLimits,
+			RateLimitShared:   opts.RateLimitShared,
+			Admission: admission.Options{
+				MaxTransfers: opts.MaxTransfers,
+				MaxBytes:     opts.MaxTransferBytes,
+				QueueSize:    opts.TransferQueue,
+				QueueTimeout: opts.TransferTimeout,
+			},
+			Cache: client.CacheOptions{
+				MemoryBytes:   opts.CacheMemoryBytes,
+				Dir:           opts.CacheDir,
+				DiskBytes:     opts.CacheDiskBytes,
+				MaxObjectSize: opts.CacheMaxObject,
+				TTL:           opts.CacheTTL,
+			},
+		})
 	if err != nil {
 		return nil, err
 	}
@@ -65,8 +127,40 @@ func NewGatewayServer(opts *Options) (*GatewayServer, error) {
 		WriteTimeout:      opts.WriteTimeout,
 		IdleTimeout:       opts.IdleTimeout,
 		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		WebsiteEndpoint:   opts.WebsiteListen,
+		TLS:               loadTLSConfig(logger, opts),
+		ShutdownDelay:     opts.ShutdownDelay,
+		ShutdownTimeout:   opts.ShutdownTimeout,
 	}
-	return &GatewayServer{logger, config, s3Gateway}, nil
+	return &GatewayServer{logger, config, s3Gateway, stopTracing}, nil
+}
+
+// loadAccessLog returns the access log delivery settings.
+func loadAccessLog(opts *Options) accesslog.Options {
+	res := accesslog.Options{FlushInterval: opts.AccessLogFlush}
+	if opts.AccessLogStdout {
+		res.Stdout = os.Stdout
+	}
+	return res
+}
+
+// setupTracing installs the OpenTelemetry tracer provider exporting to the
+// configured OTLP endpoint. Tracing stays disabled without an endpoint.
+func setupTracing(logger log.Logger, opts *Options) func(context.Context) error {
+	stop, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    opts.TracingEndpoint,
+		Insecure:    opts.TracingInsecure,
+		SampleRatio: opts.TracingSample,
+		ServiceName: opts.TracingService,
+	})
+	if err != nil {
+		logging.Error(logger, "msg", "Failed to set up tracing", "endpoint", opts.TracingEndpoint, "err", err)
+		os.Exit(1)
+	}
+	if opts.TracingEndpoint != "" {
+		logging.Info(logger, "msg", "Exporting traces", "endpoint", opts.TracingEndpoint, "sampleRatio", opts.TracingSample)
+	}
+	return stop
 }
 
 // initializeCredentialStore loads the S3 credentials from the configured file path.
@@ -86,7 +180,221 @@ func initializeCredentialStore(logger log.Logger, opts *Options) *credential.Sta
 	return credStore
 }
 
+// loadEncryptionKey loads the SSE-S3 master key from the configured file path.
+// Returns nil when server side encryption is not configured.
+func loadEncryptionKey(logger log.Logger, opts *Options) []byte {
+	keyFile := opts.EncryptionKeyFile
+	if keyFile == "" {
+		if opts.EncryptByDefault {
+			logging.Error(logger, "msg", "Encryption key file is required to encrypt by default", "flag", "-s3.encryption-key")
+			os.Exit(1)
+		}
+		return nil
+	}
+
+	key, err := encryption.LoadKeyFile(keyFile)
+	if err != nil {
+		logging.Error(logger, "msg", "Failed to load encryption key file", "file", keyFile, "err", err)
+		os.Exit(1)
+	}
+	logging.Info(logger, "msg", "Loaded SSE-S3 master key", "file", keyFile, "encryptByDefault", opts.EncryptByDefault)
+	return key
+}
+
+// loadCompression returns the default compression of objects at rest.
+func loadCompression(logger log.Logger, opts *Options) client.CompressionOptions {
+	if opts.Compression == "" {
+		return client.CompressionOptions{}
+	}
+	if !compression.Supported(opts.Compression) {
+		logging.Error(logger, "msg", "Unsupported compression algorithm", "flag", "-s3.compression", "algorithm", opts.Compression)
+		os.Exit(1)
+	}
+	res := client.CompressionOptions{Algorithm: opts.Compression}
+	for _, ct := range strings.Split(opts.CompressTypes, ",") {
+		if ct = strings.TrimSpace(ct); ct != "" {
+			res.ContentTypes = append(res.ContentTypes, ct)
+		}
+	}
+	logging.Info(logger, "msg", "Compressing objects at rest", "algorithm", res.Algorithm, "contentTypes", strings.Join(res.ContentTypes, ","))
+	return res
+}
+
+// loadKMS opens the file backed KMS at the configured path. Returns nil when
+// SSE-KMS is not configured.
+func loadKMS(logger log.Logger, opts *Options) kms.KMS {
+	keyFile := opts.KMSKeyFile
+	if keyFile == "" {
+		return nil
+	}
+
+	fileKMS, err := kms.NewFileKMS(keyFile)
+	if err != nil {
+		logging.Error(logger, "msg", "Failed to load KMS key file", "file", keyFile, "err", err)
+		os.Exit(1)
+	}
+	logging.Info(logger, "msg", "Loaded KMS keys", "file", keyFile, "defaultKey", fileKMS.DefaultKeyID())
+	return fileKMS
+}
+
+// rateLimitsFile is the JSON document listing the rate limit rules.
+type rateLimitsFile struct {
+	Rules []RateLimitRuleOptions `json:"rules"`
+}
+
+// loadRateLimits reads the rate limit rules from the configured file path,
+// followed by those listed in the config file. Exits on invalid rules;
+// returns nil when no rules are configured.
+func loadRateLimits(logger log.Logger, opts *Options) []ratelimit.Rule {
+	rulesFile := opts.RateLimitFile
+	if rulesFile == "" && len(opts.RateLimitRules) == 0 {
+		return nil
+	}
+
+	var doc rateLimitsFile
+	if rulesFile != "" {
+		data, err := os.ReadFile(rulesFile)
+		if err != nil {
+			logging.Error(logger, "msg", "Failed to read rate limits file", "file", rulesFile, "err", err)
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			logging.Error(logger, "msg", "Failed to parse rate limits file", "file", rulesFile, "err", err)
+			os.Exit(1)
+		}
+	}
+	doc.Rules = append(doc.Rules, opts.RateLimitRules...)
+
+	rules := make([]ratelimit.Rule, 0, len(doc.Rules))
+	for i := range doc.Rules {
+		rule := doc.Rules[i].rule()
+		if err := rule.Validate(); err != nil {
+			logging.Error(logger, "msg", "Invalid rate limit rule", "file", rulesFile, "index", i, "err", err)
+			os.Exit(1)
+		}
+		rules = append(rules, rule)
+	}
+	logging.Info(logger, "msg", "Loaded rate limits", "count", len(rules), "file", rulesFile, "shared", opts.RateLimitShared)
+	return rules
+}
+
+// bucketRoutesFile is the JSON document listing the bucket routes.
+type bucketRoutesFile struct {
+	Routes []BucketRouteOptions `json:"routes"`
+}
+
+// loadBucketRoutes reads the bucket routes from the configured file path,
+// followed by those listed in the config file. Routes with their own servers
+// and no credentials authenticate like the gateway connection, and all of
+// them use its TLS settings. Returns nil when no routes are configured.
+func loadBucketRoutes(logger log.Logger, opts *Options, authOptions []nats.Option, tlsOptions []nats.Option) []client.BucketRoute {
+	routesFile := opts.BucketRoutesFile
+	if routesFile == "" && len(opts.BucketRoutes) == 0 {
+		return nil
+	}
+
+	var doc bucketRoutesFile
+	if routesFile != "" {
+		data, err := os.ReadFile(routesFile)
+		if err != nil {
+			logging.Error(logger, "msg", "Failed to read bucket routes file", "file", routesFile, "err", err)
+			os.Exit(1)
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			logging.Error(logger, "msg", "Failed to parse bucket routes file", "file", routesFile, "err", err)
+			os.Exit(1)
+		}
+	}
+	doc.Routes = append(doc.Routes, opts.BucketRoutes...)
+
+	var routes []client.BucketRoute
+	for _, r := range doc.Routes {
+		route := client.BucketRoute{
+			Bucket:  r.Bucket,
+			Prefix:  r.Prefix,
+			Domain:  r.Domain,
+			Servers: r.Servers,
+		}
+		if r.Servers != "" {
+			for _, secret := range []struct {
+				path  string
+				value *string
+			}{{r.PasswordFile, &r.Password}, {r.TokenFile, &r.Token}} {
+				if secret.path == "" {
+					continue
+				}
+				value, err := readSecretFile(secret.path)
+				if err != nil {
+					logging.Error(logger, "msg", "Failed to read bucket route secret file", "file", secret.path, "err", err)
+					os.Exit(1)
+				}
+				*secret.value = value
+			}
+			switch {
+			case r.User != "" && r.Password != "":
+				route.Options = append(route.Options, nats.UserInfo(r.User, r.Password))
+			case r.Token != "":
+				route.Options = append(route.Options, nats.Token(r.Token))
+			case r.NkeyFile != "":
+				opt, err := nats.NkeyOptionFromSeed(r.NkeyFile)
+				if err != nil {
+					logging.Error(logger, "msg", "Failed to load NKey file", "file", r.NkeyFile, "err", err)
+					os.Exit(1)
+				}
+				route.Options = append(route.Options, opt)
+			case r.CredsFile != "":
+				route.Options = append(route.Options, nats.UserCredentials(r.CredsFile))
+			default:
+				route.Options = append(route.Options, authOptions...)
+			}
+			route.Options = append(route.Options, tlsOptions...)
+		}
+		routes = append(routes, route)
+	}
+	logging.Info(logger, "msg", "Loaded bucket routes", "count", len(routes), "file", routesFile)
+	return routes
+}
+
+// loadTLSConfig builds the HTTPS configuration of the listener. The
+// certificate is reloaded when its files change, and client certificates
+// are required when a client CA is configured. Returns nil for plain HTTP.
+func loadTLSConfig(logger log.Logger, opts *Options) *tls.Config {
+	if opts.TLSCert == "" && opts.TLSKey == "" {
+		if opts.TLSClientCA != "" {
+			logging.Error(logger, "msg", "Client certificate verification requires TLS", "flag", "-tls.client-ca")
+			os.Exit(1)
+		}
+		return nil
+	}
+	if opts.TLSCert == "" || opts.TLSKey == "" {
+		logging.Error(logger, "msg", "Both certificate and key are required for TLS", "flags", "-tls.cert,-tls.key")
+		os.Exit(1)
+	}
+
+	reloader, err := tlsutil.NewCertReloader(opts.TLSCert, opts.TLSKey)
+	if err != nil {
+		logging.Error(logger, "msg", "Failed to load TLS certificate", "cert", opts.TLSCert, "key", opts.TLSKey, "err", err)
+		os.Exit(1)
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if opts.TLSClientCA != "" {
+		pool, err := tlsutil.LoadCertPool(opts.TLSClientCA)
+		if err != nil {
+			logging.Error(logger, "msg", "Failed to load client CA", "file", opts.TLSClientCA, "err", err)
+			os.Exit(1)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	logging.Info(logger, "msg", "Serving HTTPS", "cert", opts.TLSCert, "clientCA", opts.TLSClientCA)
+	return config
+}
+
 // loadNatsOptions builds NATS connection options based on the configured authentication type.
+// The TLS options are built by loadNatsTLSOptions.
 func loadNatsOptions(logger log.Logger, opts *Options) []nats.Option {
 	var natsOptions []nats.Option
 
@@ -110,10 +418,31 @@ func loadNatsOptions(logger log.Logger, opts *Options) []nats.Option {
 	} else {
 		logging.Info(logger, "msg", "Using NATS anonymous connection (no authentication)")
 	}
+
+	return natsOptions
+}
+
+// loadNatsTLSOptions builds the NATS connection options of mutual TLS and
+// server verification, which every connection of the gateway uses whatever
+// its credentials.
+func loadNatsTLSOptions(logger log.Logger, opts *Options) []nats.Option {
+	var natsOptions []nats.Option
+	if (opts.NatsTLSCert == "") != (opts.NatsTLSKey == "") {
+		logging.Error(logger, "msg", "Both certificate and key are required for NATS mutual TLS", "flags", "-natsTLSCert,-natsTLSKey")
+		os.Exit(1)
+	}
+	if opts.NatsTLSCert != "" {
+		logging.Info(logger, "msg", "Using NATS mutual TLS", "cert", opts.NatsTLSCert)
+		natsOptions = append(natsOptions, nats.ClientCert(opts.NatsTLSCert, opts.NatsTLSKey))
+	}
+	if opts.NatsTLSCA != "" {
+		logging.Info(logger, "msg", "Verifying NATS servers", "ca", opts.NatsTLSCA)
+		natsOptions = append(natsOptions, nats.RootCAs(opts.NatsTLSCA))
+	}
 	return natsOptions
 }
 
-// Start starts the HTTP server with the provided configuration and blocks until it exits.
+// Start starts the HTTP servers with the provided configuration and blocks until they exit.
 func (s *GatewayServer) Start() error {
 	logging.Info(s.logger, "msg", fmt.Sprintf("Starting NATS S3 server..."))
 	router := mux.NewRouter().SkipClean(true)
@@ -121,9 +450,49 @@ func (s *GatewayServer) Start() error {
 	metrics.RegisterMetricEndpoint(router)
 	s.s3Gateway.RegisterRoutes(router)
 
-	srv := &http.Server{
-		Addr:    s.config.Endpoint,
-		Handler: router,
+	servers := []*http.Server{s.newServer(s.config.Endpoint, router, s.config.TLS)}
+	if s.config.WebsiteEndpoint != "" {
+		websiteRouter := mux.NewRouter().SkipClean(true)
+		s.s3Gateway.RegisterWebsiteRoutes(websiteRouter)
+		servers = append(servers, s.newServer(s.config.WebsiteEndpoint, websiteRouter, websiteTLS(s.config.TLS)))
+	}
+
+	serveErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			if srv.TLSConfig != nil {
+				logging.Info(s.logger, "msg", fmt.Sprintf("Listening for HTTPS requests on %s", srv.Addr))
+				// The certificate comes from TLSConfig.GetCertificate
+				serveErr <- srv.ListenAndServeTLS("", "")
+				return
+			}
+			logging.Info(s.logger, "msg", fmt.Sprintf("Listening for HTTP requests on %s", srv.Addr))
+			serveErr <- srv.ListenAndServe()
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	// A listener failing takes the gateway down like a signal, so the other
+	// listeners, the NATS connections and the tracer are stopped too
+	var failed error
+	select {
+	case failed = <-serveErr:
+		logging.Error(s.logger, "msg", "HTTP server failed, shutting down", "err", failed)
+	case sig := <-signals:
+		logging.Info(s.logger, "msg", "Shutting down", "signal", sig.String())
+	}
+	return errors.Join(failed, s.shutdown(servers))
+}
+
+// newServer returns an HTTP server listening on addr, serving HTTPS when
+// tlsConfig is set.
+func (s *GatewayServer) newServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:    addr,
+		Handler: handler,
 		// ReadTimeout covers the time from connection accept to request body read completion.
 		// For S3-compatible operations, we need to support large uploads (up to 5GB single PUT).
 		ReadTimeout: s.config.ReadTimeout,
@@ -136,8 +505,66 @@ func (s *GatewayServer) Start() error {
 		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
 		// MaxHeaderBytes limits the size of request headers to prevent memory exhaustion.
 		MaxHeaderBytes: 1 << 20, // 1 MB
+		TLSConfig:      tlsConfig,
+	}
+}
+
+// websiteTLS returns the HTTPS configuration of the website listener: that
+// of the S3 listener, without asking the anonymous visitors of websites for
+// client certificates.
+func websiteTLS(config *tls.Config) *tls.Config {
+	if config == nil {
+		return nil
+	}
+	website := config.Clone()
+	website.ClientAuth = tls.NoClientCert
+	website.ClientCAs = nil
+	return website
+}
+
+// shutdown fails health checks, waits for the shutdown delay, then stops
+// accepting requests and lets in-flight ones complete within the grace
+// period. Requests still running after it are interrupted. The NATS
+// connections are drained last.
+func (s *GatewayServer) shutdown(servers []*http.Server) error {
+	s.s3Gateway.BeginShutdown()
+	if s.config.ShutdownDelay > 0 {
+		logging.Info(s.logger, "msg", "Failing health checks before closing the listener", "delay", s.config.ShutdownDelay.String())
+		time.Sleep(s.config.ShutdownDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	expired := false
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			expired = true
+			continue
+		}
+		if err != nil {
+			logging.Error(s.logger, "msg", "Error shutting down HTTP server", "addr", srv.Addr, "err", err)
+		}
+	}
+	if expired {
+		n := s.s3Gateway.LogInterrupted()
+		logging.Warn(s.logger, "msg", "Grace period expired, interrupting in-flight requests", "count", n)
+		for _, srv := range servers {
+			if err := srv.Close(); err != nil {
+				logging.Error(s.logger, "msg", "Error closing HTTP server", "addr", srv.Addr, "err", err)
+			}
+		}
 	}
 
-	logging.Info(s.logger, "msg", fmt.Sprintf("Listening for HTTP requests on %s", s.config.Endpoint))
-	return srv.ListenAndServe()
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer drainCancel()
+	if err := s.s3Gateway.Drain(drainCtx); err != nil {
+		logging.Error(s.logger, "msg", "Error draining NATS connection", "err", err)
+		return err
+	}
+	if err := s.stopTracing(drainCtx); err != nil {
+		logging.Warn(s.logger, "msg", "Error flushing traces", "err", err)
+	}
+	logging.Info(s.logger, "msg", "Shutdown complete")
+	return nil
 }
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/wpnpeiris/nats-s3/internal/logging"
+	"github.com/wpnpeiris/nats-s3/internal/s3api"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+)
+
+func TestStart_ListenerFailureShutsDown(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	gw, err := s3api.NewS3Gateway(logger, s.ClientURL(), 1, nil, nil, s3api.S3GatewayOptions{})
+	if err != nil {
+		t.Fatalf("failed to create S3 gateway: %v", err)
+	}
+
+	// The website listener cannot bind the address already taken
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer taken.Close()
+
+	tracingStopped := false
+	srv := &GatewayServer{
+		logger: logger,
+		config: Config{
+			Endpoint:        "127.0.0.1:0",
+			WebsiteEndpoint: taken.Addr().String(),
+			ShutdownTimeout: time.Second,
+		},
+		s3Gateway: gw,
+		stopTracing: func(context.Context) error {
+			tracingStopped = true
+			return nil
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Start() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expected the listener error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Start did not return after a listener failed")
+	}
+	// Tracing is stopped last, once NATS has been drained
+	if !tracingStopped {
+		t.Fatalf("expected the gateway to be shut down")
+	}
+}
+
+func TestWebsiteTLS(t *testing.T) {
+	if websiteTLS(nil) != nil {
+		t.Fatalf("expected plain HTTP without TLS")
+	}
+	s3 := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientCAs:  x509.NewCertPool(),
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+	website := websiteTLS(s3)
+	if website.ClientAuth != tls.NoClientCert || website.ClientCAs != nil {
+		t.Errorf("expected the website listener not to ask for client certificates, got %v", website.ClientAuth)
+	}
+	if website.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected the website listener to keep the TLS settings, got min version %x", website.MinVersion)
+	}
+	if s3.ClientAuth != tls.RequireAndVerifyClientCert || s3.ClientCAs == nil {
+		t.Errorf("expected the S3 listener to keep verifying client certificates")
+	}
+}
+
+func TestLoadBucketRoutes_TLS(t *testing.T) {
+	// A self-signed CA the gateway verifies NATS servers with
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "nats-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	opts := &Options{
+		NatsTLSCA: ca,
+		BucketRoutes: []BucketRouteOptions{
+			{Bucket: "own", Servers: "nats://other:4222", User: "route", Password: "secret"},
+			{Bucket: "shared", Servers: "nats://other:4222"},
+		},
+	}
+	routes := loadBucketRoutes(logger, opts, loadNatsOptions(logger, opts), loadNatsTLSOptions(logger, opts))
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(routes))
+	}
+	for _, route := range routes {
+		var o nats.Options
+		for _, opt := range route.Options {
+			if err := opt(&o); err != nil {
+				t.Fatalf("route %s: option failed: %v", route.Bucket, err)
+			}
+		}
+		if !o.Secure || o.RootCAsCB == nil {
+			t.Errorf("route %s: expected the NATS servers to be verified with the gateway CA", route.Bucket)
+		}
+		if route.Bucket == "own" && o.User != "route" {
+			t.Errorf("route %s: expected its own credentials, got user %q", route.Bucket, o.User)
+		}
+	}
+}
+
+func TestLoadBucketRoutes_SecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := os.WriteFile(tokenFile, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	logger := logging.NewLogger(logging.Config{Level: "debug"})
+	opts := &Options{
+		BucketRoutes: []BucketRouteOptions{
+			{Bucket: "password", Servers: "nats://other:4222", User: "route", PasswordFile: passwordFile},
+			{Bucket: "token", Servers: "nats://other:4222", TokenFile: tokenFile},
+		},
+	}
+	routes := loadBucketRoutes(logger, opts, nil, nil)
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(routes))
+	}
+	for _, route := range routes {
+		var o nats.Options
+		for _, opt := range route.Options {
+			if err := opt(&o); err != nil {
+				t.Fatalf("route %s: option failed: %v", route.Bucket, err)
+			}
+		}
+		switch route.Bucket {
+		case "password":
+			if o.User != "route" || o.Password != "secret" {
+				t.Errorf("expected the password read from its file, got %q/%q", o.User, o.Password)
+			}
+		case "token":
+			if o.Token != "s3cr3t" {
+				t.Errorf("expected the token read from its file, got %q", o.Token)
+			}
+		}
+	}
+}
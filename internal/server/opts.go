@@ -2,12 +2,15 @@ package server
 
 import (
 	"flag"
-	"github.com/nats-io/nats.go"
+	"strings"
 	"time"
+
+	"github.com/nats-io/nats.go"
 )
 
 // Options holds all configuration options for the NATS S3 server.
 type Options struct {
+	ConfigFile        string
 	ServerListen      string
 	NatsServers       string
 	User              string
@@ -15,17 +18,57 @@ type Options struct {
 	Token             string
 	NkeyFile          string
 	CredsFile         string
+	NatsTLSCert       string
+	NatsTLSKey        string
+	NatsTLSCA         string
 	Replicas          int
 	CredentialsFile   string
+	EncryptionKeyFile string
+	EncryptByDefault  bool
+	KMSKeyFile        string
+	Compression       string
+	CompressTypes     string
+	BucketRoutesFile  string
+	BucketRoutes      []BucketRouteOptions
+	Domains           []string
+	WebsiteListen     string
+	WebsiteDomains    []string
+	TLSCert           string
+	TLSKey            string
+	TLSClientCA       string
+	MetricsMaxBuckets int
+	TracingEndpoint   string
+	TracingInsecure   bool
+	TracingSample     float64
+	TracingService    string
+	AccessLogFlush    time.Duration
+	AccessLogStdout   bool
+	RateLimitFile     string
+	RateLimitRules    []RateLimitRuleOptions
+	RateLimitShared   bool
+	MaxTransfers      int
+	MaxTransferBytes  int64
+	TransferQueue     int
+	TransferTimeout   time.Duration
+	CacheMemoryBytes  int64
+	CacheDir          string
+	CacheDiskBytes    int64
+	CacheMaxObject    int64
+	CacheTTL          time.Duration
 	LogFormat         string
 	LogLevel          string
 	ReadTimeout       time.Duration
 	WriteTimeout      time.Duration
 	IdleTimeout       time.Duration
 	ReadHeaderTimeout time.Duration
+	ShutdownDelay     time.Duration
+	ShutdownTimeout   time.Duration
 }
 
 // ConfigureOptions parses command-line arguments and returns an Options struct.
+// Settings not given as flags are read from NATS_S3_* environment variables
+// and then from the config file, and the result is validated before any
+// connection is made.
 // It handles -h/--help and -v/--version flags by calling the provided callbacks.
 // Returns nil options and nil error when help or version flags are used.
 func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp func()) (*Options, error) {
@@ -39,6 +82,7 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp f
 	fs.BoolVar(&showHelp, "h", false, "Print usage.")
 	fs.BoolVar(&showHelp, "help", false, "Print usage.")
 
+	fs.StringVar(&opts.ConfigFile, "config", "", "Path to a YAML config file")
 	fs.StringVar(&opts.ServerListen, "listen", "0.0.0.0:5222", "Network host:port to listen on")
 	fs.StringVar(&opts.NatsServers, "natsServers", nats.DefaultURL, "List of NATS Servers to connect")
 	fs.StringVar(&opts.User, "natsUser", "", "NATS server username (basic auth)")
@@ -46,14 +90,49 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp f
 	fs.StringVar(&opts.Token, "natsToken", "", "NATS server token (token auth)")
 	fs.StringVar(&opts.NkeyFile, "natsNKeyFile", "", "NATS server NKey seed file path (nkey auth)")
 	fs.StringVar(&opts.CredsFile, "natsCredsFile", "", "NATS server credentials file path (JWT auth)")
+	fs.StringVar(&opts.NatsTLSCert, "natsTLSCert", "", "Client certificate file for TLS to NATS (mutual TLS)")
+	fs.StringVar(&opts.NatsTLSKey, "natsTLSKey", "", "Client private key file for TLS to NATS (mutual TLS)")
+	fs.StringVar(&opts.NatsTLSCA, "natsTLSCA", "", "CA certificate file verifying the NATS servers")
 	fs.IntVar(&opts.Replicas, "replicas", 1, "Number of replicas for each jetstream element")
 	fs.StringVar(&opts.CredentialsFile, "s3.credentials", "", "Path to S3 credentials file (JSON format)")
+	fs.StringVar(&opts.EncryptionKeyFile, "s3.encryption-key", "", "Path to the 256-bit master key file enabling SSE-S3")
+	fs.BoolVar(&opts.EncryptByDefault, "s3.encrypt-by-default", false, "Encrypt objects with SSE-S3 unless the request asks for SSE-C (requires -s3.encryption-key)")
+	fs.StringVar(&opts.KMSKeyFile, "s3.kms-key-file", "", "Path to the KMS key file enabling SSE-KMS (created if missing)")
+	fs.StringVar(&opts.Compression, "s3.compression", "", "Compress objects at rest: zstd or s2 (default: disabled)")
+	fs.StringVar(&opts.CompressTypes, "s3.compress-content-types", "", "Comma separated content types to compress, e.g. text/*,application/json (default: all)")
+	fs.StringVar(&opts.BucketRoutesFile, "s3.bucket-routes", "", "Path to a JSON file routing buckets to JetStream domains or NATS connections")
+	fs.Var((*stringList)(&opts.Domains), "s3.domain", "Domain serving virtual-hosted-style requests for <bucket>.<domain> (repeatable or comma separated)")
+	fs.StringVar(&opts.WebsiteListen, "website.listen", "", "Network host:port serving bucket websites, named by the first label of the host name (default: disabled)")
+	fs.Var((*stringList)(&opts.WebsiteDomains), "website.domain", "Domain serving bucket websites for <bucket>.<domain> on the S3 listener (repeatable or comma separated)")
+	fs.StringVar(&opts.TLSCert, "tls.cert", "", "Certificate file serving HTTPS, reloaded when it changes")
+	fs.StringVar(&opts.TLSKey, "tls.key", "", "Private key file serving HTTPS, reloaded when it changes")
+	fs.StringVar(&opts.TLSClientCA, "tls.client-ca", "", "CA certificate file requiring and verifying client certificates")
+	fs.IntVar(&opts.MetricsMaxBuckets, "metrics.max-buckets", 100, "Maximum distinct buckets labeling request metrics, others are labeled _other (0 disables the bucket label)")
+	fs.StringVar(&opts.TracingEndpoint, "tracing.endpoint", "", "OTLP/HTTP endpoint exporting OpenTelemetry traces, as host:port or URL (default: disabled)")
+	fs.BoolVar(&opts.TracingInsecure, "tracing.insecure", false, "Export traces over plain HTTP")
+	fs.Float64Var(&opts.TracingSample, "tracing.sample-ratio", 1, "Fraction of new traces sampled, from 0 to 1")
+	fs.StringVar(&opts.TracingService, "tracing.service-name", "nats-s3", "service.name of the exported spans")
+	fs.DurationVar(&opts.AccessLogFlush, "access-log.flush-interval", time.Minute, "How often access logs are delivered to the target buckets of PutBucketLogging")
+	fs.BoolVar(&opts.AccessLogStdout, "access-log.stdout", false, "Also write every access log record to stdout as a JSON line")
+	fs.StringVar(&opts.RateLimitFile, "ratelimit.rules", "", "Path to a JSON file limiting the requests and bandwidth per access key, bucket and operation class")
+	fs.BoolVar(&opts.RateLimitShared, "ratelimit.shared", false, "Keep rate limit state in JetStream KV, so that all gateways enforce the limits together")
+	fs.IntVar(&opts.MaxTransfers, "admission.max-transfers", 0, "Maximum concurrent uploads, downloads and multipart completions (default: unlimited)")
+	fs.Int64Var(&opts.MaxTransferBytes, "admission.max-bytes", 0, "Byte budget shared by concurrent transfers, reserving their declared body size (default: unlimited)")
+	fs.IntVar(&opts.TransferQueue, "admission.queue-size", 100, "Maximum transfers waiting to start once the limits are reached")
+	fs.DurationVar(&opts.TransferTimeout, "admission.queue-timeout", 30*time.Second, "How long a transfer waits to start before failing with SlowDown (0 waits as long as the request)")
+	fs.Int64Var(&opts.CacheMemoryBytes, "cache.memory-bytes", 0, "Size of the in-memory cache of small objects (default: disabled)")
+	fs.StringVar(&opts.CacheDir, "cache.dir", "", "Directory of the on-disk cache of small objects, cleared on start (default: disabled)")
+	fs.Int64Var(&opts.CacheDiskBytes, "cache.disk-bytes", 0, "Size of the on-disk cache of small objects (requires -cache.dir)")
+	fs.Int64Var(&opts.CacheMaxObject, "cache.max-object-size", 1<<20, "Size of the largest object cached")
+	fs.DurationVar(&opts.CacheTTL, "cache.ttl", 5*time.Minute, "How long cached objects are served (0 until changed or evicted)")
 	fs.StringVar(&opts.LogFormat, "log.format", "logfmt", "log output format: logfmt or json")
 	fs.StringVar(&opts.LogLevel, "log.level", "info", "log level: debug, info, warn, error")
 	fs.DurationVar(&opts.ReadTimeout, "http.read-timeout", 15*time.Minute, "HTTP server read timeout (for large uploads)")
 	fs.DurationVar(&opts.WriteTimeout, "http.write-timeout", 15*time.Minute, "HTTP server write timeout (for large downloads)")
 	fs.DurationVar(&opts.IdleTimeout, "http.idle-timeout", 120*time.Second, "HTTP server idle timeout")
 	fs.DurationVar(&opts.ReadHeaderTimeout, "http.read-header-timeout", 30*time.Second, "HTTP server read header timeout (slowloris protection)")
+	fs.DurationVar(&opts.ShutdownDelay, "http.shutdown-delay", 0, "Time /healthz reports 503 before the listener closes on shutdown")
+	fs.DurationVar(&opts.ShutdownTimeout, "http.shutdown-timeout", 30*time.Second, "Grace period for in-flight requests to complete on shutdown")
 	if err := fs.Parse(args); err != nil {
 		return nil, err
 	}
@@ -68,5 +147,28 @@ func ConfigureOptions(fs *flag.FlagSet, args []string, printVersion, printHelp f
 		return nil, nil
 	}
 
+	if err := applyConfig(fs, opts); err != nil {
+		return nil, err
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 	return opts, nil
 }
+
+// stringList is a flag collecting the values of every occurrence, each of
+// which may hold several comma separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func configure(t *testing.T, args ...string) (*Options, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("nats-s3", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return ConfigureOptions(fs, args, func() {}, func() {})
+}
+
+func TestEnvName(t *testing.T) {
+	for flagName, want := range map[string]string{
+		"listen":            "NATS_S3_LISTEN",
+		"natsPassword":      "NATS_S3_NATS_PASSWORD",
+		"natsNKeyFile":      "NATS_S3_NATS_NKEY_FILE",
+		"natsTLSCert":       "NATS_S3_NATS_TLS_CERT",
+		"natsTLSCA":         "NATS_S3_NATS_TLS_CA",
+		"s3.credentials":    "NATS_S3_S3_CREDENTIALS",
+		"http.read-timeout": "NATS_S3_HTTP_READ_TIMEOUT",
+	} {
+		if got := EnvName(flagName); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestConfigureOptions_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	creds := writeFile(t, dir, "credentials.json", "{}")
+	password := writeFile(t, dir, "password", "file-secret\n")
+	token := writeFile(t, dir, "token", "env-token\n")
+	config := writeFile(t, dir, "nats-s3.yaml", `
+listen: 0.0.0.0:1111
+replicas: 3
+nats:
+  servers: nats://file:4222
+  user: admin
+  passwordFile: `+password+`
+s3:
+  credentials: `+creds+`
+  domains: [s3.example.com, s3.local]
+  routes:
+    - prefix: edge-
+      domain: edge
+log:
+  level: debug
+http:
+  readTimeout: 1m
+`)
+
+	t.Setenv("NATS_S3_CONFIG", config)
+	t.Setenv("NATS_S3_LISTEN", "0.0.0.0:2222")
+	t.Setenv("NATS_S3_NATS_SERVERS", "nats://env:4222")
+	t.Setenv("NATS_S3_NATS_TOKEN_FILE", token)
+
+	opts, err := configure(t, "--natsServers", "nats://flag:4222")
+	if err != nil {
+		t.Fatalf("ConfigureOptions: %v", err)
+	}
+	if opts.NatsServers != "nats://flag:4222" {
+		t.Errorf("flag should win over the environment, got %q", opts.NatsServers)
+	}
+	if opts.ServerListen != "0.0.0.0:2222" {
+		t.Errorf("environment should win over the config file, got %q", opts.ServerListen)
+	}
+	if opts.Replicas != 3 || opts.LogLevel != "debug" || opts.ReadTimeout != time.Minute {
+		t.Errorf("config file settings not applied: replicas=%d level=%q read-timeout=%s",
+			opts.Replicas, opts.LogLevel, opts.ReadTimeout)
+	}
+	if opts.WriteTimeout != 15*time.Minute {
+		t.Errorf("expected default write timeout, got %s", opts.WriteTimeout)
+	}
+	if opts.User != "admin" || opts.Password != "file-secret" {
+		t.Errorf("expected password read from file, got %q/%q", opts.User, opts.Password)
+	}
+	if opts.Token != "env-token" {
+		t.Errorf("expected token read from _FILE variable, got %q", opts.Token)
+	}
+	if strings.Join(opts.Domains, ",") != "s3.example.com,s3.local" {
+		t.Errorf("unexpected domains %v", opts.Domains)
+	}
+	if len(opts.BucketRoutes) != 1 || opts.BucketRoutes[0].Prefix != "edge-" || opts.BucketRoutes[0].Domain != "edge" {
+		t.Errorf("unexpected routes %+v", opts.BucketRoutes)
+	}
+}
+
+func TestConfigureOptions_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	creds := writeFile(t, dir, "credentials.json", "{}")
+
+	_, err := configure(t, "--s3.credentials", creds, "--tls.cert", filepath.Join(dir, "missing.crt"),
+		"--s3.compression", "lz4", "--log.format", "text", "--tracing.sample-ratio", "2",
+		"--ratelimit.rules", filepath.Join(dir, "missing.json"), "--admission.max-transfers", "-1",
+		"--cache.disk-bytes", "1048576")
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"tls.cert", "tls.key", "s3.compression", "log.format", "tracing.sample-ratio", "ratelimit.rules", "admission.max-transfers", "cache.dir"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %s, got: %v", want, err)
+		}
+	}
+
+	if _, err := configure(t); err == nil || !strings.Contains(err.Error(), "s3.credentials") {
+		t.Errorf("expected missing credentials error, got %v", err)
+	}
+
+	config := writeFile(t, dir, "limits.yaml", "s3:\n  credentials: "+creds+"\n"+
+		"rateLimit:\n  rules:\n  - class: delete\n    requestsPerSecond: 10\n")
+	if _, err := configure(t, "--config", config); err == nil || !strings.Contains(err.Error(), "rateLimit.rules[0]") {
+		t.Errorf("expected invalid rate limit rule error, got %v", err)
+	}
+
+	config = writeFile(t, dir, "typo.yaml", "s3:\n  credential: "+creds+"\n")
+	if _, err := configure(t, "--config", config); err == nil || !strings.Contains(err.Error(), "credential") {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+
+	t.Setenv("NATS_S3_REPLICAS", "three")
+	if _, err := configure(t, "--s3.credentials", creds); err == nil || !strings.Contains(err.Error(), "NATS_S3_REPLICAS") {
+		t.Errorf("expected invalid environment value error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadInterval bounds how often the certificate files are checked for
+// changes, such as a renewal by an external certificate manager.
+const reloadInterval = time.Second
+
+// CertReloader serves a certificate and key pair from disk, reloading it
+// when either file changes. A pair that fails to load keeps the previous
+// certificate in use.
+type CertReloader struct {
+	mu        sync.RWMutex
+	certFile  string
+	keyFile   string
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertReloader loads the certificate and key pair at the given paths.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, for use as
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.reloadIfChanged()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reloadIfChanged reloads the pair when a file changed since it was loaded,
+// checking at most once per reloadInterval.
+func (r *CertReloader) reloadIfChanged() {
+	r.mu.RLock()
+	due := time.Since(r.checkedAt) >= reloadInterval
+	r.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	modTime, err := r.latestModTime()
+	r.mu.Lock()
+	r.checkedAt = time.Now()
+	changed := err == nil && !modTime.Equal(r.modTime)
+	r.mu.Unlock()
+	if changed {
+		// Keep serving the previous pair while a renewal is half written
+		_ = r.load(modTime)
+	}
+}
+
+// load reads the pair and records the modification time it was read at.
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.checkedAt = time.Now()
+	return nil
+}
+
+// latestModTime returns the later modification time of the two files.
+func (r *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// LoadCertPool reads a PEM file of CA certificates.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,118 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for commonName and its key.
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+func commonName(t *testing.T, r *CertReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// touch moves the modification time of the files forward and lets the
+// reloader check them again.
+func touch(t *testing.T, r *CertReloader, files ...string) {
+	t.Helper()
+	later := time.Now().Add(time.Minute)
+	for _, f := range files {
+		if err := os.Chtimes(f, later, later); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	r.mu.Lock()
+	r.checkedAt = time.Time{}
+	r.mu.Unlock()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeCert(t, certFile, keyFile, "first")
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	if cn := commonName(t, r); cn != "first" {
+		t.Fatalf("expected first certificate, got %q", cn)
+	}
+
+	writeCert(t, certFile, keyFile, "renewed")
+	touch(t, r, certFile, keyFile)
+	if cn := commonName(t, r); cn != "renewed" {
+		t.Fatalf("expected renewed certificate, got %q", cn)
+	}
+
+	// A broken pair keeps the previous certificate in use
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	touch(t, r, keyFile)
+	if cn := commonName(t, r); cn != "renewed" {
+		t.Fatalf("expected renewed certificate to remain, got %q", cn)
+	}
+
+	if _, err := NewCertReloader(certFile, filepath.Join(dir, "missing.key")); err == nil {
+		t.Fatalf("expected error for missing key file")
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "ca.crt")
+	writeCert(t, certFile, filepath.Join(dir, "ca.key"), "ca")
+	if _, err := LoadCertPool(certFile); err != nil {
+		t.Fatalf("LoadCertPool: %v", err)
+	}
+	if _, err := LoadCertPool(filepath.Join(dir, "ca.key")); err == nil {
+		t.Fatalf("expected error for file without certificates")
+	}
+}
@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// PublishMsg publishes a message to JetStream with the trace context of ctx
+// in its headers.
+func PublishMsg(ctx context.Context, js jetstream.JetStream, msg *nats.Msg, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	InjectHeaders(ctx, msg.Header)
+	return js.PublishMsg(ctx, msg, opts...)
+}
+
+// KeyValue wraps a Key-Value bucket so that the messages of its Put, Create
+// and Update carry the trace context of their ctx. The bucket must not be a
+// mirror, whose writes go to the subjects of its origin.
+func KeyValue(js jetstream.JetStream, kv jetstream.KeyValue) jetstream.KeyValue {
+	return &keyValue{KeyValue: kv, js: js}
+}
+
+type keyValue struct {
+	jetstream.KeyValue
+	js jetstream.JetStream
+}
+
+// subject returns the subject a key is written to, behind the API prefix of
+// a JetStream domain.
+func (kv *keyValue) subject(key string) string {
+	prefix := ""
+	if p := kv.js.Options().APIPrefix; p != jetstream.DefaultAPIPrefix {
+		prefix = p
+	}
+	return prefix + "$KV." + kv.Bucket() + "." + key
+}
+
+func (kv *keyValue) publish(ctx context.Context, key string, value []byte, opts ...jetstream.PublishOpt) (uint64, error) {
+	ack, err := PublishMsg(ctx, kv.js, &nats.Msg{Subject: kv.subject(key), Data: value}, opts...)
+	if err != nil {
+		return 0, err
+	}
+	return ack.Sequence, nil
+}
+
+// Put implements the jetstream.KeyValue interface.
+func (kv *keyValue) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	return kv.publish(ctx, key, value)
+}
+
+// PutString implements the jetstream.KeyValue interface.
+func (kv *keyValue) PutString(ctx context.Context, key string, value string) (uint64, error) {
+	return kv.Put(ctx, key, []byte(value))
+}
+
+// Update implements the jetstream.KeyValue interface.
+func (kv *keyValue) Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	return kv.publish(ctx, key, value, jetstream.WithExpectLastSequencePerSubject(revision))
+}
+
+// Create implements the jetstream.KeyValue interface. Keys deleted or purged
+// are created again over their marker. Options such as a TTL are left to the
+// wrapped bucket.
+func (kv *keyValue) Create(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (uint64, error) {
+	if len(opts) > 0 {
+		return kv.KeyValue.Create(ctx, key, value, opts...)
+	}
+	revision, err := kv.Update(ctx, key, value, 0)
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		return revision, err
+	}
+	if _, getErr := kv.Get(ctx, key); !errors.Is(getErr, jetstream.ErrKeyNotFound) {
+		return 0, err
+	}
+	history, histErr := kv.History(ctx, key)
+	if histErr != nil || len(history) == 0 {
+		return 0, err
+	}
+	return kv.Update(ctx, key, value, history[len(history)-1].Revision())
+}
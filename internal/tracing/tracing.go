@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of the gateway spans.
+const instrumentationName = "github.com/wpnpeiris/nats-s3"
+
+// Config holds the tracing settings.
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector, as host:port or a URL; empty
+	// disables tracing unless Exporter is set
+	Endpoint string
+	// Insecure exports over plain HTTP instead of HTTPS
+	Insecure bool
+	// SampleRatio is the fraction of new traces sampled; requests joining a
+	// trace follow the sampling decision of their parent
+	SampleRatio float64
+	// ServiceName is the service.name of the exported spans
+	ServiceName string
+	// Exporter replaces the OTLP exporter, such as an in-memory exporter in
+	// tests. Spans are then exported synchronously.
+	Exporter sdktrace.SpanExporter
+}
+
+// Setup installs the tracer provider and the W3C trace context propagator
+// process-wide. The returned function flushes and stops the exporter. When
+// tracing is disabled, spans are no-ops and the function does nothing.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" && cfg.Exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	}
+	if cfg.Exporter != nil {
+		opts = append(opts, sdktrace.WithSyncer(cfg.Exporter))
+	} else {
+		exporter, err := otlptracehttp.New(ctx, exporterOptions(cfg)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// exporterOptions maps the config to OTLP/HTTP exporter options. Settings
+// left empty fall back to the OTEL_EXPORTER_OTLP_* environment variables.
+func exporterOptions(cfg Config) []otlptracehttp.Option {
+	var opts []otlptracehttp.Option
+	if strings.Contains(cfg.Endpoint, "://") {
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	} else {
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return opts
+}
+
+// Start starts a span of the gateway as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartServer starts the span of a request served by the gateway.
+func StartServer(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// End ends a span, marking it as failed when err is set.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectHeaders writes the trace context of ctx to NATS message headers.
+func InjectHeaders(ctx context.Context, h nats.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, HeaderCarrier(h))
+}
+
+// ExtractHeaders returns ctx with the trace context read from NATS message
+// headers, if any.
+func ExtractHeaders(ctx context.Context, h nats.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier(h))
+}
+
+// HeaderCarrier adapts NATS headers to a propagation.TextMapCarrier. Unlike
+// HTTP headers, NATS header keys are case-sensitive and are kept as the
+// propagator names them, such as traceparent.
+type HeaderCarrier nats.Header
+
+// Get returns the first value of a key.
+func (c HeaderCarrier) Get(key string) string {
+	if v := c[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Set replaces the values of a key.
+func (c HeaderCarrier) Set(key, value string) {
+	c[key] = []string{value}
+}
+
+// Keys lists the keys of the headers.
+func (c HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/wpnpeiris/nats-s3/internal/testutil"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestHeaderCarrier(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	stop, err := Setup(context.Background(), Config{SampleRatio: 1, ServiceName: "test", Exporter: exporter})
+	if err != nil {
+		t.Fatalf("setup tracing: %v", err)
+	}
+	defer stop(context.Background())
+
+	ctx, span := Start(context.Background(), "parent")
+	h := nats.Header{}
+	InjectHeaders(ctx, h)
+	span.End()
+
+	if h.Get("traceparent") == "" {
+		t.Fatalf("expected traceparent header, got %v", h)
+	}
+	got := trace.SpanContextFromContext(ExtractHeaders(context.Background(), h))
+	if got.TraceID() != span.SpanContext().TraceID() || got.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("expected extracted span context %v, got %v", span.SpanContext(), got)
+	}
+	if spans := exporter.GetSpans(); len(spans) != 1 || spans[0].Name != "parent" {
+		t.Fatalf("expected the parent span to be exported, got %v", spans)
+	}
+}
+
+func TestKeyValue_TraceContext(t *testing.T) {
+	s := testutil.StartJSServer(t)
+	defer s.Shutdown()
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	ctx := context.Background()
+	store, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: "traced"})
+	if err != nil {
+		t.Fatalf("create kv: %v", err)
+	}
+	stream, err := js.Stream(ctx, "KV_traced")
+	if err != nil {
+		t.Fatalf("kv stream: %v", err)
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	stop, err := Setup(ctx, Config{SampleRatio: 1, ServiceName: "test", Exporter: exporter})
+	if err != nil {
+		t.Fatalf("setup tracing: %v", err)
+	}
+	defer stop(ctx)
+	spanCtx, span := Start(ctx, "writer")
+	defer span.End()
+
+	kv := KeyValue(js, store)
+	traced := func(key string) {
+		t.Helper()
+		msg, err := stream.GetLastMsgForSubject(ctx, "$KV.traced."+key)
+		if err != nil {
+			t.Fatalf("get %s: %v", key, err)
+		}
+		got := trace.SpanContextFromContext(ExtractHeaders(ctx, msg.Header))
+		if got.TraceID() != span.SpanContext().TraceID() {
+			t.Fatalf("expected %s to carry the trace context, got headers %v", key, msg.Header)
+		}
+	}
+
+	if _, err := kv.Put(spanCtx, "put", []byte("1")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	traced("put")
+	rev, err := kv.Create(spanCtx, "create", []byte("1"))
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	traced("create")
+	if _, err := kv.Create(spanCtx, "create", []byte("2")); !errors.Is(err, jetstream.ErrKeyExists) {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if _, err := kv.Update(spanCtx, "create", []byte("2"), rev+1); !errors.Is(err, jetstream.ErrKeyExists) {
+		t.Fatalf("expected a stale revision to fail, got %v", err)
+	}
+	if _, err := kv.Update(spanCtx, "create", []byte("2"), rev); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	traced("create")
+	// Purged keys are created again
+	if err := kv.Purge(ctx, "create"); err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if _, err := kv.Create(spanCtx, "create", []byte("3")); err != nil {
+		t.Fatalf("create after purge: %v", err)
+	}
+	entry, err := kv.Get(ctx, "create")
+	if err != nil || string(entry.Value()) != "3" {
+		t.Fatalf("expected the key to be created again, got %v, %v", entry, err)
+	}
+	traced("create")
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	stop, err := Setup(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("setup tracing: %v", err)
+	}
+	if err := stop(context.Background()); err != nil {
+		t.Fatalf("stop tracing: %v", err)
+	}
+}
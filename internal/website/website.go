@@ -0,0 +1,189 @@
+// Package website models the static website hosting of buckets: index and
+// error documents, and the routing rules redirecting requests.
+package website
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxRoutingRules is the most routing rules a website may have, as with S3.
+const maxRoutingRules = 50
+
+// ErrInvalidConfig is returned for website configurations S3 would reject.
+var ErrInvalidConfig = errors.New("invalid website configuration")
+
+// Config is the static website hosting of a bucket. Either RedirectAll is
+// set, or IndexDocument with an optional ErrorDocument and routing rules.
+type Config struct {
+	// IndexDocument is the suffix appended to requests for a directory,
+	// such as index.html
+	IndexDocument string `json:"index_document,omitempty"`
+	// ErrorDocument is the key of the object returned when a request fails
+	ErrorDocument string `json:"error_document,omitempty"`
+	// RedirectAll sends every request to another host
+	RedirectAll *RedirectAll `json:"redirect_all,omitempty"`
+	// RoutingRules redirect the requests matching their condition; the
+	// first match applies
+	RoutingRules []RoutingRule `json:"routing_rules,omitempty"`
+}
+
+// RedirectAll redirects every request of a website to the same key on
+// another host.
+type RedirectAll struct {
+	HostName string `json:"host_name"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// RoutingRule redirects the requests matching its condition. A rule without
+// a condition matches every request.
+type RoutingRule struct {
+	Condition *Condition `json:"condition,omitempty"`
+	Redirect  Redirect   `json:"redirect"`
+}
+
+// Condition matches requests by key prefix and by the HTTP error they fail
+// with. Rules without an error code redirect before the object is read.
+type Condition struct {
+	KeyPrefixEquals             string `json:"key_prefix_equals,omitempty"`
+	HTTPErrorCodeReturnedEquals int    `json:"http_error_code_returned_equals,omitempty"`
+}
+
+// Redirect is where a routing rule sends a request. Empty fields keep the
+// host, protocol and key of the request.
+type Redirect struct {
+	HostName             string `json:"host_name,omitempty"`
+	Protocol             string `json:"protocol,omitempty"`
+	HTTPRedirectCode     int    `json:"http_redirect_code,omitempty"`
+	ReplaceKeyPrefixWith string `json:"replace_key_prefix_with,omitempty"`
+	ReplaceKeyWith       string `json:"replace_key_with,omitempty"`
+}
+
+// Validate checks the configuration against the rules of S3.
+func (c *Config) Validate() error {
+	if c.RedirectAll != nil {
+		if c.IndexDocument != "" || c.ErrorDocument != "" || len(c.RoutingRules) > 0 {
+			return fmt.Errorf("%w: RedirectAllRequestsTo excludes other settings", ErrInvalidConfig)
+		}
+		if c.RedirectAll.HostName == "" {
+			return fmt.Errorf("%w: missing redirect host name", ErrInvalidConfig)
+		}
+		return validProtocol(c.RedirectAll.Protocol)
+	}
+
+	if c.IndexDocument == "" || strings.Contains(c.IndexDocument, "/") {
+		return fmt.Errorf("%w: the index document suffix must be non-empty and contain no slash", ErrInvalidConfig)
+	}
+	if len(c.RoutingRules) > maxRoutingRules {
+		return fmt.Errorf("%w: more than %d routing rules", ErrInvalidConfig, maxRoutingRules)
+	}
+	for _, rule := range c.RoutingRules {
+		if cond := rule.Condition; cond != nil {
+			if cond.KeyPrefixEquals == "" && cond.HTTPErrorCodeReturnedEquals == 0 {
+				return fmt.Errorf("%w: empty routing rule condition", ErrInvalidConfig)
+			}
+			if code := cond.HTTPErrorCodeReturnedEquals; code != 0 && (code < 400 || code > 599) {
+				return fmt.Errorf("%w: error code %d is not a 4XX or 5XX code", ErrInvalidConfig, code)
+			}
+		}
+		redirect := rule.Redirect
+		if redirect == (Redirect{}) {
+			return fmt.Errorf("%w: empty routing rule redirect", ErrInvalidConfig)
+		}
+		if redirect.ReplaceKeyPrefixWith != "" && redirect.ReplaceKeyWith != "" {
+			return fmt.Errorf("%w: ReplaceKeyPrefixWith and ReplaceKeyWith are exclusive", ErrInvalidConfig)
+		}
+		if code := redirect.HTTPRedirectCode; code != 0 && (code < 300 || code > 399) {
+			return fmt.Errorf("%w: redirect code %d is not a 3XX code", ErrInvalidConfig, code)
+		}
+		if err := validProtocol(redirect.Protocol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validProtocol(p string) error {
+	if p != "" && p != "http" && p != "https" {
+		return fmt.Errorf("%w: protocol %q", ErrInvalidConfig, p)
+	}
+	return nil
+}
+
+// IndexKey returns the key of the object serving a request key: the index
+// document of the directory for keys ending in a slash, and of the root for
+// the empty key.
+func (c *Config) IndexKey(key string) string {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return key + c.IndexDocument
+	}
+	return key
+}
+
+// Match returns the first routing rule matching a request key, with the
+// HTTP error status the request failed with or zero before it is served.
+// Returns nil when no rule matches.
+func (c *Config) Match(key string, status int) *RoutingRule {
+	for i := range c.RoutingRules {
+		rule := &c.RoutingRules[i]
+		cond := rule.Condition
+		if cond == nil {
+			if status == 0 {
+				return rule
+			}
+			continue
+		}
+		if cond.HTTPErrorCodeReturnedEquals != status {
+			continue
+		}
+		if strings.HasPrefix(key, cond.KeyPrefixEquals) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Location returns the URL a routing rule redirects a request to. scheme
+// and host are those of the request.
+func (r *RoutingRule) Location(key, scheme, host string) string {
+	redirect := r.Redirect
+	switch {
+	case redirect.ReplaceKeyWith != "":
+		key = redirect.ReplaceKeyWith
+	case redirect.ReplaceKeyPrefixWith != "":
+		prefix := ""
+		if r.Condition != nil {
+			prefix = r.Condition.KeyPrefixEquals
+		}
+		key = redirect.ReplaceKeyPrefixWith + strings.TrimPrefix(key, prefix)
+	}
+	return location(redirect.Protocol, redirect.HostName, key, scheme, host)
+}
+
+// StatusCode returns the HTTP status of the redirect, 301 by default.
+func (r *RoutingRule) StatusCode() int {
+	if r.Redirect.HTTPRedirectCode != 0 {
+		return r.Redirect.HTTPRedirectCode
+	}
+	return http.StatusMovedPermanently
+}
+
+// Location returns the URL the request for a key is redirected to. scheme
+// is that of the request.
+func (r *RedirectAll) Location(key, scheme string) string {
+	return location(r.Protocol, r.HostName, key, scheme, r.HostName)
+}
+
+// location builds a redirect URL, defaulting the protocol and host to those
+// of the request.
+func location(protocol, hostName, key, scheme, host string) string {
+	if protocol == "" {
+		protocol = scheme
+	}
+	if hostName == "" {
+		hostName = host
+	}
+	return protocol + "://" + hostName + "/" + key
+}
@@ -0,0 +1,80 @@
+package website
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Config{
+		IndexDocument: "index.html",
+		ErrorDocument: "404.html",
+		RoutingRules: []RoutingRule{
+			{Condition: &Condition{KeyPrefixEquals: "old/"}, Redirect: Redirect{ReplaceKeyPrefixWith: "new/"}},
+			{Condition: &Condition{HTTPErrorCodeReturnedEquals: 404}, Redirect: Redirect{HostName: "example.com", Protocol: "https"}},
+		},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid configuration, got %v", err)
+	}
+
+	for name, cfg := range map[string]Config{
+		"missing index":      {},
+		"index with slash":   {IndexDocument: "a/index.html"},
+		"redirect all mixed": {IndexDocument: "index.html", RedirectAll: &RedirectAll{HostName: "example.com"}},
+		"redirect all host":  {RedirectAll: &RedirectAll{Protocol: "https"}},
+		"bad protocol":       {RedirectAll: &RedirectAll{HostName: "example.com", Protocol: "ftp"}},
+		"empty redirect":     {IndexDocument: "index.html", RoutingRules: []RoutingRule{{}}},
+		"empty condition":    {IndexDocument: "index.html", RoutingRules: []RoutingRule{{Condition: &Condition{}, Redirect: Redirect{ReplaceKeyWith: "a"}}}},
+		"both replacements":  {IndexDocument: "index.html", RoutingRules: []RoutingRule{{Redirect: Redirect{ReplaceKeyWith: "a", ReplaceKeyPrefixWith: "b"}}}},
+		"redirect code":      {IndexDocument: "index.html", RoutingRules: []RoutingRule{{Redirect: Redirect{HostName: "a", HTTPRedirectCode: 200}}}},
+		"error code":         {IndexDocument: "index.html", RoutingRules: []RoutingRule{{Condition: &Condition{HTTPErrorCodeReturnedEquals: 200}, Redirect: Redirect{HostName: "a"}}}},
+	} {
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("%s: expected ErrInvalidConfig, got %v", name, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cfg := Config{
+		IndexDocument: "index.html",
+		RoutingRules: []RoutingRule{
+			{Condition: &Condition{KeyPrefixEquals: "docs/"}, Redirect: Redirect{ReplaceKeyPrefixWith: "documents/", HTTPRedirectCode: 302}},
+			{Condition: &Condition{HTTPErrorCodeReturnedEquals: 404}, Redirect: Redirect{HostName: "fallback.example.com", ReplaceKeyWith: "missing.html"}},
+		},
+	}
+	if key := cfg.IndexKey(""); key != "index.html" {
+		t.Fatalf("unexpected root index key %q", key)
+	}
+	if key := cfg.IndexKey("guide/"); key != "guide/index.html" {
+		t.Fatalf("unexpected directory index key %q", key)
+	}
+
+	rule := cfg.Match("docs/a.html", 0)
+	if rule == nil || rule.StatusCode() != 302 {
+		t.Fatalf("expected the prefix rule, got %+v", rule)
+	}
+	if loc := rule.Location("docs/a.html", "http", "site.local"); loc != "http://site.local/documents/a.html" {
+		t.Fatalf("unexpected prefix redirect %q", loc)
+	}
+	if rule := cfg.Match("other.html", 0); rule != nil {
+		t.Fatalf("expected no rule before serving, got %+v", rule)
+	}
+
+	rule = cfg.Match("other.html", 404)
+	if rule == nil || rule.StatusCode() != 301 {
+		t.Fatalf("expected the error code rule, got %+v", rule)
+	}
+	if loc := rule.Location("other.html", "https", "site.local"); loc != "https://fallback.example.com/missing.html" {
+		t.Fatalf("unexpected error redirect %q", loc)
+	}
+	if rule := cfg.Match("other.html", 403); rule != nil {
+		t.Fatalf("expected no rule for 403, got %+v", rule)
+	}
+
+	all := RedirectAll{HostName: "www.example.com", Protocol: "https"}
+	if loc := all.Location("a/b.html", "http"); loc != "https://www.example.com/a/b.html" {
+		t.Fatalf("unexpected redirect all location %q", loc)
+	}
+}